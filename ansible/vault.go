@@ -0,0 +1,296 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package ansible
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// VaultHeader is the prefix of all ansible-vault payloads
+const VaultHeader = "$ANSIBLE_VAULT"
+
+// VaultTag is the YAML tag used for inline vault values
+const VaultTag = "!vault"
+
+const cipherName = "AES256"
+const saltLen = 32
+const keyLen = 32
+const ivLen = aes.BlockSize
+const iterations = 10000
+const lineWidth = 80
+
+// IsVaulted checks for the ansible-vault header
+func IsVaulted(str string) bool {
+	return strings.HasPrefix(strings.TrimSpace(str), VaultHeader+";")
+}
+
+// ReadPasswordFile reads a vault password file, if the file is
+// executable it is run and its output is used, as ansible does
+func ReadPasswordFile(file string) ([]byte, error) {
+	fullPath, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(fullPath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read vault password file: %s", err)
+	}
+
+	var pw []byte
+	if fi.Mode()&0111 != 0 {
+		pw, err = exec.Command(fullPath).Output()
+	} else {
+		pw, err = ioutil.ReadFile(filepath.Clean(fullPath))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read vault password file: %s", err)
+	}
+	pw = bytes.TrimRight(pw, "\r\n")
+	if len(pw) == 0 {
+		return nil, fmt.Errorf("vault password file '%s' is empty", file)
+	}
+
+	return pw, nil
+}
+
+// Decrypt returns the plain text of an ansible-vault 1.1/1.2 AES256 payload
+func Decrypt(vaultText string, password []byte) ([]byte, error) {
+	lines := strings.Split(strings.TrimSpace(vaultText), "\n")
+	header := strings.Split(strings.TrimSpace(lines[0]), ";")
+	if len(header) < 3 || header[0] != VaultHeader {
+		return nil, fmt.Errorf("not an ansible-vault payload")
+	}
+	if header[1] != "1.1" && header[1] != "1.2" {
+		return nil, fmt.Errorf("unsupported vault format version: %s", header[1])
+	}
+	if strings.TrimSpace(header[2]) != cipherName {
+		return nil, fmt.Errorf("unsupported vault cipher: %s", header[2])
+	}
+
+	var body strings.Builder
+	for _, line := range lines[1:] {
+		body.WriteString(strings.TrimSpace(line))
+	}
+	payload, err := hex.DecodeString(body.String())
+	if err != nil {
+		return nil, fmt.Errorf("vault payload is not valid hex: %s", err)
+	}
+	parts := strings.Split(string(payload), "\n")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("vault payload is malformed")
+	}
+	salt, err := hex.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("vault salt is not valid hex: %s", err)
+	}
+	mac, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("vault hmac is not valid hex: %s", err)
+	}
+	cipherText, err := hex.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("vault cipher text is not valid hex: %s", err)
+	}
+
+	cipherKey, hmacKey, iv := deriveKeys(password, salt)
+	h := hmac.New(sha256.New, hmacKey)
+	_, _ = h.Write(cipherText)
+	if !hmac.Equal(h.Sum(nil), mac) {
+		return nil, fmt.Errorf("vault HMAC mismatch (wrong password?)")
+	}
+
+	block, err := aes.NewCipher(cipherKey)
+	if err != nil {
+		return nil, err
+	}
+	plainText := make([]byte, len(cipherText))
+	cipher.NewCTR(block, iv).XORKeyStream(plainText, cipherText)
+
+	return unpad(plainText)
+}
+
+// Encrypt returns an ansible-vault 1.1 (or 1.2 if a vault ID is given) AES256 payload
+func Encrypt(plainText []byte, password []byte, vaultID string) (string, error) {
+	salt := make([]byte, saltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	cipherKey, hmacKey, iv := deriveKeys(password, salt)
+	block, err := aes.NewCipher(cipherKey)
+	if err != nil {
+		return "", err
+	}
+	padded := pad(plainText)
+	cipherText := make([]byte, len(padded))
+	cipher.NewCTR(block, iv).XORKeyStream(cipherText, padded)
+
+	h := hmac.New(sha256.New, hmacKey)
+	_, _ = h.Write(cipherText)
+
+	payload := fmt.Sprintf("%s\n%s\n%s", hex.EncodeToString(salt), hex.EncodeToString(h.Sum(nil)), hex.EncodeToString(cipherText))
+	encoded := hex.EncodeToString([]byte(payload))
+
+	var buf strings.Builder
+	if vaultID != "" {
+		buf.WriteString(fmt.Sprintf("%s;1.2;%s;%s\n", VaultHeader, cipherName, vaultID))
+	} else {
+		buf.WriteString(fmt.Sprintf("%s;1.1;%s\n", VaultHeader, cipherName))
+	}
+	for i := 0; i < len(encoded); i += lineWidth {
+		end := i + lineWidth
+		if end > len(encoded) {
+			end = len(encoded)
+		}
+		buf.WriteString(encoded[i:end])
+		buf.WriteString("\n")
+	}
+
+	return buf.String(), nil
+}
+
+// WalkScalars calls fn for every scalar node in the given YAML node tree
+func WalkScalars(node *yaml.Node, fn func(n *yaml.Node) error) error {
+	if node == nil {
+		return nil
+	}
+	if node.Kind == yaml.ScalarNode {
+		return fn(node)
+	}
+	for _, child := range node.Content {
+		if err := WalkScalars(child, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DecryptDocument decrypts a YAML document that is either a vault encrypted
+// file or that contains inline !vault values, returning plain YAML
+func DecryptDocument(doc []byte, password []byte) ([]byte, error) {
+	var err error
+
+	if IsVaulted(string(doc)) {
+		doc, err = Decrypt(string(doc), password)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var node yaml.Node
+	if err = yaml.Unmarshal(doc, &node); err != nil {
+		return nil, fmt.Errorf("unable to parse YAML: %s", err)
+	}
+	err = WalkScalars(&node, func(n *yaml.Node) error {
+		if n.Tag != VaultTag {
+			return nil
+		}
+		plainText, err := Decrypt(n.Value, password)
+		if err != nil {
+			return fmt.Errorf("line %d: %s", n.Line, err)
+		}
+		n.Tag = "!!str"
+		n.Style = 0
+		n.Value = string(plainText)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return yaml.Marshal(&node)
+}
+
+// pbkdf2 key derivation (RFC 8018), the output is split into the
+// cipher key, the HMAC key, and the CTR IV as ansible-vault does
+func deriveKeys(password []byte, salt []byte) ([]byte, []byte, []byte) {
+	key := pbkdf2(password, salt, iterations, 2*keyLen+ivLen, sha256.New)
+	return key[:keyLen], key[keyLen : 2*keyLen], key[2*keyLen:]
+}
+
+func pbkdf2(password []byte, salt []byte, iter int, keyLen int, h func() hash.Hash) []byte {
+	prf := hmac.New(h, password)
+	hashLen := prf.Size()
+	numBlocks := (keyLen + hashLen - 1) / hashLen
+
+	var buf [4]byte
+	dk := make([]byte, 0, numBlocks*hashLen)
+	u := make([]byte, hashLen)
+	for block := 1; block <= numBlocks; block++ {
+		prf.Reset()
+		_, _ = prf.Write(salt)
+		buf[0] = byte(block >> 24)
+		buf[1] = byte(block >> 16)
+		buf[2] = byte(block >> 8)
+		buf[3] = byte(block)
+		_, _ = prf.Write(buf[:4])
+		dk = prf.Sum(dk)
+		t := dk[len(dk)-hashLen:]
+		copy(u, t)
+
+		for n := 2; n <= iter; n++ {
+			prf.Reset()
+			_, _ = prf.Write(u)
+			u = u[:0]
+			u = prf.Sum(u)
+			for x := range u {
+				t[x] ^= u[x]
+			}
+		}
+	}
+	return dk[:keyLen]
+}
+
+func pad(data []byte) []byte {
+	n := aes.BlockSize - len(data)%aes.BlockSize
+	return append(append([]byte{}, data...), bytes.Repeat([]byte{byte(n)}, n)...)
+}
+
+func unpad(data []byte) ([]byte, error) {
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("vault plain text has invalid padding")
+	}
+	n := int(data[len(data)-1])
+	if n == 0 || n > aes.BlockSize {
+		return nil, fmt.Errorf("vault plain text has invalid padding")
+	}
+	for _, b := range data[len(data)-n:] {
+		if int(b) != n {
+			return nil, fmt.Errorf("vault plain text has invalid padding")
+		}
+	}
+	return data[:len(data)-n], nil
+}
@@ -0,0 +1,180 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package audit scores secrets against a strength policy: their length, the character
+// classes they use, an estimate of their entropy, and (optionally) whether they are in an
+// offline copy of a breached password dataset
+package audit
+
+import (
+	"bufio"
+	"crypto/sha1" // #nosec G505 the breached password datasets are keyed by SHA-1
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// DefaultMinLength, DefaultMinEntropy, and DefaultMinClasses are the policy used when none is given
+const (
+	DefaultMinLength  = 12
+	DefaultMinEntropy = 60
+	DefaultMinClasses = 1
+)
+
+// Policy is what a secret must meet to not be reported as weak
+type Policy struct {
+	MinLength  int
+	MinEntropy float64
+	MinClasses int
+	Breached   *Breached
+}
+
+// Score describes the strength of a secret, without the secret itself
+type Score struct {
+	Length  int
+	Classes int
+	Entropy float64
+}
+
+// Strength scores a secret, the entropy is the smaller of the estimate from the size of
+// the character classes used and the Shannon entropy of its characters, so that long
+// values made of a few repeated characters are not mistaken for strong ones
+func Strength(secret string) Score {
+	var lower, upper, digit, symbol, other bool
+	counts := map[rune]int{}
+	length := 0
+	for _, r := range secret {
+		length++
+		counts[r]++
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < unicode.MaxASCII && unicode.IsPrint(r):
+			symbol = true
+		default:
+			other = true
+		}
+	}
+
+	pool, classes := 0, 0
+	for _, class := range []struct {
+		used bool
+		size int
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if class.used {
+			pool += class.size
+			classes++
+		}
+	}
+	if length == 0 {
+		return Score{}
+	}
+
+	shannon := 0.0
+	for _, n := range counts {
+		p := float64(n) / float64(length)
+		shannon -= p * math.Log2(p)
+	}
+	entropy := math.Min(float64(length)*math.Log2(float64(pool)), shannon*float64(length))
+	return Score{Length: length, Classes: classes, Entropy: math.Round(entropy*10) / 10}
+}
+
+// Check scores a secret and returns what is wrong with it, if anything
+func (p Policy) Check(secret string) (Score, []string, error) {
+	var problems []string
+	score := Strength(secret)
+	if score.Length < p.MinLength {
+		problems = append(problems, fmt.Sprintf("too short (%d characters, at least %d needed)", score.Length, p.MinLength))
+	}
+	if score.Classes < p.MinClasses {
+		problems = append(problems, fmt.Sprintf("too few character classes (%d, at least %d needed)", score.Classes, p.MinClasses))
+	}
+	if score.Entropy < p.MinEntropy {
+		problems = append(problems, fmt.Sprintf("low entropy (%.1f bits, at least %.1f needed)", score.Entropy, p.MinEntropy))
+	}
+	if p.Breached != nil {
+		found, err := p.Breached.Contains(secret)
+		if err != nil {
+			return score, problems, err
+		}
+		if found {
+			problems = append(problems, "found in the breached password dataset")
+		}
+	}
+	return score, problems, nil
+}
+
+// Breached is an offline copy of a breached password dataset in its k-anonymity layout:
+// one file per 5 character prefix of the upper case SHA-1 hash, named after the prefix
+// (with or without .txt), holding the rest of each hash, optionally followed by :count
+type Breached struct {
+	Dir string
+}
+
+// OpenBreached checks that the dataset directory exists
+func OpenBreached(dir string) (*Breached, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory of hash range files", dir)
+	}
+	return &Breached{Dir: dir}, nil
+}
+
+// Contains returns true if the secret's hash is in the dataset, only the range file
+// for the first 5 characters of the hash is read
+func (b *Breached) Contains(secret string) (bool, error) {
+	hash := fmt.Sprintf("%X", sha1.Sum([]byte(secret))) // #nosec G401
+	prefix, suffix := hash[:5], hash[5:]
+
+	var file *os.File
+	var err error
+	for _, name := range []string{prefix, prefix + ".txt", strings.ToLower(prefix), strings.ToLower(prefix) + ".txt"} {
+		if file, err = os.Open(filepath.Join(b.Dir, name)); err == nil {
+			break
+		}
+	}
+	if file == nil {
+		// no hash with this prefix
+		return false, nil
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.ToUpper(strings.TrimSpace(scanner.Text()))
+		if i := strings.Index(line, ":"); i >= 0 {
+			line = line[:i]
+		}
+		if line == suffix || line == hash {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
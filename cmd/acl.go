@@ -0,0 +1,115 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Everbridge/generate-secure-pillar/pki"
+	"github.com/Everbridge/generate-secure-pillar/utils"
+	"github.com/spf13/viper"
+)
+
+// the key fingerprints (or key IDs) of each team, from the teams setting of the config file
+var teamKeys map[string][]string
+
+// readTeams reads the teams setting of the config file, once
+func readTeams() map[string][]string {
+	if teamKeys == nil {
+		teamKeys = map[string][]string{}
+		if err := viper.UnmarshalKey("teams", &teamKeys); err != nil {
+			exitWithf(utils.ExitConfig, "config error: unable to read teams: %s", err)
+		}
+	}
+	return teamKeys
+}
+
+// aclProblems checks that a value is only encrypted to keys of the teams in its __acl (or
+// the escrow key), and to at least one key of each of them
+func aclProblems(pk *pki.Pki, value string, teams []string) []string {
+	if len(teams) == 0 {
+		return nil
+	}
+	if pki.IsNaclValue(value) {
+		return []string{"nacl value can't be checked against its __acl"}
+	}
+	recips, err := pk.Recipients(value)
+	if err != nil {
+		// reported as a value that can't be read
+		return nil
+	}
+
+	var problems []string
+	known := readTeams()
+	for _, team := range teams {
+		if _, ok := known[team]; !ok {
+			problems = append(problems, fmt.Sprintf("__acl team '%s' is not in the config file's teams", team))
+		}
+	}
+	covered := map[string]bool{}
+	for _, r := range recips {
+		if r.Anonymous {
+			problems = append(problems, "encrypted to an anonymous key, which can't be checked against the __acl")
+			continue
+		}
+		if isEscrowRecipient(pk, r) {
+			continue
+		}
+		team := recipientTeam(r, teams)
+		if team == "" {
+			name := r.KeyID
+			if r.Identity != "" {
+				name = fmt.Sprintf("%s (%s)", r.KeyID, r.Identity)
+			}
+			problems = append(problems, fmt.Sprintf("shared with %s, who isn't in %s", name, strings.Join(teams, ", ")))
+			continue
+		}
+		covered[team] = true
+	}
+	for _, team := range teams {
+		if _, ok := known[team]; ok && !covered[team] {
+			problems = append(problems, fmt.Sprintf("not encrypted to any key of team '%s'", team))
+		}
+	}
+	return problems
+}
+
+// recipientTeam returns the first of the teams the recipient's key belongs to
+func recipientTeam(r pki.Recipient, teams []string) string {
+	for _, team := range teams {
+		for _, key := range teamKeys[team] {
+			if keyMatches(r.KeyID, key) || (r.Fingerprint != "" && keyMatches(r.Fingerprint, key)) {
+				return team
+			}
+		}
+	}
+	return ""
+}
+
+func isEscrowRecipient(pk *pki.Pki, r pki.Recipient) bool {
+	for _, id := range pk.EscrowKeyIDs {
+		if fmt.Sprintf("%016X", id) == r.KeyID {
+			return true
+		}
+	}
+	return false
+}
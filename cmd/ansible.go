@@ -0,0 +1,195 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+//go:build !encryptonly
+// +build !encryptonly
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Everbridge/generate-secure-pillar/ansible"
+	"github.com/Everbridge/generate-secure-pillar/pki"
+	"github.com/Everbridge/generate-secure-pillar/sls"
+	"github.com/Everbridge/generate-secure-pillar/utils"
+	"github.com/spf13/cobra"
+	yaml "gopkg.in/yaml.v3"
+)
+
+const importArg = "import"
+const exportArg = "export"
+
+var vaultPasswordFile string
+var vaultID string
+var vaultWholeFile bool
+
+// ansibleCmd represents the ansible command
+var ansibleCmd = &cobra.Command{
+	Use:   "ansible",
+	Short: "convert between ansible-vault and PGP encrypted values",
+	Long: `convert between ansible-vault and PGP encrypted values
+
+import: decrypt an ansible-vault encrypted file, or the inline !vault values in a file,
+        and write an sls file with the values PGP encrypted
+export: decrypt the PGP encrypted values in an sls file and write them as inline
+        !vault values (or as a vault encrypted file with --whole-file)`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) == 0 {
+			err := cmd.Help()
+			if err != nil {
+				logger.Fatal(err)
+			}
+			os.Exit(0)
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		inputFilePath, err := filepath.Abs(inputFilePath)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		outputFilePath, err := filepath.Abs(outputFilePath)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		if vaultPasswordFile == "" {
+			exitWithf(utils.ExitUsage, "ansible: --vault-password-file is required")
+		}
+		password, err := ansible.ReadPasswordFile(vaultPasswordFile)
+		if err != nil {
+			logger.Fatalf("ansible: %s", err)
+		}
+		pk := getPki()
+
+		var buffer bytes.Buffer
+		switch args[0] {
+		case importArg:
+			buffer, err = vaultImport(inputFilePath, outputFilePath, password, pk)
+		case exportArg:
+			buffer, err = vaultExport(inputFilePath, password, pk)
+		default:
+			exitWithf(utils.ExitUsage, "unknown argument: '%s'", args[0])
+		}
+		if err != nil {
+			logger.Fatalf("ansible %s: %s", args[0], err)
+		}
+		_, err = sls.WriteSlsFileContext(commandContext, buffer, outputFilePath)
+		if err != nil {
+			logger.Fatalf("ansible %s: %s", args[0], err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(ansibleCmd)
+	ansibleCmd.PersistentFlags().StringVarP(&inputFilePath, "file", "f", os.Stdin.Name(), "input file (defaults to STDIN)")
+	ansibleCmd.PersistentFlags().StringVarP(&outputFilePath, "outfile", "o", os.Stdout.Name(), "output file (defaults to STDOUT)")
+	ansibleCmd.PersistentFlags().StringVar(&vaultPasswordFile, "vault-password-file", "", "ansible-vault password file (run if executable)")
+	ansibleCmd.PersistentFlags().StringVar(&vaultID, "vault-id", "", "vault ID label to use when exporting (vault format 1.2)")
+	ansibleCmd.PersistentFlags().BoolVar(&vaultWholeFile, "whole-file", false, "export as a vault encrypted file rather than inline !vault values")
+}
+
+// vaultImport decrypts ansible-vault data and PGP encrypts it as sls data
+func vaultImport(inFile string, outFile string, password []byte, pk pki.Pki) (bytes.Buffer, error) {
+	var buffer bytes.Buffer
+
+	doc, err := ioutil.ReadFile(filepath.Clean(inFile))
+	if err != nil {
+		return buffer, err
+	}
+	plainDoc, err := ansible.DecryptDocument(doc, password)
+	if err != nil {
+		return buffer, err
+	}
+
+	s := sls.New("", pk, topLevelElement)
+	s.FilePath = outFile
+	if err = s.ReadBytes(plainDoc); err != nil {
+		return buffer, err
+	}
+
+	return s.PerformAction(sls.Encrypt)
+}
+
+// vaultExport decrypts PGP encrypted sls values and ansible-vault encrypts them
+func vaultExport(inFile string, password []byte, pk pki.Pki) (bytes.Buffer, error) {
+	var buffer bytes.Buffer
+
+	s := sls.New(inFile, pk, topLevelElement)
+	if s.Error != nil {
+		return buffer, s.Error
+	}
+	out, err := yaml.Marshal(s.Yaml.Values)
+	if err != nil {
+		return buffer, err
+	}
+	var node yaml.Node
+	if err = yaml.Unmarshal(out, &node); err != nil {
+		return buffer, err
+	}
+
+	err = ansible.WalkScalars(&node, func(n *yaml.Node) error {
+		if !strings.Contains(n.Value, pki.PGPHeader) {
+			return nil
+		}
+		plainText, err := pk.DecryptSecret(n.Value)
+		if err != nil {
+			return fmt.Errorf("line %d: %s", n.Line, err)
+		}
+		plainText = sls.DecodeValue(plainText)
+		if vaultWholeFile {
+			n.Value = plainText
+			n.Style = 0
+			return nil
+		}
+		vaulted, err := ansible.Encrypt([]byte(plainText), password, vaultID)
+		if err != nil {
+			return err
+		}
+		n.Tag = ansible.VaultTag
+		n.Style = yaml.LiteralStyle
+		n.Value = vaulted
+		return nil
+	})
+	if err != nil {
+		return buffer, err
+	}
+
+	out, err = yaml.Marshal(&node)
+	if err != nil {
+		return buffer, err
+	}
+	if vaultWholeFile {
+		vaulted, err := ansible.Encrypt(out, password, vaultID)
+		if err != nil {
+			return buffer, err
+		}
+		out = []byte(vaulted)
+	}
+	buffer.Write(out)
+
+	return buffer, nil
+}
@@ -0,0 +1,57 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"os"
+
+	"github.com/Everbridge/generate-secure-pillar/pki"
+	"github.com/Everbridge/generate-secure-pillar/sls"
+	"github.com/Everbridge/generate-secure-pillar/utils"
+)
+
+// archivePath is a tar, gzipped tar, or zip file of pillar to process with recurse instead of a directory
+var archivePath string
+
+const archiveUsage = "with recurse, process the .sls files in this .tar, .tar.gz, or .zip file (or URL) instead of a directory"
+
+// readArchive reads the entries of --archive
+func readArchive() []utils.ArchiveEntry {
+	entries, err := utils.ReadArchive(commandContext, archivePath)
+	fatal("archive", err)
+	return entries
+}
+
+// processArchive applies an action to the .sls files in the entries of --archive and, unless
+// only the keys are listed, writes them to --outfile or back to the archive
+func processArchive(entries []utils.ArchiveEntry, action string, pk pki.Pki) {
+	count, err := utils.ProcessArchive(commandContext, entries, archivePath, ".sls", action, topLevelElement, pk)
+	fatal(action, err)
+	if action == sls.Validate || count == 0 {
+		return
+	}
+
+	out := archivePath
+	if outputFilePath != os.Stdout.Name() {
+		out = outputFilePath
+	}
+	fatal(action, utils.WriteArchive(commandContext, entries, out))
+}
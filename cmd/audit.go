@@ -0,0 +1,126 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+//go:build !encryptonly
+// +build !encryptonly
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/Everbridge/generate-secure-pillar/audit"
+	"github.com/Everbridge/generate-secure-pillar/output"
+	"github.com/Everbridge/generate-secure-pillar/sls"
+	"github.com/Everbridge/generate-secure-pillar/utils"
+	"github.com/spf13/cobra"
+)
+
+var auditPolicy = audit.Policy{}
+var breachedDir string
+
+// auditCmd represents the audit command
+var auditCmd = &cobra.Command{
+	Use:   "audit",
+	Short: "decrypt the values in a file or directory in memory and report the ones that don't meet the strength policy",
+	Long: `Decrypts each encrypted value in memory and scores it by its length, the character classes it uses,
+and an estimate of its entropy. Values that fall short of the policy are reported by path, the values
+themselves are never printed. --breached also checks each value against an offline copy of a breached
+password dataset, in its k-anonymity layout (a file of hash suffixes per 5 character SHA-1 prefix).`,
+	Example: `$ generate-secure-pillar audit --min-length 16 --breached /data/pwned-passwords -d /path/to/pillar/secure/stuff`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if breachedDir != "" {
+			breached, err := audit.OpenBreached(breachedDir)
+			if err != nil {
+				exitWithf(utils.ExitUsage, "audit: --breached: %s", err)
+			}
+			auditPolicy.Breached = breached
+		}
+		pk := getPki()
+
+		var files []string
+		dir := recurseDirectory(cmd)
+		if dir != "" {
+			files = findFiles("audit", dir)
+		} else {
+			files = []string{inputFilePath}
+		}
+
+		weak := []output.WeakSecret{}
+		for _, file := range files {
+			s := sls.New(file, pk, topLevelElement)
+			if s.Error != nil {
+				warnOrFail("audit", s.Error)
+				continue
+			}
+			weak = append(weak, auditValues(&s)...)
+		}
+
+		if structuredOutput() {
+			writeOutput(weak)
+		} else {
+			for _, w := range weak {
+				fmt.Printf("%s: %s: %s\n", w.File, w.Path, strings.Join(w.Problems, ", "))
+			}
+		}
+		if len(weak) > 0 {
+			exitWithf(utils.ExitError, "audit: %d weak secrets found in %d files", len(weak), len(files))
+		}
+	},
+}
+
+// auditValues decrypts each encrypted value in a file and checks it against the policy
+func auditValues(s *sls.Sls) []output.WeakSecret {
+	var weak []output.WeakSecret
+
+	values := s.EncryptedValues()
+	paths := make([]string, 0, len(values))
+	for path := range values {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		plainText, err := s.ProcessValues(values[path], sls.Decrypt)
+		if err != nil {
+			warnOrFail("audit", fmt.Errorf("%s: %s: %s", s.FilePath, path, err))
+			continue
+		}
+		score, problems, err := auditPolicy.Check(fmt.Sprintf("%v", plainText))
+		fatal("audit", err)
+		if len(problems) > 0 {
+			weak = append(weak, output.WeakSecret{File: s.FilePath, Path: path, Length: score.Length, Classes: score.Classes, Entropy: score.Entropy, Problems: problems})
+		}
+	}
+	return weak
+}
+
+func init() {
+	rootCmd.AddCommand(auditCmd)
+	auditCmd.PersistentFlags().StringVarP(&inputFilePath, "file", "f", os.Stdin.Name(), "input file (defaults to STDIN)")
+	auditCmd.PersistentFlags().StringVarP(&recurseDir, "dir", "d", "", "recurse over all .sls files in the given directory")
+	auditCmd.PersistentFlags().IntVar(&auditPolicy.MinLength, "min-length", audit.DefaultMinLength, "report secrets shorter than this")
+	auditCmd.PersistentFlags().Float64Var(&auditPolicy.MinEntropy, "min-entropy", audit.DefaultMinEntropy, "report secrets with an estimated entropy below this many bits")
+	auditCmd.PersistentFlags().IntVar(&auditPolicy.MinClasses, "min-classes", audit.DefaultMinClasses, "report secrets that use fewer character classes (lower, upper, digit, symbol, other)")
+	auditCmd.PersistentFlags().StringVar(&breachedDir, "breached", "", "directory with an offline breached password dataset (SHA-1 hash range files) to check secrets against")
+}
@@ -0,0 +1,153 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Everbridge/generate-secure-pillar/output"
+	"github.com/Everbridge/generate-secure-pillar/pki"
+	"github.com/Everbridge/generate-secure-pillar/sls"
+	"github.com/Everbridge/generate-secure-pillar/utils"
+	"github.com/spf13/cobra"
+)
+
+var changelogFrom string
+var changelogTo = "HEAD"
+var changelogDecrypt bool
+
+// changelogCmd represents the changelog command
+var changelogCmd = &cobra.Command{
+	Use:   "changelog",
+	Short: "list the secrets added, removed, changed, or re-encrypted between two git refs",
+	Example: `
+# the secrets changed since the last release, for the release notes
+$ generate-secure-pillar changelog --from v1 --to v2 -d pillar/
+
+# decrypt changed values to tell a new value from the same value re-encrypted
+$ generate-secure-pillar changelog --from v1 --to v2 -d pillar/ --decrypt`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if changelogFrom == "" {
+			exitWithf(utils.ExitUsage, "changelog: --from is required")
+		}
+		if changelogDecrypt && pki.EncryptOnly {
+			exitWithf(utils.ExitUsage, "changelog: --decrypt is not available in this encrypt only build")
+		}
+		dir := recurseDirectory(cmd)
+		if dir == "" {
+			dir = "."
+		}
+		var pk pki.Pki
+		if changelogDecrypt {
+			pk = getPki()
+		}
+
+		fromFiles, err := gitSlsFiles(changelogFrom, dir)
+		fatal("changelog", err)
+		toFiles, err := gitSlsFiles(changelogTo, dir)
+		fatal("changelog", err)
+		files := make([]string, 0, len(fromFiles)+len(toFiles))
+		for file := range fromFiles {
+			files = append(files, file)
+		}
+		for file := range toFiles {
+			if !fromFiles[file] {
+				files = append(files, file)
+			}
+		}
+		sort.Strings(files)
+
+		changes := []output.SecretChange{}
+		for _, file := range files {
+			var from, to *sls.Sls
+			if fromFiles[file] {
+				if from, err = gitSls(changelogFrom, file, pk); err != nil {
+					warnOrFail("changelog", err)
+					continue
+				}
+			}
+			if toFiles[file] {
+				if to, err = gitSls(changelogTo, file, pk); err != nil {
+					warnOrFail("changelog", err)
+					continue
+				}
+			}
+			fileChanges, err := utils.CompareSecrets(file, from, to, changelogDecrypt)
+			fatal("changelog", err)
+			changes = append(changes, fileChanges...)
+		}
+
+		if structuredOutput() {
+			writeOutput(changes)
+			return
+		}
+		for _, c := range changes {
+			keys := ""
+			fromKeys, toKeys := strings.Join(c.FromKeys, ","), strings.Join(c.ToKeys, ",")
+			if c.Status == utils.ChangeReEncrypted && fromKeys != toKeys {
+				keys = fmt.Sprintf(" (%s -> %s)", fromKeys, toKeys)
+			}
+			fmt.Printf("%s: %s: %s%s\n", c.Status, c.File, c.Path, keys)
+		}
+		fmt.Printf("%d secrets changed between %s and %s\n", len(changes), changelogFrom, changelogTo)
+	},
+}
+
+// gitSlsFiles lists the .sls files under dir at a git ref, by their path in the repository
+func gitSlsFiles(ref string, dir string) (map[string]bool, error) {
+	out, err := runGit("ls-tree", "-r", "-z", "--full-name", "--name-only", ref, "--", dir)
+	if err != nil {
+		return nil, err
+	}
+	files := map[string]bool{}
+	for _, file := range strings.Split(string(out), "\x00") {
+		if strings.HasSuffix(file, ".sls") {
+			files[file] = true
+		}
+	}
+	return files, nil
+}
+
+// gitSls reads an .sls file as it is at a git ref
+func gitSls(ref string, file string, pk pki.Pki) (*sls.Sls, error) {
+	data, err := runGit("cat-file", "blob", ref+":"+file)
+	if err != nil {
+		return nil, err
+	}
+	s := sls.NewWithOptions("", pk, topLevelElement, sls.DefaultOptions)
+	s.FilePath = filepath.Base(file)
+	if err = s.ReadBytes(data); err != nil {
+		return nil, fmt.Errorf("%s:%s: %s", ref, file, err)
+	}
+	return &s, nil
+}
+
+func init() {
+	rootCmd.AddCommand(changelogCmd)
+	changelogCmd.PersistentFlags().StringVar(&changelogFrom, "from", "", "the git ref (tag, branch, or commit) to compare from")
+	changelogCmd.PersistentFlags().StringVar(&changelogTo, "to", changelogTo, "the git ref to compare to")
+	changelogCmd.PersistentFlags().StringVarP(&recurseDir, "dir", "d", "", "the directory of .sls files to compare (defaults to the current directory)")
+	changelogCmd.PersistentFlags().BoolVar(&changelogDecrypt, "decrypt", false, "decrypt changed values to tell a changed value from one re-encrypted (needs the private key)")
+	changelogCmd.PersistentFlags().StringVar(&gitBinary, "git-binary", gitBinary, "the git command to run")
+}
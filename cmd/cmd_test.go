@@ -0,0 +1,670 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Everbridge/generate-secure-pillar/pki"
+	"github.com/Everbridge/generate-secure-pillar/sls"
+	"github.com/Everbridge/generate-secure-pillar/testenv"
+	"github.com/Everbridge/generate-secure-pillar/utils"
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+)
+
+// Assert fails the test if the condition is false
+func Assert(tb testing.TB, condition bool, msg string, v ...interface{}) {
+	if !condition {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d: "+msg+"\033[39m\n\n", append([]interface{}{filepath.Base(file), line}, v...)...)
+		tb.FailNow()
+	}
+}
+
+// Ok fails the test if the `err` is not nil
+func Ok(tb testing.TB, err error) {
+	if err != nil {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d: Unexpected error: %s\033[39m\n\n", filepath.Base(file), line, err.Error())
+		tb.FailNow()
+	}
+}
+
+// Equals fails the test if exp is not equal to act
+func Equals(tb testing.TB, exp, act interface{}) {
+	if !reflect.DeepEqual(exp, act) {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d:\n\n\tExpected: %#v\n\n\tGot: %#v\033[39m\n\n", filepath.Base(file), line, exp, act)
+		tb.FailNow()
+	}
+}
+
+// newTestPki returns a test environment with a generated key pair and a Pki using it
+func newTestPki(t *testing.T) (*testenv.Env, pki.Pki) {
+	env, err := testenv.New("")
+	Ok(t, err)
+	return env, pki.New(env.KeyName, env.PubRing, env.SecRing)
+}
+
+// gitIn runs git in dir and returns its trimmed output
+func gitIn(t *testing.T, dir string, args ...string) string {
+	args = append([]string{"-C", dir, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)
+	out, err := exec.Command("git", args...).CombinedOutput() // #nosec G204
+	Assert(t, err == nil, "git %s: %s", args, string(out))
+	return strings.TrimSpace(string(out))
+}
+
+// pushedCommit commits the files (an empty content removes one) on top of HEAD without
+// moving a ref, as a pushed commit is before the pre-receive hook accepts it
+func pushedCommit(t *testing.T, repo string, files map[string]string) string {
+	for name, content := range files {
+		if content == "" {
+			gitIn(t, repo, "rm", "-q", name)
+			continue
+		}
+		Ok(t, ioutil.WriteFile(filepath.Join(repo, name), []byte(content), 0600))
+		gitIn(t, repo, "add", name)
+	}
+	tree := gitIn(t, repo, "write-tree")
+	commit := gitIn(t, repo, "commit-tree", tree, "-p", "HEAD", "-m", "pushed")
+	gitIn(t, repo, "reset", "-q", "--hard", "HEAD")
+	return commit
+}
+
+func TestPreReceive(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("no git binary")
+	}
+	env, p := newTestPki(t)
+	defer env.Remove()
+	allowed, err := pki.ReadRecipientKeys(env.PubRing)
+	Ok(t, err)
+
+	repo := filepath.Join(env.Dir, "repo")
+	Ok(t, os.MkdirAll(repo, 0700))
+	gitIn(t, repo, "init", "-q")
+	Ok(t, ioutil.WriteFile(filepath.Join(repo, "old.sls"), []byte("secure_vars:\n  a: b\n"), 0600))
+	gitIn(t, repo, "add", "old.sls")
+	gitIn(t, repo, "commit", "-q", "-m", "base")
+	Ok(t, os.Setenv("GIT_DIR", filepath.Join(repo, ".git")))
+	defer os.Unsetenv("GIT_DIR")
+	topLevelElement = "secure_vars"
+	defer func() { topLevelElement = "" }()
+
+	check := func(commit string) []string {
+		blobs, err := pushedBlobs(commit)
+		Ok(t, err)
+		var messages []string
+		for _, b := range blobs {
+			for _, problem := range checkBlob(b, allowed) {
+				messages = append(messages, b.path+": "+problem.Path+": "+problem.Message)
+			}
+		}
+		return messages
+	}
+
+	cipherText, err := p.EncryptSecret("hunter2")
+	Ok(t, err)
+	indented := strings.Replace(cipherText, "\n", "\n    ", -1)
+	clean := pushedCommit(t, repo, map[string]string{"clean.sls": "#!yaml|gpg\n\nsecure_vars:\n  password: |-\n    " + indented + "\n"})
+	Equals(t, []string(nil), check(clean))
+
+	plain := pushedCommit(t, repo, map[string]string{"plain.sls": "secure_vars:\n  password: hunter2\n"})
+	Equals(t, []string{"plain.sls: secure_vars:password: plain text value under 'secure_vars'"}, check(plain))
+
+	deleted := pushedCommit(t, repo, map[string]string{"old.sls": ""})
+	Equals(t, []string(nil), check(deleted))
+	Assert(t, isZeroID("0000000000000000000000000000000000000000"), "expected the zero ID of a deleted ref", nil)
+
+	malformed := pushedCommit(t, repo, map[string]string{"broken.sls": "secure_vars: [unclosed\n"})
+	problems := check(malformed)
+	Assert(t, len(problems) == 1 && strings.HasPrefix(problems[0], "broken.sls: : yaml:"), "expected a problem for the malformed file", problems)
+}
+
+func TestCommandGate(t *testing.T) {
+	for _, words := range [][]string{{"decrypt", "all"}, {"rotate"}, {"env"}, {"audit"}, {"sync"}, {"changelog"}, {"unlock"}, {"shell"}} {
+		Assert(t, commandDisabled([]string{"decrypt"}, words), "expected '%s' to be disabled by decrypt", words)
+	}
+	for _, words := range [][]string{{"encrypt", "all"}, {"keys", "path"}, {"verify"}, {"create"}} {
+		Assert(t, !commandDisabled([]string{"decrypt"}, words), "expected '%s' to be allowed", words)
+	}
+	Assert(t, commandDisabled([]string{"keys split"}, []string{"keys", "split"}), "expected keys split to be disabled", nil)
+	Assert(t, !commandDisabled([]string{"keys split"}, []string{"keys", "path"}), "expected keys path to be allowed", nil)
+	Assert(t, !commandDisabled([]string{"keys split"}, []string{"keys"}), "expected keys to be allowed", nil)
+
+	dir, err := ioutil.TempDir("", "gsp-gate")
+	Ok(t, err)
+	defer os.RemoveAll(dir)
+	activeProfile = &GSPProfile{Name: "developer", AuditLog: filepath.Join(dir, "audit.log")}
+	defer func() { activeProfile = nil }()
+	Ok(t, writeAuditLog(activeProfile.AuditLog, "decrypt all", "denied"))
+	Ok(t, writeAuditLog(activeProfile.AuditLog, "env", "denied"))
+	data, err := ioutil.ReadFile(activeProfile.AuditLog)
+	Ok(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	Equals(t, 2, len(lines))
+	Assert(t, strings.Contains(lines[0], `"profile":"developer","command":"decrypt all","outcome":"denied"`), "expected the audit event", lines[0])
+}
+
+func TestConfigProfiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gsp-config")
+	Ok(t, err)
+	defer os.RemoveAll(dir)
+	cfgPath := filepath.Join(dir, "config.yaml")
+	Ok(t, ioutil.WriteFile(cfgPath, []byte(`# team settings, keep this comment
+env_conventions:
+  directory: pillar/{env} # where the pillar lives
+profiles:
+  - name: dev
+    default: true
+    default_key: Dev Salt Master
+# trailing settings
+log_format: json
+`), 0600))
+	viper.SetConfigFile(cfgPath)
+	Ok(t, viper.ReadInConfig())
+	defer viper.Reset()
+
+	profiles, err := loadProfiles()
+	Ok(t, err)
+	profiles, err = addProfile(profiles, "prod", []string{"default_key=Prod Salt Master", "backend=gpg", "allowed_keys=AAAA, BBBB"})
+	Ok(t, err)
+	Ok(t, useProfile(profiles, "prod"))
+	Ok(t, setProfile(profiles, "dev", "max_value_size", "1024"))
+	Ok(t, saveProfiles(profiles))
+
+	data, err := ioutil.ReadFile(cfgPath)
+	Ok(t, err)
+	for _, want := range []string{"# team settings, keep this comment", "directory: pillar/{env} # where the pillar lives", "# trailing settings", "log_format: json"} {
+		Assert(t, strings.Contains(string(data), want), "expected '%s' to be kept in:\n%s", want, string(data))
+	}
+	Assert(t, strings.Index(string(data), "env_conventions") < strings.Index(string(data), "profiles:"), "expected the key order to be kept:\n%s", string(data))
+
+	Ok(t, viper.ReadInConfig())
+	Equals(t, "json", viper.GetString("log_format"))
+	profiles, err = loadProfiles()
+	Ok(t, err)
+	Equals(t, 2, len(profiles))
+	Equals(t, GSPProfile{Name: "dev", DefaultKey: "Dev Salt Master", MaxValueSize: 1024}, profiles[0])
+	Equals(t, GSPProfile{Name: "prod", Default: true, DefaultKey: "Prod Salt Master", Backend: "gpg", AllowedKeys: []string{"AAAA", "BBBB"}}, profiles[1])
+
+	// an unknown profile or an invalid value is refused and the file is left alone
+	Equals(t, "no profile named 'stage'", setProfile(profiles, "stage", "backend", "gpg").Error())
+	Equals(t, "no profile named 'stage'", useProfile(profiles, "stage").Error())
+	_, err = addProfile(profiles, "dev", nil)
+	Equals(t, "profile 'dev' already exists", err.Error())
+	_, err = addProfile(profiles, "qa", []string{"backend"})
+	Equals(t, "invalid setting 'backend', expected setting=value", err.Error())
+	Equals(t, "'max_value_size' must be a number", setProfile(profiles, "dev", "max_value_size", "big").Error())
+	Equals(t, "'default' must be true or false", setProfile(profiles, "dev", "default", "maybe").Error())
+	Equals(t, "unknown setting 'backnd' (did you mean 'backend'?)", setProfile(profiles, "dev", "backnd", "gpg").Error())
+	Ok(t, setProfile(profiles, "dev", "backend", "bogus"))
+	err = saveProfiles(profiles)
+	Assert(t, err != nil && strings.Contains(err.Error(), "unknown backend 'bogus'"), "expected the backend to be refused, got %v", err)
+	unchanged, err := ioutil.ReadFile(cfgPath)
+	Ok(t, err)
+	Equals(t, string(data), string(unchanged))
+	problems := validateConfig([]interface{}{map[string]interface{}{"name": "dev", "retry_backoff": "soon"}, map[string]interface{}{"default_key": "x"}})
+	Equals(t, []string{"profiles[0] (dev): invalid retry_backoff 'soon', expected a duration such as 500ms or 2s", "profiles[1]: 'name' is required"}, problems)
+}
+
+func TestBuildProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gsp-init")
+	Ok(t, err)
+	defer os.RemoveAll(dir)
+
+	profiles := []GSPProfile{{Name: "dev", Default: true}}
+	in := bufio.NewReader(strings.NewReader("prod\n" + dir + "\n\nProd Salt Master\ny\n"))
+	p, err := buildProfile(in, profiles)
+	Ok(t, err)
+	Equals(t, GSPProfile{Name: "prod", Default: true, GnupgHome: dir, Backend: "pgp", DefaultKey: "Prod Salt Master",
+		DefaultPubRing: filepath.Join(dir, "pubring.gpg"), DefaultSecRing: filepath.Join(dir, "secring.gpg")}, p)
+	Assert(t, !profiles[0].Default, "expected the old default to be cleared", nil)
+
+	_, err = buildProfile(bufio.NewReader(strings.NewReader("dev\n")), profiles)
+	Equals(t, "profile 'dev' already exists", err.Error())
+	_, err = buildProfile(bufio.NewReader(strings.NewReader("qa\n"+dir+"\n\n\n")), profiles)
+	Equals(t, "a key is required", err.Error())
+}
+
+// TestHelperProcess runs the command line in GSP_HELPER_ARGS (a JSON list) when the test
+// binary is started by runGsp, so that commands that exit can be tested
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("GSP_HELPER_PROCESS") != "1" {
+		return
+	}
+	var args []string
+	Ok(t, json.Unmarshal([]byte(os.Getenv("GSP_HELPER_ARGS")), &args))
+	os.Args = append([]string{"generate-secure-pillar"}, args...)
+	Execute()
+	os.Exit(0)
+}
+
+// runGsp runs generate-secure-pillar with the keys of env and an empty home directory,
+// and returns its output and exit code
+func runGsp(t *testing.T, env *testenv.Env, stdin string, args ...string) (string, int) {
+	args = append([]string{"--pubring", env.PubRing, "--secring", env.SecRing, "-k", env.KeyName}, args...)
+	encoded, err := json.Marshal(args)
+	Ok(t, err)
+	cmd := exec.Command(os.Args[0], "-test.run=^TestHelperProcess$") // #nosec G204
+	cmd.Env = append(os.Environ(), "GSP_HELPER_PROCESS=1", "GSP_HELPER_ARGS="+string(encoded),
+		"HOME="+env.Dir, "XDG_CONFIG_HOME="+filepath.Join(env.Dir, ".config"), "GNUPGHOME="+env.GnupgHome)
+	cmd.Stdin = strings.NewReader(stdin)
+	out, err := cmd.CombinedOutput()
+	if _, ok := err.(*exec.ExitError); err != nil && !ok {
+		t.Fatalf("%s: %s", args, err)
+	}
+	return string(out), cmd.ProcessState.ExitCode()
+}
+
+func TestCreate(t *testing.T) {
+	env, _ := newTestPki(t)
+	defer os.RemoveAll(env.Dir)
+	file := filepath.Join(env.Dir, "new.sls")
+
+	out, code := runGsp(t, env, "", "create", "-o", file, "-n", "first", "-s", "one")
+	Equals(t, 0, code)
+	data, err := ioutil.ReadFile(file)
+	Ok(t, err)
+	Assert(t, strings.Contains(string(data), "first:"), "expected the value to be written: %s\n%s", string(data), out)
+
+	// an existing file is not replaced, and is left as it was
+	out, code = runGsp(t, env, "", "create", "-o", file, "-n", "second", "-s", "two")
+	Equals(t, utils.ExitUsage, code)
+	Assert(t, strings.Contains(out, "already exists, use 'update' to change it"), "expected the refusal, got %s", out)
+	unchanged, err := ioutil.ReadFile(file)
+	Ok(t, err)
+	Equals(t, string(data), string(unchanged))
+
+	// --merge-strategy keeps the values that are there
+	out, code = runGsp(t, env, "", "create", "-o", file, "--merge-strategy", "replace", "-n", "second", "-s", "two")
+	Equals(t, 0, code)
+	data, err = ioutil.ReadFile(file)
+	Ok(t, err)
+	Assert(t, strings.Contains(string(data), "first:") && strings.Contains(string(data), "second:"), "expected both values: %s\n%s", string(data), out)
+
+	// --force replaces the file
+	out, code = runGsp(t, env, "", "create", "-o", file, "--force", "-n", "third", "-s", "three")
+	Equals(t, 0, code)
+	data, err = ioutil.ReadFile(file)
+	Ok(t, err)
+	Assert(t, !strings.Contains(string(data), "first:") && strings.Contains(string(data), "third:"), "expected only the new value: %s\n%s", string(data), out)
+}
+
+func TestConfirm(t *testing.T) {
+	for answer, want := range map[string]bool{"y\n": true, "YES\n": true, " yes \n": true, "y": true, "n\n": false, "no\n": false, "\n": false, "": false, "sure\n": false} {
+		ok, err := confirmFrom(strings.NewReader(answer), true, "decrypt 2 files")
+		Ok(t, err)
+		Assert(t, ok == want, "expected %v for the answer %q", want, answer)
+	}
+
+	_, err := confirmFrom(strings.NewReader("y\n"), false, "decrypt 2 files")
+	Equals(t, "decrypt 2 files: not confirmed, use --yes when not running interactively", err.Error())
+	assumeYes = true
+	defer func() { assumeYes = false }()
+	ok, err := confirmFrom(strings.NewReader(""), false, "decrypt 2 files")
+	Ok(t, err)
+	Assert(t, ok, "expected --yes to confirm", nil)
+}
+
+func TestDecryptDirs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gsp-decrypt-dirs")
+	Ok(t, err)
+	defer os.RemoveAll(dir)
+	allowed := filepath.Join(dir, "scratch")
+
+	p := &GSPProfile{Name: "developer"}
+	Ok(t, decryptDirAllowed(p, "/etc"))
+	p.DecryptDirs = []string{allowed}
+	Ok(t, decryptDirAllowed(p, allowed))
+	Ok(t, decryptDirAllowed(p, filepath.Join(allowed, "dev", "pillar")))
+	for _, unsafe := range []string{dir, allowed + "-other", filepath.Join(allowed, "..", "prod"), "/etc"} {
+		err = decryptDirAllowed(p, unsafe)
+		Assert(t, err != nil && strings.Contains(err.Error(), "is not in decrypt_dirs"), "expected %s to be refused, got %v", unsafe, err)
+	}
+
+	// decrypt recurse stops before reading any file in a directory that isn't allowed
+	env, _ := newTestPki(t)
+	defer os.RemoveAll(env.Dir)
+	cfgDir := filepath.Join(env.Dir, ".config", "generate-secure-pillar")
+	Ok(t, os.MkdirAll(cfgDir, 0700))
+	cfg := fmt.Sprintf("profiles:\n  - name: developer\n    default: true\n    decrypt_dirs: [%s]\n", allowed)
+	Ok(t, ioutil.WriteFile(filepath.Join(cfgDir, "config.yaml"), []byte(cfg), 0600))
+	out, code := runGsp(t, env, "", "--yes", "decrypt", "recurse", "-d", env.PillarDir)
+	Equals(t, utils.ExitConfig, code)
+	Assert(t, strings.Contains(out, "profile 'developer': "+env.PillarDir+" is not in decrypt_dirs"), "expected the refusal, got %s", out)
+}
+
+func TestEncryptedSettings(t *testing.T) {
+	env, _ := newTestPki(t)
+	defer os.RemoveAll(env.Dir)
+	if !env.GPGImported {
+		t.Skip("the test key could not be imported with gpg")
+	}
+	cfgPath := filepath.Join(env.Dir, "config.yaml")
+	Ok(t, ioutil.WriteFile(cfgPath, []byte("profiles:\n  - name: dev\n    default: true\n    gnupg_home: "+env.GnupgHome+"\n"), 0600))
+	viper.SetConfigFile(cfgPath)
+	Ok(t, viper.ReadInConfig())
+	defer viper.Reset()
+
+	// a passphrase is only kept encrypted
+	profiles, err := loadProfiles()
+	Ok(t, err)
+	Ok(t, setProfile(profiles, "dev", "passphrase", "hunter2"))
+	err = saveProfiles(profiles)
+	Assert(t, err != nil && strings.Contains(err.Error(), "'passphrase' must be encrypted"), "expected a plain text passphrase to be refused, got %v", err)
+
+	encryptTo = env.KeyName
+	defer func() { encryptTo = "" }()
+	profiles, err = loadProfiles()
+	Ok(t, err)
+	Ok(t, setProfile(profiles, "dev", "passphrase", "hunter2"))
+	Ok(t, setProfile(profiles, "dev", "pkcs11_pin", "1234"))
+	Ok(t, setProfile(profiles, "dev", "passphrase_from", "env://DEV_PASSPHRASE"))
+	err = setProfile(profiles, "dev", "default_key", "Dev Salt Master")
+	Assert(t, err != nil && strings.Contains(err.Error(), "'default_key' can't be encrypted"), "expected default_key to be refused, got %v", err)
+	err = saveProfiles(profiles)
+	Assert(t, err != nil && strings.Contains(err.Error(), "only one of 'passphrase' and 'passphrase_from' can be set"), "expected both passphrase settings to be refused, got %v", err)
+	profiles[0].PassphraseFrom = ""
+	Ok(t, saveProfiles(profiles))
+
+	data, err := ioutil.ReadFile(cfgPath)
+	Ok(t, err)
+	Assert(t, !strings.Contains(string(data), "hunter2") && !strings.Contains(string(data), "1234"), "expected no plain text secret in:\n%s", string(data))
+	Ok(t, viper.ReadInConfig())
+	profiles, err = loadProfiles()
+	Ok(t, err)
+	Assert(t, strings.HasPrefix(profiles[0].Passphrase, pki.PGPHeader), "expected an encrypted passphrase, got %s", profiles[0].Passphrase)
+	Ok(t, decryptProfile(&profiles[0]))
+	Equals(t, "hunter2", profiles[0].Passphrase)
+	Equals(t, "1234", profiles[0].PKCS11PIN)
+
+	// an encrypted setting that isn't a secret is refused when the profile is read
+	enc, err := encryptSetting(&profiles[0], "passphrase", "Dev Salt Master")
+	Ok(t, err)
+	other := GSPProfile{Name: "dev", GnupgHome: env.GnupgHome, DefaultKey: enc}
+	Equals(t, "'default_key' can't be encrypted", decryptProfile(&other).Error())
+}
+
+func TestProfilePassphrase(t *testing.T) {
+	env, _ := newTestPki(t)
+	defer os.RemoveAll(env.Dir)
+	if !env.GPGImported {
+		t.Skip("the test key could not be imported with gpg")
+	}
+	// a secring with the test key and a key protected by the passphrase 'right'
+	home := filepath.Join(env.Dir, "protected")
+	Ok(t, os.Mkdir(home, 0700))
+	gpg := func(args ...string) []byte {
+		out, err := exec.Command("gpg", append([]string{"--homedir", home, "--batch", "--pinentry-mode", "loopback", "--passphrase", "right"}, args...)...).Output() // #nosec G204
+		Ok(t, err)
+		return out
+	}
+	gpg("--quick-gen-key", "Protected Key <protected@example.com>", "rsa2048", "default", "never")
+	ring, err := ioutil.ReadFile(env.SecRing)
+	Ok(t, err)
+	secRing := filepath.Join(home, "secring.gpg")
+	Ok(t, ioutil.WriteFile(secRing, append(ring, gpg("--export-secret-keys")...), 0600))
+
+	encryptTo = env.KeyName
+	defer func() { encryptTo = "" }()
+	cfgDir := filepath.Join(env.Dir, ".config", "generate-secure-pillar")
+	Ok(t, os.MkdirAll(cfgDir, 0700))
+	writeProfile := func(passphrase string) {
+		enc, err := encryptSetting(&GSPProfile{Name: "dev", GnupgHome: env.GnupgHome}, "passphrase", passphrase)
+		Ok(t, err)
+		cfg := fmt.Sprintf("profiles:\n  - name: dev\n    default: true\n    gnupg_home: %s\n    passphrase: |\n      %s\n",
+			env.GnupgHome, strings.Replace(strings.TrimSpace(enc), "\n", "\n      ", -1))
+		Ok(t, ioutil.WriteFile(filepath.Join(cfgDir, "config.yaml"), []byte(cfg), 0600))
+	}
+	file := filepath.Join(env.PillarDir, "secrets.sls")
+
+	// a wrong profile passphrase fails, rather than being dropped with a warning
+	writeProfile("wrong")
+	out, code := runGsp(t, env, "", "--secring", secRing, "decrypt", "all", "-f", file)
+	Assert(t, code != 0, "expected a wrong profile passphrase to fail, got %s", out)
+	Assert(t, strings.Contains(out, "profile passphrase is wrong"), "expected the profile passphrase to be named, got %s", out)
+	Assert(t, !strings.Contains(out, "kept by 'unlock'"), "expected no warning about 'unlock', got %s", out)
+
+	writeProfile("right")
+	out, code = runGsp(t, env, "", "--secring", secRing, "decrypt", "all", "-f", file)
+	Equals(t, 0, code)
+	Assert(t, !strings.Contains(out, "passphrase"), "expected the profile passphrase to unlock the key, got %s", out)
+}
+
+func TestSSH(t *testing.T) {
+	env, _ := newTestPki(t)
+	defer os.RemoveAll(env.Dir)
+	bin := filepath.Join(env.Dir, "bin")
+	Ok(t, os.Mkdir(bin, 0700))
+	// the fake ssh records the host, the remote command split the way the remote shell
+	// splits it, and its stdin, then answers with the file named by GSP_SSH_REPLY
+	stub := fmt.Sprintf(`#!/bin/sh
+echo "$1" > %[1]s/host
+eval "set -- $2"
+printf '%%s\n' "$@" > %[1]s/remote
+cat > %[1]s/stdin
+cat "$GSP_SSH_REPLY"
+exit ${GSP_SSH_EXIT:-0}
+`, env.Dir)
+	Ok(t, ioutil.WriteFile(filepath.Join(bin, "ssh"), []byte(stub), 0700)) // #nosec G306
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	Ok(t, os.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH")))
+	reply := filepath.Join(env.Dir, "reply")
+	Ok(t, ioutil.WriteFile(reply, []byte("secret: plain text\n"), 0600))
+	Ok(t, os.Setenv("GSP_SSH_REPLY", reply))
+	defer os.Unsetenv("GSP_SSH_REPLY")
+	read := func(name string) string {
+		data, err := ioutil.ReadFile(filepath.Join(env.Dir, name))
+		Ok(t, err)
+		return string(data)
+	}
+
+	// the file is sent on stdin, local flags stay here and the others are quoted for the remote shell
+	file := filepath.Join(env.PillarDir, "secrets.sls")
+	sent, err := ioutil.ReadFile(file)
+	Ok(t, err)
+	out, code := runGsp(t, env, "", "--delimiter", "'", "decrypt", "all", "-f", file, "--ssh", "admin@salt-master")
+	Equals(t, 0, code)
+	Assert(t, strings.Contains(out, "secret: plain text"), "expected the remote output, got %s", out)
+	Equals(t, "admin@salt-master\n", read("host"))
+	Equals(t, "generate-secure-pillar\ndecrypt\n--delimiter='\n--pgp_key=Test Salt Master\nall\n", read("remote"))
+	Equals(t, string(sent), read("stdin"))
+
+	// the output of decrypt all is written here, with a different remote binary
+	outFile := filepath.Join(env.Dir, "plain.sls")
+	_, code = runGsp(t, env, "", "decrypt", "all", "-f", file, "-o", outFile, "--ssh", "salt-master", "--ssh-binary", "/opt/gsp/bin/gsp")
+	Equals(t, 0, code)
+	Equals(t, "secret: plain text\n", read("plain.sls"))
+	Assert(t, strings.HasPrefix(read("remote"), "/opt/gsp/bin/gsp\ndecrypt\n"), "expected the --ssh-binary, got %s", read("remote"))
+
+	// keys recurse sends each file and collects the reports
+	Ok(t, ioutil.WriteFile(reply, []byte(`{"count":1,"keys":[{"path":"secret"}]}`), 0600))
+	out, code = runGsp(t, env, "", "--format", "json", "keys", "recurse", "-d", env.PillarDir, "--ssh", "salt-master")
+	Equals(t, 0, code)
+	var reports []map[string]interface{}
+	Ok(t, json.Unmarshal([]byte(out[strings.Index(out, "["):]), &reports))
+	Assert(t, len(reports) > 1, "expected a report for each file, got %s", out)
+	Equals(t, float64(1), reports[0]["count"])
+	Assert(t, strings.HasPrefix(reports[0]["file"].(string), env.PillarDir), "expected the local file name, got %v", reports[0]["file"])
+	Assert(t, strings.HasSuffix(read("remote"), "\nall\n--format=json\n"), "unexpected remote command %s", read("remote"))
+
+	// the remote exit code is kept, and commands that can't run remotely are refused
+	Ok(t, os.Setenv("GSP_SSH_EXIT", "3"))
+	defer os.Unsetenv("GSP_SSH_EXIT")
+	_, code = runGsp(t, env, "", "decrypt", "all", "-f", file, "--ssh", "salt-master")
+	Equals(t, 3, code)
+	out, code = runGsp(t, env, "", "--yes", "decrypt", "recurse", "-d", env.PillarDir, "--ssh", "salt-master")
+	Equals(t, utils.ExitUsage, code)
+	Assert(t, strings.Contains(out, "can't be run on another host"), "expected the refusal, got %s", out)
+}
+
+func TestShellHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gsp-history")
+	Ok(t, err)
+	defer os.RemoveAll(dir)
+	defer os.Setenv("XDG_CONFIG_HOME", os.Getenv("XDG_CONFIG_HOME"))
+	Ok(t, os.Setenv("XDG_CONFIG_HOME", dir))
+
+	file := shellHistoryFile()
+	Equals(t, filepath.Join(dir, "generate-secure-pillar", "shell_history"), file)
+	noConfig = true
+	Equals(t, "", shellHistoryFile())
+	noConfig = false
+
+	// values given to set are never written, and only the last lines are kept
+	history := []string{shellHistoryEntry("cd secret_stuff"), shellHistoryEntry("set db_password hunter2")}
+	for i := 0; i < shellHistorySize; i++ {
+		history = append(history, "ls")
+	}
+	Ok(t, writeShellHistory(file, history[:2]))
+	Equals(t, []string{"cd secret_stuff", "set db_password"}, readShellHistory(file))
+	Ok(t, writeShellHistory(file, history))
+	Equals(t, shellHistorySize, len(readShellHistory(file)))
+
+	// the file is only readable by the user, even when it was there before
+	Ok(t, os.Chmod(file, 0644))
+	Ok(t, writeShellHistory(file, history[:1]))
+	info, err := os.Stat(file)
+	Ok(t, err)
+	Equals(t, os.FileMode(0600), info.Mode().Perm())
+
+	// a home directory that can't be written to is reported, no file means no history
+	blocked := filepath.Join(dir, "blocked")
+	Ok(t, ioutil.WriteFile(blocked, nil, 0600))
+	Assert(t, writeShellHistory(filepath.Join(blocked, "generate-secure-pillar", "shell_history"), history) != nil, "expected an error for a home that isn't a directory", nil)
+	Ok(t, writeShellHistory("", history))
+	Equals(t, []string(nil), readShellHistory(""))
+}
+
+func TestWalkLimits(t *testing.T) {
+	env, _ := newTestPki(t)
+	defer os.RemoveAll(env.Dir)
+
+	out, code := runGsp(t, env, "", "keys", "recurse", "-d", env.PillarDir, "--max-files", "1")
+	Equals(t, utils.ExitUsage, code)
+	Assert(t, strings.Contains(out, "more than --max-files 1 .sls files"), "expected the limit error, got %s", out)
+	out, code = runGsp(t, env, "", "encrypt", "recurse", "-d", env.PillarDir, "--max-depth", "1")
+	Equals(t, 0, code)
+	out, code = runGsp(t, env, "", "verify", "-d", env.PillarDir, "--max-depth", "1")
+	Assert(t, code != utils.ExitUsage, "expected the files to be within --max-depth 1, got %s", out)
+	_, code = runGsp(t, env, "", "keys", "recurse", "-d", env.PillarDir, "--max-depth", "-1")
+	Equals(t, utils.ExitUsage, code)
+}
+
+func TestWatchEvent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gsp-watch")
+	Ok(t, err)
+	defer os.RemoveAll(dir)
+	watcher, err := fsnotify.NewWatcher()
+	Ok(t, err)
+	defer watcher.Close()
+	Ok(t, watchTree(watcher, dir))
+
+	// a saved .sls file is pending, saving it again starts the delay over
+	pending := map[string]time.Time{}
+	file := filepath.Join(dir, "secrets.sls")
+	watchEvent(watcher, fsnotify.Event{Name: file, Op: fsnotify.Create}, pending)
+	first, ok := pending[file]
+	Assert(t, ok, "expected %s to be pending", file)
+	time.Sleep(10 * time.Millisecond)
+	watchEvent(watcher, fsnotify.Event{Name: file, Op: fsnotify.Write}, pending)
+	Assert(t, pending[file].After(first), "expected a second save to start the delay over", pending)
+
+	// other files aren't encrypted
+	watchEvent(watcher, fsnotify.Event{Name: filepath.Join(dir, "notes.txt"), Op: fsnotify.Write}, pending)
+	Equals(t, 1, len(pending))
+
+	// a file that is removed, renamed away, or only has its mode changed isn't encrypted
+	for _, op := range []fsnotify.Op{fsnotify.Remove, fsnotify.Rename, fsnotify.Chmod} {
+		pending[file] = time.Now()
+		watchEvent(watcher, fsnotify.Event{Name: file, Op: op}, pending)
+		Equals(t, map[string]time.Time{}, pending)
+	}
+
+	// a new directory is watched, with the directories under it
+	nested := filepath.Join(dir, "app", "db")
+	Ok(t, os.MkdirAll(nested, 0700))
+	watchEvent(watcher, fsnotify.Event{Name: filepath.Join(dir, "app"), Op: fsnotify.Create}, pending)
+	Equals(t, map[string]time.Time{}, pending)
+	saved := filepath.Join(nested, "db.sls")
+	Ok(t, ioutil.WriteFile(saved, []byte("db:\n  password: hunter2\n"), 0600))
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case event := <-watcher.Events:
+			if event.Name != saved {
+				continue
+			}
+		case err := <-watcher.Errors:
+			Ok(t, err)
+		case <-timeout:
+			t.Fatalf("no event for %s in a new directory", saved)
+		}
+		break
+	}
+}
+
+func TestEncryptWatched(t *testing.T) {
+	env, pk := newTestPki(t)
+	defer env.Remove()
+
+	// values tagged !file are read from their files and encrypted, without changing the defaults
+	Ok(t, ioutil.WriteFile(filepath.Join(env.PillarDir, "db.txt"), []byte("hunter2\n"), 0600))
+	file := filepath.Join(env.PillarDir, "tagged.sls")
+	Ok(t, ioutil.WriteFile(file, []byte("db:\n  password: !file db.txt\n  user: salt\n"), 0600))
+	encryptWatched(file, pk)
+	Equals(t, false, sls.DefaultOptions.ResolveFiles)
+	s := sls.New(file, pk, topLevelElement)
+	Ok(t, s.Error)
+	Equals(t, []string(nil), s.PlainValues())
+	buf, err := ioutil.ReadFile(file)
+	Ok(t, err)
+	Assert(t, strings.Contains(string(buf), pki.PGPHeader), "expected %s to be encrypted: %s", file, string(buf))
+	Assert(t, !strings.Contains(string(buf), "db.txt"), "expected the !file tag to be replaced: %s", string(buf))
+
+	// a file without plain text values isn't written again
+	Ok(t, os.Chtimes(file, time.Unix(0, 0), time.Unix(0, 0)))
+	encryptWatched(file, pk)
+	info, err := os.Stat(file)
+	Ok(t, err)
+	Equals(t, time.Unix(0, 0), info.ModTime())
+	unchanged, err := ioutil.ReadFile(file)
+	Ok(t, err)
+	Equals(t, string(buf), string(unchanged))
+
+	// a file that can't be read is left for the next save
+	encryptWatched(filepath.Join(env.PillarDir, "missing.sls"), pk)
+	_, err = os.Stat(filepath.Join(env.PillarDir, "missing.sls"))
+	Assert(t, os.IsNotExist(err), "expected a missing file not to be written", err)
+}
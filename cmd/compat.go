@@ -0,0 +1,127 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// The command line was first built with urfave/cli, these keep the spellings that
+// scripts written for it use working, the commands have its short names as aliases.
+
+// legacyFlagNames are the flag spellings urfave/cli accepted, by the names they have now
+var legacyFlagNames = map[string]string{
+	"prof":     "profile",
+	"pgp-key":  "pgp_key",
+	"pgpkey":   "pgp_key",
+	"out-file": "outfile",
+	"in-file":  "file",
+}
+
+var debugLog bool
+
+func init() {
+	rootCmd.SetGlobalNormalizationFunc(legacyFlagName)
+	rootCmd.PersistentFlags().BoolVar(&debugLog, "debug", false, "adds line number info to log output")
+	cobra.OnInitialize(func() {
+		if debugLog {
+			logger.SetReportCaller(true)
+			logger.SetLevel(logrus.DebugLevel)
+		}
+	})
+}
+
+// legacyHelpAlias gives the help command its urfave/cli short name too
+func legacyHelpAlias() {
+	rootCmd.InitDefaultHelpCmd()
+	for _, cmd := range rootCmd.Commands() {
+		if cmd.Name() == "help" {
+			cmd.Aliases = append(cmd.Aliases, "h")
+		}
+	}
+}
+
+// legacyFlagName normalizes the old spellings of flags
+func legacyFlagName(f *pflag.FlagSet, name string) pflag.NormalizedName {
+	if current, ok := legacyFlagNames[name]; ok {
+		return pflag.NormalizedName(current)
+	}
+	return pflag.NormalizedName(name)
+}
+
+// legacyArgs turns long flags written with one dash, as urfave/cli (like the flag package)
+// accepted them, e.g. -file, into --file, the values of flags are left as they are
+func legacyArgs(args []string) []string {
+	flags := map[string]*pflag.Flag{}
+	shorthands := map[string]*pflag.Flag{}
+	collectFlags(rootCmd, flags, shorthands)
+
+	out := make([]string, len(args))
+	takesValue := false
+	for i, arg := range args {
+		out[i] = arg
+		if arg == "--" {
+			copy(out[i:], args[i:])
+			break
+		}
+		if takesValue {
+			// the value of the flag before it
+			takesValue = false
+			continue
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			continue
+		}
+		long := strings.HasPrefix(arg, "--")
+		name := strings.TrimLeft(arg, "-")
+		hasValue := strings.Contains(name, "=")
+		if hasValue {
+			name = name[:strings.Index(name, "=")]
+		}
+		flag := flags[string(legacyFlagName(nil, name))]
+		if !long && len(name) > 1 && flag != nil {
+			out[i] = "-" + arg
+		} else if !long {
+			flag = shorthands[name]
+		}
+		takesValue = flag != nil && !hasValue && flag.NoOptDefVal == ""
+	}
+	return out
+}
+
+// collectFlags lists the flags of a command and the commands under it
+func collectFlags(cmd *cobra.Command, flags map[string]*pflag.Flag, shorthands map[string]*pflag.Flag) {
+	add := func(f *pflag.Flag) {
+		flags[f.Name] = f
+		if f.Shorthand != "" {
+			shorthands[f.Shorthand] = f
+		}
+	}
+	cmd.Flags().VisitAll(add)
+	cmd.PersistentFlags().VisitAll(add)
+	for _, sub := range cmd.Commands() {
+		collectFlags(sub, flags, shorthands)
+	}
+}
@@ -0,0 +1,1260 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Everbridge/generate-secure-pillar/metrics"
+	"github.com/Everbridge/generate-secure-pillar/output"
+	"github.com/Everbridge/generate-secure-pillar/pki"
+	"github.com/Everbridge/generate-secure-pillar/sls"
+	"github.com/Everbridge/generate-secure-pillar/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	tilde "gopkg.in/mattes/go-expand-tilde.v1"
+	yaml "gopkg.in/yaml.v3"
+)
+
+// GSPProfile is a named set of defaults kept in the config file
+type GSPProfile struct {
+	Name               string   `mapstructure:"name" yaml:"name" json:"name"`
+	Default            bool     `mapstructure:"default" yaml:"default" json:"default"`
+	DefaultKey         string   `mapstructure:"default_key" yaml:"default_key,omitempty" json:"default_key,omitempty"`
+	GnupgHome          string   `mapstructure:"gnupg_home" yaml:"gnupg_home,omitempty" json:"gnupg_home,omitempty"`
+	DefaultPubRing     string   `mapstructure:"default_pub_ring" yaml:"default_pub_ring,omitempty" json:"default_pub_ring,omitempty"`
+	DefaultSecRing     string   `mapstructure:"default_sec_ring" yaml:"default_sec_ring,omitempty" json:"default_sec_ring,omitempty"`
+	Backend            string   `mapstructure:"backend" yaml:"backend,omitempty" json:"backend,omitempty"`
+	Cipher             string   `mapstructure:"cipher" yaml:"cipher,omitempty" json:"cipher,omitempty"`
+	AllowedKeys        []string `mapstructure:"allowed_keys" yaml:"allowed_keys,omitempty" json:"allowed_keys,omitempty"`
+	DefaultElement     string   `mapstructure:"default_element" yaml:"default_element,omitempty" json:"default_element,omitempty"`
+	DefaultOutput      string   `mapstructure:"default_output" yaml:"default_output,omitempty" json:"default_output,omitempty"`
+	MaxValueSize       int      `mapstructure:"max_value_size" yaml:"max_value_size,omitempty" json:"max_value_size,omitempty"`
+	ChunkSize          int      `mapstructure:"chunk_size" yaml:"chunk_size,omitempty" json:"chunk_size,omitempty"`
+	MaxDepth           int      `mapstructure:"max_depth" yaml:"max_depth,omitempty" json:"max_depth,omitempty"`
+	MaxFiles           int      `mapstructure:"max_files" yaml:"max_files,omitempty" json:"max_files,omitempty"`
+	MaxConcurrency     int      `mapstructure:"max_concurrency" yaml:"max_concurrency,omitempty" json:"max_concurrency,omitempty"`
+	Retries            int      `mapstructure:"retries" yaml:"retries,omitempty" json:"retries,omitempty"`
+	RetryBackoff       string   `mapstructure:"retry_backoff" yaml:"retry_backoff,omitempty" json:"retry_backoff,omitempty"`
+	BatchSize          int      `mapstructure:"batch_size" yaml:"batch_size,omitempty" json:"batch_size,omitempty"`
+	SecretKeyFrom      string   `mapstructure:"secret_key_from" yaml:"secret_key_from,omitempty" json:"secret_key_from,omitempty"`
+	PassphraseFrom     string   `mapstructure:"passphrase_from" yaml:"passphrase_from,omitempty" json:"passphrase_from,omitempty"`
+	Passphrase         string   `mapstructure:"passphrase" yaml:"passphrase,omitempty" json:"passphrase,omitempty"`
+	DecryptDirs        []string `mapstructure:"decrypt_dirs" yaml:"decrypt_dirs,omitempty" json:"decrypt_dirs,omitempty"`
+	EscrowKey          string   `mapstructure:"escrow_key" yaml:"escrow_key,omitempty" json:"escrow_key,omitempty"`
+	NaclPkFile         string   `mapstructure:"nacl_pk_file" yaml:"nacl_pk_file,omitempty" json:"nacl_pk_file,omitempty"`
+	NaclSkFile         string   `mapstructure:"nacl_sk_file" yaml:"nacl_sk_file,omitempty" json:"nacl_sk_file,omitempty"`
+	NaclBoxType        string   `mapstructure:"nacl_box_type" yaml:"nacl_box_type,omitempty" json:"nacl_box_type,omitempty"`
+	PKCS11Module       string   `mapstructure:"pkcs11_module" yaml:"pkcs11_module,omitempty" json:"pkcs11_module,omitempty"`
+	PKCS11Slot         string   `mapstructure:"pkcs11_slot" yaml:"pkcs11_slot,omitempty" json:"pkcs11_slot,omitempty"`
+	PKCS11KeyID        string   `mapstructure:"pkcs11_key_id" yaml:"pkcs11_key_id,omitempty" json:"pkcs11_key_id,omitempty"`
+	PKCS11PINFrom      string   `mapstructure:"pkcs11_pin_from" yaml:"pkcs11_pin_from,omitempty" json:"pkcs11_pin_from,omitempty"`
+	PKCS11PIN          string   `mapstructure:"pkcs11_pin" yaml:"pkcs11_pin,omitempty" json:"pkcs11_pin,omitempty"`
+	KeyOrder           string   `mapstructure:"key_order" yaml:"key_order,omitempty" json:"key_order,omitempty"`
+	Indent             int      `mapstructure:"indent" yaml:"indent,omitempty" json:"indent,omitempty"`
+	ArmorWidth         int      `mapstructure:"armor_width" yaml:"armor_width,omitempty" json:"armor_width,omitempty"`
+	HashComments       bool     `mapstructure:"hash_comments" yaml:"hash_comments,omitempty" json:"hash_comments,omitempty"`
+	KeepQuotes         bool     `mapstructure:"keep_quotes" yaml:"keep_quotes,omitempty" json:"keep_quotes,omitempty"`
+	PreserveExact      bool     `mapstructure:"preserve_exact" yaml:"preserve_exact,omitempty" json:"preserve_exact,omitempty"`
+	FailOnDuplicates   bool     `mapstructure:"fail_on_duplicate_keys" yaml:"fail_on_duplicate_keys,omitempty" json:"fail_on_duplicate_keys,omitempty"`
+	Schema             string   `mapstructure:"schema" yaml:"schema,omitempty" json:"schema,omitempty"`
+	WarnOutsideElement bool     `mapstructure:"warn_outside_element" yaml:"warn_outside_element,omitempty" json:"warn_outside_element,omitempty"`
+	ForbiddenPatterns  []string `mapstructure:"forbidden_patterns" yaml:"forbidden_patterns,omitempty" json:"forbidden_patterns,omitempty"`
+	DisabledCommands   []string `mapstructure:"disabled_commands" yaml:"disabled_commands,omitempty" json:"disabled_commands,omitempty"`
+	AuditLog           string   `mapstructure:"audit_log" yaml:"audit_log,omitempty" json:"audit_log,omitempty"`
+}
+
+// output modes for default_output
+const outputStdout = "stdout"
+const outputUpdate = "update"
+
+// backends that can be named in a profile, false if not available in this build
+var knownBackends = map[string]bool{
+	pki.PGPBackendName:    true,
+	pki.GPGBackendName:    true,
+	pki.NaclBackendName:   true,
+	pki.PKCS11BackendName: !pki.EncryptOnly,
+	"age":                 false,
+	"kms":                 false,
+	"vault":               false,
+}
+
+// settings that can hold a PGP message encrypted for the user's own key, decrypted
+// with gpg when the profile is read, credentials for new backends belong here too
+var secretSettings = map[string]bool{
+	"secret_key_from": true,
+	"passphrase_from": true,
+	"pkcs11_pin_from": true,
+	"passphrase":      true,
+	"pkcs11_pin":      true,
+}
+
+// settings that hold the secret itself, which are only read encrypted, and the
+// setting naming where the secret is kept that can't be used with them
+var encryptedOnlySettings = map[string]string{
+	"passphrase": "passphrase_from",
+	"pkcs11_pin": "pkcs11_pin_from",
+}
+
+// the key to encrypt a setting for with 'config set'
+var encryptTo string
+
+// the profile in use, if any
+var activeProfile *GSPProfile
+
+// profiles from the system config file, these are not written to the user's file
+var systemProfiles []GSPProfile
+
+// EnvConventions maps an --env name to a directory, element, and profile,
+// "{env}" in a value is replaced with the environment name
+type EnvConventions struct {
+	Directory string `mapstructure:"directory"`
+	Element   string `mapstructure:"element"`
+	Profile   string `mapstructure:"profile"`
+}
+
+// the conventions used when env_conventions is not in the config file
+var defaultEnvConventions = EnvConventions{
+	Directory: "{env}",
+	Element:   "{env}_secure_vars",
+	Profile:   "{env}",
+}
+
+// the --env conventions with the environment name filled in
+var envSettings *EnvConventions
+
+// set if the profiles could not be read, reported when keys are needed
+var profileErr error
+
+// the decrypted passphrase and pkcs11_pin of the active profile, used when no
+// --passphrase-from or --pkcs11-pin-from is given
+var profilePassphrase string
+var profilePIN string
+
+// configCmd represents the config command
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "manage and validate the config file",
+	Run: func(cmd *cobra.Command, args []string) {
+		err := cmd.Help()
+		if err != nil {
+			logger.Fatal(err)
+		}
+	},
+}
+
+// configValidateCmd represents the config validate command
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "check the config file for errors",
+	Run: func(cmd *cobra.Command, args []string) {
+		problems := validateConfig(viper.Get("profiles"))
+		if len(problems) == 0 {
+			fmt.Printf("%s: OK\n", viper.ConfigFileUsed())
+			return
+		}
+		for _, problem := range problems {
+			fmt.Printf("%s: %s\n", viper.ConfigFileUsed(), problem)
+		}
+		metrics.Exit(utils.ExitConfig)
+	},
+}
+
+// configListCmd represents the config list command
+var configListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "list the profiles in the config file, the default is marked with '*'",
+	Run: func(cmd *cobra.Command, args []string) {
+		profiles, err := loadProfiles()
+		if err != nil {
+			logger.Fatalf("config list: %s", err)
+		}
+		if structuredOutput() {
+			summaries := []output.ProfileSummary{}
+			for _, p := range profiles {
+				summaries = append(summaries, output.ProfileSummary{Name: p.Name, Default: p.Default, Backend: p.Backend})
+			}
+			writeOutput(summaries)
+			return
+		}
+		for _, p := range profiles {
+			mark := " "
+			if p.Default {
+				mark = "*"
+			}
+			fmt.Printf("%s %s\n", mark, p.Name)
+		}
+	},
+}
+
+// configShowCmd represents the config show command
+var configShowCmd = &cobra.Command{
+	Use:   "show [profile]",
+	Short: "show the settings of a profile (defaults to the profile in use)",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 {
+			if activeProfile == nil {
+				logger.Fatalf("config show: no profile selected and no default profile in %s", configFilePath())
+			}
+			args = append(args, activeProfile.Name)
+		}
+		// read again, encrypted settings are shown as they are in the file
+		profiles, err := loadProfiles()
+		if err != nil {
+			logger.Fatalf("config show: %s", err)
+		}
+		p := findProfile(profiles, args[0])
+		if p == nil {
+			logger.Fatalf("config show: no profile named '%s'", args[0])
+		}
+		if outputFormat == output.JSON {
+			writeOutput(p)
+			return
+		}
+		out, err := yaml.Marshal(p)
+		if err != nil {
+			logger.Fatalf("config show: %s", err)
+		}
+		fmt.Print(string(out))
+	},
+}
+
+// configAddProfileCmd represents the config add-profile command
+var configAddProfileCmd = &cobra.Command{
+	Use:     "add-profile <name> [setting=value ...]",
+	Short:   "add a profile to the config file",
+	Example: `$ generate-secure-pillar config add-profile prod default_key="Prod Salt Master" backend=gpg cipher=aes256`,
+	Args:    cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		profiles, err := loadProfiles()
+		if err != nil {
+			logger.Fatalf("config add-profile: %s", err)
+		}
+		if profiles, err = addProfile(profiles, args[0], args[1:]); err != nil {
+			logger.Fatalf("config add-profile: %s", err)
+		}
+		if err = saveProfiles(profiles); err != nil {
+			logger.Fatalf("config add-profile: %s", err)
+		}
+	},
+}
+
+// configSetCmd represents the config set command
+var configSetCmd = &cobra.Command{
+	Use:   "set <profile> <setting> <value>",
+	Short: "change a setting in a profile, list values are comma separated",
+	Example: `$ generate-secure-pillar config set dev default_key "Dev Salt Master"
+$ generate-secure-pillar config set --encrypt-to me@example.com dev passphrase_from env://DEV_PASSPHRASE`,
+	Args: cobra.ExactArgs(3),
+	Run: func(cmd *cobra.Command, args []string) {
+		profiles, err := loadProfiles()
+		if err != nil {
+			logger.Fatalf("config set: %s", err)
+		}
+		if err = setProfile(profiles, args[0], args[1], args[2]); err != nil {
+			logger.Fatalf("config set: %s", err)
+		}
+		if err = saveProfiles(profiles); err != nil {
+			logger.Fatalf("config set: %s", err)
+		}
+	},
+}
+
+// configUseCmd represents the config use command
+var configUseCmd = &cobra.Command{
+	Use:   "use <profile>",
+	Short: "make the given profile the default",
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		profiles, err := loadProfiles()
+		if err != nil {
+			logger.Fatalf("config use: %s", err)
+		}
+		if err = useProfile(profiles, args[0]); err != nil {
+			logger.Fatalf("config use: %s", err)
+		}
+		if err = saveProfiles(profiles); err != nil {
+			logger.Fatalf("config use: %s", err)
+		}
+	},
+}
+
+// configInitCmd represents the config init command
+var configInitCmd = &cobra.Command{
+	Use:   "init",
+	Short: "interactively create a profile from the local GnuPG setup",
+	Run: func(cmd *cobra.Command, args []string) {
+		profiles, err := loadProfiles()
+		if err != nil {
+			logger.Fatalf("config init: %s", err)
+		}
+		p, err := buildProfile(bufio.NewReader(os.Stdin), profiles)
+		if err != nil {
+			logger.Fatalf("config init: %s", err)
+		}
+		if err = saveProfiles(append(profiles, p)); err != nil {
+			logger.Fatalf("config init: %s", err)
+		}
+		fmt.Printf("added profile '%s' to %s\n", p.Name, configFilePath())
+	},
+}
+
+// configStorePassphraseCmd represents the config store-passphrase command
+var configStorePassphraseCmd = &cobra.Command{
+	Use:   "store-passphrase <profile>",
+	Short: "store the secring passphrase of a profile in the macOS Keychain or the Secret Service (libsecret)",
+	Long: `Prompts for the passphrase (or reads it from STDIN when piped), stores it in the
+OS keychain under the service 'generate-secure-pillar' with the profile name as the
+account, and points the profile's passphrase_from at it.`,
+	Example: `$ generate-secure-pillar config store-passphrase prod`,
+	Args:    cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		profiles, err := loadProfiles()
+		if err != nil {
+			logger.Fatalf("config store-passphrase: %s", err)
+		}
+		p := findProfile(profiles, args[0])
+		if p == nil {
+			logger.Fatalf("config store-passphrase: no profile named '%s'", args[0])
+		}
+
+		var secret []byte
+		if stdinIsPiped() {
+			secret, err = ioutil.ReadAll(os.Stdin)
+			secret = []byte(strings.TrimRight(string(secret), "\r\n"))
+		} else {
+			secret, err = pki.PromptSecret(fmt.Sprintf("passphrase for profile '%s'", p.Name))
+		}
+		if err != nil {
+			logger.Fatalf("config store-passphrase: %s", err)
+		}
+		if len(secret) == 0 {
+			logger.Fatalf("config store-passphrase: empty passphrase")
+		}
+
+		uri := pki.KeychainURI(pki.KeychainService, p.Name)
+		if err = pki.StoreKeychain(uri, secret); err != nil {
+			logger.Fatalf("config store-passphrase: %s", err)
+		}
+		p.PassphraseFrom = uri
+		if err = saveProfiles(profiles); err != nil {
+			logger.Fatalf("config store-passphrase: %s", err)
+		}
+		fmt.Printf("stored the passphrase for profile '%s', passphrase_from is %s\n", p.Name, uri)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configValidateCmd)
+	configCmd.AddCommand(configListCmd)
+	configCmd.AddCommand(configShowCmd)
+	configCmd.AddCommand(configAddProfileCmd)
+	configCmd.AddCommand(configSetCmd)
+	configCmd.AddCommand(configUseCmd)
+	configCmd.AddCommand(configInitCmd)
+	configCmd.AddCommand(configStorePassphraseCmd)
+	configSetCmd.Flags().StringVar(&encryptTo, "encrypt-to", "", "encrypt the value for this (your own) PGP key with gpg, for "+strings.Join(secretSettingNames(), ", "))
+}
+
+// configFilePath returns the config file in use, or where it would be by default
+func configFilePath() string {
+	if used := viper.ConfigFileUsed(); used != "" {
+		return used
+	}
+	if cfgFile != "" {
+		return cfgFile
+	}
+	dir, err := userConfigDir()
+	if err != nil {
+		logger.Fatal(err)
+	}
+	return filepath.Join(dir, "config.yaml")
+}
+
+// mergeProfileLists adds the system profiles that the user has not overridden,
+// a default profile in the user's file replaces any system default
+func mergeProfileLists(sysList []interface{}, userList []interface{}) []interface{} {
+	names := map[string]bool{}
+	userDefault := false
+	for _, item := range userList {
+		if prof, ok := toStringMap(item); ok {
+			if name, ok := prof["name"].(string); ok {
+				names[name] = true
+			}
+			userDefault = userDefault || prof["default"] == true
+		}
+	}
+
+	var merged []interface{}
+	for _, item := range sysList {
+		prof, ok := toStringMap(item)
+		if !ok {
+			merged = append(merged, item)
+			continue
+		}
+		if name, ok := prof["name"].(string); ok && names[name] {
+			continue
+		}
+		if userDefault && prof["default"] == true {
+			copied := make(map[string]interface{}, len(prof))
+			for k, v := range prof {
+				copied[k] = v
+			}
+			copied["default"] = false
+			prof = copied
+		}
+		merged = append(merged, prof)
+	}
+
+	return append(merged, userList...)
+}
+
+// userProfiles removes the system profiles that have not been changed
+func userProfiles(profiles []GSPProfile) []GSPProfile {
+	var res []GSPProfile
+	for _, p := range profiles {
+		if sp := findProfile(systemProfiles, p.Name); sp != nil {
+			// a system default may have been replaced by one of the user's profiles
+			cp := *sp
+			cp.Default = cp.Default && p.Default
+			if reflect.DeepEqual(cp, p) {
+				continue
+			}
+		}
+		res = append(res, p)
+	}
+	return res
+}
+
+func findProfile(profiles []GSPProfile, name string) *GSPProfile {
+	for i := range profiles {
+		if profiles[i].Name == name {
+			return &profiles[i]
+		}
+	}
+	return nil
+}
+
+// setProfileValue sets a profile field by its config file name
+func setProfileValue(p *GSPProfile, setting string, value string) error {
+	v := reflect.ValueOf(p).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get("mapstructure") != setting {
+			continue
+		}
+		field := v.Field(i)
+		switch field.Kind() {
+		case reflect.Bool:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("'%s' must be true or false", setting)
+			}
+			field.SetBool(b)
+		case reflect.Int:
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("'%s' must be a number", setting)
+			}
+			field.SetInt(int64(n))
+		case reflect.Slice:
+			var list []string
+			for _, item := range strings.Split(value, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					list = append(list, item)
+				}
+			}
+			field.Set(reflect.ValueOf(list))
+		default:
+			field.SetString(value)
+		}
+		return nil
+	}
+
+	msg := fmt.Sprintf("unknown setting '%s'", setting)
+	if guess := closestName(setting, profileFields()); guess != "" {
+		msg = fmt.Sprintf("%s (did you mean '%s'?)", msg, guess)
+	}
+	return fmt.Errorf("%s", msg)
+}
+
+// addProfile returns the profiles with a new one built from setting=value arguments,
+// the first profile added becomes the default
+func addProfile(profiles []GSPProfile, name string, settings []string) ([]GSPProfile, error) {
+	if findProfile(profiles, name) != nil {
+		return profiles, fmt.Errorf("profile '%s' already exists", name)
+	}
+
+	p := GSPProfile{Name: name, Default: len(profiles) == 0}
+	for _, arg := range settings {
+		parts := strings.SplitN(arg, "=", 2)
+		if len(parts) != 2 {
+			return profiles, fmt.Errorf("invalid setting '%s', expected setting=value", arg)
+		}
+		if err := setProfileValue(&p, parts[0], parts[1]); err != nil {
+			return profiles, err
+		}
+	}
+	return append(profiles, p), nil
+}
+
+// setProfile changes a setting of the named profile, encrypting it first with --encrypt-to
+func setProfile(profiles []GSPProfile, name string, setting string, value string) error {
+	p := findProfile(profiles, name)
+	if p == nil {
+		return fmt.Errorf("no profile named '%s'", name)
+	}
+	if encryptTo != "" {
+		var err error
+		if value, err = encryptSetting(p, setting, value); err != nil {
+			return err
+		}
+	}
+	return setProfileValue(p, setting, value)
+}
+
+// useProfile makes the named profile the only default
+func useProfile(profiles []GSPProfile, name string) error {
+	if findProfile(profiles, name) == nil {
+		return fmt.Errorf("no profile named '%s'", name)
+	}
+	for i := range profiles {
+		profiles[i].Default = profiles[i].Name == name
+	}
+	return nil
+}
+
+// saveProfiles validates the profiles and writes them to the config file,
+// any other top level settings in the file are kept along with their comments
+func saveProfiles(profiles []GSPProfile) error {
+	out, err := yaml.Marshal(profiles)
+	if err != nil {
+		return err
+	}
+	var raw interface{}
+	if err = yaml.Unmarshal(out, &raw); err != nil {
+		return err
+	}
+	if problems := validateConfig(raw); len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+
+	cfgPath := configFilePath()
+	data, err := ioutil.ReadFile(filepath.Clean(cfgPath))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	var doc yaml.Node
+	if err = yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("unable to parse %s: %s", cfgPath, err)
+	}
+	if len(doc.Content) == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return fmt.Errorf("unable to update %s: the top level must be a map of settings", cfgPath)
+	}
+	out, err = yaml.Marshal(userProfiles(profiles))
+	if err != nil {
+		return err
+	}
+	var list yaml.Node
+	if err = yaml.Unmarshal(out, &list); err != nil {
+		return err
+	}
+	setMapValue(root, "profiles", list.Content[0])
+
+	out, err = yaml.Marshal(&doc)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(cfgPath), 0700); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(cfgPath, out, 0600)
+}
+
+// setMapValue replaces the value of key in a mapping node, or adds it at the end
+func setMapValue(m *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			m.Content[i+1] = value
+			return
+		}
+	}
+	m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+}
+
+// buildProfile prompts for the profile settings, using the local GnuPG setup for defaults
+func buildProfile(in *bufio.Reader, profiles []GSPProfile) (GSPProfile, error) {
+	p := GSPProfile{Default: len(profiles) == 0}
+
+	name := "default"
+	if len(profiles) > 0 {
+		name = ""
+	}
+	p.Name = prompt(in, "profile name", name)
+	if p.Name == "" {
+		return p, fmt.Errorf("a profile name is required")
+	}
+	if findProfile(profiles, p.Name) != nil {
+		return p, fmt.Errorf("profile '%s' already exists", p.Name)
+	}
+
+	home := gnupgHome
+	if home == "" {
+		home = "~/.gnupg"
+	}
+	p.GnupgHome = prompt(in, "GnuPG home", home)
+
+	// GnuPG 2.1+ keeps keys in a keybox that only the gpg backend can read
+	backend := pki.PGPBackendName
+	dir, err := tilde.Expand(p.GnupgHome)
+	if err != nil {
+		return p, err
+	}
+	if _, err = os.Stat(filepath.Join(dir, "pubring.gpg")); os.IsNotExist(err) {
+		if _, err = os.Stat(filepath.Join(dir, "pubring.kbx")); err == nil {
+			backend = pki.GPGBackendName
+		}
+	}
+	p.Backend = prompt(in, "backend (pgp or gpg)", backend)
+	if p.Backend == pki.PGPBackendName {
+		p.DefaultPubRing = filepath.Join(p.GnupgHome, "pubring.gpg")
+		p.DefaultSecRing = filepath.Join(p.GnupgHome, "secring.gpg")
+	}
+
+	var keys []pki.KeyListing
+	if g, err := pki.NewGPGBackend("", p.GnupgHome); err == nil {
+		keys, _ = g.SecretKeys()
+	}
+	for i, key := range keys {
+		fmt.Printf("  %d) %s %s\n", i+1, key.Fingerprint, key.UID)
+	}
+	choice := ""
+	if len(keys) > 0 {
+		choice = "1"
+	}
+	p.DefaultKey = prompt(in, "key to use (number from the list, or a key name, email, or ID)", choice)
+	if n, err := strconv.Atoi(p.DefaultKey); err == nil && n > 0 && n <= len(keys) {
+		p.DefaultKey = keys[n-1].UID
+		p.AllowedKeys = []string{keys[n-1].Fingerprint}
+	}
+	if p.DefaultKey == "" {
+		return p, fmt.Errorf("a key is required")
+	}
+
+	if len(profiles) > 0 {
+		answer := strings.ToLower(prompt(in, "make this the default profile (y/n)", "n"))
+		p.Default = answer == "y" || answer == "yes" || answer == "true"
+		if p.Default {
+			for i := range profiles {
+				profiles[i].Default = false
+			}
+		}
+	}
+
+	return p, nil
+}
+
+// prompt reads a line of input, returning def if nothing is entered
+func prompt(in *bufio.Reader, question string, def string) string {
+	if def != "" {
+		fmt.Printf("%s [%s]: ", question, def)
+	} else {
+		fmt.Printf("%s: ", question)
+	}
+	answer, _ := in.ReadString('\n')
+	answer = strings.TrimSpace(answer)
+	if answer == "" {
+		return def
+	}
+	return answer
+}
+
+// loadProfiles decodes the profiles from the config file
+func loadProfiles() ([]GSPProfile, error) {
+	var profiles []GSPProfile
+
+	if !viper.IsSet("profiles") {
+		return profiles, nil
+	}
+	if err := viper.UnmarshalKey("profiles", &profiles); err != nil {
+		return profiles, fmt.Errorf("unable to read profiles: %s (run 'config validate' for details)", err)
+	}
+
+	return profiles, nil
+}
+
+// readProfile finds the selected (or default) profile, errors are kept until
+// a key is needed so that the config subcommands can still run
+func readProfile() {
+	profiles, err := loadProfiles()
+	if err != nil {
+		profileErr = err
+		return
+	}
+	profName := rootCmd.Flag("profile").Value.String()
+	if envSettings != nil && profName == "" {
+		profName = envSettings.Profile
+	}
+
+	for i := range profiles {
+		if profName == profiles[i].Name || (profName == "" && profiles[i].Default) {
+			activeProfile = &profiles[i]
+			break
+		}
+	}
+	if activeProfile == nil {
+		if profName != "" {
+			profileErr = fmt.Errorf("no profile named '%s' in %s", profName, viper.ConfigFileUsed())
+		}
+		return
+	}
+
+	if err = decryptProfile(activeProfile); err != nil {
+		profileErr = fmt.Errorf("profile '%s': %s", activeProfile.Name, err)
+		return
+	}
+	applyProfile(activeProfile)
+}
+
+// encryptSetting encrypts the value of a setting for the --encrypt-to key with gpg
+func encryptSetting(p *GSPProfile, setting string, value string) (string, error) {
+	if !secretSettings[setting] {
+		return value, fmt.Errorf("'%s' can't be encrypted, only %s can", setting, strings.Join(secretSettingNames(), ", "))
+	}
+	gpg, err := pki.NewGPGBackend(encryptTo, p.GnupgHome)
+	if err != nil {
+		return value, err
+	}
+	return gpg.EncryptSecret(value)
+}
+
+// secretSettingNames lists the settings that can be encrypted
+func secretSettingNames() []string {
+	names := make([]string, 0, len(secretSettings))
+	for name := range secretSettings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// readEnvConventions fills in the --env conventions from the config file (or the defaults)
+func readEnvConventions() {
+	if envName == "" {
+		return
+	}
+
+	conv := defaultEnvConventions
+	if err := viper.UnmarshalKey("env_conventions", &conv); err != nil {
+		exitWithf(utils.ExitConfig, "config error: unable to read env_conventions: %s", err)
+	}
+	envSettings = &EnvConventions{
+		Directory: strings.Replace(conv.Directory, "{env}", envName, -1),
+		Element:   strings.Replace(conv.Element, "{env}", envName, -1),
+		Profile:   strings.Replace(conv.Profile, "{env}", envName, -1),
+	}
+}
+
+// applyEnvConventions sets the element from the --env conventions unless it was
+// given on the command line or by the profile
+func applyEnvConventions() {
+	if envSettings == nil || rootCmd.PersistentFlags().Changed("element") {
+		return
+	}
+	if activeProfile != nil && activeProfile.DefaultElement != "" {
+		return
+	}
+	topLevelElement = envSettings.Element
+}
+
+// recurseDirectory returns the --dir value, or the --env directory when no --file is given
+func recurseDirectory(cmd *cobra.Command) string {
+	if recurseDir != "" || envSettings == nil || cmd.Flags().Changed("file") {
+		return recurseDir
+	}
+	return envSettings.Directory
+}
+
+// checkEnvPath warns when a file is outside of the --env directory
+func checkEnvPath(file string) {
+	if envSettings == nil || envSettings.Directory == "" || file == os.Stdin.Name() || file == os.Stdout.Name() || file == "" || sls.IsRemote(file) {
+		return
+	}
+	dir, err := filepath.Abs(envSettings.Directory)
+	if err != nil {
+		return
+	}
+	full, err := filepath.Abs(file)
+	if err != nil {
+		return
+	}
+	if rel, err := filepath.Rel(dir, full); err != nil || strings.HasPrefix(rel, "..") {
+		warnOrFail("env", fmt.Errorf("%s is not in the '%s' environment directory (%s)", file, envName, envSettings.Directory))
+	}
+}
+
+// checkGitFilter warns when a file is already encrypted by git-crypt or transcrypt, the values
+// in it would be encrypted twice and any plain text in it is only as safe as that filter
+func checkGitFilter(file string) {
+	if file == os.Stdin.Name() || file == os.Stdout.Name() || file == "" || sls.IsRemote(file) {
+		return
+	}
+	tool, err := utils.GitFilter(file)
+	if err != nil || tool == "" {
+		return
+	}
+	warnOrFail("git", fmt.Errorf("%s is encrypted by %s (see .gitattributes), its values would be encrypted twice and any plain text in it relies on %s", file, tool, tool))
+}
+
+// checkGitFilters runs checkGitFilter on the input and output files, or the files in the --dir directory
+func checkGitFilters() {
+	checkGitFilter(inputFilePath)
+	if outputFilePath != inputFilePath {
+		checkGitFilter(outputFilePath)
+	}
+	if recurseDir == "" {
+		return
+	}
+	if info, err := os.Stat(recurseDir); err != nil || !info.IsDir() {
+		return
+	}
+	files := findFiles("git", recurseDir)
+	for _, file := range files {
+		checkGitFilter(file)
+	}
+}
+
+// checkDecryptDir makes sure a directory is under one of the profile's decrypt_dirs, if any are set
+func checkDecryptDir(dir string) {
+	if activeProfile == nil {
+		return
+	}
+	if err := decryptDirAllowed(activeProfile, dir); err != nil {
+		exitWithf(utils.ExitConfig, "profile '%s': %s", activeProfile.Name, err)
+	}
+}
+
+// decryptDirAllowed returns an error when dir is not under one of the decrypt_dirs of p
+func decryptDirAllowed(p *GSPProfile, dir string) error {
+	if len(p.DecryptDirs) == 0 {
+		return nil
+	}
+	full, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	for _, allowed := range p.DecryptDirs {
+		allowed, err := tilde.Expand(allowed)
+		if err != nil {
+			continue
+		}
+		if allowed, err = filepath.Abs(allowed); err != nil {
+			continue
+		}
+		if rel, err := filepath.Rel(allowed, full); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is not in decrypt_dirs (%s)", dir, strings.Join(p.DecryptDirs, ", "))
+}
+
+// applyProfile sets any values not given on the command line from the profile
+func applyProfile(p *GSPProfile) {
+	flags := rootCmd.PersistentFlags()
+
+	if p.GnupgHome != "" {
+		gnupgHome = p.GnupgHome
+		if !flags.Changed("pubring") {
+			publicKeyRing = fmt.Sprintf("%s/pubring.gpg", p.GnupgHome)
+		}
+		if !flags.Changed("secring") {
+			privateKeyRing = fmt.Sprintf("%s/secring.gpg", p.GnupgHome)
+		}
+	}
+	if p.DefaultPubRing != "" && !flags.Changed("pubring") {
+		publicKeyRing = p.DefaultPubRing
+	}
+	if p.DefaultSecRing != "" && !flags.Changed("secring") {
+		privateKeyRing = p.DefaultSecRing
+	}
+	if p.DefaultKey != "" && !flags.Changed("pgp_key") {
+		pgpKeyName = p.DefaultKey
+	}
+	if p.Backend != "" && !flags.Changed("backend") {
+		backendName = p.Backend
+	}
+	if p.DefaultElement != "" && !flags.Changed("element") {
+		topLevelElement = p.DefaultElement
+	}
+	if p.MaxValueSize != 0 && !flags.Changed("max-value-size") {
+		maxValueSize = p.MaxValueSize
+	}
+	if p.ChunkSize != 0 && !flags.Changed("chunk-size") {
+		chunkSize = p.ChunkSize
+	}
+	if p.MaxDepth != 0 && !flags.Changed("max-depth") {
+		walkLimits.MaxDepth = p.MaxDepth
+	}
+	if p.MaxFiles != 0 && !flags.Changed("max-files") {
+		walkLimits.MaxFiles = p.MaxFiles
+	}
+	if p.MaxConcurrency != 0 && !flags.Changed("max-concurrency") {
+		backendLimits.Concurrency = p.MaxConcurrency
+	}
+	if p.Retries != 0 && !flags.Changed("retries") {
+		backendLimits.Retries = p.Retries
+	}
+	if p.RetryBackoff != "" && !flags.Changed("retry-backoff") {
+		// checked by validateConfig
+		backendLimits.Backoff, _ = time.ParseDuration(p.RetryBackoff)
+	}
+	if p.BatchSize != 0 && !flags.Changed("batch-size") {
+		backendLimits.BatchSize = p.BatchSize
+	}
+	if p.SecretKeyFrom != "" && !flags.Changed("secret-key-from") {
+		secretKeyFrom = p.SecretKeyFrom
+	}
+	if p.PassphraseFrom != "" && !flags.Changed("passphrase-from") {
+		passphraseFrom = p.PassphraseFrom
+	}
+	profilePassphrase = p.Passphrase
+	if p.EscrowKey != "" && !flags.Changed("escrow-key") {
+		escrowKey = p.EscrowKey
+	}
+	if p.NaclPkFile != "" && !flags.Changed("nacl-pk-file") {
+		naclPublicKeyFile = p.NaclPkFile
+	}
+	if p.NaclSkFile != "" && !flags.Changed("nacl-sk-file") {
+		naclSecretKeyFile = p.NaclSkFile
+	}
+	if p.NaclBoxType != "" && !flags.Changed("nacl-box-type") {
+		naclBoxType = p.NaclBoxType
+	}
+	if p.PKCS11Module != "" && !flags.Changed("pkcs11-module") {
+		pkcs11Module = p.PKCS11Module
+	}
+	if p.PKCS11Slot != "" && !flags.Changed("pkcs11-slot") {
+		pkcs11Slot = p.PKCS11Slot
+	}
+	if p.PKCS11KeyID != "" && !flags.Changed("pkcs11-key-id") {
+		pkcs11KeyID = p.PKCS11KeyID
+	}
+	if p.PKCS11PINFrom != "" && !flags.Changed("pkcs11-pin-from") {
+		pkcs11PINFrom = p.PKCS11PINFrom
+	}
+	profilePIN = p.PKCS11PIN
+	if p.KeyOrder != "" && !flags.Changed("key-order") {
+		keyOrder = p.KeyOrder
+	}
+	if p.Indent != 0 && !flags.Changed("indent") {
+		indent = p.Indent
+	}
+	if p.ArmorWidth != 0 && !flags.Changed("armor-width") {
+		armorWidth = p.ArmorWidth
+	}
+	if p.HashComments && !flags.Changed("hash-comments") {
+		hashComments = true
+	}
+	if p.KeepQuotes && !flags.Changed("keep-quotes") {
+		keepQuotes = true
+	}
+	if p.PreserveExact && !flags.Changed("preserve-exact") {
+		preserveExact = true
+	}
+	if p.FailOnDuplicates && !flags.Changed("fail-on-duplicate-keys") {
+		failOnDuplicates = true
+	}
+	if p.Schema != "" && !flags.Changed("schema") {
+		schemaFile = p.Schema
+	}
+	if p.WarnOutsideElement && !flags.Changed("warn-outside-element") {
+		warnOutsideElement = true
+	}
+	if len(p.ForbiddenPatterns) > 0 && !flags.Changed("forbid") {
+		forbiddenPatterns = p.ForbiddenPatterns
+	}
+}
+
+// checkProfileKey enforces the cipher and allowed key settings of the active profile
+func checkProfileKey(pk *pki.Pki) {
+	if activeProfile == nil {
+		return
+	}
+	if err := pk.SetCipher(activeProfile.Cipher); err != nil {
+		exitWithf(utils.ExitConfig, "profile '%s': %s", activeProfile.Name, err)
+	}
+	if len(activeProfile.AllowedKeys) == 0 {
+		return
+	}
+
+	fingerprint, err := pk.Fingerprint()
+	if err != nil {
+		logger.Fatalf("profile '%s': %s", activeProfile.Name, err)
+	}
+	for _, allowed := range activeProfile.AllowedKeys {
+		if keyMatches(fingerprint, allowed) {
+			return
+		}
+	}
+	exitWithf(utils.ExitConfig, "profile '%s': key '%s' (%s) is not in allowed_keys", activeProfile.Name, pgpKeyName, fingerprint)
+}
+
+// useEscrowKey adds the escrow key as a recipient, it is an error if it can't be found
+func useEscrowKey(pk *pki.Pki) {
+	if err := pk.SetEscrow(escrowKey); err != nil {
+		exitWithf(utils.ExitConfig, "escrow key: %s", err)
+	}
+}
+
+// keyMatches compares a fingerprint to a fingerprint or (long/short) key ID
+func keyMatches(fingerprint string, keyID string) bool {
+	keyID = strings.ToUpper(strings.Replace(strings.TrimPrefix(keyID, "0x"), " ", "", -1))
+	return keyID != "" && strings.HasSuffix(strings.ToUpper(fingerprint), keyID)
+}
+
+// useUpdateInPlace returns true if --update was given, or if the profile defaults to updating files
+func useUpdateInPlace(cmd *cobra.Command) bool {
+	if cmd.Flags().Changed("update") || activeProfile == nil {
+		return updateInPlace
+	}
+	return activeProfile.DefaultOutput == outputUpdate
+}
+
+// validateConfig checks the raw profile data and returns a list of problems
+func validateConfig(raw interface{}) []string {
+	var problems []string
+
+	if raw == nil {
+		return problems
+	}
+	list, ok := raw.([]interface{})
+	if !ok {
+		return append(problems, "'profiles' must be a list")
+	}
+
+	fields := profileFields()
+	names := map[string]bool{}
+	defaults := 0
+	for i, item := range list {
+		prof, ok := toStringMap(item)
+		if !ok {
+			problems = append(problems, fmt.Sprintf("profiles[%d]: must be a map of settings", i))
+			continue
+		}
+		label := fmt.Sprintf("profiles[%d]", i)
+		if name, ok := prof["name"].(string); ok && name != "" {
+			label = fmt.Sprintf("profiles[%d] (%s)", i, name)
+			if names[name] {
+				problems = append(problems, fmt.Sprintf("%s: duplicate profile name", label))
+			}
+			names[name] = true
+		} else {
+			problems = append(problems, fmt.Sprintf("%s: 'name' is required", label))
+		}
+
+		keys := make([]string, 0, len(prof))
+		for key := range prof {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			kind, ok := fields[key]
+			if !ok {
+				msg := fmt.Sprintf("%s: unknown setting '%s'", label, key)
+				if guess := closestName(key, fields); guess != "" {
+					msg = fmt.Sprintf("%s (did you mean '%s'?)", msg, guess)
+				}
+				problems = append(problems, msg)
+				continue
+			}
+			if !kindMatches(prof[key], kind) {
+				problems = append(problems, fmt.Sprintf("%s: '%s' must be a %s", label, key, kind))
+			}
+			if val, ok := prof[key].(string); ok && !secretSettings[key] && strings.HasPrefix(strings.TrimSpace(val), pki.PGPHeader) {
+				problems = append(problems, fmt.Sprintf("%s: '%s' can't be encrypted, only %s can", label, key, strings.Join(secretSettingNames(), ", ")))
+			}
+			if from, ok := encryptedOnlySettings[key]; ok {
+				if val, _ := prof[key].(string); !strings.HasPrefix(strings.TrimSpace(val), pki.PGPHeader) {
+					problems = append(problems, fmt.Sprintf("%s: '%s' must be encrypted, set it with 'config set --encrypt-to <your key>'", label, key))
+				}
+				if prof[from] != nil {
+					problems = append(problems, fmt.Sprintf("%s: only one of '%s' and '%s' can be set", label, key, from))
+				}
+			}
+		}
+
+		if prof["default"] == true {
+			defaults++
+		}
+		if backend, ok := prof["backend"].(string); ok {
+			available, known := knownBackends[backend]
+			if !known {
+				problems = append(problems, fmt.Sprintf("%s: unknown backend '%s', expected one of: %s", label, backend, strings.Join(backendNames(), ", ")))
+			} else if !available {
+				problems = append(problems, fmt.Sprintf("%s: backend '%s' is not supported by this version", label, backend))
+			}
+		}
+		if backoff, ok := prof["retry_backoff"].(string); ok {
+			if _, err := time.ParseDuration(backoff); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: invalid retry_backoff '%s', expected a duration such as 500ms or 2s", label, backoff))
+			}
+		}
+		if cipher, ok := prof["cipher"].(string); ok {
+			if err := (&pki.Pki{}).SetCipher(cipher); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %s", label, err))
+			}
+		}
+		if patterns, ok := prof["forbidden_patterns"].([]interface{}); ok {
+			for _, p := range patterns {
+				if _, err := sls.CompilePatterns([]string{fmt.Sprintf("%v", p)}); err != nil {
+					problems = append(problems, fmt.Sprintf("%s: forbidden_patterns: %s", label, err))
+				}
+			}
+		}
+		if commands, ok := prof["disabled_commands"].([]interface{}); ok {
+			for _, c := range commands {
+				if name := strings.Fields(fmt.Sprintf("%v", c)); len(name) == 0 || !isCommand(name[0]) {
+					problems = append(problems, fmt.Sprintf("%s: disabled_commands: unknown command '%v'", label, c))
+				}
+			}
+		}
+		if output, ok := prof["default_output"].(string); ok && output != outputStdout && output != outputUpdate {
+			problems = append(problems, fmt.Sprintf("%s: 'default_output' must be '%s' or '%s'", label, outputStdout, outputUpdate))
+		}
+	}
+	if defaults > 1 {
+		problems = append(problems, fmt.Sprintf("%d profiles are marked as default, only one is allowed", defaults))
+	}
+
+	return problems
+}
+
+// isCommand returns true for the name of a command, including those left out of encrypt only builds
+func isCommand(name string) bool {
+	if name == "decrypt" || name == "rotate" {
+		return true
+	}
+	for _, c := range rootCmd.Commands() {
+		if c.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// profileFields returns the config keys of GSPProfile and the kind of value they hold
+func profileFields() map[string]string {
+	fields := map[string]string{}
+	t := reflect.TypeOf(GSPProfile{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		switch f.Type.Kind() {
+		case reflect.Bool:
+			fields[f.Tag.Get("mapstructure")] = "boolean"
+		case reflect.Int:
+			fields[f.Tag.Get("mapstructure")] = "number"
+		case reflect.Slice:
+			fields[f.Tag.Get("mapstructure")] = "list"
+		default:
+			fields[f.Tag.Get("mapstructure")] = "string"
+		}
+	}
+	return fields
+}
+
+func kindMatches(val interface{}, kind string) bool {
+	switch kind {
+	case "boolean":
+		_, ok := val.(bool)
+		return ok
+	case "list":
+		_, ok := val.([]interface{})
+		return ok
+	case "number":
+		_, ok := val.(int)
+		return ok
+	default:
+		_, ok := val.(string)
+		return ok
+	}
+}
+
+func toStringMap(item interface{}) (map[string]interface{}, bool) {
+	switch m := item.(type) {
+	case map[string]interface{}:
+		return m, true
+	case map[interface{}]interface{}:
+		res := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			res[fmt.Sprintf("%v", k)] = v
+		}
+		return res, true
+	}
+	return nil, false
+}
+
+func backendNames() []string {
+	names := make([]string, 0, len(knownBackends))
+	for name := range knownBackends {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// availableBackends lists the backends this version supports
+func availableBackends() []string {
+	names := []string{}
+	for _, name := range backendNames() {
+		if knownBackends[name] {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// closestName returns the field name within an edit distance of 2 of the given name
+func closestName(name string, fields map[string]string) string {
+	best := ""
+	bestDist := 3
+	for field := range fields {
+		if d := sls.EditDistance(name, field); d < bestDist {
+			best = field
+			bestDist = d
+		}
+	}
+	return best
+}
@@ -0,0 +1,62 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+//go:build !encryptonly
+// +build !encryptonly
+
+package cmd
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/Everbridge/generate-secure-pillar/pki"
+)
+
+// decryptProfile decrypts the settings of a profile that are PGP messages, with gpg
+// (and gpg-agent) so that the passphrase of the user's key is never needed here
+func decryptProfile(p *GSPProfile) error {
+	var gpg *pki.GPGBackend
+	v := reflect.ValueOf(p).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := v.Field(i)
+		if field.Kind() != reflect.String || !strings.HasPrefix(strings.TrimSpace(field.String()), pki.PGPHeader) {
+			continue
+		}
+		setting := t.Field(i).Tag.Get("mapstructure")
+		if !secretSettings[setting] {
+			return fmt.Errorf("'%s' can't be encrypted", setting)
+		}
+		if gpg == nil {
+			var err error
+			if gpg, err = pki.NewGPGBackend("", p.GnupgHome); err != nil {
+				return fmt.Errorf("'%s': %s", setting, err)
+			}
+		}
+		plainText, err := gpg.DecryptSecret(field.String())
+		if err != nil {
+			return fmt.Errorf("'%s': %s", setting, err)
+		}
+		field.SetString(strings.TrimSpace(plainText))
+	}
+	return nil
+}
@@ -22,27 +22,63 @@ package cmd
 
 import (
 	"os"
-	"path/filepath"
 	"strings"
 
 	"github.com/Everbridge/generate-secure-pillar/sls"
+	"github.com/Everbridge/generate-secure-pillar/utils"
 	"github.com/spf13/cobra"
 )
 
+var forceCreate bool
+
 // createCmd represents the create command
 var createCmd = &cobra.Command{
-	Use:   "create",
-	Short: "create a new sls file",
+	Use:     "create",
+	Aliases: []string{"c"},
+	Short:   "create a new sls file",
 	Run: func(cmd *cobra.Command, args []string) {
-		outputFilePath, err := filepath.Abs(outputFilePath)
+		outputFilePath, err := absPath(outputFilePath)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		// values are taken as given, they may contain commas, brackets, or newlines
+		secretNames, err := cmd.Flags().GetStringArray("name")
 		if err != nil {
 			logger.Fatal(err)
 		}
-		secretNames := strings.Split(strings.Trim(cmd.Flag("name").Value.String(), "[]"), ",")
-		secretValues := strings.Split(strings.Trim(cmd.Flag("value").Value.String(), "[]"), ",")
+		secretValues, err := cmd.Flags().GetStringArray("value")
+		if err != nil {
+			logger.Fatal(err)
+		}
+		if err = sls.CheckMergeStrategy(mergeStrategy); err != nil {
+			exitWithf(utils.ExitUsage, "create: %s", err)
+		}
+		// don't lose the values in an existing file, stdout and other devices are fine
+		merge := false
+		if info, err := os.Stat(outputFilePath); err == nil && info.Mode().IsRegular() && !forceCreate {
+			if !cmd.Flags().Changed("merge-strategy") {
+				exitWithf(utils.ExitUsage, "create: %s already exists, use 'update' to change it, --merge-strategy to merge into it, or --force to replace it", outputFilePath)
+			}
+			merge = true
+		}
 		pk := getPki()
-		s := sls.New(outputFilePath, pk, topLevelElement)
-		err = s.ProcessYaml(secretNames, secretValues)
+		s := sls.New("", pk, topLevelElement)
+		if merge {
+			s = sls.New(outputFilePath, pk, topLevelElement)
+			if s.Error != nil {
+				fatal("create", s.Error)
+			}
+		}
+		s.FilePath = outputFilePath
+		secretNames = s.ElementPaths(secretNames)
+		err = s.MergeYaml(secretNames, secretValues, mergeStrategy)
+		if err != nil {
+			logger.Fatalf("create: %s", err)
+		}
+		if _, err = s.Derive(secretNames); err != nil {
+			logger.Fatalf("create: %s", err)
+		}
+		err = applyMeta(cmd, &s, secretNames)
 		if err != nil {
 			logger.Fatalf("create: %s", err)
 		}
@@ -50,7 +86,7 @@ var createCmd = &cobra.Command{
 		if err != nil {
 			logger.Fatalf("create: %s", err)
 		}
-		_, err = sls.WriteSlsFile(buffer, outputFilePath)
+		_, err = sls.WriteSlsFileContext(commandContext, buffer, outputFilePath)
 		if err != nil {
 			logger.Fatalf("create: %s", err)
 		}
@@ -62,4 +98,7 @@ func init() {
 	createCmd.PersistentFlags().StringVarP(&outputFilePath, "outfile", "o", os.Stdout.Name(), "output file (defaults to STDOUT)")
 	createCmd.PersistentFlags().StringArrayP("name", "n", nil, "secret name(s)")
 	createCmd.PersistentFlags().StringArrayP("value", "s", nil, "secret value(s)")
+	createCmd.PersistentFlags().BoolVar(&forceCreate, "force", false, "replace the output file if it already exists, its values are lost")
+	createCmd.PersistentFlags().StringVar(&mergeStrategy, "merge-strategy", sls.MergeReplace, "merge into the output file if it already exists, lists are: "+strings.Join(sls.MergeStrategies, ", "))
+	createCmd.PersistentFlags().StringArray("meta", nil, "metadata field=value kept (unencrypted) with the secret(s), e.g. expires=2025-01-01 or owner=team-x")
 }
@@ -0,0 +1,115 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/Everbridge/generate-secure-pillar/pki"
+	"github.com/Everbridge/generate-secure-pillar/sls"
+	"github.com/Everbridge/generate-secure-pillar/utils"
+	"github.com/spf13/cobra"
+)
+
+const packets = "packets"
+
+// debugCmd represents the debug command
+var debugCmd = &cobra.Command{
+	Use:   "debug",
+	Short: "show how encrypted values are built, to diagnose values a renderer can't read",
+	Example: `
+# dump the OpenPGP packets of a value, like gpg --list-packets
+$ generate-secure-pillar debug packets -f new.sls -p secure_vars:password
+
+# dump every encrypted value in a file
+$ generate-secure-pillar debug packets -f new.sls`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) == 0 {
+			err := cmd.Help()
+			if err != nil {
+				logger.Fatal(err)
+			}
+			os.Exit(0)
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		if args[0] != packets {
+			exitWithf(utils.ExitUsage, "unknown argument: '%s'", args[0])
+		}
+		inputFilePath, err := inputPath(inputFilePath)
+		fatal("debug", err)
+		// the packets are read from the values, no key ring is needed
+		s := sls.New(inputFilePath, pki.Pki{}, topLevelElement)
+		if s.Error != nil {
+			fatal("debug", s.Error)
+		}
+		dumpPackets(&s)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(debugCmd)
+	debugCmd.PersistentFlags().StringVarP(&inputFilePath, "file", "f", os.Stdin.Name(), "input file (defaults to STDIN)")
+	debugCmd.PersistentFlags().StringArrayVarP(&yamlPaths, "path", "p", nil, "YAML path(s) of the values to examine (defaults to all encrypted values)")
+}
+
+// dumpPackets writes the packets of the values at --path, or of every encrypted value
+func dumpPackets(s *sls.Sls) {
+	values := s.EncryptedValues()
+	paths := yamlPaths
+	if len(paths) == 0 {
+		for p := range values {
+			paths = append(paths, p)
+		}
+		sort.Strings(paths)
+	}
+
+	failed := 0
+	for i, p := range paths {
+		if !s.PathExists(p) {
+			fatal("debug", s.PathError(p))
+		}
+		value, ok := values[p]
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("%s:\n", p)
+		switch {
+		case !ok:
+			logger.Errorf("debug: %s is not an encrypted value", p)
+			failed++
+		case pki.IsNaclValue(value):
+			logger.Errorf("debug: %s is a nacl value, it has no OpenPGP packets", p)
+			failed++
+		default:
+			if err := pki.DumpPackets(os.Stdout, value); err != nil {
+				logger.Errorf("debug: %s: %s", p, err)
+				failed++
+			}
+		}
+	}
+	if failed > 0 {
+		exitWithf(utils.ExitError, "debug: %d of %d values could not be read", failed, len(paths))
+	}
+}
@@ -18,12 +18,17 @@
 // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
 // THE SOFTWARE.
 
+//go:build !encryptonly
+// +build !encryptonly
+
 package cmd
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 
+	"github.com/Everbridge/generate-secure-pillar/pki"
 	"github.com/Everbridge/generate-secure-pillar/sls"
 	"github.com/Everbridge/generate-secure-pillar/utils"
 	"github.com/spf13/cobra"
@@ -31,8 +36,9 @@ import (
 
 // decryptCmd represents the decrypt command
 var decryptCmd = &cobra.Command{
-	Use:   "decrypt",
-	Short: "perform decryption operations",
+	Use:     "decrypt",
+	Aliases: []string{"d"},
+	Short:   "perform decryption operations",
 	PreRunE: func(cmd *cobra.Command, args []string) error {
 		if len(args) == 0 {
 			err := cmd.Help()
@@ -44,12 +50,12 @@ var decryptCmd = &cobra.Command{
 		return nil
 	},
 	Run: func(cmd *cobra.Command, args []string) {
-		pk := getPki()
-		outputFilePath, err := filepath.Abs(outputFilePath)
-		if err != nil {
-			logger.Fatal(err)
+		if sshHost != "" {
+			runOverSSH(cmd, args)
+			return
 		}
-		inputFilePath, err := filepath.Abs(inputFilePath)
+		pk := getPki()
+		outputFilePath, err := absPath(outputFilePath)
 		if err != nil {
 			logger.Fatal(err)
 		}
@@ -57,23 +63,52 @@ var decryptCmd = &cobra.Command{
 		// process args
 		switch args[0] {
 		case all:
+			inputFilePath := inputFile()
 			if inputFilePath == os.Stdin.Name() && !stdinIsPiped() {
 				logger.Infof("reading from %s", os.Stdin.Name())
 			}
 			s := sls.New(inputFilePath, pk, topLevelElement)
-			if inputFilePath != os.Stdin.Name() && updateInPlace {
+			if s.Error != nil {
+				fatal("decrypt", s.Error)
+			}
+			if inputFilePath != os.Stdin.Name() && useUpdateInPlace(cmd) {
 				outputFilePath = inputFilePath
 			}
 			buffer, err := s.PerformAction("decrypt")
-			utils.SafeWrite(buffer, outputFilePath, err)
+			utils.SafeWrite(commandContext, buffer, outputFilePath, err)
 		case recurse:
-			err = utils.ProcessDir(recurseDir, ".sls", "decrypt", outputFilePath, topLevelElement, pk)
+			if archivePath != "" {
+				entries := readArchive()
+				if count := len(utils.ArchiveFiles(entries, ".sls")); count > 0 {
+					if !confirm(fmt.Sprintf("decrypt %d files in %s, writing plain text", count, archivePath)) {
+						exitWithf(utils.ExitError, "decrypt recurse not confirmed")
+					}
+				}
+				processArchive(entries, sls.Decrypt, pk)
+				return
+			}
+			dir := recurseDirectory(cmd)
+			checkDecryptDir(dir)
+			if count := len(findFiles("decrypt", dir)); count > 0 {
+				if !confirm(fmt.Sprintf("decrypt %d files in %s, writing plain text", count, dir)) {
+					exitWithf(utils.ExitError, "decrypt recurse not confirmed")
+				}
+			}
+			report := runReport("decrypt", dir)
+			err = utils.ProcessDirReport(commandContext, dir, ".sls", walkLimits, "decrypt", outputFilePath, topLevelElement, pk, report)
+			writeRunReport(report, err)
 			if err != nil {
-				logger.Warnf("decrypt: %s", err)
+				warnOrFail("decrypt", err)
 			}
+		case stage:
+			stageDir(recurseDirectory(cmd), pk)
 		case path:
+			inputFilePath := inputFile()
 			s := sls.New(inputFilePath, pk, topLevelElement)
-			utils.PathAction(&s, yamlPath, "decrypt")
+			if s.Error != nil {
+				fatal("decrypt", s.Error)
+			}
+			pathAction(&s, sls.Decrypt, outputFilePath)
 		default:
 			err = cmd.Help()
 			if err != nil {
@@ -83,11 +118,57 @@ var decryptCmd = &cobra.Command{
 	},
 }
 
+const stage = "stage"
+
+var stageTarget = utils.StageTar
+
+// stageDir writes decrypted copies of the files in dir to a tar stream or a RAM backed directory
+func stageDir(dir string, pk pki.Pki) {
+	// stdout is kept for the tar stream or the directory name
+	logToStderr()
+	switch stageTarget {
+	case utils.StageTar:
+		out := os.Stdout
+		if outputFilePath != os.Stdout.Name() {
+			f, err := os.OpenFile(filepath.Clean(outputFilePath), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+			if err != nil {
+				fatal("stage", err)
+			}
+			defer f.Close()
+			out = f
+		} else if fi, err := out.Stat(); err == nil && fi.Mode()&os.ModeCharDevice != 0 {
+			exitWithf(utils.ExitUsage, "stage: not writing a tar stream to a terminal, use --outfile or a pipe")
+		}
+		count, err := utils.StageToTar(dir, ".sls", walkLimits, topLevelElement, pk, out)
+		fatal("stage", err)
+		logger.Infof("staged %d files from %s", count, dir)
+	case utils.StageTmpfs:
+		staged, count, err := utils.StageToTmpfs(dir, ".sls", walkLimits, topLevelElement, pk)
+		fatal("stage", err)
+		logger.Infof("staged %d files from %s, remove %s when done", count, dir, staged)
+		fmt.Println(staged)
+	default:
+		exitWithf(utils.ExitUsage, "unknown stage target '%s', expected %s or %s", stageTarget, utils.StageTar, utils.StageTmpfs)
+	}
+}
+
+// decryptValues returns a value with the encrypted values in it decrypted,
+// encrypt only builds have a version that fails (see decrypt_encryptonly.go)
+func decryptValues(s *sls.Sls, val interface{}) (interface{}, error) {
+	return s.ProcessValues(val, sls.Decrypt)
+}
+
 func init() {
 	rootCmd.AddCommand(decryptCmd)
-	decryptCmd.PersistentFlags().StringVarP(&yamlPath, "path", "p", "", "YAML path to decrypt")
+	addSSHFlags(decryptCmd)
+	decryptCmd.PersistentFlags().StringArrayVarP(&yamlPaths, "path", "p", nil, "YAML path(s) to decrypt, with --outfile the file is written once with all of them decrypted, otherwise the values are printed")
 	decryptCmd.PersistentFlags().StringVarP(&recurseDir, "dir", "d", "", "recurse over all .sls files in the given directory")
+	decryptCmd.PersistentFlags().StringVar(&reportFile, "report", "", reportUsage)
+	decryptCmd.PersistentFlags().StringVar(&archivePath, "archive", "", archiveUsage+", it is written back unless --outfile names another archive")
 	decryptCmd.PersistentFlags().StringVarP(&inputFilePath, "file", "f", os.Stdin.Name(), "input file (defaults to STDIN)")
 	decryptCmd.PersistentFlags().StringVarP(&outputFilePath, "outfile", "o", os.Stdout.Name(), "output file (defaults to STDOUT)")
 	decryptCmd.PersistentFlags().BoolVarP(&updateInPlace, "update", "u", false, "update the input file")
+	decryptCmd.PersistentFlags().StringArrayVar(&includePaths, "include-path", nil, "only change the values under paths matching this pattern, e.g. 'db:*' (can be repeated)")
+	decryptCmd.PersistentFlags().StringArrayVar(&excludePaths, "exclude-path", nil, "leave the values under paths matching this pattern as they are (can be repeated)")
+	decryptCmd.PersistentFlags().StringVar(&stageTarget, "to", stageTarget, "where 'decrypt stage' writes decrypted copies: tar (a tar stream on STDOUT or --outfile) or tmpfs (a new directory in /dev/shm)")
 }
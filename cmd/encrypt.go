@@ -22,17 +22,21 @@ package cmd
 
 import (
 	"os"
-	"path/filepath"
 
 	"github.com/Everbridge/generate-secure-pillar/sls"
 	"github.com/Everbridge/generate-secure-pillar/utils"
 	"github.com/spf13/cobra"
 )
 
+var includePaths []string
+var excludePaths []string
+var styleHints bool
+
 // encryptCmd represents the encrypt command
 var encryptCmd = &cobra.Command{
-	Use:   "encrypt",
-	Short: "perform encryption operations",
+	Use:     "encrypt",
+	Aliases: []string{"e"},
+	Short:   "perform encryption operations",
 	PreRunE: func(cmd *cobra.Command, args []string) error {
 		if len(args) == 0 {
 			err := cmd.Help()
@@ -44,12 +48,10 @@ var encryptCmd = &cobra.Command{
 		return nil
 	},
 	Run: func(cmd *cobra.Command, args []string) {
+		// values tagged !file are read from their files, and must be encrypted
+		sls.DefaultOptions.ResolveFiles = true
 		pk := getPki()
-		outputFilePath, err := filepath.Abs(outputFilePath)
-		if err != nil {
-			logger.Fatal(err)
-		}
-		inputFilePath, err := filepath.Abs(inputFilePath)
+		outputFilePath, err := absPath(outputFilePath)
 		if err != nil {
 			logger.Fatal(err)
 		}
@@ -57,23 +59,42 @@ var encryptCmd = &cobra.Command{
 		// process args
 		switch args[0] {
 		case all:
+			inputFilePath := inputFile()
 			if inputFilePath == os.Stdin.Name() && !stdinIsPiped() {
 				logger.Infof("reading from %s", os.Stdin.Name())
 			}
 			s := sls.New(inputFilePath, pk, topLevelElement)
-			if inputFilePath != os.Stdin.Name() && updateInPlace {
+			if s.Error != nil {
+				fatal("encrypt", s.Error)
+			}
+			if inputFilePath != os.Stdin.Name() && useUpdateInPlace(cmd) {
 				outputFilePath = inputFilePath
 			}
 			buffer, err := s.PerformAction("encrypt")
-			utils.SafeWrite(buffer, outputFilePath, err)
+			utils.SafeWrite(commandContext, buffer, outputFilePath, err)
+			provenanceFiles([]string{outputFilePath}, pk)
 		case recurse:
-			err := utils.ProcessDir(recurseDir, ".sls", "encrypt", outputFilePath, topLevelElement, pk)
+			if archivePath != "" {
+				processArchive(readArchive(), sls.Encrypt, pk)
+				return
+			}
+			dir := recurseDirectory(cmd)
+			report := runReport("encrypt", dir)
+			err := utils.ProcessDirReport(commandContext, dir, ".sls", walkLimits, "encrypt", outputFilePath, topLevelElement, pk, report)
+			writeRunReport(report, err)
 			if err != nil {
-				logger.Warnf("encrypt: %s", err)
+				warnOrFail("encrypt", err)
 			}
+			files := findFiles("encrypt", dir)
+			provenanceFiles(files, pk)
 		case path:
+			inputFilePath := inputFile()
 			s := sls.New(inputFilePath, pk, topLevelElement)
-			utils.PathAction(&s, yamlPath, "encrypt")
+			if s.Error != nil {
+				fatal("encrypt", s.Error)
+			}
+			pathAction(&s, sls.Encrypt, outputFilePath)
+			provenanceFiles([]string{outputFilePath}, pk)
 		default:
 			err = cmd.Help()
 			if err != nil {
@@ -85,9 +106,15 @@ var encryptCmd = &cobra.Command{
 
 func init() {
 	rootCmd.AddCommand(encryptCmd)
-	encryptCmd.PersistentFlags().StringVarP(&yamlPath, "path", "p", "", "YAML path to encrypt")
+	encryptCmd.PersistentFlags().StringArrayVarP(&yamlPaths, "path", "p", nil, "YAML path(s) to encrypt, with --outfile the file is written once with all of them encrypted, otherwise the values are printed")
 	encryptCmd.PersistentFlags().StringVarP(&recurseDir, "dir", "d", "", "recurse over all .sls files in the given directory")
+	encryptCmd.PersistentFlags().StringVar(&reportFile, "report", "", reportUsage)
+	encryptCmd.PersistentFlags().StringVar(&archivePath, "archive", "", archiveUsage+", it is written back unless --outfile names another archive")
 	encryptCmd.PersistentFlags().StringVarP(&inputFilePath, "file", "f", os.Stdin.Name(), "input file (defaults to STDIN)")
 	encryptCmd.PersistentFlags().StringVarP(&outputFilePath, "outfile", "o", os.Stdout.Name(), "output file (defaults to STDOUT)")
 	encryptCmd.PersistentFlags().BoolVarP(&updateInPlace, "update", "u", false, "update the input file")
+	encryptCmd.PersistentFlags().StringVar(&provenanceKey, "provenance-key", "", "sign a record of who encrypted each value and when with this key, in a <file>"+sls.ProvenanceSuffix+" sidecar")
+	encryptCmd.PersistentFlags().StringArrayVar(&includePaths, "include-path", nil, "only change the values under paths matching this pattern, e.g. 'db:*' (can be repeated)")
+	encryptCmd.PersistentFlags().StringArrayVar(&excludePaths, "exclude-path", nil, "leave the values under paths matching this pattern as they are (can be repeated)")
+	encryptCmd.PersistentFlags().BoolVar(&styleHints, "style-hints", false, "record the scalar style of each value (e.g. a folded block) as metadata, so decrypt writes it in the same style")
 }
@@ -0,0 +1,97 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+//go:build !encryptonly
+// +build !encryptonly
+
+package cmd
+
+import (
+	"os"
+
+	"github.com/Everbridge/generate-secure-pillar/sls"
+	"github.com/Everbridge/generate-secure-pillar/utils"
+	"github.com/spf13/cobra"
+)
+
+var envPrefix string
+var envFormat string
+var envMask bool
+
+// envCmd represents the env command
+var envCmd = &cobra.Command{
+	Use:   "env",
+	Short: "print decrypted values as environment variables to source into a shell",
+	Long: `Prints a variable for each decrypted value (by default, all of the values under the top level
+element), named after its path in upper case with the prefix before it. The format is shell
+(export lines), dotenv (a .env file), or systemd (an EnvironmentFile).`,
+	Example: `$ eval "$(generate-secure-pillar env --file x.sls --prefix APP_)"
+$ generate-secure-pillar env --file x.sls --prefix APP_ --format systemd --outfile /etc/app/env
+$ generate-secure-pillar env --file x.sls --mask`,
+	Run: func(cmd *cobra.Command, args []string) {
+		inputFilePath, err := inputPath(inputFilePath)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		outputFilePath, err := absPath(outputFilePath)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		paths, err := cmd.Flags().GetStringArray("path")
+		if err != nil {
+			logger.Fatal(err)
+		}
+		mappings, err := cmd.Flags().GetStringArray("map")
+		if err != nil {
+			logger.Fatal(err)
+		}
+		names, err := utils.ParseNameMappings(mappings)
+		if err != nil {
+			exitWithf(utils.ExitUsage, "env: %s", err)
+		}
+		if err = utils.CheckEnvFormat(envFormat); err != nil {
+			exitWithf(utils.ExitUsage, "env: %s", err)
+		}
+
+		pk := getPki()
+		s := sls.New(inputFilePath, pk, topLevelElement)
+		if s.Error != nil {
+			fatal("env", s.Error)
+		}
+		buffer, err := utils.EnvValues(&s, paths, names, envPrefix, envFormat, envMask)
+		fatal("env", err)
+		_, err = sls.WriteSlsFileContext(commandContext, buffer, outputFilePath)
+		if err != nil {
+			logger.Fatalf("env: %s", err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(envCmd)
+	envCmd.PersistentFlags().StringVarP(&inputFilePath, "file", "f", os.Stdin.Name(), "input file (defaults to STDIN)")
+	envCmd.PersistentFlags().StringVarP(&outputFilePath, "outfile", "o", os.Stdout.Name(), "output file (defaults to STDOUT)")
+	envCmd.PersistentFlags().StringVar(&envPrefix, "prefix", "", "prefix for the variable names, e.g. APP_")
+	// replaces the global --format, env is always written as variables
+	envCmd.Flags().StringVar(&envFormat, "format", utils.Shell, "variables as shell export lines (shell), a .env file (dotenv), or a systemd EnvironmentFile (systemd)")
+	envCmd.PersistentFlags().BoolVar(&envMask, "mask", false, "print ******** in place of each value, to show which variables would be set")
+	envCmd.PersistentFlags().StringArrayP("path", "p", nil, "YAML path(s) to export (defaults to all values under the top level element)")
+	envCmd.PersistentFlags().StringArray("map", nil, "variable name mapping(s) in the form 'some:yaml:path=var_name', the prefix is added to these too")
+}
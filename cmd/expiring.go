@@ -0,0 +1,153 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Everbridge/generate-secure-pillar/output"
+	"github.com/Everbridge/generate-secure-pillar/pki"
+	"github.com/Everbridge/generate-secure-pillar/sls"
+	"github.com/spf13/cobra"
+)
+
+var expiringWithin string
+
+// expiringCmd represents the expiring command
+var expiringCmd = &cobra.Command{
+	Use:   "expiring",
+	Short: "list secrets with an expiry date that are due for rotation",
+	Example: `
+# list secrets expiring in the next 30 days (or already expired)
+$ generate-secure-pillar expiring --within 30d -d /path/to/pillar/secure/stuff`,
+	Run: func(cmd *cobra.Command, args []string) {
+		within, err := parseWithin(expiringWithin)
+		if err != nil {
+			logger.Fatalf("expiring: %s", err)
+		}
+
+		var files []string
+		if dir := recurseDirectory(cmd); dir != "" {
+			files = findFiles("expiring", dir)
+		} else {
+			files = []string{inputFilePath}
+		}
+
+		deadline := time.Now().Add(within)
+		report := []output.ExpiringSecret{}
+		for _, file := range files {
+			s := sls.New(file, pki.Pki{}, topLevelElement)
+			if s.Error != nil {
+				warnOrFail("expiring", s.Error)
+				continue
+			}
+			entries := s.MetaEntries()
+			sort.SliceStable(entries, func(i, j int) bool { return entries[i].Expires.Before(entries[j].Expires) })
+			for _, e := range entries {
+				if e.Expires.IsZero() || e.Expires.After(deadline) {
+					continue
+				}
+				expired := e.Expires.Before(time.Now())
+				if structuredOutput() {
+					report = append(report, output.ExpiringSecret{
+						File:    file,
+						Path:    e.Path,
+						Expires: e.Expires.Format(sls.DateFormat),
+						Expired: expired,
+						Fields:  e.Fields,
+					})
+					continue
+				}
+				status := "expires"
+				if expired {
+					status = "EXPIRED"
+				}
+				fmt.Printf("%s: %s %s %s%s\n", file, e.Path, status, e.Expires.Format(sls.DateFormat), metaDetails(e.Fields))
+			}
+		}
+		if structuredOutput() {
+			writeOutput(report)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(expiringCmd)
+	expiringCmd.PersistentFlags().StringVarP(&inputFilePath, "file", "f", os.Stdin.Name(), "input file (defaults to STDIN)")
+	expiringCmd.PersistentFlags().StringVarP(&recurseDir, "dir", "d", "", "recurse over all .sls files in the given directory")
+	expiringCmd.PersistentFlags().StringVar(&expiringWithin, "within", "30d", "report secrets expiring within this time, e.g. 30d, 2w, or 12h")
+}
+
+// applyMeta adds any --meta fields to the named secrets
+func applyMeta(cmd *cobra.Command, s *sls.Sls, secretNames []string) error {
+	fields, err := cmd.Flags().GetStringArray("meta")
+	if err != nil || len(fields) == 0 {
+		return err
+	}
+	meta, err := sls.ParseMeta(fields)
+	if err != nil {
+		return err
+	}
+	for _, name := range secretNames {
+		if err = s.SetMeta(name, meta); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseWithin parses a duration, allowing days (d) and weeks (w) as units
+func parseWithin(within string) (time.Duration, error) {
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if strings.HasSuffix(within, suffix) {
+			n, err := strconv.Atoi(strings.TrimSuffix(within, suffix))
+			if err != nil {
+				return 0, fmt.Errorf("invalid duration '%s'", within)
+			}
+			return time.Duration(n) * unit, nil
+		}
+	}
+	d, err := time.ParseDuration(within)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration '%s'", within)
+	}
+	return d, nil
+}
+
+// metaDetails formats the metadata fields other than the expiry date
+func metaDetails(fields map[string]interface{}) string {
+	var details []string
+	for k, v := range fields {
+		if k != sls.ExpiresField {
+			details = append(details, fmt.Sprintf("%s: %v", k, v))
+		}
+	}
+	if len(details) == 0 {
+		return ""
+	}
+	sort.Strings(details)
+	return " (" + strings.Join(details, ", ") + ")"
+}
@@ -0,0 +1,94 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+//go:build !encryptonly
+// +build !encryptonly
+
+package cmd
+
+import (
+	"os"
+
+	"github.com/Everbridge/generate-secure-pillar/sls"
+	"github.com/Everbridge/generate-secure-pillar/utils"
+	"github.com/spf13/cobra"
+)
+
+var exportTarget string
+
+// exportCmd represents the export command
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "export decrypted values as terraform tfvars JSON, an env file, or systemd credentials",
+	Run: func(cmd *cobra.Command, args []string) {
+		inputFilePath, err := inputPath(inputFilePath)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		outputFilePath, err := absPath(outputFilePath)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		paths, err := cmd.Flags().GetStringArray("path")
+		if err != nil {
+			logger.Fatal(err)
+		}
+		mappings, err := cmd.Flags().GetStringArray("map")
+		if err != nil {
+			logger.Fatal(err)
+		}
+		names, err := utils.ParseNameMappings(mappings)
+		if err != nil {
+			logger.Fatalf("export: %s", err)
+		}
+
+		credentials := exportTarget == utils.Creds || exportTarget == utils.SystemdCreds
+		if credentials && outputFilePath == os.Stdout.Name() {
+			exitWithf(utils.ExitUsage, "export: the %s target writes a file per value, --outfile must be a directory", exportTarget)
+		}
+
+		pk := getPki()
+		s := sls.New(inputFilePath, pk, topLevelElement)
+		if s.Error != nil {
+			fatal("export", s.Error)
+		}
+		if credentials {
+			creds, err := utils.WriteCredentials(&s, paths, names, outputFilePath, exportTarget == utils.SystemdCreds)
+			fatal("export", err)
+			logger.Infof("wrote %d credentials to %s", len(creds), outputFilePath)
+			return
+		}
+		buffer, err := utils.ExportValues(&s, paths, names, exportTarget)
+		fatal("export", err)
+		_, err = sls.WriteSlsFileContext(commandContext, buffer, outputFilePath)
+		if err != nil {
+			logger.Fatalf("export: %s", err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+	exportCmd.PersistentFlags().StringVarP(&inputFilePath, "file", "f", os.Stdin.Name(), "input file (defaults to STDIN)")
+	exportCmd.PersistentFlags().StringVarP(&outputFilePath, "outfile", "o", os.Stdout.Name(), "output file (defaults to STDOUT)")
+	exportCmd.PersistentFlags().StringVarP(&exportTarget, "target", "t", utils.TfVars, "export target: tfvars (terraform.tfvars.json), env, or creds (a file per value in the --outfile directory, for systemd's LoadCredential=) or systemd-creds (the same, encrypted with systemd-creds)")
+	exportCmd.PersistentFlags().StringArrayP("path", "p", nil, "YAML path(s) to export (defaults to all values under the top level element)")
+	exportCmd.PersistentFlags().StringArray("map", nil, "variable name mapping(s) in the form 'some:yaml:path=var_name'")
+}
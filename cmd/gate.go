@@ -0,0 +1,124 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Everbridge/generate-secure-pillar/utils"
+	"github.com/spf13/cobra"
+)
+
+// auditEvent is a line of the audit log
+type auditEvent struct {
+	Time    string `json:"time"`
+	User    string `json:"user"`
+	Host    string `json:"host"`
+	Profile string `json:"profile"`
+	Command string `json:"command"`
+	Outcome string `json:"outcome"`
+}
+
+// decryptingCommands are the commands that can decrypt values, or unlock the key to,
+// "decrypt" in disabled_commands disables all of them
+var decryptingCommands = []string{"decrypt", "rotate", "export", "ansible", "env", "audit", "sync", "changelog", "unlock", "shell"}
+
+// checkCommandAllowed fails a command that the disabled_commands of the profile name, e.g. "decrypt"
+// or "keys split", and logs the attempt to the audit log of the profile, if it has one
+func checkCommandAllowed(cmd *cobra.Command, args []string) {
+	if activeProfile == nil || len(activeProfile.DisabledCommands) == 0 {
+		return
+	}
+	words := strings.Fields(strings.TrimPrefix(cmd.CommandPath(), cmd.Root().Name()))
+	if len(args) > 0 {
+		words = append(words, args[0])
+	}
+	if !commandDisabled(activeProfile.DisabledCommands, words) {
+		return
+	}
+	command := strings.Join(words, " ")
+	if err := writeAuditLog(activeProfile.AuditLog, command, "denied"); err != nil {
+		logger.Warnf("audit log: %s", err)
+	}
+	exitWithf(utils.ExitError, "'%s' is disabled in profile '%s'", command, activeProfile.Name)
+}
+
+// commandDisabled returns true when one of the disabled commands starts the words of a command
+func commandDisabled(disabled []string, words []string) bool {
+	for _, d := range disabled {
+		if strings.TrimSpace(d) == "decrypt" {
+			for _, c := range decryptingCommands {
+				if commandMatches([]string{c}, words) {
+					return true
+				}
+			}
+		}
+		if commandMatches(strings.Fields(d), words) {
+			return true
+		}
+	}
+	return false
+}
+
+// commandMatches returns true when the words of a disabled command start the words of a command
+func commandMatches(disabled []string, words []string) bool {
+	if len(disabled) == 0 || len(disabled) > len(words) {
+		return false
+	}
+	for i := range disabled {
+		if disabled[i] != words[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// writeAuditLog appends an event to the audit log, a JSON object per line
+func writeAuditLog(file string, command string, outcome string) error {
+	if file == "" {
+		return nil
+	}
+	host, _ := os.Hostname()
+	line, err := json.Marshal(auditEvent{
+		Time:    time.Now().UTC().Format(time.RFC3339),
+		User:    encryptingUser(),
+		Host:    host,
+		Profile: activeProfile.Name,
+		Command: command,
+		Outcome: outcome,
+	})
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Clean(file), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(line, '\n'))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,107 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"os"
+
+	"github.com/Everbridge/generate-secure-pillar/generate"
+	"github.com/Everbridge/generate-secure-pillar/sls"
+	"github.com/Everbridge/generate-secure-pillar/utils"
+	"github.com/spf13/cobra"
+)
+
+var templateFile string
+var forceGenerate bool
+
+// generateCmd represents the generate command
+var generateCmd = &cobra.Command{
+	Use:   "generate",
+	Short: "generate random secrets from a template, encrypt them, and write the sls file",
+	Long: `Generates a random value for each path in the template and encrypts it. When the output
+file already exists the paths that have a value are kept as they are, so that a template can be
+run again after adding to it. --force generates new values for all of the paths.`,
+	Example: `$ generate-secure-pillar -k "Salt Master" generate --template template.yaml --outfile new.sls`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if templateFile == "" {
+			exitWithf(utils.ExitUsage, "generate: --template is required")
+		}
+		tmpl, err := generate.Load(templateFile)
+		if err != nil {
+			exitWithf(utils.ExitUsage, "generate: %s: %s", templateFile, err)
+		}
+		outputFilePath, err := absPath(outputFilePath)
+		if err != nil {
+			logger.Fatal(err)
+		}
+
+		pk := getPki()
+		s := sls.New("", pk, topLevelElement)
+		exists := false
+		if info, err := os.Stat(outputFilePath); err == nil && info.Mode().IsRegular() {
+			exists = true
+			s = sls.New(outputFilePath, pk, topLevelElement)
+			if s.Error != nil {
+				fatal("generate", s.Error)
+			}
+		}
+		s.FilePath = outputFilePath
+
+		var names, values []string
+		for i := range tmpl.Secrets {
+			entry := &tmpl.Secrets[i]
+			name := s.ElementPaths([]string{entry.Path})[0]
+			if exists && !forceGenerate && s.PathExists(name) {
+				logger.Infof("%s already has a value, it was kept", name)
+				continue
+			}
+			value, err := entry.Generate()
+			if err != nil {
+				logger.Fatalf("generate: %s: %s", name, err)
+			}
+			names = append(names, name)
+			values = append(values, value)
+		}
+		if exists && len(names) == 0 {
+			logger.Infof("all of the paths in %s have values, '%s' was not written", templateFile, outputFilePath)
+			return
+		}
+
+		if err = s.ProcessYaml(names, values); err != nil {
+			logger.Fatalf("generate: %s", err)
+		}
+		buffer, err := s.FormatBuffer("")
+		if err != nil {
+			logger.Fatalf("generate: %s", err)
+		}
+		_, err = sls.WriteSlsFileContext(commandContext, buffer, outputFilePath)
+		if err != nil {
+			logger.Fatalf("generate: %s", err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(generateCmd)
+	generateCmd.PersistentFlags().StringVarP(&templateFile, "template", "t", "", "template listing the paths to generate secrets for")
+	generateCmd.PersistentFlags().StringVarP(&outputFilePath, "outfile", "o", os.Stdout.Name(), "output file (defaults to STDOUT)")
+	generateCmd.PersistentFlags().BoolVar(&forceGenerate, "force", false, "generate new values for paths that already have one")
+}
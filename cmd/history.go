@@ -0,0 +1,80 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/Everbridge/generate-secure-pillar/output"
+	"github.com/Everbridge/generate-secure-pillar/sls"
+	"github.com/Everbridge/generate-secure-pillar/utils"
+	"github.com/spf13/cobra"
+)
+
+// historyCmd represents the history command
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "list the previous values kept for a secret (see update --history)",
+	Example: `
+# list the previous values of a secret, with when they were replaced and the key used
+$ generate-secure-pillar history --path "secure_vars:db_password" --file new.sls`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if yamlPath == "" {
+			exitWithf(utils.ExitUsage, "history: --path is required")
+		}
+		pk := getPki()
+		s := sls.New(inputFilePath, pk, topLevelElement)
+		if s.Error != nil {
+			fatal("history", s.Error)
+		}
+
+		if !s.PathExists(yamlPath) {
+			fatal("history", s.PathError(yamlPath))
+		}
+		history := s.History(yamlPath)
+		if structuredOutput() {
+			items := []output.HistoryItem{}
+			for i, entry := range history {
+				items = append(items, output.HistoryItem{Index: i, Replaced: entry.Replaced, Key: entry.Key})
+			}
+			writeOutput(items)
+			return
+		}
+		if len(history) == 0 {
+			fmt.Printf("%s: no history\n", yamlPath)
+			return
+		}
+		for i, entry := range history {
+			key := entry.Key
+			if key == "" {
+				key = "unknown key"
+			}
+			fmt.Printf("%d: replaced %s (%s)\n", i, entry.Replaced, key)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+	historyCmd.PersistentFlags().StringVarP(&inputFilePath, "file", "f", os.Stdin.Name(), "input file (defaults to STDIN)")
+	historyCmd.PersistentFlags().StringVarP(&yamlPath, "path", "p", "", "YAML path of the secret")
+}
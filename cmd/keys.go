@@ -22,22 +22,36 @@ package cmd
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 
+	"github.com/Everbridge/generate-secure-pillar/metrics"
+	"github.com/Everbridge/generate-secure-pillar/output"
+	"github.com/Everbridge/generate-secure-pillar/pki"
+	"github.com/Everbridge/generate-secure-pillar/shamir"
 	"github.com/Everbridge/generate-secure-pillar/sls"
 	"github.com/Everbridge/generate-secure-pillar/utils"
 	"github.com/spf13/cobra"
 )
 
 const count = "count"
+const split = "split"
+const combine = "combine"
+const graph = "graph"
 
 var verbose bool
+var shareCount int
+var shareThreshold int
+var graphPaths bool
 
 // keysCmd represents the keys command
 var keysCmd = &cobra.Command{
-	Use:   "keys",
-	Short: "show PGP key IDs used",
+	Use:     "keys",
+	Aliases: []string{"k"},
+	Short:   "show PGP key IDs used",
 	PreRunE: func(cmd *cobra.Command, args []string) error {
 		if len(args) == 0 {
 			err := cmd.Help()
@@ -49,55 +63,341 @@ var keysCmd = &cobra.Command{
 		return nil
 	},
 	Run: func(cmd *cobra.Command, args []string) {
+		// these don't use a key
+		switch args[0] {
+		case split:
+			splitSecret()
+			return
+		case combine:
+			combineShares(args[1:])
+			return
+		}
+
+		if sshHost != "" {
+			runOverSSH(cmd, args)
+			return
+		}
 		pk := getPki()
 		outputFilePath = os.Stdout.Name()
-		inputFilePath, err := filepath.Abs(inputFilePath)
-		if err != nil {
-			logger.Fatal(err)
-		}
 
 		// process args
 		switch args[0] {
 		case all:
+			inputFilePath := inputFile()
 			if inputFilePath == os.Stdin.Name() && !stdinIsPiped() {
 				logger.Infof("reading from %s", os.Stdin.Name())
 			}
 			s := sls.New(inputFilePath, pk, topLevelElement)
+			if s.Error != nil {
+				fatal("keys", s.Error)
+			}
 			buffer, err := s.PerformAction("validate")
-			if err != nil {
-				logger.Fatal(err)
+			fatal("keys", err)
+			if structuredOutput() {
+				writeOutput(keyReport(&s))
+				return
 			}
 			fmt.Printf("%s\n", buffer.String())
+			report := keyReport(&s)
+			if verbose {
+				printRecipients(report)
+			}
+			printProvenance(report)
 		case recurse:
-			err := utils.ProcessDir(recurseDir, ".sls", "validate", outputFilePath, topLevelElement, pk)
+			if archivePath != "" {
+				keysArchive(pk)
+				return
+			}
+			if assembleIncludes {
+				logicalKeyReports(recurseDirectory(cmd), pk)
+				return
+			}
+			if structuredOutput() {
+				writeOutput(keyReports(recurseDirectory(cmd), pk))
+				return
+			}
+			err := utils.ProcessDirContext(commandContext, recurseDirectory(cmd), ".sls", walkLimits, "validate", outputFilePath, topLevelElement, pk)
 			if err != nil {
-				logger.Warnf("keys: %s", err)
+				warnOrFail("keys", err)
 			}
+		case graph:
+			keyGraph(recurseDirectory(cmd), pk)
 		case path:
+			inputFilePath := inputFile()
 			s := sls.New(inputFilePath, pk, topLevelElement)
-			utils.PathAction(&s, yamlPath, "validate")
+			if s.Error != nil {
+				fatal("keys", s.Error)
+			}
+			// each item of a list is reported on its own, as path[0], path[1], ...
+			var itemPaths []string
+			for _, p := range yamlPaths {
+				itemPaths = append(itemPaths, s.ItemPaths(p)...)
+			}
+			yamlPaths = itemPaths
+			pathAction(&s, sls.Validate, "")
 		case count:
+			inputFilePath := inputFile()
 			s := sls.New(inputFilePath, pk, topLevelElement)
-			_, err := s.PerformAction("validate")
-			if err != nil {
-				logger.Fatal(err)
+			if s.Error != nil {
+				fatal("keys", s.Error)
 			}
-			if verbose {
+			_, err := s.PerformAction("validate")
+			fatal("keys", err)
+			if structuredOutput() {
+				writeOutput(keyReport(&s))
+			} else if verbose {
 				fmt.Println(s.KeyMeta)
 			}
 			if s.KeyCount > 1 {
-				os.Exit(s.KeyCount)
+				metrics.Exit(s.KeyCount)
 			}
 		default:
-			logger.Fatalf("unknown argument: '%s'", args[0])
+			exitWithf(utils.ExitUsage, "unknown argument: '%s'", args[0])
 		}
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(keysCmd)
-	keysCmd.PersistentFlags().StringVarP(&yamlPath, "path", "p", "", "YAML path to examine")
+	addSSHFlags(keysCmd)
+	keysCmd.PersistentFlags().StringArrayVarP(&yamlPaths, "path", "p", nil, "YAML path(s) to examine")
 	keysCmd.PersistentFlags().StringVarP(&recurseDir, "dir", "d", "", "recurse over all .sls files in the given directory")
+	keysCmd.PersistentFlags().StringVar(&archivePath, "archive", "", archiveUsage)
 	keysCmd.PersistentFlags().StringVarP(&inputFilePath, "file", "f", os.Stdin.Name(), "input file (defaults to STDIN)")
-	keysCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "verbose output")
+	keysCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "verbose output, with all of the keys each value is encrypted to")
+	keysCmd.PersistentFlags().BoolVar(&assembleIncludes, "assemble", false, "with recurse, report each file together with the files it includes, as Salt assembles them")
+	keysCmd.PersistentFlags().BoolVar(&graphPaths, "paths", false, "with graph, add the path of each value between its file and key")
+	keysCmd.PersistentFlags().IntVar(&shareCount, "shares", 5, "number of shares 'keys split' creates, one per custodian")
+	keysCmd.PersistentFlags().IntVar(&shareThreshold, "threshold", 3, "number of shares needed to recover the secret with 'keys combine'")
+}
+
+// keyReport lists the keys found by the validate action
+func keyReport(s *sls.Sls) output.KeyReport {
+	report := output.KeyReport{File: s.FilePath, Keys: []output.Key{}, Uses: []output.KeyUse{}}
+	if s.FilePath == os.Stdin.Name() {
+		report.File = "-"
+	}
+	seen := make(map[string]bool)
+	values := s.EncryptedValues()
+	for p, desc := range s.KeyPaths() {
+		key := output.ParseKey(desc)
+		report.Uses = append(report.Uses, output.KeyUse{Path: p, Key: key, Recipients: recipientsOf(s.Pki, values[p])})
+		if !seen[key.KeyID] {
+			seen[key.KeyID] = true
+			report.Keys = append(report.Keys, key)
+		}
+	}
+	sort.Slice(report.Uses, func(i, j int) bool { return report.Uses[i].Path < report.Uses[j].Path })
+	sort.Slice(report.Keys, func(i, j int) bool { return report.Keys[i].KeyID < report.Keys[j].KeyID })
+	report.Count = len(report.Keys)
+	addProvenance(s, &report)
+	return report
+}
+
+// recipientsOf lists all of the keys a PGP value is encrypted to
+func recipientsOf(pk *pki.Pki, value string) []output.Recipient {
+	if value == "" || pki.IsNaclValue(value) {
+		return nil
+	}
+	found, err := pk.Recipients(value)
+	if err != nil {
+		return nil
+	}
+	recipients := make([]output.Recipient, len(found))
+	for i, r := range found {
+		recipients[i] = output.Recipient{KeyID: r.KeyID, Identity: r.Identity, Anonymous: r.Anonymous}
+	}
+	return recipients
+}
+
+// printRecipients prints the keys each value in a key report is encrypted to (keys all --verbose)
+func printRecipients(report output.KeyReport) {
+	for _, use := range report.Uses {
+		names := make([]string, len(use.Recipients))
+		for i, r := range use.Recipients {
+			switch {
+			case r.Anonymous:
+				names[i] = r.KeyID + " (anonymous, can be any key)"
+			case r.Identity == "":
+				names[i] = r.KeyID + " (not in the keyring)"
+			default:
+				names[i] = r.KeyID + " (" + r.Identity + ")"
+			}
+		}
+		if len(names) > 0 {
+			fmt.Printf("  %s: encrypted to %s\n", use.Path, strings.Join(names, ", "))
+		}
+	}
+}
+
+// keyReports lists the keys used in each .sls file in a directory
+func keyReports(dir string, pk pki.Pki) []output.KeyReport {
+	reports := []output.KeyReport{}
+	files := findFiles("keys", dir)
+	for _, file := range files {
+		s := sls.New(file, pk, topLevelElement)
+		if s.Error != nil {
+			warnOrFail("keys", s.Error)
+			continue
+		}
+		if _, err := s.PerformAction(sls.Validate); err != nil {
+			warnOrFail("keys", err)
+			continue
+		}
+		reports = append(reports, keyReport(&s))
+	}
+	return reports
+}
+
+// keysArchive lists the keys used in each file in --archive
+func keysArchive(pk pki.Pki) {
+	entries := readArchive()
+	if !structuredOutput() {
+		processArchive(entries, sls.Validate, pk)
+		return
+	}
+	reports := []output.KeyReport{}
+	for _, e := range utils.ArchiveFiles(entries, ".sls") {
+		s := e.Sls(commandContext, archivePath, topLevelElement, pk)
+		if s.Error != nil {
+			warnOrFail("keys", s.Error)
+			continue
+		}
+		if _, err := s.PerformAction(sls.Validate); err != nil {
+			warnOrFail("keys", err)
+			continue
+		}
+		reports = append(reports, keyReport(&s))
+	}
+	writeOutput(reports)
+}
+
+// keyGraph writes the graph of the files in a directory and the keys their values are encrypted with
+func keyGraph(dir string, pk pki.Pki) {
+	if dir == "" {
+		exitWithf(utils.ExitUsage, "keys graph needs a directory (-d)")
+	}
+	absDir, err := filepath.Abs(dir)
+	fatal("keys", err)
+	g := output.KeyGraph{Nodes: []output.GraphNode{}, Edges: []output.GraphEdge{}}
+	keys := map[string]bool{}
+	for _, report := range keyReports(absDir, pk) {
+		if len(report.Uses) == 0 {
+			continue
+		}
+		file := report.File
+		if rel, err := filepath.Rel(absDir, file); err == nil {
+			file = rel
+		}
+		g.Nodes = append(g.Nodes, output.GraphNode{ID: file, Kind: output.FileNode, Label: file})
+		counts := map[string]int{}
+		for _, use := range report.Uses {
+			if !keys[use.KeyID] {
+				keys[use.KeyID] = true
+				label := use.KeyID
+				if use.Identity != "" {
+					label += "\n" + use.Identity
+				}
+				g.Nodes = append(g.Nodes, output.GraphNode{ID: use.KeyID, Kind: output.KeyNode, Label: label})
+			}
+			if graphPaths {
+				id := file + ":" + use.Path
+				g.Nodes = append(g.Nodes, output.GraphNode{ID: id, Kind: output.PathNode, Label: use.Path})
+				g.Edges = append(g.Edges, output.GraphEdge{From: file, To: id, Count: 1}, output.GraphEdge{From: id, To: use.KeyID, Count: 1})
+				continue
+			}
+			counts[use.KeyID]++
+		}
+		ids := make([]string, 0, len(counts))
+		for id := range counts {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			g.Edges = append(g.Edges, output.GraphEdge{From: file, To: id, Count: counts[id]})
+		}
+	}
+
+	switch {
+	case output.IsGraph(outputFormat):
+		fatal("keys", output.WriteGraph(os.Stdout, outputFormat, g))
+	case structuredOutput():
+		writeOutput(g)
+	default:
+		for _, e := range g.Edges {
+			fmt.Printf("%s -> %s (%d)\n", e.From, e.To, e.Count)
+		}
+	}
+}
+
+// logicalKeyReports lists the keys used in each document assembled from the files in a directory
+func logicalKeyReports(dir string, pk pki.Pki) {
+	reports := []output.KeyReport{}
+	for _, doc := range logicalDocuments(dir, pk) {
+		buffer, err := doc.Sls.PerformAction(sls.Validate)
+		if err != nil {
+			warnOrFail("keys", err)
+			continue
+		}
+		report := keyReport(&doc.Sls)
+		report.Files = doc.Files
+		reports = append(reports, report)
+		if !structuredOutput() {
+			fmt.Printf("%s (%d files):\nkey count: %d\n%s\n", doc.Sls.FilePath, len(doc.Files), doc.Sls.KeyCount, buffer.String())
+		}
+	}
+	if structuredOutput() {
+		writeOutput(reports)
+	}
+}
+
+// logicalDocuments assembles the files in a directory with the files they include
+func logicalDocuments(dir string, pk pki.Pki) []utils.LogicalDocument {
+	docs, err := utils.LogicalDocuments(dir, ".sls", walkLimits, topLevelElement, pk)
+	fatal("include", err)
+	return docs
+}
+
+// splitSecret splits the exported private key (or passphrase) in --file into
+// shares, printed one per line
+func splitSecret() {
+	secret, err := ioutil.ReadFile(filepath.Clean(inputFilePath))
+	fatal("keys split", err)
+	shares, err := shamir.Split(secret, shareCount, shareThreshold)
+	if err != nil {
+		exitWithf(utils.ExitUsage, "keys split: %s", err)
+	}
+	for _, share := range shares {
+		fmt.Println(shamir.Encode(share, shareThreshold))
+	}
+}
+
+// combineShares recovers a secret from shares, read from the given files
+// (any number of shares per file) or one per line from STDIN
+func combineShares(files []string) {
+	var text []string
+	if len(files) == 0 {
+		files = []string{os.Stdin.Name()}
+	}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(filepath.Clean(file))
+		fatal("keys combine", err)
+		text = append(text, strings.Fields(string(data))...)
+	}
+
+	var shares [][]byte
+	threshold := 0
+	for _, t := range text {
+		share, k, err := shamir.Decode(t)
+		fatal("keys combine", err)
+		shares = append(shares, share)
+		threshold = k
+	}
+	if len(shares) < threshold {
+		exitWithf(utils.ExitError, "keys combine: %d shares are needed, got %d", threshold, len(shares))
+	}
+	secret, err := shamir.Combine(shares)
+	fatal("keys combine", err)
+	_, err = os.Stdout.Write(secret)
+	fatal("keys combine", err)
 }
@@ -0,0 +1,62 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+//go:build !encryptonly
+// +build !encryptonly
+
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/Everbridge/generate-secure-pillar/pki"
+	"github.com/spf13/cobra"
+)
+
+var lockStatus bool
+
+// lockCmd represents the lock command
+var lockCmd = &cobra.Command{
+	Use:   "lock",
+	Short: "drop the passphrase kept by 'unlock' before it expires",
+	Run: func(cmd *cobra.Command, args []string) {
+		socket := pki.AgentSocket()
+		if lockStatus {
+			status, err := pki.AgentStatus(socket)
+			if err != nil {
+				fmt.Println("locked")
+				return
+			}
+			fmt.Printf("unlocked until %s (%s left)\n", status.Expires.Format("15:04:05"), time.Until(status.Expires).Round(time.Second))
+			return
+		}
+		if err := pki.AgentLock(socket); err != nil {
+			logger.Infof("lock: %s", err)
+			return
+		}
+		fmt.Println("locked")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(lockCmd)
+	lockCmd.Flags().BoolVar(&lockStatus, "status", false, "show whether a passphrase is kept and until when, without locking")
+}
@@ -0,0 +1,99 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/Everbridge/generate-secure-pillar/sls"
+	"github.com/Everbridge/generate-secure-pillar/utils"
+	"github.com/spf13/cobra"
+)
+
+const writeArg = "write"
+const verifyArg = "verify"
+
+var manifestPath string
+
+// manifestCmd represents the manifest command
+var manifestCmd = &cobra.Command{
+	Use:   "manifest",
+	Short: "write or verify a signed checksum manifest of the .sls files in a directory",
+	Example: `
+# sign the checksums of every .sls file under a directory (writes MANIFEST.asc in the directory)
+$ generate-secure-pillar -k "Salt Master" manifest write -d /path/to/pillar/secure/stuff
+
+# check that no file was changed, removed, or added since the manifest was signed
+$ generate-secure-pillar -k "Salt Master" manifest verify -d /path/to/pillar/secure/stuff`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		dir := recurseDirectory(cmd)
+		if dir == "" {
+			exitWithf(utils.ExitUsage, "manifest: a directory is required (-d)")
+		}
+		if manifestPath == "" {
+			manifestPath = filepath.Join(dir, utils.ManifestName)
+		}
+
+		switch args[0] {
+		case writeArg:
+			pk := getPki()
+			manifest, err := utils.Manifest(dir, ".sls", walkLimits)
+			fatal("manifest", err)
+			signed, err := pk.ClearSign(manifest)
+			fatal("manifest", err)
+			_, err = sls.WriteSlsFileContext(commandContext, *bytes.NewBufferString(signed), manifestPath)
+			fatal("manifest", err)
+		case verifyArg:
+			pk := getPki()
+			signed, err := ioutil.ReadFile(filepath.Clean(manifestPath))
+			fatal("manifest", err)
+			manifest, err := pk.VerifySigned(string(signed))
+			if err != nil {
+				exitWithf(utils.ExitError, "manifest: %s: %s", manifestPath, err)
+			}
+			problems, err := utils.CheckManifest(dir, ".sls", walkLimits, manifest)
+			fatal("manifest", err)
+
+			if structuredOutput() {
+				writeOutput(problems)
+			} else {
+				for _, p := range problems {
+					fmt.Printf("%s: %s\n", p.File, p.Message)
+				}
+			}
+			if len(problems) > 0 {
+				exitWithf(utils.ExitError, "manifest: %d files do not match %s", len(problems), manifestPath)
+			}
+		default:
+			exitWithf(utils.ExitUsage, "unknown argument: '%s'", args[0])
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(manifestCmd)
+	manifestCmd.PersistentFlags().StringVarP(&recurseDir, "dir", "d", "", "directory of .sls files the manifest covers")
+	manifestCmd.PersistentFlags().StringVar(&manifestPath, "manifest", "", "manifest file (defaults to MANIFEST.asc in the directory)")
+}
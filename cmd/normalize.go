@@ -0,0 +1,173 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/Everbridge/generate-secure-pillar/output"
+	"github.com/Everbridge/generate-secure-pillar/pki"
+	"github.com/Everbridge/generate-secure-pillar/sls"
+	"github.com/spf13/cobra"
+)
+
+var fixPaths bool
+
+// normalizeCmd represents the normalize-paths command
+var normalizeCmd = &cobra.Command{
+	Use:   "normalize-paths",
+	Short: "report (or fix) keys that are written with different cases across files",
+	Example: `
+# list keys that are written with different cases across a directory
+$ generate-secure-pillar normalize-paths -d /path/to/pillar/secure/stuff
+
+# rename them to the most used spelling
+$ generate-secure-pillar normalize-paths --fix -d /path/to/pillar/secure/stuff`,
+	Run: func(cmd *cobra.Command, args []string) {
+		var files []string
+		if dir := recurseDirectory(cmd); dir != "" {
+			files = findFiles("normalize-paths", dir)
+		} else {
+			files = []string{inputFilePath}
+		}
+
+		docs := make(map[string]*sls.Sls)
+		for _, file := range files {
+			s := sls.New(file, pki.Pki{}, topLevelElement)
+			if s.Error != nil {
+				warnOrFail("normalize-paths", s.Error)
+				continue
+			}
+			if !s.IsInclude {
+				docs[file] = &s
+			}
+		}
+
+		conflicts := caseConflicts(docs)
+		if fixPaths {
+			for file, s := range docs {
+				if fixCase(s, conflicts) {
+					buffer, err := s.FormatBuffer("")
+					fatal("normalize-paths", err)
+					_, err = sls.WriteSlsFileContext(commandContext, buffer, file)
+					fatal("normalize-paths", err)
+				}
+			}
+			return
+		}
+
+		if structuredOutput() {
+			writeOutput(conflicts)
+			return
+		}
+		for _, c := range conflicts {
+			var spellings []string
+			for _, sp := range c.Spellings {
+				spellings = append(spellings, fmt.Sprintf("%s (%s)", sp.Path, strings.Join(sp.Files, ", ")))
+			}
+			fmt.Printf("%s: %s\n", c.Path, strings.Join(spellings, "; "))
+		}
+		if len(conflicts) > 0 {
+			warnOrFail("normalize-paths", fmt.Errorf("%d keys are written with different cases, use --fix to rename them", len(conflicts)))
+		}
+	},
+}
+
+// caseConflicts returns the paths written in more than one case, the most used spelling first
+func caseConflicts(docs map[string]*sls.Sls) []output.CaseConflict {
+	spellings := make(map[string]map[string][]string)
+	for file, s := range docs {
+		for _, p := range s.Paths() {
+			lower := strings.ToLower(p)
+			if spellings[lower] == nil {
+				spellings[lower] = make(map[string][]string)
+			}
+			spellings[lower][p] = append(spellings[lower][p], file)
+		}
+	}
+
+	conflicts := []output.CaseConflict{}
+	for _, bySpelling := range spellings {
+		if len(bySpelling) < 2 {
+			continue
+		}
+		c := output.CaseConflict{}
+		for p, files := range bySpelling {
+			sort.Strings(files)
+			c.Spellings = append(c.Spellings, output.Spelling{Path: p, Files: files})
+		}
+		sort.Slice(c.Spellings, func(i, j int) bool {
+			if len(c.Spellings[i].Files) != len(c.Spellings[j].Files) {
+				return len(c.Spellings[i].Files) > len(c.Spellings[j].Files)
+			}
+			return c.Spellings[i].Path < c.Spellings[j].Path
+		})
+		c.Path = c.Spellings[0].Path
+		conflicts = append(conflicts, c)
+	}
+	sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].Path < conflicts[j].Path })
+	return conflicts
+}
+
+// fixCase renames the keys in a document to the most used spelling, shortest paths first
+// so that parents are renamed before their children, it returns true if anything changed
+func fixCase(s *sls.Sls, conflicts []output.CaseConflict) bool {
+	canonical := make(map[string]string, len(conflicts))
+	for _, c := range conflicts {
+		canonical[strings.ToLower(c.Path)] = c.Path
+	}
+
+	changed := false
+	for depth := 1; ; depth++ {
+		found := false
+		for _, p := range s.Paths() {
+			parts := sls.SplitPath(p)
+			if len(parts) != depth {
+				continue
+			}
+			found = true
+			want, ok := canonical[strings.ToLower(p)]
+			if !ok || want == p {
+				continue
+			}
+			wantParts := sls.SplitPath(want)
+			if err := s.RenameKey(p, wantParts[len(wantParts)-1]); err != nil {
+				warnOrFail("normalize-paths", fmt.Errorf("%s: %s", s.FilePath, err))
+				continue
+			}
+			logger.Infof("%s: renamed %s to %s", s.FilePath, p, want)
+			changed = true
+		}
+		if !found {
+			return changed
+		}
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(normalizeCmd)
+	normalizeCmd.PersistentFlags().StringVarP(&inputFilePath, "file", "f", os.Stdin.Name(), "input file (defaults to STDIN)")
+	normalizeCmd.PersistentFlags().StringVarP(&recurseDir, "dir", "d", "", "recurse over all .sls files in the given directory")
+	normalizeCmd.PersistentFlags().BoolVar(&fixPaths, "fix", false, "rename the keys to the spelling used in the most files")
+}
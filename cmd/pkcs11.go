@@ -0,0 +1,47 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+//go:build !encryptonly
+// +build !encryptonly
+
+package cmd
+
+import (
+	"github.com/Everbridge/generate-secure-pillar/pki"
+	"github.com/Everbridge/generate-secure-pillar/utils"
+)
+
+// useToken decrypts with the private key on the --pkcs11-module token
+func useToken(pk *pki.Pki) {
+	if pkcs11Module == "" {
+		exitWithf(utils.ExitConfig, "the %s backend needs a PKCS#11 module (--pkcs11-module or pkcs11_module)", pki.PKCS11BackendName)
+	}
+	pin, err := pki.TokenPIN(pkcs11PINFrom, pkcs11Slot)
+	if pkcs11PINFrom == "" && profilePIN != "" {
+		pin = []byte(profilePIN)
+	}
+	if err != nil {
+		exitWithf(utils.ExitConfig, "PKCS#11 PIN: %s", err)
+	}
+	token := &pki.PKCS11Token{Module: pkcs11Module, Slot: pkcs11Slot, KeyID: pkcs11KeyID, PIN: pin}
+	if err = pk.UseToken(token); err != nil {
+		exitWithf(utils.ExitConfig, "%s", err)
+	}
+}
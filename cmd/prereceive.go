@@ -0,0 +1,225 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Everbridge/generate-secure-pillar/output"
+	"github.com/Everbridge/generate-secure-pillar/pki"
+	"github.com/Everbridge/generate-secure-pillar/sls"
+	"github.com/Everbridge/generate-secure-pillar/utils"
+	"github.com/spf13/cobra"
+)
+
+var allowedPubKeys []string
+var gitBinary = "git"
+
+// preReceiveCmd represents the pre-receive command
+var preReceiveCmd = &cobra.Command{
+	Use:   "pre-receive",
+	Short: "reject pushes with plain text secrets or values encrypted to keys that aren't allowed, from a git pre-receive hook",
+	Example: `
+# hooks/pre-receive on the git server, no keyring or private key is needed
+#!/bin/sh
+exec generate-secure-pillar --forbid 'BEGIN (RSA |EC )?PRIVATE KEY' -e secure_vars pre-receive --allow-pubkey /etc/gsp/master.asc --allow-pubkey /etc/gsp/escrow.asc`,
+	Run: func(cmd *cobra.Command, args []string) {
+		var allowed []pki.RecipientKey
+		for _, file := range allowedPubKeys {
+			keys, err := pki.ReadRecipientKeys(file)
+			fatal("pre-receive", err)
+			allowed = append(allowed, keys...)
+		}
+
+		problems := []output.Problem{}
+		seen := map[string]bool{}
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) != 3 {
+				continue
+			}
+			// a deleted ref adds nothing
+			if isZeroID(fields[1]) {
+				continue
+			}
+			blobs, err := pushedBlobs(fields[1])
+			fatal("pre-receive", err)
+			for _, b := range blobs {
+				if seen[b.id] {
+					continue
+				}
+				seen[b.id] = true
+				problems = append(problems, checkBlob(b, allowed)...)
+			}
+		}
+		fatal("pre-receive", scanner.Err())
+
+		if structuredOutput() {
+			writeOutput(problems)
+		} else {
+			for _, p := range problems {
+				fmt.Fprintf(os.Stderr, "%s: %s: %s\n", p.File, p.Path, p.Message)
+			}
+		}
+		if len(problems) > 0 {
+			exitWithf(utils.ExitError, "pre-receive: push rejected, %d problems found", len(problems))
+		}
+	},
+}
+
+// gitBlob is an .sls file added or changed by a pushed commit
+type gitBlob struct {
+	id     string
+	commit string
+	path   string
+}
+
+// isZeroID returns true for the all zero object name git uses for a ref that doesn't exist
+func isZeroID(id string) bool {
+	return strings.Trim(id, "0") == ""
+}
+
+// pushedBlobs lists the .sls files added or changed by the commits a push
+// adds, every commit is checked so that a secret removed again later in the
+// same push is still found in the history
+func pushedBlobs(newID string) ([]gitBlob, error) {
+	out, err := runGit("rev-list", "--reverse", newID, "--not", "--all")
+	if err != nil {
+		return nil, err
+	}
+	var blobs []gitBlob
+	for _, commit := range strings.Fields(string(out)) {
+		diff, err := runGit("diff-tree", "-r", "-z", "--root", "--no-commit-id", "--diff-filter=d", commit)
+		if err != nil {
+			return nil, err
+		}
+		// raw output is ":<mode> <mode> <blob> <blob> <status>" NUL <path> NUL
+		parts := strings.Split(string(diff), "\x00")
+		for i := 0; i+1 < len(parts); i += 2 {
+			info := strings.Fields(parts[i])
+			path := parts[i+1]
+			if len(info) < 5 || !strings.HasSuffix(path, ".sls") || strings.HasPrefix(info[1], "16") {
+				continue
+			}
+			blobs = append(blobs, gitBlob{id: info[3], commit: commit, path: path})
+		}
+	}
+	return blobs, nil
+}
+
+// checkBlob reads an .sls file from the repository and returns its problems
+func checkBlob(b gitBlob, allowed []pki.RecipientKey) []output.Problem {
+	file := fmt.Sprintf("%.12s:%s", b.commit, b.path)
+	data, err := runGit("cat-file", "blob", b.id)
+	if err != nil {
+		return []output.Problem{{File: file, Message: err.Error()}}
+	}
+
+	s := sls.NewWithOptions("", pki.Pki{}, topLevelElement, sls.DefaultOptions)
+	s.FilePath = filepath.Base(b.path)
+	if err = s.ReadBytes(data); err != nil {
+		return []output.Problem{{File: file, Message: err.Error()}}
+	}
+
+	var problems []output.Problem
+	add := func(path string, rule string, message string) {
+		problems = append(problems, output.Problem{File: file, Path: path, Message: message, Line: s.Line(path), Rule: rule})
+	}
+	for _, m := range s.ForbiddenPlainText() {
+		add(m.Path, "plaintext-secret", fmt.Sprintf("plain text matches the forbidden pattern '%s'", m.Pattern))
+	}
+	// without an element there is no telling secrets from other settings
+	if topLevelElement != "" {
+		for _, path := range s.PlainValues() {
+			add(path, "plaintext-secret", fmt.Sprintf("plain text value under '%s'", topLevelElement))
+		}
+	}
+
+	if len(allowed) == 0 {
+		return problems
+	}
+	values := s.EncryptedValues()
+	paths := make([]string, 0, len(values))
+	for path := range values {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		for _, message := range disallowedKeys(values[path], allowed) {
+			add(path, "disallowed-key", message)
+		}
+	}
+	return problems
+}
+
+// disallowedKeys lists the keys a value is encrypted to that aren't one of the allowed keys
+func disallowedKeys(value string, allowed []pki.RecipientKey) []string {
+	if pki.IsNaclValue(value) {
+		return []string{"nacl value isn't encrypted to an allowed key"}
+	}
+	ids, err := pki.EncryptedToKeyIDs(value)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	var problems []string
+	for _, id := range ids {
+		found := false
+		for _, key := range allowed {
+			for _, keyID := range key.KeyIDs {
+				found = found || id == keyID
+			}
+		}
+		if !found {
+			problems = append(problems, fmt.Sprintf("encrypted to key %016X, which isn't allowed", id))
+		}
+	}
+	return problems
+}
+
+// runGit runs git in the repository the hook was started in, with the
+// environment (GIT_DIR, quarantined objects) git gave the hook
+func runGit(args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	c := exec.CommandContext(commandContext, gitBinary, args...) // #nosec G204
+	c.Stdout = &stdout
+	c.Stderr = &stderr
+	if err := c.Run(); err != nil {
+		if commandContext.Err() != nil {
+			return nil, commandContext.Err()
+		}
+		return nil, fmt.Errorf("git %s: %s %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+func init() {
+	rootCmd.AddCommand(preReceiveCmd)
+	preReceiveCmd.PersistentFlags().StringArrayVar(&allowedPubKeys, "allow-pubkey", nil, "armored or binary public key file, every value must only be encrypted to these keys (can be repeated)")
+	preReceiveCmd.PersistentFlags().StringVar(&gitBinary, "git-binary", gitBinary, "the git command to run")
+}
@@ -0,0 +1,160 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"time"
+
+	"github.com/Everbridge/generate-secure-pillar/output"
+	"github.com/Everbridge/generate-secure-pillar/pki"
+	"github.com/Everbridge/generate-secure-pillar/sls"
+)
+
+var provenanceKey string
+
+// signingPki returns the Pki that signs provenance records, with the key of the person encrypting
+func signingPki() pki.Pki {
+	return signerPki(provenanceKey)
+}
+
+// signerPki returns a Pki that signs with the named key, from the GnuPG keyring with the gpg backend
+func signerPki(name string) pki.Pki {
+	if backendName == pki.GPGBackendName {
+		return pki.NewGPG(name, gnupgHome)
+	}
+	return pki.New(name, publicKeyRing, privateKeyRing)
+}
+
+// verifyingPki returns a Pki that checks provenance signatures with the local public keys,
+// the built in PGP backend has them in its pubring and the others use the GnuPG keyring
+func verifyingPki(pk pki.Pki) (pki.Pki, error) {
+	if pk.Backend == nil {
+		return pk, nil
+	}
+	if _, ok := pk.Backend.(*pki.GPGBackend); ok {
+		return pk, nil
+	}
+	g, err := pki.NewGPGBackend("", gnupgHome)
+	return pki.Pki{Backend: g}, err
+}
+
+// encryptingUser returns the name of the user running the command
+func encryptingUser() string {
+	if usr, err := user.Current(); err == nil && usr.Username != "" {
+		return usr.Username
+	}
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	return "unknown"
+}
+
+// readProvenance returns the records in a file's provenance sidecar and who signed them,
+// the records are nil when there is no sidecar
+func readProvenance(file string, pk pki.Pki) (map[string]sls.Provenance, string, error) {
+	signed, err := ioutil.ReadFile(filepath.Clean(sls.ProvenancePath(file)))
+	if os.IsNotExist(err) {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	text, signer, err := pk.SignedBy(string(signed))
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %s", sls.ProvenancePath(file), err)
+	}
+	records, err := sls.ParseProvenance(text)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %s", sls.ProvenancePath(file), err)
+	}
+	return records, signer, nil
+}
+
+// provenanceFiles records the provenance of the values in files written by encrypt, with --provenance-key
+func provenanceFiles(files []string, pk pki.Pki) {
+	if provenanceKey == "" {
+		return
+	}
+	for _, file := range files {
+		if file == "" || file == os.Stdout.Name() {
+			logger.Warnf("provenance: not recorded for output to STDOUT, use --outfile or --update")
+			continue
+		}
+		recordProvenance(file, pk)
+	}
+}
+
+// recordProvenance signs a record of who encrypted each value in a file and when,
+// the records of values that haven't changed since the last one are kept
+func recordProvenance(file string, pk pki.Pki) {
+	s := sls.New(file, pk, topLevelElement)
+	if s.Error != nil {
+		fatal("provenance", s.Error)
+	}
+	signer := signingPki()
+	old, _, err := readProvenance(file, signer)
+	if err != nil {
+		warnOrFail("provenance", fmt.Errorf("%s, starting a new record", err))
+	}
+	records := s.UpdateProvenance(old, encryptingUser(), time.Now())
+	signed, err := signer.ClearSign(sls.FormatProvenance(records))
+	fatal("provenance", err)
+	_, err = sls.WriteSlsFileContext(commandContext, *bytes.NewBufferString(signed), sls.ProvenancePath(file))
+	fatal("provenance", err)
+}
+
+// addProvenance adds the provenance of each value to a key report, from the file's sidecar
+func addProvenance(s *sls.Sls, report *output.KeyReport) {
+	if _, err := os.Stat(sls.ProvenancePath(s.FilePath)); err != nil {
+		return
+	}
+	verifier, err := verifyingPki(*s.Pki)
+	if err != nil {
+		warnOrFail("keys", err)
+		return
+	}
+	records, signer, err := readProvenance(s.FilePath, verifier)
+	if err != nil {
+		warnOrFail("keys", err)
+		return
+	}
+	current := s.CurrentProvenance(records)
+	for i, use := range report.Uses {
+		if rec, ok := current[use.Path]; ok {
+			report.Uses[i].Provenance = &output.Provenance{EncryptedBy: rec.User, EncryptedAt: rec.Time.Format(time.RFC3339), SignedBy: signer}
+		}
+	}
+}
+
+// printProvenance prints who encrypted each value in a key report, if it is known
+func printProvenance(report output.KeyReport) {
+	for _, use := range report.Uses {
+		if p := use.Provenance; p != nil {
+			fmt.Printf("  %s: encrypted by %s at %s (signed by %s)\n", use.Path, p.EncryptedBy, p.EncryptedAt, p.SignedBy)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"github.com/Everbridge/generate-secure-pillar/sls"
+	"github.com/Everbridge/generate-secure-pillar/utils"
+)
+
+var reportFile string
+
+const reportUsage = "write a JSON report of the files processed, their values, the keys used, and any failures to this file, signed with --pgp_key"
+
+// runReport starts the --report for an action over a directory, nil without --report
+func runReport(action string, dir string) *utils.RunReport {
+	if reportFile == "" || dir == "" {
+		return nil
+	}
+	return utils.NewRunReport(action, dir, encryptingUser(), Version)
+}
+
+// writeRunReport signs the --report of a run that ended with runErr (nil if it didn't fail)
+// with the configured key and writes it, so it can be attached to a change as evidence of what was done
+func writeRunReport(report *utils.RunReport, runErr error) {
+	if report == nil {
+		return
+	}
+	out, err := json.MarshalIndent(report.Finish(runErr), "", "  ")
+	fatal("report", err)
+	signer := signerPki(pgpKeyName)
+	signed, err := signer.ClearSign(string(out) + "\n")
+	if err != nil {
+		fatal("report", fmt.Errorf("unable to sign the report with '%s': %s", pgpKeyName, err))
+	}
+	_, err = sls.WriteSlsFileContext(commandContext, *bytes.NewBufferString(signed), reportFile)
+	fatal("report", err)
+	logger.Infof("report written to %s", reportFile)
+}
@@ -0,0 +1,88 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/Everbridge/generate-secure-pillar/sls"
+	"github.com/Everbridge/generate-secure-pillar/utils"
+	"github.com/spf13/cobra"
+)
+
+var rollbackIndex int
+
+// rollbackCmd represents the rollback command
+var rollbackCmd = &cobra.Command{
+	Use:   "rollback",
+	Short: "restore a previous value of a secret from its history",
+	Example: `
+# restore the most recent previous value of a secret
+$ generate-secure-pillar rollback --path "secure_vars:db_password" --file new.sls
+
+# restore a specific entry, as numbered by the history command
+$ generate-secure-pillar rollback --path "secure_vars:db_password" --index 0 --file new.sls`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if yamlPath == "" {
+			exitWithf(utils.ExitUsage, "rollback: --path is required")
+		}
+		inputFilePath, err := filepath.Abs(inputFilePath)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		if inputFilePath != os.Stdin.Name() {
+			outputFilePath = inputFilePath
+		}
+
+		pk := getPki()
+		s := sls.New(inputFilePath, pk, topLevelElement)
+		if s.Error != nil {
+			fatal("rollback", s.Error)
+		}
+
+		if !s.PathExists(yamlPath) {
+			fatal("rollback", s.PathError(yamlPath))
+		}
+		index := rollbackIndex
+		if !cmd.Flags().Changed("index") {
+			index = len(s.History(yamlPath)) - 1
+		}
+		if err = s.Rollback(yamlPath, index); err != nil {
+			logger.Fatalf("rollback: %s", err)
+		}
+		buffer, err := s.FormatBuffer("")
+		if err != nil {
+			logger.Fatalf("rollback: %s", err)
+		}
+		_, err = sls.WriteSlsFileContext(commandContext, buffer, outputFilePath)
+		if err != nil {
+			logger.Fatalf("rollback: %s", err)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(rollbackCmd)
+	rollbackCmd.PersistentFlags().StringVarP(&inputFilePath, "file", "f", os.Stdin.Name(), "input file (defaults to STDIN)")
+	rollbackCmd.PersistentFlags().StringVarP(&yamlPath, "path", "p", "", "YAML path of the secret")
+	rollbackCmd.PersistentFlags().IntVar(&rollbackIndex, "index", 0, "history entry to restore (defaults to the most recent)")
+}
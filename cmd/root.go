@@ -21,11 +21,21 @@ package cmd
 // THE SOFTWARE.
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 
+	"github.com/Everbridge/generate-secure-pillar/metrics"
+	"github.com/Everbridge/generate-secure-pillar/output"
 	"github.com/Everbridge/generate-secure-pillar/pki"
+	"github.com/Everbridge/generate-secure-pillar/schema"
+	"github.com/Everbridge/generate-secure-pillar/sls"
+	"github.com/Everbridge/generate-secure-pillar/utils"
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -45,6 +55,48 @@ var updateInPlace bool
 var topLevelElement string
 var recurseDir string
 var yamlPath string
+var yamlPaths []string
+var backendName = pki.PGPBackendName
+var gnupgHome string
+var envName string
+var maxValueSize = sls.DefaultOptions.MaxValueSize
+var chunkSize int
+var maxLineSize = sls.DefaultMaxLineSize
+var walkLimits utils.WalkLimits
+var whitespaceMode = sls.WhitespaceWarn
+var tempDir = os.Getenv("GSP_TEMP_DIR")
+var noConfig = os.Getenv("GSP_NO_CONFIG") != ""
+var outputFormat = output.Text
+var strict bool
+var metricsFile string
+var statsdAddr string
+var backendLimits = pki.DefaultLimits
+var secretKeyFrom = os.Getenv("GSP_SECRET_KEY_FROM")
+var passphraseFrom = os.Getenv("GSP_PASSPHRASE_FROM")
+var secretKeyFile string
+var passphraseRetries = 3
+var assumeYes bool
+var escrowKey = os.Getenv("GSP_ESCROW_KEY")
+var ignoreCase bool
+var warnOutsideElement bool
+var schemaFile string
+var forbiddenPatterns []string
+var pathDelimiter = sls.PathDelimiter
+var naclPublicKeyFile = pki.DefaultNaclPublicKeyFile
+var naclSecretKeyFile = pki.DefaultNaclSecretKeyFile
+var naclBoxType = pki.NaclSealedBox
+var pkcs11Module string
+var pkcs11Slot string
+var pkcs11KeyID string
+var pkcs11PINFrom = os.Getenv("GSP_PKCS11_PIN_FROM")
+var keyOrder = sls.KeyOrderSorted
+var indent = sls.DefaultIndent
+var armorWidth int
+var hashComments bool
+var keepQuotes bool
+var preserveExact bool
+var failOnDuplicates bool
+var assembleIncludes bool
 
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
@@ -58,7 +110,7 @@ $ generate-secure-pillar --profile dev create --name secret_name1 --value secret
 $ generate-secure-pillar -k "Salt Master" create --name secret_name1 --value secret_value1 --name secret_name2 --value secret_value2 --outfile new.sls
 
 # add to the new file
-$ generate-secure-pillar -k "Salt Master" update --name new_secret_name --value new_secret_value --file new.sls
+$ generate-secure-pillar -k "Salt Master" update --create --name new_secret_name --value new_secret_value --file new.sls
 
 # update an existing value
 $ generate-secure-pillar -k "Salt Master" update --name secret_name --value secret_value3 --file new.sls
@@ -92,7 +144,22 @@ $ generate-secure-pillar keys recurse -d /path/to/pillar/secure/stuff
 # show the PGP Key ID used for an element at a path in a file
 $ generate-secure-pillar keys path --path "some:yaml:path" --file new.sls
 `,
-	Version: "1.0.592",
+	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		metrics.SetCommand(strings.TrimPrefix(cmd.CommandPath(), cmd.Root().Name()+" "))
+		checkCommandAllowed(cmd, args)
+		if output.IsGraph(outputFormat) && (cmd != keysCmd || len(args) == 0 || args[0] != graph) {
+			exitWithf(utils.ExitUsage, "--format %s is only for 'keys graph'", outputFormat)
+		}
+		if outputFormat == output.SARIF && cmd.Name() != "verify" {
+			exitWithf(utils.ExitUsage, "--format %s is only for 'verify'", outputFormat)
+		}
+	},
+	PersistentPostRun: func(cmd *cobra.Command, args []string) {
+		cancelCommand()
+		if err := metrics.Emit(0); err != nil {
+			logger.Warnf("metrics: %s", err)
+		}
+	},
 }
 
 const all = "all"
@@ -102,107 +169,630 @@ const path = "path"
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
+	legacyHelpAlias()
+	rootCmd.SetArgs(legacyArgs(os.Args[1:]))
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
-		os.Exit(1)
+		metrics.Exit(utils.ExitUsage)
 	}
 }
 
 func init() {
 	logger.Out = os.Stdout
+	logger.ExitFunc = metrics.Exit
 	cobra.OnInitialize(initConfig)
-
-	// respect the env var if set
-	gpgHome := os.Getenv("GNUPGHOME")
-	if gpgHome != "" {
-		publicKeyRing = fmt.Sprintf("%s/pubring.gpg", gpgHome)
-		privateKeyRing = fmt.Sprintf("%s/secring.gpg", gpgHome)
+	if pki.EncryptOnly {
+		rootCmd.Short = "Create and update encrypted content (encrypt only build, without decryption)."
 	}
 
-	// check for GNUPG1 pubring file
-	filePath, err := tilde.Expand(publicKeyRing)
-	if err != nil {
-		logger.Fatalf("Error with GNUPG pubring path: %s", err)
-	}
-	if _, err = os.Stat(filepath.Clean(filePath)); os.IsNotExist(err) {
-		if err != nil {
-			logger.Fatalf("Error finding GNUPG pubring file: %s", err)
-		}
+	// respect the env var if set
+	gnupgHome = os.Getenv("GNUPGHOME")
+	if gnupgHome != "" {
+		publicKeyRing = fmt.Sprintf("%s/pubring.gpg", gnupgHome)
+		privateKeyRing = fmt.Sprintf("%s/secring.gpg", gnupgHome)
 	}
 
 	rootCmd.PersistentFlags().Bool("version", false, "print the version")
-	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.config/generate-secure-pillar/config.yaml)")
+	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $XDG_CONFIG_HOME/generate-secure-pillar/config.yaml or $HOME/.config/generate-secure-pillar/config.yaml)")
 	rootCmd.PersistentFlags().StringVar(&profile, "profile", "", "config file (default is $HOME/.config/generate-secure-pillar/config.yaml)")
 	rootCmd.PersistentFlags().StringVarP(&pgpKeyName, "pgp_key", "k", pgpKeyName, "PGP key name, email, or ID to use for encryption")
 	rootCmd.PersistentFlags().StringVar(&publicKeyRing, "pubring", publicKeyRing, "PGP public keyring")
 	rootCmd.PersistentFlags().StringVar(&privateKeyRing, "secring", privateKeyRing, "PGP private keyring")
 	rootCmd.PersistentFlags().StringVarP(&topLevelElement, "element", "e", "", "Name of the top level element under which encrypted key/value pairs are kept")
+	rootCmd.PersistentFlags().StringVar(&envName, "env", "", "environment name, selects the directory, element, and profile for it (default conventions: <env>/, <env>_secure_vars, <env>)")
+	rootCmd.PersistentFlags().IntVar(&maxValueSize, "max-value-size", maxValueSize, "warn when encrypting a value larger than this many bytes (0 for no limit)")
+	rootCmd.PersistentFlags().IntVar(&maxLineSize, "max-line-size", maxLineSize, "longest line read from a file, e.g. an armored value or minified JSON on one line")
+	rootCmd.PersistentFlags().StringVar(&whitespaceMode, "whitespace", whitespaceMode, "what to do about CRLF line endings and whitespace in encrypted values when reading a file: warn, error, or fix")
+	rootCmd.PersistentFlags().IntVar(&walkLimits.MaxDepth, "max-depth", 0, "fail when a directory a command recurses over has a directory more than this many levels below it (0 for no limit)")
+	rootCmd.PersistentFlags().IntVar(&walkLimits.MaxFiles, "max-files", 0, "fail when a directory a command recurses over has more than this many .sls files (0 for no limit)")
+	rootCmd.PersistentFlags().IntVar(&chunkSize, "chunk-size", 0, "split values larger than this many bytes across list items when encrypting (0 to never split)")
+	rootCmd.PersistentFlags().StringVar(&tempDir, "temp-dir", tempDir, "directory for temporary files, defaults to the directory of the file being written (or set GSP_TEMP_DIR)")
+	rootCmd.PersistentFlags().BoolVar(&noConfig, "no-config", noConfig, "do not read any config file, use only flags and environment variables (or set GSP_NO_CONFIG)")
+	rootCmd.PersistentFlags().StringVar(&outputFormat, "format", outputFormat, "output format for reports (keys, path, expiring, history, config list/show): text, json, or yaml, dot or mermaid for 'keys graph', or sarif for 'verify'")
+	rootCmd.PersistentFlags().BoolVar(&strict, "strict", false, "treat warnings (include files, a missing element, values of unsupported types, failed files when recursing) as errors, exiting with status 4")
+	rootCmd.PersistentFlags().StringVar(&metricsFile, "metrics-file", "", "write OpenMetrics counters (files processed, values encrypted, failures, duration) to this file when done")
+	rootCmd.PersistentFlags().StringVar(&statsdAddr, "statsd", "", "send StatsD counters (files processed, values encrypted, failures, duration) to this host:port over UDP when done")
+	rootCmd.PersistentFlags().IntVar(&backendLimits.Concurrency, "max-concurrency", backendLimits.Concurrency, "most requests in flight at once to a remote backend (0 for no limit)")
+	rootCmd.PersistentFlags().IntVar(&backendLimits.Retries, "retries", backendLimits.Retries, "times a throttled or timed out request to a remote backend is retried")
+	rootCmd.PersistentFlags().DurationVar(&backendLimits.Backoff, "retry-backoff", backendLimits.Backoff, "delay before the first retry of a remote backend request, doubled for each retry after that")
+	rootCmd.PersistentFlags().DurationVar(&commandTimeout, "timeout", 0, "give up when a command runs for longer than this, e.g. 5m, killing any gpg or secret store CLI it started (0 for no limit)")
+	rootCmd.PersistentFlags().IntVar(&backendLimits.BatchSize, "batch-size", backendLimits.BatchSize, "most values sent in one request to a remote backend that supports batches")
+	rootCmd.PersistentFlags().StringVar(&secretKeyFrom, "secret-key-from", secretKeyFrom, "read the armored private key from aws-sm://<id>, aws-ssm://<name>, gcp-sm://projects/<p>/secrets/<s>, env://<var>, or file://<path> instead of the secring (or set GSP_SECRET_KEY_FROM)")
+	rootCmd.PersistentFlags().StringVar(&secretKeyFile, "secret-key-file", "", "read the armored private key from this file instead of the secring, no keyring is needed (or set GSP_SECRET_KEY to the armored key)")
+	rootCmd.PersistentFlags().StringVar(&passphraseFrom, "passphrase-from", passphraseFrom, "read the private key passphrase from a secret store, same forms as --secret-key-from (or set GSP_PASSPHRASE_FROM)")
+	rootCmd.PersistentFlags().IntVar(&passphraseRetries, "passphrase-retries", passphraseRetries, "how many times to ask for the private key passphrase again when it is wrong, when there is a terminal (0 to fail straight away)")
+	rootCmd.PersistentFlags().StringVar(&pathDelimiter, "delimiter", pathDelimiter, "separator between the keys in --path and --name, a key containing it can also be written with a backslash before it (a\\:b)")
+	rootCmd.PersistentFlags().StringVar(&keyOrder, "key-order", keyOrder, "order of the keys in written files: sorted, or original (as read, with new keys after them sorted)")
+	rootCmd.PersistentFlags().IntVar(&indent, "indent", indent, "spaces per nesting level in written files (2 to 9)")
+	rootCmd.PersistentFlags().IntVar(&armorWidth, "armor-width", 0, "rewrap the lines of PGP armored values in written files to this width (0 to leave them as they are)")
+	rootCmd.PersistentFlags().BoolVar(&keepQuotes, "keep-quotes", false, "write plain text values quoted or unquoted as they were read, e.g. on stays unquoted and '0123' single quoted, decrypted values are quoted where needed")
+	rootCmd.PersistentFlags().BoolVar(&preserveExact, "preserve-exact", false, "write files by replacing only the text of the values that changed, so no other byte changes, failing when that isn't possible")
+	rootCmd.PersistentFlags().BoolVar(&hashComments, "hash-comments", false, "write a comment with the start of each encrypted value's SHA-256 digest next to it in written files")
+	rootCmd.PersistentFlags().BoolVar(&failOnDuplicates, "fail-on-duplicate-keys", false, "fail reading a file with a key given more than once in the same map, instead of warning and keeping the last one")
+	rootCmd.PersistentFlags().BoolVar(&ignoreCase, "ignore-case", false, "match the keys in --path and --name case insensitively when there is no exact match")
+	rootCmd.PersistentFlags().StringVar(&schemaFile, "schema", "", "JSON Schema (JSON or YAML) that files must match before and after they are encrypted, decrypted, or rotated")
+	rootCmd.PersistentFlags().StringArrayVar(&forbiddenPatterns, "forbid", nil, "regular expression that fails encrypt and verify when it matches a key or plain text value, e.g. 'BEGIN RSA PRIVATE KEY' (can be repeated)")
+	rootCmd.PersistentFlags().BoolVar(&warnOutsideElement, "warn-outside-element", false, "warn about each plain text value outside --element when encrypting (an error with --strict)")
+	rootCmd.PersistentFlags().StringVar(&escrowKey, "escrow-key", escrowKey, "PGP key name, email, ID, or fingerprint of an escrow key that every value is also encrypted to (or set GSP_ESCROW_KEY)")
+	rootCmd.PersistentFlags().BoolVar(&assumeYes, "yes", false, "do not ask for confirmation before writing plain text over a directory (required when not run interactively)")
+	rootCmd.PersistentFlags().StringVar(&backendName, "backend", backendName, "encryption backend: pgp (built in), gpg (system gpg binary, respects gpg-agent and smartcards), pkcs11 (built in, decrypting with a key on a PKCS#11 token), or nacl (NACL[] values for Salt's nacl renderer)")
+	rootCmd.PersistentFlags().StringVar(&pkcs11Module, "pkcs11-module", pkcs11Module, "pkcs11 backend PKCS#11 module (e.g. /usr/lib/softhsm/libsofthsm2.so)")
+	rootCmd.PersistentFlags().StringVar(&pkcs11Slot, "pkcs11-slot", pkcs11Slot, "pkcs11 backend token slot (default: the first slot with a token)")
+	rootCmd.PersistentFlags().StringVar(&pkcs11KeyID, "pkcs11-key-id", pkcs11KeyID, "pkcs11 backend ID (hex) of the RSA private key object on the token")
+	rootCmd.PersistentFlags().StringVar(&pkcs11PINFrom, "pkcs11-pin-from", pkcs11PINFrom, "pkcs11 backend PIN source: prompt, agent (gpg-agent), or a secret store, same forms as --secret-key-from (or set GSP_PKCS11_PIN_FROM)")
+	rootCmd.PersistentFlags().StringVar(&naclPublicKeyFile, "nacl-pk-file", naclPublicKeyFile, "nacl backend public key file, as written by 'salt-run nacl.keygen'")
+	rootCmd.PersistentFlags().StringVar(&naclSecretKeyFile, "nacl-sk-file", naclSecretKeyFile, "nacl backend secret key file, as written by 'salt-run nacl.keygen'")
+	rootCmd.PersistentFlags().StringVar(&naclBoxType, "nacl-box-type", naclBoxType, "nacl backend box type: sealedbox (encrypt with the public key) or secretbox (the secret key), as set in nacl.config")
 }
 
+// systemConfigFile holds site wide settings, the user config file is merged over it
+var systemConfigFile = "/etc/generate-secure-pillar/config.yaml"
+
 // initConfig reads in config file and ENV variables if set.
 func initConfig() {
-	if cfgFile != "" {
-		// Use config file from the flag.
-		viper.SetConfigFile(cfgFile)
+	viper.SetConfigType("yaml")
+	viper.AutomaticEnv() // read in environment variables that match
+
+	if err := output.Check(outputFormat); err != nil {
+		exitWithf(utils.ExitUsage, "%s", err)
+	}
+	if output.IsStructured(outputFormat) || output.IsGraph(outputFormat) || outputFormat == output.SARIF {
+		// keep stdout for the report itself
+		logToStderr()
+	}
+
+	metrics.Configure(metricsFile, statsdAddr)
+	if commandTimeout < 0 {
+		exitWithf(utils.ExitUsage, "--timeout can't be negative")
+	}
+	startTimeout()
+	if pathDelimiter == "" || strings.Contains(pathDelimiter, `\`) {
+		exitWithf(utils.ExitUsage, "--delimiter must be set and can't contain a backslash")
+	}
+	sls.PathDelimiter = pathDelimiter
+	sls.TempDir = tempDir
+	if noConfig {
+		if profile != "" {
+			profileErr = fmt.Errorf("--profile cannot be used with --no-config")
+		}
+		readEnvConventions()
+		applyEnvConventions()
 	} else {
-		// Find home directory.
-		home, err := homedir.Dir()
+		readConfig()
+	}
+	useSecretKeyFile()
+	if pki.EncryptOnly {
+		// after the profile is applied, so that its settings are checked too
+		checkEncryptOnly()
+	}
+
+	if keyOrder != sls.KeyOrderSorted && keyOrder != sls.KeyOrderOriginal {
+		exitWithf(utils.ExitUsage, "--key-order must be %s or %s", sls.KeyOrderSorted, sls.KeyOrderOriginal)
+	}
+	if indent < 2 || indent > 9 {
+		exitWithf(utils.ExitUsage, "--indent must be between 2 and 9")
+	}
+	if armorWidth < 0 {
+		exitWithf(utils.ExitUsage, "--armor-width can't be negative")
+	}
+	if walkLimits.MaxDepth < 0 || walkLimits.MaxFiles < 0 {
+		exitWithf(utils.ExitUsage, "--max-depth and --max-files can't be negative")
+	}
+
+	var pillarSchema *schema.Schema
+	if schemaFile != "" {
+		var err error
+		pillarSchema, err = schema.Load(schemaFile)
 		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+			exitWithf(utils.ExitUsage, "--schema: %s", err)
 		}
+	}
 
-		configPath := fmt.Sprintf("%s/.config/generate-secure-pillar/", home)
-		dir := filepath.Clean(configPath)
-		err = os.MkdirAll(dir, 0700)
+	forbidden, err := sls.CompilePatterns(forbiddenPatterns)
+	if err != nil {
+		exitWithf(utils.ExitUsage, "--forbid: %s", err)
+	}
+
+	if err = sls.CheckWhitespaceMode(whitespaceMode); err != nil {
+		exitWithf(utils.ExitUsage, "--whitespace: %s", err)
+	}
+	if err = sls.CheckPathPatterns(includePaths); err != nil {
+		exitWithf(utils.ExitUsage, "--include-path: %s", err)
+	}
+	if err = sls.CheckPathPatterns(excludePaths); err != nil {
+		exitWithf(utils.ExitUsage, "--exclude-path: %s", err)
+	}
+
+	sls.DefaultOptions = sls.Options{
+		MaxValueSize:        maxValueSize,
+		MaxLineSize:         maxLineSize,
+		Whitespace:          whitespaceMode,
+		ChunkSize:           chunkSize,
+		Strict:              strict,
+		IgnoreCase:          ignoreCase,
+		KeyOrder:            keyOrder,
+		Indent:              indent,
+		ArmorWidth:          armorWidth,
+		HashComments:        hashComments,
+		KeepQuotes:          keepQuotes,
+		PreserveExact:       preserveExact,
+		FailOnDuplicateKeys: failOnDuplicates,
+		WarnOutsideElement:  warnOutsideElement,
+		Schema:              pillarSchema,
+		Forbidden:           forbidden,
+		IncludePaths:        includePaths,
+		ExcludePaths:        excludePaths,
+		StyleHints:          styleHints,
+		Context:             commandContext,
+	}
+}
+
+// readConfig reads the user and system config files and selects the profile,
+// the config file is only read here, 'config init' and the other config commands create it
+func readConfig() {
+	userFile := cfgFile
+	if userFile == "" {
+		dir, err := userConfigDir()
 		if err != nil {
-			logger.Fatalf("error creating config file path: %s", err)
+			// no home directory (e.g. a container), so there is no user config
+			logger.Debugf("no user config: %s", err)
+		} else {
+			userFile = filepath.Join(dir, "config.yaml")
 		}
-		_, err = os.OpenFile(dir+"/config.yaml", os.O_RDONLY|os.O_CREATE, 0660)
+	}
+
+	// If a config file is found, read it in.
+	if userFile != "" {
+		viper.SetConfigFile(userFile)
+		if _, err := os.Stat(userFile); err == nil || cfgFile != "" {
+			err = viper.ReadInConfig() // Find and read the config file
+			if err != nil {            // Handle errors reading the config file
+				exitWithf(utils.ExitConfig, "Fatal error config file: %s", err)
+			}
+		}
+	}
+	mergeSystemConfig()
+	readEnvConventions()
+	readProfile()
+	applyEnvConventions()
+}
+
+// userConfigDir returns $XDG_CONFIG_HOME/generate-secure-pillar, or ~/.config/generate-secure-pillar
+func userConfigDir() (string, error) {
+	base := os.Getenv("XDG_CONFIG_HOME")
+	if base == "" {
+		home, err := homedir.Dir()
 		if err != nil {
-			logger.Fatalf("Error creating config file: %s", err)
+			return "", err
 		}
+		base = filepath.Join(home, ".config")
+	}
 
-		// set config in "~/.config/generate-secure-pillar/config.yaml".
-		viper.AddConfigPath(configPath)
-		viper.SetConfigName("config")
-		viper.SetConfigType("yaml")
+	return filepath.Join(base, "generate-secure-pillar"), nil
+}
+
+// mergeSystemConfig uses the system config file for any settings not in the user file,
+// profiles are merged by name with the user's profiles taking precedence
+func mergeSystemConfig() {
+	if _, err := os.Stat(systemConfigFile); os.IsNotExist(err) {
+		return
+	}
+	sys := viper.New()
+	sys.SetConfigFile(systemConfigFile)
+	if err := sys.ReadInConfig(); err != nil {
+		exitWithf(utils.ExitConfig, "Fatal error config file: %s", err)
 	}
 
-	viper.AutomaticEnv() // read in environment variables that match
+	for _, key := range sys.AllKeys() {
+		viper.SetDefault(key, sys.Get(key))
+	}
+	if err := sys.UnmarshalKey("profiles", &systemProfiles); err != nil {
+		exitWithf(utils.ExitConfig, "Fatal error config file: %s: %s", systemConfigFile, err)
+	}
+	if sysList, ok := sys.Get("profiles").([]interface{}); ok {
+		userList, _ := viper.Get("profiles").([]interface{})
+		viper.Set("profiles", mergeProfileLists(sysList, userList))
+	}
+}
 
-	// If a config file is found, read it in.
-	err := viper.ReadInConfig() // Find and read the config file
-	if err != nil {             // Handle errors reading the config file
-		logger.Fatalf("Fatal error config file: %s", err)
+// logToStderr sends all log messages to stderr, leaving stdout for output
+func logToStderr() {
+	setLogOutput(os.Stderr)
+}
+
+// setLogOutput sends the log messages of every package to w
+func setLogOutput(w io.Writer) {
+	logger.Out = w
+	sls.SetLogOutput(w)
+	pki.SetLogOutput(w)
+	utils.SetLogOutput(w)
+}
+
+// failureHints say what to do about an error of a failure class
+var failureHints = map[error]string{
+	pki.ErrKeyNotFound:    "check --pgp_key and the key rings (--pubring and --secring)",
+	pki.ErrWrongKey:       "it is encrypted to a key that is not available here, 'keys path' shows which one",
+	pki.ErrCorruptArmor:   "the value was damaged (e.g. by a merge conflict), restore it from version control or encrypt it again",
+	sls.ErrNotEncrypted:   "it is plain text, 'encrypt path' encrypts it",
+	sls.ErrIncludeSkipped: "without --strict files with include directives are skipped",
+	sls.ErrMergeConflict:  "resolve the conflict by keeping one side's value whole, a value merged from both sides can't be decrypted",
+	sls.ErrDuplicateKey:   "remove all but one of the keys, Salt refuses to render a file with a duplicate key",
+}
+
+// failureHint returns the hint for the failure class of err, if it has one
+func failureHint(err error) string {
+	for class, hint := range failureHints {
+		if errors.Is(err, class) {
+			return hint
+		}
 	}
-	readProfile()
+	return ""
 }
 
-func getPki() pki.Pki {
-	return pki.New(pgpKeyName, publicKeyRing, privateKeyRing)
-}
-
-func readProfile() {
-	if viper.IsSet("profiles") {
-		profiles := viper.Get("profiles")
-		profName := rootCmd.Flag("profile").Value.String()
-
-		if profName != "" || pgpKeyName == "" {
-			for _, prof := range profiles.([]interface{}) {
-				p := prof.(map[interface{}]interface{})
-				if p["default"] == true || profName == p["name"] {
-					gpgHome := p["gnupg_home"].(string)
-					if gpgHome != "" {
-						publicKeyRing = fmt.Sprintf("%s/pubring.gpg", gpgHome)
-						privateKeyRing = fmt.Sprintf("%s/secring.gpg", gpgHome)
-					}
-					if p["default_key"] != nil {
-						pgpKeyName = p["default_key"].(string)
-					}
-				}
+// fatal logs an error and exits with the exit code for it, it does nothing for a nil error
+func fatal(prefix string, err error) {
+	if err != nil {
+		err = timedOut(err)
+		if hint := failureHint(err); hint != "" {
+			exitWithf(utils.ExitCode(err), "%s: %s (%s)", prefix, err, hint)
+		}
+		exitWithf(utils.ExitCode(err), "%s: %s", prefix, err)
+	}
+}
+
+// warnOrFail logs an error as a warning, with --strict it exits with utils.ExitStrict,
+// a command that timed out always fails
+func warnOrFail(prefix string, err error) {
+	if commandContext.Err() == context.DeadlineExceeded {
+		fatal(prefix, err)
+	}
+	if strict {
+		exitWithf(utils.ExitStrict, "%s: %s", prefix, err)
+	}
+	logger.Warnf("%s: %s", prefix, err)
+}
+
+// exitWithf logs an error and exits with the given exit code
+func exitWithf(code int, format string, args ...interface{}) {
+	logger.Errorf(format, args...)
+	metrics.Exit(code)
+}
+
+// confirm asks the user to confirm an operation, without --yes it fails when stdin is not a terminal
+func confirm(question string) bool {
+	ok, err := confirmFrom(os.Stdin, !stdinIsPiped(), question)
+	if err != nil {
+		exitWithf(utils.ExitUsage, "%s", err)
+	}
+	return ok
+}
+
+// confirmFrom reads the answer to a confirmation from in, anything but yes (including
+// no answer at all) is a no, it's an error when in is not interactive and --yes wasn't given
+func confirmFrom(in io.Reader, interactive bool, question string) (bool, error) {
+	if assumeYes {
+		return true, nil
+	}
+	if !interactive {
+		return false, fmt.Errorf("%s: not confirmed, use --yes when not running interactively", question)
+	}
+	fmt.Fprintf(os.Stderr, "%s (y/n) [n]: ", question)
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes", nil
+}
+
+// structuredOutput returns true when --format asks for json or yaml
+func structuredOutput() bool {
+	return output.IsStructured(outputFormat)
+}
+
+// findFiles returns the .sls files in a directory, within the --max-depth and --max-files limits
+func findFiles(prefix string, dir string) []string {
+	files, err := utils.FindFilesByExtLimited(dir, ".sls", walkLimits)
+	fatal(prefix, err)
+	return files
+}
+
+// absPath returns the absolute path of a file, URLs are returned as they are
+func absPath(file string) (string, error) {
+	if sls.IsRemote(file) {
+		return file, nil
+	}
+	return filepath.Abs(file)
+}
+
+// inputPath returns the absolute path of an input file, "-" is STDIN, and a directory
+// is resolved to its init.sls or the sls its top.sls names
+func inputPath(file string) (string, error) {
+	if file == "-" {
+		return os.Stdin.Name(), nil
+	}
+	full, err := absPath(file)
+	if err != nil || sls.IsRemote(full) {
+		return full, err
+	}
+	if fi, err := os.Stat(full); err == nil && fi.IsDir() {
+		resolved, err := sls.ResolveDir(full)
+		if err != nil {
+			return full, err
+		}
+		logger.Infof("using %s for %s", resolved, file)
+		return resolved, nil
+	}
+	return full, nil
+}
+
+// inputFile returns the -f file, resolved by inputPath, for the commands that read one
+func inputFile() string {
+	file, err := inputPath(inputFilePath)
+	if err != nil {
+		logger.Fatal(err)
+	}
+	return file
+}
+
+// writeOutput writes a report to stdout in the --format format
+func writeOutput(v interface{}) {
+	if err := output.Write(os.Stdout, outputFormat, v); err != nil {
+		logger.Fatal(err)
+	}
+}
+
+// pathAction applies an action to the values at the --path paths and reports the results,
+// with an output file the document is written once with all of the values changed
+func pathAction(s *sls.Sls, action string, outFile string) {
+	if len(yamlPaths) == 0 {
+		exitWithf(utils.ExitUsage, "--path is required")
+	}
+	if outFile != "" && outFile != os.Stdout.Name() {
+		// all of the paths are changed in the document and it is written once
+		for _, p := range yamlPaths {
+			if !s.PathExists(p) {
+				fatal("path", s.PathError(p))
 			}
 		}
+		_, err := s.ProcessPaths(yamlPaths, action)
+		fatal("path action failed", err)
+		buffer, err := s.FormatBuffer("")
+		utils.SafeWrite(commandContext, buffer, outFile, err)
+		return
+	}
+	for _, p := range yamlPaths {
+		if !s.PathExists(p) {
+			fatal("path", s.PathError(p))
+		}
+	}
+	if !structuredOutput() {
+		for _, p := range yamlPaths {
+			utils.PathAction(s, p, action)
+		}
+		return
+	}
+	processedVals, err := s.ProcessPaths(yamlPaths, action)
+	if err != nil {
+		fatal("path action failed", err)
+	}
+	if len(yamlPaths) == 1 {
+		writeOutput(output.PathValue{File: s.FilePath, Path: yamlPaths[0], Value: processedVals[0]})
+		return
+	}
+	values := make([]output.PathValue, len(yamlPaths))
+	for i, p := range yamlPaths {
+		values[i] = output.PathValue{File: s.FilePath, Path: p, Value: processedVals[i]}
+	}
+	writeOutput(values)
+}
+
+// getPki returns the Pki for the backend, which stops when the command times out
+func getPki() pki.Pki {
+	pk := newPki()
+	pk.SetContext(commandContext)
+	return pk
+}
+
+func newPki() pki.Pki {
+	if profileErr != nil {
+		exitWithf(utils.ExitConfig, "config error: %s", profileErr)
+	}
+
+	switch backendName {
+	case pki.GPGBackendName:
+		pk := pki.NewGPG(pgpKeyName, gnupgHome)
+		pk.SetLimits(backendLimits)
+		useSecretSources(&pk)
+		checkProfileKey(&pk)
+		useEscrowKey(&pk)
+		checkEnvPath(inputFilePath)
+		checkEnvPath(outputFilePath)
+		checkGitFilters()
+		return pk
+	case pki.PKCS11BackendName:
+		pk := pki.New(pgpKeyName, publicKeyRing, "")
+		useToken(&pk)
+		checkProfileKey(&pk)
+		useEscrowKey(&pk)
+		checkEnvPath(inputFilePath)
+		checkEnvPath(outputFilePath)
+		checkGitFilters()
+		return pk
+	case pki.NaclBackendName:
+		pk := pki.NewNacl(naclPublicKeyFile, naclSecretKeyFile, naclBoxType)
+		useEscrowKey(&pk)
+		checkEnvPath(inputFilePath)
+		checkEnvPath(outputFilePath)
+		checkGitFilters()
+		return pk
+	case pki.PGPBackendName, "":
+	default:
+		exitWithf(utils.ExitUsage, "unknown backend: '%s'", backendName)
+	}
+
+	// check for GNUPG1 pubring file
+	filePath, err := tilde.Expand(publicKeyRing)
+	if err != nil {
+		logger.Fatalf("Error with GNUPG pubring path: %s", err)
+	}
+	_, err = os.Stat(filepath.Clean(filePath))
+	pubRingFound := !os.IsNotExist(err)
+	if !pubRingFound && secretKeyFrom == "" {
+		logger.Fatalf("Error finding GNUPG pubring file: %s (use '--backend gpg' with GnuPG 2.1+ keyrings)", err)
+	}
+
+	pubRing, secRing := publicKeyRing, privateKeyRing
+	if secretKeyFrom != "" {
+		// the secring is not needed (or wanted) on disk
+		secRing = ""
+		if !pubRingFound {
+			// nor the pubring, values are encrypted to the secret key
+			pubRing = ""
+		}
+	}
+	pk := pki.New(pgpKeyName, pubRing, secRing)
+	useSecretSources(&pk)
+	checkProfileKey(&pk)
+	useEscrowKey(&pk)
+	checkEnvPath(inputFilePath)
+	checkEnvPath(outputFilePath)
+	checkGitFilters()
+
+	return pk
+}
+
+// checkEncryptOnly makes sure that an encrypt only build has no private key configured,
+// the secring is never read
+func checkEncryptOnly() {
+	flags := rootCmd.PersistentFlags()
+	switch {
+	case flags.Changed("secring"):
+		exitWithf(utils.ExitConfig, "--secring can't be used with an encrypt only build")
+	case flags.Changed("nacl-sk-file"):
+		exitWithf(utils.ExitConfig, "--nacl-sk-file can't be used with an encrypt only build")
+	case activeProfile != nil && activeProfile.DefaultSecRing != "":
+		exitWithf(utils.ExitConfig, "profile '%s': default_sec_ring can't be used with an encrypt only build", activeProfile.Name)
+	case activeProfile != nil && activeProfile.NaclSkFile != "":
+		exitWithf(utils.ExitConfig, "profile '%s': nacl_sk_file can't be used with an encrypt only build", activeProfile.Name)
+	case backendName == pki.PKCS11BackendName:
+		exitWithf(utils.ExitConfig, "the %s backend can't be used with an encrypt only build", backendName)
+	case secretKeyFrom != "" || passphraseFrom != "":
+		exitWithf(utils.ExitConfig, "a private key or passphrase can't be used with an encrypt only build")
+	}
+	privateKeyRing = ""
+	naclSecretKeyFile = ""
+}
+
+// useSecretKeyFile makes --secret-key-file, or the armored key in GSP_SECRET_KEY when no
+// other source is given, the secret key source
+func useSecretKeyFile() {
+	switch {
+	case secretKeyFile != "" && rootCmd.PersistentFlags().Changed("secret-key-from"):
+		exitWithf(utils.ExitUsage, "--secret-key-file and --secret-key-from can't be used together")
+	case secretKeyFile != "":
+		secretKeyFrom = "file://" + secretKeyFile
+	case secretKeyFrom == "" && os.Getenv("GSP_SECRET_KEY") != "":
+		secretKeyFrom = "env://GSP_SECRET_KEY"
+	}
+}
+
+// useSecretSources loads the private key and passphrase from --secret-key-from and --passphrase-from
+func useSecretSources(pk *pki.Pki) {
+	var passphrase []byte
+	var armored string
+	if (passphraseFrom == "" || secretKeyFrom == "") && !pki.EncryptOnly {
+		// the passphrase (and key) kept by 'unlock', until it expires
+		if secret, err := pki.AgentGet(pki.AgentSocket()); err == nil {
+			passphrase, armored = secret.Passphrase, string(secret.SecretKey)
+		}
+	}
+	if passphraseFrom != "" {
+		secret, err := pki.FetchSecretContext(commandContext, passphraseFrom)
+		if err != nil {
+			logger.Fatalf("passphrase: %s", timedOut(err))
+		}
+		passphrase = []byte(secret)
+	} else if profilePassphrase != "" {
+		passphrase = []byte(profilePassphrase)
+	}
+	// the profile's passphrase is used in place of one kept by 'unlock', and must be right
+	fromProfile := passphraseFrom == "" && profilePassphrase != ""
+
+	if secretKeyFrom != "" {
+		var err error
+		if armored, err = pki.FetchSecretContext(commandContext, secretKeyFrom); err != nil {
+			logger.Fatalf("secret key: %s", timedOut(err))
+		}
+	}
+	pk.Reprompt = passphraseReprompt()
+	if armored != "" {
+		err := pk.UseSecretKey(armored, passphrase)
+		if pki.IsPassphraseError(err) && pk.Reprompt != nil {
+			err = pk.Reprompt.Again(err, func(passphrase []byte) error {
+				return pk.UseSecretKey(armored, passphrase)
+			})
+		}
+		if fromProfile && pki.IsPassphraseError(err) {
+			logger.Fatalf("profile passphrase is wrong: %s", err)
+		}
+		if err != nil {
+			logger.Fatalf("secret key: %s", timedOut(err))
+		}
+	} else if err := pk.Unlock(passphrase); err != nil {
+		if passphraseFrom == "" && !fromProfile {
+			// the unlocked key may not be the one that is used
+			logger.Warnf("the passphrase kept by 'unlock' was not used: %s", err)
+			return
+		}
+		if pki.IsPassphraseError(err) && pk.Reprompt != nil {
+			err = pk.Reprompt.Again(err, pk.Unlock)
+		}
+		if fromProfile && pki.IsPassphraseError(err) {
+			logger.Fatalf("profile passphrase is wrong: %s", err)
+		}
+		if err != nil {
+			logger.Fatalf("passphrase: %s", timedOut(err))
+		}
+	}
+}
+
+// passphraseReprompt asks for the passphrase again when decrypting fails because it is wrong,
+// it is nil when there is no terminal to ask on or --passphrase-retries is 0
+func passphraseReprompt() *pki.Reprompt {
+	if passphraseRetries <= 0 || pki.EncryptOnly {
+		return nil
+	}
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		return nil
+	}
+	_ = tty.Close()
+	return &pki.Reprompt{
+		Retries: passphraseRetries,
+		Prompt: func() ([]byte, error) {
+			return pki.PromptSecret(fmt.Sprintf("passphrase for '%s'", pgpKeyName))
+		},
+		Warn: func(err error, left int) {
+			logger.Warnf("wrong passphrase: %s (%d more tries)", err, left)
+		},
 	}
 }
 
@@ -18,30 +18,48 @@
 // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
 // THE SOFTWARE.
 
+//go:build !encryptonly
+// +build !encryptonly
+
 package cmd
 
 import (
+	"strings"
+
+	"github.com/Everbridge/generate-secure-pillar/pki"
 	"github.com/Everbridge/generate-secure-pillar/sls"
 	"github.com/Everbridge/generate-secure-pillar/utils"
 	"github.com/spf13/cobra"
 )
 
+var fromBackend string
+
 // rotateCmd represents the rotate command
 var rotateCmd = &cobra.Command{
-	Use:   "rotate",
-	Short: "decrypt existing files and re-encrypt with a new key",
+	Use:     "rotate",
+	Aliases: []string{"r"},
+	Short:   "decrypt existing files and re-encrypt with a new key",
 	Run: func(cmd *cobra.Command, args []string) {
+		if fromBackend != "" {
+			from := sourcePki()
+			sls.DefaultOptions.RotateFrom = &from
+		}
 		pk := getPki()
 
-		if recurseDir != "" {
-			err := utils.ProcessDir(recurseDir, ".sls", "rotate", outputFilePath, topLevelElement, pk)
+		if dir := recurseDirectory(cmd); dir != "" {
+			report := runReport("rotate", dir)
+			err := utils.ProcessDirReport(commandContext, dir, ".sls", walkLimits, "rotate", outputFilePath, topLevelElement, pk, report)
+			writeRunReport(report, err)
 			if err != nil {
-				logger.Warnf("rotate: %s", err)
+				warnOrFail("rotate", err)
 			}
 		} else if inputFilePath != "" {
 			s := sls.New(inputFilePath, pk, topLevelElement)
+			if s.Error != nil {
+				fatal("rotate", s.Error)
+			}
 			buf, err := s.PerformAction("rotate")
-			utils.SafeWrite(buf, outputFilePath, err)
+			utils.SafeWrite(commandContext, buf, outputFilePath, err)
 		} else {
 			err := cmd.Help()
 			if err != nil {
@@ -54,5 +72,21 @@ var rotateCmd = &cobra.Command{
 func init() {
 	rootCmd.AddCommand(rotateCmd)
 	rotateCmd.PersistentFlags().StringVarP(&recurseDir, "dir", "d", "", "recurse over all .sls files in the given directory")
+	rotateCmd.PersistentFlags().StringVar(&reportFile, "report", "", reportUsage)
 	rotateCmd.PersistentFlags().StringVarP(&inputFilePath, "file", "f", "", "input file (defaults to STDIN)")
+	rotateCmd.PersistentFlags().StringVar(&fromBackend, "from-backend", "", "backend the values are encrypted with, to re-encrypt them with --backend")
+	rotateCmd.PersistentFlags().StringArrayVar(&includePaths, "include-path", nil, "only change the values under paths matching this pattern, e.g. 'db:*' (can be repeated)")
+	rotateCmd.PersistentFlags().StringArrayVar(&excludePaths, "exclude-path", nil, "leave the values under paths matching this pattern as they are (can be repeated)")
+}
+
+// sourcePki returns the --from-backend Pki that decrypts the values being rotated,
+// it is set up from the same flags and profile as the backend they are encrypted with
+func sourcePki() pki.Pki {
+	if available, known := knownBackends[fromBackend]; !known || !available {
+		exitWithf(utils.ExitUsage, "rotate: unknown backend '%s', expected one of: %s", fromBackend, strings.Join(availableBackends(), ", "))
+	}
+	target := backendName
+	backendName = fromBackend
+	defer func() { backendName = target }()
+	return getPki()
 }
@@ -0,0 +1,401 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/Everbridge/generate-secure-pillar/pki"
+	"github.com/Everbridge/generate-secure-pillar/sls"
+	"github.com/Everbridge/generate-secure-pillar/utils"
+	"github.com/spf13/cobra"
+)
+
+// shellHistorySize is the most lines kept in the shell history file
+const shellHistorySize = 1000
+
+const shellHelp = `commands:
+  open <file>          read a file, the keys stay loaded between files
+  get <path>           show the decrypted value at a path
+  set <path> [value]   encrypt a value and set it at a path (asks for the value when it isn't given)
+  keys [path]          show the keys used for a value or the whole file
+  paths                list the paths in the file
+  tree                 show the structure of the file with its values redacted
+  write                write the changes to the file
+  help                 show this help
+  quit, exit           leave the shell ('quit!' leaves without writing changes)
+`
+
+// shellCmd represents the shell command
+var shellCmd = &cobra.Command{
+	Use:   "shell",
+	Short: "run get, set, and keys commands in one session, keeping the keys loaded",
+	Example: `
+# edit a file interactively
+$ generate-secure-pillar -k "Salt Master" shell -f new.sls
+
+# run a script of commands
+$ printf 'get secret_name\nset other_secret new_value\nwrite\n' | generate-secure-pillar -k "Salt Master" shell -f new.sls`,
+	Run: func(cmd *cobra.Command, args []string) {
+		session := shellSession{pk: getPki()}
+		if cmd.Flags().Changed("file") {
+			session.open(inputFilePath)
+		}
+
+		if stdinIsPiped() {
+			// a script, one command per line
+			scanner := bufio.NewScanner(os.Stdin)
+			for scanner.Scan() {
+				if !session.run(scanner.Text(), scanner) {
+					break
+				}
+			}
+			if session.dirty {
+				logger.Warnf("shell: the changes were not written")
+			}
+			return
+		}
+
+		restore, err := rawTerminal()
+		fatal("shell", err)
+		defer restore()
+		setLogOutput(crlfWriter{os.Stderr})
+		editor := utils.NewLineEditor(os.Stdin, os.Stdout, "gsp> ")
+		historyFile := shellHistoryFile()
+		editor.History = readShellHistory(historyFile)
+		session.editor = editor
+		for {
+			line, err := editor.ReadLine()
+			if err == utils.ErrInterrupted {
+				continue
+			}
+			if err == io.EOF {
+				line = "quit"
+			} else if err != nil {
+				restore()
+				fatal("shell", err)
+			}
+			if entry := shellHistoryEntry(line); entry != "" {
+				editor.AddHistory(entry)
+				if err = writeShellHistory(historyFile, editor.History); err != nil {
+					logger.Warnf("shell: the history is not kept: %s", err)
+					historyFile = ""
+				}
+			}
+			if !session.run(line, nil) {
+				return
+			}
+		}
+	},
+}
+
+// shellSession is the state kept between shell commands
+type shellSession struct {
+	pk     pki.Pki
+	s      *sls.Sls
+	dirty  bool
+	editor *utils.LineEditor
+}
+
+// run runs a shell command, it returns false when the shell should exit,
+// the value for a set without one is read from the script or the terminal
+func (session *shellSession) run(line string, script *bufio.Scanner) bool {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+		return true
+	}
+	command := fields[0]
+	rest := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), command))
+	session.reloadKeys()
+
+	switch command {
+	case "quit", "exit":
+		if session.dirty {
+			session.println("there are changes that were not written, 'write' them or use 'quit!'")
+			return true
+		}
+		return false
+	case "quit!", "exit!":
+		return false
+	case "help":
+		session.print(shellHelp)
+	case "open":
+		if session.dirty {
+			session.println("there are changes that were not written, 'write' them first")
+			return true
+		}
+		session.open(rest)
+	default:
+		if session.s == nil {
+			session.println("no file is open, use 'open <file>' (or 'help')")
+			return true
+		}
+		session.fileCommand(command, rest, script)
+	}
+	return true
+}
+
+// fileCommand runs a command on the open file
+func (session *shellSession) fileCommand(command string, rest string, script *bufio.Scanner) {
+	s := session.s
+	path, value := rest, ""
+	if i := strings.IndexAny(rest, " \t"); i > 0 {
+		path, value = rest[:i], strings.TrimSpace(rest[i:])
+	}
+
+	switch command {
+	case "get":
+		if !s.PathExists(path) {
+			session.println(s.PathError(path))
+			return
+		}
+		val, err := decryptValues(s, s.GetValueFromPath(path))
+		session.result(path, val, err)
+	case "keys":
+		if path == "" {
+			buffer, err := s.Tree(0)
+			if err != nil {
+				session.println(err)
+				return
+			}
+			session.print(buffer.String())
+			return
+		}
+		if !s.PathExists(path) {
+			session.println(s.PathError(path))
+			return
+		}
+		val, err := s.ProcessValues(s.GetValueFromPath(path), sls.Validate)
+		session.result(path, val, err)
+	case "set":
+		if path == "" {
+			session.println("set needs a path")
+			return
+		}
+		if value == "" {
+			var err error
+			if value, err = session.readValue(script); err != nil {
+				session.println(err)
+				return
+			}
+		}
+		if err := s.ProcessYaml([]string{path}, []string{value}); err != nil {
+			session.println(err)
+			return
+		}
+		session.dirty = true
+	case "paths":
+		for _, p := range s.Paths() {
+			session.println(p)
+		}
+	case "tree":
+		buffer, err := s.Tree(0)
+		if err != nil {
+			session.println(err)
+			return
+		}
+		session.print(buffer.String())
+	case "write":
+		buffer, err := s.FormatBuffer("")
+		if err == nil {
+			_, err = sls.WriteSlsFileContext(commandContext, buffer, s.FilePath)
+		}
+		if err != nil {
+			session.println(err)
+			return
+		}
+		session.dirty = false
+	default:
+		session.println(fmt.Sprintf("unknown command '%s' (see 'help')", command))
+	}
+}
+
+// open reads a file into the session
+func (session *shellSession) open(file string) {
+	file, err := absPath(file)
+	if err != nil {
+		session.println(err)
+		return
+	}
+	s := sls.New("", session.pk, topLevelElement)
+	s.FilePath = file
+	if _, statErr := os.Stat(file); statErr == nil || sls.IsRemote(file) {
+		s = sls.New(file, session.pk, topLevelElement)
+		if s.Error != nil {
+			session.println(s.Error)
+			return
+		}
+	}
+	session.s = &s
+	session.dirty = false
+}
+
+// reloadKeys loads the keys again when a keyring file has changed since they were loaded,
+// otherwise the keyrings read when the shell started are used for every command
+func (session *shellSession) reloadKeys() {
+	if !pki.Keys.Changed() {
+		return
+	}
+	session.println("the keyrings have changed, loading the keys again")
+	session.pk = getPki()
+	if session.s != nil {
+		session.s.Pki = &session.pk
+	}
+}
+
+// readValue reads the value for a set, from the next line of a script or
+// from the terminal without echoing it
+func (session *shellSession) readValue(script *bufio.Scanner) (string, error) {
+	if script != nil {
+		if !script.Scan() {
+			return "", fmt.Errorf("set: no value to read")
+		}
+		return script.Text(), nil
+	}
+	session.print("value: ")
+	value, err := utils.NewLineEditor(os.Stdin, ioutil.Discard, "").ReadLine()
+	session.print("\n")
+	return value, err
+}
+
+// result prints the result of a get or keys
+func (session *shellSession) result(path string, val interface{}, err error) {
+	if err != nil {
+		session.println(err)
+		return
+	}
+	session.println(fmt.Sprintf("%s: %s", path, strings.TrimSpace(fmt.Sprintf("%v", val))))
+}
+
+// print writes output, with the line endings a terminal in raw mode needs
+func (session *shellSession) print(text string) {
+	if session.editor != nil {
+		text = strings.Replace(text, "\n", "\r\n", -1)
+	}
+	fmt.Print(text)
+}
+
+func (session *shellSession) println(v interface{}) {
+	session.print(fmt.Sprintf("%v\n", v))
+}
+
+// shellHistoryEntry returns the line as it is kept in the history, values given to set
+// are never kept, so that no plain text is written to the history file
+func shellHistoryEntry(line string) string {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return ""
+	}
+	if fields[0] == "set" && len(fields) > 2 {
+		return strings.Join(fields[:2], " ")
+	}
+	return strings.Join(fields, " ")
+}
+
+// shellHistoryFile returns the history file next to the user config file,
+// no history is kept with --no-config
+func shellHistoryFile() string {
+	if noConfig {
+		return ""
+	}
+	dir, err := userConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "shell_history")
+}
+
+func readShellHistory(file string) []string {
+	if file == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(filepath.Clean(file))
+	if err != nil {
+		return nil
+	}
+	var history []string
+	for _, line := range strings.Split(string(data), "\n") {
+		if entry := shellHistoryEntry(line); entry != "" {
+			history = append(history, entry)
+		}
+	}
+	return history
+}
+
+// writeShellHistory writes the last shellHistorySize lines of the history to a file
+// only the user can read, nothing is written when file is empty
+func writeShellHistory(file string, history []string) error {
+	if file == "" {
+		return nil
+	}
+	if len(history) > shellHistorySize {
+		history = history[len(history)-shellHistorySize:]
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(file, []byte(strings.Join(history, "\n")+"\n"), 0600); err != nil {
+		return err
+	}
+	// a file that was already there keeps its mode when it's written
+	return os.Chmod(file, 0600)
+}
+
+// crlfWriter ends lines with a carriage return too, for a terminal in raw mode
+type crlfWriter struct {
+	w io.Writer
+}
+
+func (c crlfWriter) Write(p []byte) (int, error) {
+	_, err := c.w.Write(bytes.Replace(p, []byte("\n"), []byte("\r\n"), -1))
+	return len(p), err
+}
+
+// rawTerminal puts the terminal on stdin in raw mode, the returned function restores it
+func rawTerminal() (func(), error) {
+	stty := func(args ...string) (string, error) {
+		cmd := exec.Command("stty", args...)
+		cmd.Stdin = os.Stdin
+		out, err := cmd.Output()
+		return strings.TrimSpace(string(out)), err
+	}
+	saved, err := stty("-g")
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the terminal settings: %s", err)
+	}
+	if _, err = stty("raw", "-echo"); err != nil {
+		return nil, fmt.Errorf("unable to set up the terminal: %s", err)
+	}
+	return func() { _, _ = stty(saved) }, nil
+}
+
+func init() {
+	rootCmd.AddCommand(shellCmd)
+	shellCmd.PersistentFlags().StringVarP(&inputFilePath, "file", "f", "", "file to open")
+}
@@ -0,0 +1,186 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/Everbridge/generate-secure-pillar/metrics"
+	"github.com/Everbridge/generate-secure-pillar/output"
+	"github.com/Everbridge/generate-secure-pillar/sls"
+	"github.com/Everbridge/generate-secure-pillar/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+// sshHost runs decrypt and keys on another host (e.g. the Salt master, where the private key is)
+var sshHost string
+var sshCommand = "ssh"
+var sshRemoteBinary = "generate-secure-pillar"
+
+// sshLocalFlags are used on this host and not passed on to the remote command,
+// the files they name are local and the remote host uses its own keys and config
+var sshLocalFlags = map[string]bool{
+	"ssh": true, "ssh-command": true, "ssh-binary": true, "file": true, "dir": true, "outfile": true, "update": true,
+	"config": true, "no-config": true, "pubring": true, "secring": true, "secret-key-file": true, "secret-key-from": true,
+	"passphrase-from": true, "temp-dir": true, "metrics-file": true, "statsd": true, "timeout": true, "yes": true, "archive": true,
+}
+
+// addSSHFlags adds the flags for running a command on another host
+func addSSHFlags(c *cobra.Command) {
+	c.PersistentFlags().StringVar(&sshHost, "ssh", "", "run the command on this host ([user@]host) where the private key is, the files are sent to it and the results come back, no key is needed here")
+	c.PersistentFlags().StringVar(&sshCommand, "ssh-command", sshCommand, "ssh command and its options, e.g. 'ssh -p 2222'")
+	c.PersistentFlags().StringVar(&sshRemoteBinary, "ssh-binary", sshRemoteBinary, "generate-secure-pillar binary on the --ssh host")
+}
+
+// runOverSSH runs a decrypt or keys command on the --ssh host, one file at a time
+func runOverSSH(cmd *cobra.Command, args []string) {
+	switch {
+	case args[0] == all, args[0] == path, cmd.Name() == "keys" && args[0] == count:
+	case cmd.Name() == "keys" && args[0] == recurse:
+		sshKeysRecurse(cmd)
+		return
+	default:
+		exitWithf(utils.ExitUsage, "--ssh: '%s %s' can't be run on another host, use all, path, or (keys) count and recurse", cmd.Name(), args[0])
+	}
+
+	file, err := inputPath(inputFilePath)
+	fatal(cmd.Name(), err)
+	outFile, err := absPath(outputFilePath)
+	fatal(cmd.Name(), err)
+	if cmd.Name() == "decrypt" && file != os.Stdin.Name() && useUpdateInPlace(cmd) {
+		outFile = file
+	}
+	if args[0] == path && outFile != os.Stdout.Name() {
+		exitWithf(utils.ExitUsage, "--ssh: 'decrypt path' only prints the values, use 'decrypt all' to write a file")
+	}
+	data := sshInput(file)
+
+	if cmd.Name() == "keys" || outFile == os.Stdout.Name() {
+		sshExit(sshRun(cmd, args, data, os.Stdout))
+		return
+	}
+	var buf bytes.Buffer
+	sshExit(sshRun(cmd, args, data, &buf))
+	_, err = sls.WriteSlsFileContext(commandContext, buf, outFile)
+	fatal(cmd.Name(), err)
+}
+
+// sshKeysRecurse lists the keys of the files in a directory, each file is sent to the --ssh host in turn
+func sshKeysRecurse(cmd *cobra.Command) {
+	files := findFiles("keys", recurseDirectory(cmd))
+	reports := []output.KeyReport{}
+	for _, file := range files {
+		data := sshInput(file)
+		if !structuredOutput() {
+			fmt.Printf("%s:\n", file)
+			if err := sshRun(cmd, []string{all}, data, os.Stdout); err != nil {
+				warnOrFail("keys", fmt.Errorf("%s: %s", file, err))
+			}
+			continue
+		}
+
+		var buf bytes.Buffer
+		if err := sshRun(cmd, []string{all, "--format=" + output.JSON}, data, &buf); err != nil {
+			warnOrFail("keys", fmt.Errorf("%s: %s", file, err))
+			continue
+		}
+		var report output.KeyReport
+		if err := json.Unmarshal(buf.Bytes(), &report); err != nil {
+			warnOrFail("keys", fmt.Errorf("%s: unexpected output from %s: %s", file, sshHost, err))
+			continue
+		}
+		report.File = file
+		reports = append(reports, report)
+	}
+	if structuredOutput() {
+		writeOutput(reports)
+	}
+}
+
+// sshInput reads a file to send to the --ssh host
+func sshInput(file string) []byte {
+	var data []byte
+	var err error
+	switch {
+	case file == os.Stdin.Name():
+		data, err = ioutil.ReadAll(os.Stdin)
+	case sls.IsRemote(file):
+		data, err = sls.ReadRemoteContext(commandContext, file)
+	default:
+		data, err = ioutil.ReadFile(filepath.Clean(file))
+	}
+	fatal("ssh", err)
+	return data
+}
+
+// sshRun runs the command on the --ssh host with data on its stdin, its stderr (logs and any
+// passphrase prompt) is passed through, flags in args override the forwarded ones
+func sshRun(cmd *cobra.Command, args []string, data []byte, stdout io.Writer) error {
+	remote := []string{sshRemoteBinary, cmd.Name()}
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		if sshLocalFlags[f.Name] {
+			return
+		}
+		if f.Value.Type() == "stringArray" {
+			values, _ := cmd.Flags().GetStringArray(f.Name)
+			for _, v := range values {
+				remote = append(remote, fmt.Sprintf("--%s=%s", f.Name, v))
+			}
+			return
+		}
+		remote = append(remote, fmt.Sprintf("--%s=%s", f.Name, f.Value.String()))
+	})
+	remote = append(remote, args...)
+	quoted := make([]string, len(remote))
+	for i, arg := range remote {
+		quoted[i] = "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+	}
+
+	sshArgs := strings.Fields(sshCommand)
+	if len(sshArgs) == 0 {
+		exitWithf(utils.ExitUsage, "--ssh-command is empty")
+	}
+	sshArgs = append(sshArgs, sshHost, strings.Join(quoted, " "))
+	logger.Debugf("running %s", strings.Join(sshArgs, " "))
+	c := exec.CommandContext(commandContext, sshArgs[0], sshArgs[1:]...) // #nosec G204
+	c.Stdin = bytes.NewReader(data)
+	c.Stdout = stdout
+	c.Stderr = os.Stderr
+	return timedOut(c.Run())
+}
+
+// sshExit exits with the exit code of the remote command when it failed, it has already
+// said why on stderr (ssh exits with 255 when it can't connect, after saying why too)
+func sshExit(err error) {
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		metrics.Exit(exitErr.ExitCode())
+	}
+	fatal("ssh", err)
+}
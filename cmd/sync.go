@@ -0,0 +1,88 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+//go:build !encryptonly
+// +build !encryptonly
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/Everbridge/generate-secure-pillar/utils"
+	"github.com/spf13/cobra"
+)
+
+var syncSrc string
+var syncDst string
+var syncToKey string
+var syncDryRun bool
+
+// syncCmd represents the sync command
+var syncCmd = &cobra.Command{
+	Use:   "sync",
+	Short: "write the files that differ (by decrypted value) from one tree to another, re-encrypted with the destination key",
+	Example: `
+# promote staging pillar data to production, re-encrypted with the production key
+$ generate-secure-pillar sync --src /srv/pillar/staging --dst /srv/pillar/prod --to-key "Prod Salt Master"
+
+# only report which files would be written
+$ generate-secure-pillar sync --src /srv/pillar/staging --dst /srv/pillar/prod --to-key "Prod Salt Master" --dry-run`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if syncSrc == "" || syncDst == "" {
+			exitWithf(utils.ExitUsage, "sync: --src and --dst are required")
+		}
+		if syncToKey != "" {
+			// values are decrypted with any key in the secret key ring, and encrypted with this one
+			pgpKeyName = syncToKey
+		}
+		pk := getPki()
+
+		results, err := utils.SyncDir(commandContext, syncSrc, syncDst, ".sls", walkLimits, topLevelElement, pk, syncDryRun)
+		fatal("sync", err)
+
+		if structuredOutput() {
+			writeOutput(results)
+			return
+		}
+		written := 0
+		for _, result := range results {
+			if result.Status == utils.SyncAdded || result.Status == utils.SyncChanged {
+				written++
+			}
+			if result.Status != utils.SyncUnchanged {
+				fmt.Printf("%s: %s\n", result.Status, result.Path)
+			}
+		}
+		verb := "written"
+		if syncDryRun {
+			verb = "to write"
+		}
+		fmt.Printf("%d of %d files %s\n", written, len(results), verb)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(syncCmd)
+	syncCmd.PersistentFlags().StringVar(&syncSrc, "src", "", "directory of .sls files to copy from")
+	syncCmd.PersistentFlags().StringVar(&syncDst, "dst", "", "directory to write the new and changed files to")
+	syncCmd.PersistentFlags().StringVar(&syncToKey, "to-key", "", "PGP key name, email, or ID to encrypt the written files with (defaults to --pgp_key)")
+	syncCmd.PersistentFlags().BoolVar(&syncDryRun, "dry-run", false, "report the files that would be written without writing them")
+}
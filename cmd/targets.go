@@ -0,0 +1,171 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Everbridge/generate-secure-pillar/output"
+	"github.com/Everbridge/generate-secure-pillar/pki"
+	"github.com/Everbridge/generate-secure-pillar/sls"
+	"github.com/Everbridge/generate-secure-pillar/utils"
+	"github.com/spf13/cobra"
+)
+
+var targetMinion string
+var targetGrains []string
+var topFile string
+var targetSaltenv string
+
+// targetsCmd represents the targets command
+var targetsCmd = &cobra.Command{
+	Use:   "targets",
+	Short: "list the encrypted values a minion receives, by matching it against the pillar top file",
+	Example: `
+# the encrypted values web01 receives from the pillar in /srv/pillar (read from /srv/pillar/top.sls)
+$ generate-secure-pillar targets --minion web01 --grain os:Debian --grain roles:web -d /srv/pillar
+
+# check that a new secret reaches a minion before deploying (exits 1 if it doesn't)
+$ generate-secure-pillar targets --minion db01 -d /srv/pillar --path "secure_vars:db_password"`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if targetMinion == "" {
+			exitWithf(utils.ExitUsage, "targets: --minion is required")
+		}
+		dir := recurseDirectory(cmd)
+		if dir == "" {
+			exitWithf(utils.ExitUsage, "targets: --dir is required")
+		}
+		root, err := filepath.Abs(dir)
+		fatal("targets", err)
+		top := topFile
+		if top == "" {
+			top = filepath.Join(root, "top.sls")
+		}
+
+		minion := sls.Minion{ID: targetMinion, Grains: map[string][]string{}}
+		for _, grain := range targetGrains {
+			i := strings.LastIndex(grain, ":")
+			if i < 0 {
+				exitWithf(utils.ExitUsage, "targets: --grain '%s' is not <grain>:<value>", grain)
+			}
+			minion.Grains[grain[:i]] = append(minion.Grains[grain[:i]], grain[i+1:])
+		}
+
+		targets, err := sls.ReadTop(top)
+		fatal("targets", err)
+		secrets := []output.ReachableSecret{}
+		seen := map[string]bool{}
+		for _, t := range targets {
+			if targetSaltenv != "" && t.Env != targetSaltenv {
+				continue
+			}
+			ok, err := t.Matches(minion)
+			if err != nil {
+				warnOrFail("targets", err)
+				continue
+			}
+			if !ok {
+				continue
+			}
+			for _, name := range t.Names {
+				if seen[t.Env+"\x00"+name] {
+					continue
+				}
+				seen[t.Env+"\x00"+name] = true
+				found, err := reachableSecrets(root, top, name)
+				if err != nil {
+					warnOrFail("targets", err)
+					continue
+				}
+				for i := range found {
+					found[i].Env, found[i].Target = t.Env, t.Target
+				}
+				secrets = append(secrets, found...)
+			}
+		}
+		if yamlPath != "" {
+			secrets = secretsAt(secrets, yamlPath)
+		}
+
+		if structuredOutput() {
+			writeOutput(secrets)
+		} else {
+			for _, s := range secrets {
+				fmt.Printf("%s: %s (%s from '%s' in %s)\n", s.File, s.Path, s.Sls, s.Target, s.Env)
+			}
+		}
+		if yamlPath != "" && len(secrets) == 0 {
+			exitWithf(utils.ExitError, "targets: %s doesn't receive %s", targetMinion, yamlPath)
+		}
+	},
+}
+
+// reachableSecrets lists the encrypted values of an sls name, assembled with the files it includes
+func reachableSecrets(root string, top string, name string) ([]output.ReachableSecret, error) {
+	file, err := sls.IncludeFile(root, top, name)
+	if err != nil {
+		return nil, err
+	}
+	doc, _, err := sls.Assemble(root, file, pki.Pki{}, topLevelElement)
+	if err != nil {
+		return nil, err
+	}
+	rel, err := filepath.Rel(root, file)
+	if err != nil {
+		rel = file
+	}
+
+	values := doc.EncryptedValues()
+	paths := make([]string, 0, len(values))
+	for path := range values {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	secrets := make([]output.ReachableSecret, 0, len(paths))
+	for _, path := range paths {
+		secrets = append(secrets, output.ReachableSecret{File: rel, Path: path, Sls: name})
+	}
+	return secrets, nil
+}
+
+// secretsAt keeps the secrets at a path or under it
+func secretsAt(secrets []output.ReachableSecret, path string) []output.ReachableSecret {
+	kept := []output.ReachableSecret{}
+	for _, s := range secrets {
+		if s.Path == path || strings.HasPrefix(s.Path, path+sls.PathDelimiter) {
+			kept = append(kept, s)
+		}
+	}
+	return kept
+}
+
+func init() {
+	rootCmd.AddCommand(targetsCmd)
+	targetsCmd.PersistentFlags().StringVar(&targetMinion, "minion", "", "the minion ID to match against the top file")
+	targetsCmd.PersistentFlags().StringArrayVar(&targetGrains, "grain", nil, "a grain of the minion as <grain>:<value>, for grain and compound targets (can be repeated)")
+	targetsCmd.PersistentFlags().StringVarP(&recurseDir, "dir", "d", "", "the pillar root")
+	targetsCmd.PersistentFlags().StringVar(&topFile, "top", "", "the pillar top file (defaults to top.sls in the pillar root)")
+	targetsCmd.PersistentFlags().StringVar(&targetSaltenv, "saltenv", "", "only match the targets of this pillar environment (defaults to all of them)")
+	targetsCmd.PersistentFlags().StringVarP(&yamlPath, "path", "p", "", "only list the values at or under this YAML path, exit 1 if there are none")
+}
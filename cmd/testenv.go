@@ -0,0 +1,64 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/Everbridge/generate-secure-pillar/testenv"
+	"github.com/spf13/cobra"
+)
+
+var testEnvDir string
+
+// testEnvCmd represents the test-env command
+var testEnvCmd = &cobra.Command{
+	Use:   "test-env",
+	Short: "create a disposable GnuPG home with a test key pair and sample pillar files",
+	Example: `
+# try out encrypting and decrypting without touching your real keyring
+$ eval "$(generate-secure-pillar test-env)"
+$ generate-secure-pillar -k "Test Salt Master" encrypt recurse -d $GSP_TEST_PILLAR
+
+# create it in a given (new or empty) directory, e.g. for CI
+$ generate-secure-pillar test-env -d /tmp/gsp-test`,
+	Run: func(cmd *cobra.Command, args []string) {
+		env, err := testenv.New(testEnvDir)
+		fatal("test-env", err)
+		if !env.GPGImported {
+			logger.Warnf("test-env: the key was not imported into gpg, only the pgp backend can use it")
+		}
+
+		if structuredOutput() {
+			writeOutput(env)
+			return
+		}
+		// shell commands, so that the output can be eval'd
+		fmt.Printf("export GNUPGHOME='%s'\n", env.GnupgHome)
+		fmt.Printf("export GSP_TEST_PILLAR='%s'\n", env.PillarDir)
+		fmt.Printf("# test key: %s (%s), remove %s when done\n", env.KeyName, env.Fingerprint, env.Dir)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(testEnvCmd)
+	testEnvCmd.PersistentFlags().StringVarP(&testEnvDir, "dir", "d", "", "directory to create the test environment in (defaults to a new temporary directory)")
+}
@@ -0,0 +1,54 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// commandTimeout limits how long a command can run (0 for no limit)
+var commandTimeout time.Duration
+
+// commandContext is done when the command has run for --timeout, it's passed to
+// everything that can take long: reading remote files, backends, and processing directories
+var commandContext = context.Background()
+var cancelCommand context.CancelFunc = func() {}
+
+// startTimeout starts the --timeout clock for a command
+func startTimeout() {
+	cancelCommand()
+	if commandTimeout > 0 {
+		commandContext, cancelCommand = context.WithTimeout(context.Background(), commandTimeout)
+	} else {
+		commandContext, cancelCommand = context.WithCancel(context.Background())
+	}
+}
+
+// timedOut replaces an error with one saying that the command timed out when it did,
+// the errors of a cancelled request (e.g. a killed CLI) don't say why it was cancelled
+func timedOut(err error) error {
+	if err != nil && commandContext.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %s", commandTimeout)
+	}
+	return err
+}
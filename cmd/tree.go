@@ -0,0 +1,65 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/Everbridge/generate-secure-pillar/pki"
+	"github.com/Everbridge/generate-secure-pillar/sls"
+	"github.com/spf13/cobra"
+)
+
+var showChars int
+
+// treeCmd represents the tree command
+var treeCmd = &cobra.Command{
+	Use:   "tree",
+	Short: "show the structure of a file with its values redacted",
+	Example: `
+# review which values in a file are encrypted, and to which keys
+$ generate-secure-pillar tree -f /path/to/file.sls
+
+# show the first 4 characters of plain text values
+$ generate-secure-pillar tree --show 4 -f /path/to/file.sls`,
+	Run: func(cmd *cobra.Command, args []string) {
+		// the key IDs are read from the encrypted values, no key ring is needed
+		s := sls.New(inputFilePath, pki.Pki{}, topLevelElement)
+		if s.Error != nil {
+			fatal("tree", s.Error)
+		}
+
+		if structuredOutput() {
+			writeOutput(s.Redacted(showChars))
+			return
+		}
+		buffer, err := s.Tree(showChars)
+		fatal("tree", err)
+		fmt.Print(buffer.String())
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(treeCmd)
+	treeCmd.PersistentFlags().StringVarP(&inputFilePath, "file", "f", os.Stdin.Name(), "input file (defaults to STDIN)")
+	treeCmd.PersistentFlags().IntVar(&showChars, "show", 0, "show this many characters of plain text values instead of '<plaintext>'")
+}
@@ -0,0 +1,152 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+//go:build !encryptonly
+// +build !encryptonly
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/Everbridge/generate-secure-pillar/pki"
+	"github.com/Everbridge/generate-secure-pillar/utils"
+	"github.com/spf13/cobra"
+)
+
+var unlockFor time.Duration
+var unlockServe bool
+
+// unlockCmd represents the unlock command
+var unlockCmd = &cobra.Command{
+	Use:   "unlock",
+	Short: "keep the private key passphrase in an agent for a limited time, so decrypting doesn't ask for it",
+	Example: `
+# decrypt without the passphrase for the next 15 minutes
+$ generate-secure-pillar -k "Salt Master" unlock --for 15m
+$ generate-secure-pillar -k "Salt Master" decrypt all -f us1.sls
+
+# drop it sooner
+$ generate-secure-pillar lock`,
+	Run: func(cmd *cobra.Command, args []string) {
+		socket := pki.AgentSocket()
+		if unlockServe {
+			serveAgent(socket)
+			return
+		}
+		if unlockFor <= 0 {
+			exitWithf(utils.ExitUsage, "unlock: --for must be a positive duration, e.g. 15m")
+		}
+		if status, err := pki.AgentStatus(socket); err == nil {
+			exitWithf(utils.ExitUsage, "unlock: already unlocked until %s, run 'lock' first", status.Expires.Format("15:04:05"))
+		}
+
+		secret := pki.AgentSecret{Expires: time.Now().Add(unlockFor)}
+		var err error
+		if passphraseFrom != "" {
+			var passphrase string
+			passphrase, err = pki.FetchSecret(passphraseFrom)
+			secret.Passphrase = []byte(passphrase)
+		} else if profilePassphrase != "" {
+			secret.Passphrase = []byte(profilePassphrase)
+		} else {
+			secret.Passphrase, err = pki.PromptSecret(fmt.Sprintf("passphrase for '%s'", pgpKeyName))
+		}
+		fatal("unlock", err)
+		if secretKeyFrom != "" {
+			armored, err := pki.FetchSecret(secretKeyFrom)
+			fatal("unlock", err)
+			secret.SecretKey = []byte(armored)
+		}
+
+		// check the passphrase before keeping it, the key is loaded by getPki
+		pk := getPki()
+		if len(secret.SecretKey) == 0 {
+			fatal("unlock", pk.Unlock(secret.Passphrase))
+		}
+
+		fatal("unlock", startAgent(socket, secret))
+		fmt.Printf("unlocked until %s, run 'generate-secure-pillar lock' to lock sooner\n", secret.Expires.Format("15:04:05"))
+	},
+}
+
+// startAgent runs 'unlock --serve' in the background, the secret is passed on its stdin
+func startAgent(socket string, secret pki.AgentSecret) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	agent := exec.Command(exe, "--no-config", "unlock", "--serve")
+	agent.Env = append(os.Environ(), pki.AgentSocketEnv+"="+socket)
+	// in its own session, so that it outlives the terminal it was started from
+	agent.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	stdin, err := agent.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err = agent.Start(); err != nil {
+		return err
+	}
+	err = json.NewEncoder(stdin).Encode(secret)
+	if closeErr := stdin.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err = agent.Process.Release(); err != nil {
+		return err
+	}
+
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(50 * time.Millisecond) {
+		if _, err = pki.AgentStatus(socket); err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("the agent did not start: %s", err)
+}
+
+// serveAgent is the background agent started by unlock
+func serveAgent(socket string) {
+	data, err := ioutil.ReadAll(os.Stdin)
+	fatal("unlock", err)
+	var secret pki.AgentSecret
+	err = json.Unmarshal(data, &secret)
+	for i := range data {
+		data[i] = 0
+	}
+	fatal("unlock", err)
+	fatal("unlock", pki.ServeAgent(socket, secret))
+}
+
+func init() {
+	rootCmd.AddCommand(unlockCmd)
+	unlockCmd.Flags().DurationVar(&unlockFor, "for", 15*time.Minute, "how long the passphrase is kept, it is dropped after that")
+	unlockCmd.Flags().BoolVar(&unlockServe, "serve", false, "run the agent (started by unlock)")
+	if err := unlockCmd.Flags().MarkHidden("serve"); err != nil {
+		logger.Fatal(err)
+	}
+}
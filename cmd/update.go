@@ -26,13 +26,20 @@ import (
 	"strings"
 
 	"github.com/Everbridge/generate-secure-pillar/sls"
+	"github.com/Everbridge/generate-secure-pillar/utils"
 	"github.com/spf13/cobra"
 )
 
+var keepHistory bool
+var skipUnchanged bool
+var createPaths bool
+var mergeStrategy string
+
 // updateCmd represents the update command
 var updateCmd = &cobra.Command{
-	Use:   "update",
-	Short: "update the value of the given key in the given file",
+	Use:     "update",
+	Aliases: []string{"u"},
+	Short:   "update the value of the given key in the given file",
 	Run: func(cmd *cobra.Command, args []string) {
 		inputFilePath, err := filepath.Abs(inputFilePath)
 		if err != nil {
@@ -42,11 +49,54 @@ var updateCmd = &cobra.Command{
 			outputFilePath = inputFilePath
 		}
 
-		secretNames := strings.Split(strings.Trim(cmd.Flag("name").Value.String(), "[]"), ",")
-		secretValues := strings.Split(strings.Trim(cmd.Flag("value").Value.String(), "[]"), ",")
+		// values are taken as given, they may contain commas, brackets, or newlines
+		secretNames, err := cmd.Flags().GetStringArray("name")
+		if err != nil {
+			logger.Fatal(err)
+		}
+		secretValues, err := cmd.Flags().GetStringArray("value")
+		if err != nil {
+			logger.Fatal(err)
+		}
+		if err = sls.CheckMergeStrategy(mergeStrategy); err != nil {
+			exitWithf(utils.ExitUsage, "update: %s", err)
+		}
 		pk := getPki()
 		s := sls.New(inputFilePath, pk, topLevelElement)
-		err = s.ProcessYaml(secretNames, secretValues)
+		if s.Error != nil {
+			fatal("update", s.Error)
+		}
+		secretNames = s.ElementPaths(secretNames)
+		if !createPaths {
+			for _, name := range secretNames {
+				if !s.PathExists(name) {
+					exitWithf(utils.ExitError, "update: %s (use --create to add it)", s.PathError(name))
+				}
+			}
+		}
+		metaNames := secretNames
+		if skipUnchanged {
+			secretNames, secretValues = changedValues(&s, secretNames, secretValues)
+			if len(secretNames) == 0 && !cmd.Flags().Changed("meta") {
+				logger.Infof("no values changed, '%s' was not written", inputFilePath)
+				return
+			}
+		}
+		if keepHistory {
+			for _, name := range secretNames {
+				if err = s.PushHistory(name); err != nil {
+					logger.Fatal(err)
+				}
+			}
+		}
+		err = s.MergeYaml(secretNames, secretValues, mergeStrategy)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		if _, err = s.Derive(secretNames); err != nil {
+			logger.Fatal(err)
+		}
+		err = applyMeta(cmd, &s, metaNames)
 		if err != nil {
 			logger.Fatal(err)
 		}
@@ -54,7 +104,7 @@ var updateCmd = &cobra.Command{
 		if err != nil {
 			logger.Fatal(err)
 		}
-		_, err = sls.WriteSlsFile(buffer, outputFilePath)
+		_, err = sls.WriteSlsFileContext(commandContext, buffer, outputFilePath)
 		if err != nil {
 			logger.Fatal(err)
 		}
@@ -66,4 +116,29 @@ func init() {
 	updateCmd.PersistentFlags().StringVarP(&inputFilePath, "file", "f", os.Stdin.Name(), "input file (defaults to STDIN)")
 	updateCmd.PersistentFlags().StringArrayP("name", "n", nil, "secret name(s)")
 	updateCmd.PersistentFlags().StringArrayP("value", "s", nil, "secret value(s)")
+	updateCmd.PersistentFlags().BoolVar(&keepHistory, "history", false, "keep the previous (encrypted) value in a __history list next to the secret")
+	updateCmd.PersistentFlags().BoolVar(&createPaths, "create", false, "add names that are not already in the file, without it a missing name is an error")
+	updateCmd.PersistentFlags().StringVar(&mergeStrategy, "merge-strategy", sls.MergeReplace, "how a name that is a list gets the new value: "+strings.Join(sls.MergeStrategies, ", "))
+	updateCmd.PersistentFlags().BoolVar(&skipUnchanged, "skip-unchanged", false, "decrypt the existing values and leave the ones that already have the given value as they are")
+	updateCmd.PersistentFlags().StringArray("meta", nil, "metadata field=value kept (unencrypted) with the secret(s), e.g. expires=2025-01-01 or owner=team-x")
+}
+
+// changedValues drops the names (and their values) whose existing values decrypt to the new value
+func changedValues(s *sls.Sls, secretNames []string, secretValues []string) ([]string, []string) {
+	var names, values []string
+	for i, name := range secretNames {
+		if i >= len(secretValues) {
+			names = append(names, name)
+			continue
+		}
+		same, err := s.Unchanged(name, secretValues[i])
+		fatal("update", err)
+		if same {
+			logger.Infof("%s is unchanged", name)
+			continue
+		}
+		names = append(names, name)
+		values = append(values, secretValues[i])
+	}
+	return names, values
 }
@@ -0,0 +1,236 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Everbridge/generate-secure-pillar/output"
+	"github.com/Everbridge/generate-secure-pillar/pki"
+	"github.com/Everbridge/generate-secure-pillar/sls"
+	"github.com/Everbridge/generate-secure-pillar/utils"
+	"github.com/spf13/cobra"
+)
+
+var againstPubKeys []string
+var recipients []pki.RecipientKey
+var verifyStructural bool
+
+// verifyCmd represents the verify command
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "check that the encrypted values in a file or directory can be read and are encrypted to the escrow key",
+	Example: `
+# check that every encrypted value under a directory is also encrypted to the escrow key
+$ generate-secure-pillar --escrow-key "Security Escrow" verify -d /path/to/pillar/secure/stuff
+
+# check that the Salt master can decrypt every value before deploying
+$ generate-secure-pillar verify --against-pubkey master.asc -d /path/to/pillar
+
+# check the armor, structure, and policies without any keys, e.g. in an unprivileged CI stage
+$ generate-secure-pillar --forbid 'BEGIN RSA PRIVATE KEY' verify --structural -d /path/to/pillar`,
+	Run: func(cmd *cobra.Command, args []string) {
+		recipients = nil
+		for _, file := range againstPubKeys {
+			keys, err := pki.ReadRecipientKeys(file)
+			fatal("verify", err)
+			recipients = append(recipients, keys...)
+		}
+		// the keys a value is encrypted to are read from the value, checking them needs no keyring
+		var pk pki.Pki
+		if verifyStructural {
+			if escrowKey != "" {
+				logger.Warnf("verify: --structural doesn't check the escrow key, it needs the keyring (use --against-pubkey with its public key)")
+			}
+		} else if len(recipients) == 0 || escrowKey != "" {
+			pk = getPki()
+		}
+
+		var files []string
+		dir := recurseDirectory(cmd)
+		if dir != "" {
+			files = findFiles("verify", dir)
+		} else {
+			files = []string{inputFilePath}
+		}
+
+		problems := []output.Problem{}
+		if assembleIncludes && dir != "" {
+			for _, doc := range logicalDocuments(dir, pk) {
+				problems = append(problems, verifyValues(&doc.Sls, &pk)...)
+			}
+		} else {
+			for _, file := range files {
+				s := sls.New(file, pk, topLevelElement)
+				if s.Error != nil && verifyStructural {
+					problems = append(problems, output.Problem{File: file, Message: s.Error.Error(), Rule: "yaml"})
+					continue
+				}
+				if s.Error != nil {
+					warnOrFail("verify", s.Error)
+					continue
+				}
+				problems = append(problems, verifyValues(&s, &pk)...)
+				if !verifyStructural {
+					continue
+				}
+				if problem := s.RendererProblem(); problem != "" {
+					problems = append(problems, output.Problem{File: s.FilePath, Line: 1, Message: problem, Rule: "renderer"})
+				}
+			}
+		}
+
+		if outputFormat == output.SARIF {
+			writeSARIF(problems)
+		} else if structuredOutput() {
+			writeOutput(problems)
+		} else {
+			for _, p := range problems {
+				fmt.Printf("%s: %s: %s\n", p.File, p.Path, p.Message)
+			}
+		}
+		if len(problems) > 0 {
+			exitWithf(utils.ExitError, "verify: %d problems found in %d files", len(problems), len(files))
+		}
+	},
+}
+
+// verifyValues checks each encrypted value in a file
+func verifyValues(s *sls.Sls, pk *pki.Pki) []output.Problem {
+	var problems []output.Problem
+
+	values := s.EncryptedValues()
+	acls := s.ACLs()
+	for _, a := range s.ArmorProblems() {
+		// there is nothing more to check in a damaged value
+		problems = append(problems, output.Problem{File: s.FilePath, Path: a.Path, Message: a.Message, Line: s.Line(a.Path), Rule: "corrupt-armor"})
+		delete(values, a.Path)
+	}
+	paths := make([]string, 0, len(values))
+	for path := range values {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		problem := ""
+		rule := "missing-escrow"
+		if verifyStructural && !pki.IsNaclValue(values[path]) {
+			// no keys: check the message is whole, the escrow key isn't checked
+			if err := pki.CheckMessage(values[path]); err != nil {
+				problem, rule = err.Error(), "unreadable-value"
+			}
+		} else if pki.IsNaclValue(values[path]) {
+			// nacl values don't name a key, and can't have an escrow key
+			if len(pk.EscrowKeyIDs) > 0 {
+				problem = fmt.Sprintf("nacl value can't be encrypted to the escrow key '%s'", escrowKey)
+			}
+		} else if len(pk.EscrowKeyIDs) == 0 {
+			if _, err := pki.EncryptedToKeyIDs(values[path]); err != nil {
+				problem, rule = err.Error(), "unreadable-value"
+			}
+		} else if ok, err := pk.HasEscrow(values[path]); err != nil {
+			problem, rule = err.Error(), "unreadable-value"
+		} else if !ok {
+			problem = fmt.Sprintf("not encrypted to the escrow key '%s'", escrowKey)
+		}
+		if problem != "" {
+			problems = append(problems, output.Problem{File: s.FilePath, Path: path, Message: problem, Line: s.Line(path), Rule: rule})
+		}
+		for _, message := range recipientProblems(values[path]) {
+			problems = append(problems, output.Problem{File: s.FilePath, Path: path, Message: message, Line: s.Line(path), Rule: "missing-recipient"})
+		}
+		for _, message := range aclProblems(pk, values[path], acls[path]) {
+			problems = append(problems, output.Problem{File: s.FilePath, Path: path, Message: message, Line: s.Line(path), Rule: "acl"})
+		}
+	}
+	for _, w := range s.WhitespaceProblems() {
+		problems = append(problems, output.Problem{File: s.FilePath, Path: w.Path, Message: w.Message, Line: s.Line(w.Path), Rule: "armor-whitespace"})
+	}
+	for _, m := range s.ForbiddenPlainText() {
+		problems = append(problems, output.Problem{File: s.FilePath, Path: m.Path, Message: fmt.Sprintf("plain text matches the forbidden pattern '%s'", m.Pattern), Line: s.Line(m.Path), Rule: "plaintext-secret"})
+	}
+	if verifyStructural && topLevelElement != "" {
+		for _, path := range s.PlainValues() {
+			problems = append(problems, output.Problem{File: s.FilePath, Path: path, Message: fmt.Sprintf("plain text value under '%s'", topLevelElement), Line: s.Line(path), Rule: "plaintext-secret"})
+		}
+	}
+	return problems
+}
+
+// verifyRules are the kinds of problems verify reports
+var verifyRules = []output.Rule{
+	{ID: "corrupt-armor", Description: "armored value was damaged, e.g. by a merge conflict"},
+	{ID: "unreadable-value", Description: "encrypted value can't be read"},
+	{ID: "missing-escrow", Description: "encrypted value isn't encrypted to the escrow key"},
+	{ID: "missing-recipient", Description: "encrypted value isn't encrypted to a key given with --against-pubkey"},
+	{ID: "acl", Description: "encrypted value is shared with keys outside the teams in its __acl"},
+	{ID: "yaml", Description: "file can't be read as YAML"},
+	{ID: "renderer", Description: "file has no renderer line that decrypts its values"},
+	{ID: "armor-whitespace", Description: "armored value has whitespace that changes how it is read"},
+	{ID: "plaintext-secret", Description: "plain text value matches a forbidden pattern"},
+}
+
+// writeSARIF writes the problems as a SARIF log, with file paths relative
+// to the working directory as the code scanning dashboards expect
+func writeSARIF(problems []output.Problem) {
+	cwd, _ := os.Getwd()
+	for i, p := range problems {
+		if rel, err := filepath.Rel(cwd, p.File); err == nil && filepath.IsAbs(p.File) && !strings.HasPrefix(rel, "..") {
+			p.File = rel
+		}
+		problems[i].File = filepath.ToSlash(p.File)
+	}
+	err := output.WriteSARIF(os.Stdout, rootCmd.Name(), Version, "https://github.com/Everbridge/generate-secure-pillar", verifyRules, problems)
+	fatal("verify", err)
+}
+
+// recipientProblems lists the --against-pubkey keys a value is not encrypted to
+func recipientProblems(value string) []string {
+	var problems []string
+	if len(recipients) > 0 && pki.IsNaclValue(value) {
+		return []string{"nacl value isn't encrypted to a PGP key"}
+	}
+	for _, key := range recipients {
+		ok, err := key.EncryptedTo(value)
+		if err != nil {
+			// reported as a value that can't be read
+			return nil
+		}
+		if !ok {
+			problems = append(problems, fmt.Sprintf("not encrypted to %s (%s)", key.Fingerprint, key.Identity))
+		}
+	}
+	return problems
+}
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+	verifyCmd.PersistentFlags().StringVarP(&inputFilePath, "file", "f", os.Stdin.Name(), "input file (defaults to STDIN)")
+	verifyCmd.PersistentFlags().StringVarP(&recurseDir, "dir", "d", "", "recurse over all .sls files in the given directory")
+	verifyCmd.PersistentFlags().StringArrayVar(&againstPubKeys, "against-pubkey", nil, "armored or binary public key file (e.g. the Salt master's) that every value must be encrypted to, no keyring or private key is needed (can be repeated)")
+	verifyCmd.PersistentFlags().BoolVar(&verifyStructural, "structural", false, "check the armor and checksum of each value, the YAML, the renderer line, and the policies with no keyring or private key, plain text under --element is a problem")
+	verifyCmd.PersistentFlags().BoolVar(&assembleIncludes, "assemble", false, "with --dir, check each file together with the files it includes, as Salt assembles them")
+}
@@ -0,0 +1,74 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+
+	"github.com/Everbridge/generate-secure-pillar/output"
+	"github.com/Everbridge/generate-secure-pillar/pki"
+	"github.com/Everbridge/generate-secure-pillar/sls"
+	"github.com/spf13/cobra"
+)
+
+// set when building, e.g.
+// -ldflags "-X github.com/Everbridge/generate-secure-pillar/cmd.Commit=$(git rev-parse HEAD)"
+var (
+	Version   = "1.0.592"
+	Commit    = "unknown"
+	BuildDate = "unknown"
+)
+
+func init() {
+	rootCmd.Version = Version
+	cobra.AddTemplateFunc("versionInfo", versionInfo)
+	rootCmd.SetVersionTemplate(`{{versionInfo}}`)
+}
+
+// versionInfo is printed by --version, as JSON or YAML with --format so that
+// scripts can check what the tool supports before they run it
+func versionInfo() string {
+	if !structuredOutput() {
+		return fmt.Sprintf("%s version %s (commit %s, built %s)\n", rootCmd.Name(), Version, Commit, BuildDate)
+	}
+	var buf bytes.Buffer
+	if err := output.Write(&buf, outputFormat, buildInfo()); err != nil {
+		logger.Fatal(err)
+	}
+	return buf.String()
+}
+
+// buildInfo describes this build of the tool
+func buildInfo() output.Version {
+	return output.Version{
+		Version:       Version,
+		Commit:        Commit,
+		BuildDate:     BuildDate,
+		GoVersion:     runtime.Version(),
+		EncryptOnly:   pki.EncryptOnly,
+		Backends:      availableBackends(),
+		FormatVersion: sls.FormatVersion,
+		Features:      sls.FormatFeatures,
+		OutputFormats: output.Formats,
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Everbridge/generate-secure-pillar/pki"
+	"github.com/Everbridge/generate-secure-pillar/sls"
+	"github.com/Everbridge/generate-secure-pillar/utils"
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/cobra"
+)
+
+var watchDelay time.Duration
+
+// watchCmd represents the watch command
+var watchCmd = &cobra.Command{
+	Use:   "watch",
+	Short: "encrypt the plain text values in .sls files as they are saved",
+	Example: `
+# encrypt values under the element as soon as they are saved
+$ generate-secure-pillar -k "Salt Master" -e secure_vars watch -d /path/to/pillar`,
+	Run: func(cmd *cobra.Command, args []string) {
+		dir := recurseDirectory(cmd)
+		if dir == "" {
+			exitWithf(utils.ExitUsage, "watch needs a directory (-d)")
+		}
+		if watchDelay <= 0 {
+			exitWithf(utils.ExitUsage, "watch: --delay must be a positive duration, e.g. 500ms")
+		}
+		dir, err := filepath.Abs(dir)
+		fatal("watch", err)
+		pk := getPki()
+
+		watcher, err := fsnotify.NewWatcher()
+		fatal("watch", err)
+		defer watcher.Close()
+		fatal("watch", watchTree(watcher, dir))
+
+		// what was saved before watching started
+		files := findFiles("watch", dir)
+		for _, file := range files {
+			encryptWatched(file, pk)
+		}
+		logger.Infof("watching %s for changes to .sls files", dir)
+
+		// editors can write a file more than once when saving it, a file is encrypted
+		// once it hasn't changed for the delay
+		pending := map[string]time.Time{}
+		ticker := time.NewTicker(watchDelay / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				watchEvent(watcher, event, pending)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logger.Warnf("watch: %s", err)
+			case now := <-ticker.C:
+				for file, changed := range pending {
+					if now.Sub(changed) >= watchDelay {
+						delete(pending, file)
+						encryptWatched(file, pk)
+					}
+				}
+			}
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(watchCmd)
+	watchCmd.PersistentFlags().StringVarP(&recurseDir, "dir", "d", "", "directory to watch, with the directories under it")
+	watchCmd.PersistentFlags().StringArrayVar(&includePaths, "include-path", nil, "only encrypt the values under paths matching this pattern, e.g. 'db:*' (can be repeated)")
+	watchCmd.PersistentFlags().StringArrayVar(&excludePaths, "exclude-path", nil, "leave the values under paths matching this pattern as they are (can be repeated)")
+	watchCmd.PersistentFlags().DurationVar(&watchDelay, "delay", 500*time.Millisecond, "how long a file must be unchanged before it is encrypted")
+}
+
+// watchTree watches a directory and the directories under it, except hidden ones such as .git
+func watchTree(watcher *fsnotify.Watcher, dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if path != dir && strings.HasPrefix(info.Name(), ".") {
+			return filepath.SkipDir
+		}
+		return watcher.Add(path)
+	})
+}
+
+// watchEvent notes a saved .sls file, and watches new directories
+func watchEvent(watcher *fsnotify.Watcher, event fsnotify.Event, pending map[string]time.Time) {
+	switch {
+	case event.Op&fsnotify.Create != 0:
+		if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+			if err := watchTree(watcher, event.Name); err != nil {
+				logger.Warnf("watch: %s", err)
+			}
+			return
+		}
+	case event.Op&fsnotify.Write == 0:
+		// removed, renamed away, or only its mode changed
+		delete(pending, event.Name)
+		return
+	}
+	if filepath.Ext(event.Name) == ".sls" {
+		pending[event.Name] = time.Now()
+	}
+}
+
+// encryptWatched encrypts the plain text values in a file and writes it, a file without
+// any is left as it is (so that writing it doesn't trigger another encryption)
+func encryptWatched(file string, pk pki.Pki) {
+	// values tagged !file are read from their files, and must be encrypted
+	opts := sls.DefaultOptions
+	opts.ResolveFiles = true
+	s := sls.NewWithOptions(file, pk, topLevelElement, opts)
+	if s.Error != nil {
+		logger.Warnf("watch: %s", s.Error)
+		return
+	}
+	plain := len(s.PlainValues())
+	if plain == 0 {
+		return
+	}
+	buffer, err := s.PerformAction(sls.Encrypt)
+	if err != nil {
+		logger.Warnf("watch: %s: %s", file, err)
+		return
+	}
+	// values left out by --include-path or --exclude-path stay plain text
+	encrypted := plain - len(s.PlainValues())
+	if encrypted == 0 {
+		return
+	}
+	if _, err := sls.WriteSlsFileContext(commandContext, buffer, file); err != nil {
+		logger.Warnf("watch: %s", err)
+		return
+	}
+	logger.Infof("watch: encrypted %d values in %s", encrypted, file)
+}
@@ -0,0 +1,220 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package generate makes random secrets (passwords, UUIDs, and RSA keys) from a
+// template that lists the pillar paths to fill in
+package generate
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// Password, UUID, and RSA are the kinds of secret a template entry can ask for,
+// RSA is followed by the key size, e.g. rsa:4096
+const (
+	Password = "password"
+	UUID     = "uuid"
+	RSA      = "rsa"
+)
+
+// DefaultLength is the length of a password when the template doesn't give one
+const DefaultLength = 32
+
+// DefaultClasses are the characters a password uses when the template doesn't say
+var DefaultClasses = []string{"lower", "upper", "digit"}
+
+// Classes are the character classes a password can be made from
+var Classes = map[string]string{
+	"lower":  "abcdefghijklmnopqrstuvwxyz",
+	"upper":  "ABCDEFGHIJKLMNOPQRSTUVWXYZ",
+	"digit":  "0123456789",
+	"symbol": "!#$%&*+-=?@^_~",
+}
+
+// Entry is one secret in a template
+type Entry struct {
+	Path    string   `yaml:"path"`
+	Type    string   `yaml:"type"`
+	Length  int      `yaml:"length"`
+	Classes []string `yaml:"classes"`
+}
+
+// Template lists the secrets to generate
+type Template struct {
+	Secrets []Entry `yaml:"secrets"`
+}
+
+// Load reads and checks a template file
+func Load(file string) (Template, error) {
+	data, err := ioutil.ReadFile(filepath.Clean(file))
+	if err != nil {
+		return Template{}, err
+	}
+	return Parse(data)
+}
+
+// Parse reads and checks a template
+func Parse(data []byte) (Template, error) {
+	var t Template
+	if err := yaml.Unmarshal(data, &t); err != nil {
+		return t, fmt.Errorf("unable to read the template: %s", err)
+	}
+	if len(t.Secrets) == 0 {
+		return t, fmt.Errorf("the template has no secrets")
+	}
+	for i := range t.Secrets {
+		e := &t.Secrets[i]
+		if e.Path == "" {
+			return t, fmt.Errorf("secrets[%d]: 'path' is required", i)
+		}
+		if err := e.check(); err != nil {
+			return t, fmt.Errorf("%s: %s", e.Path, err)
+		}
+	}
+	return t, nil
+}
+
+// check fills in the defaults of an entry and checks its settings
+func (e *Entry) check() error {
+	if e.Type == "" {
+		e.Type = Password
+	}
+	switch {
+	case e.Type == Password:
+		if e.Length == 0 {
+			e.Length = DefaultLength
+		}
+		if len(e.Classes) == 0 {
+			e.Classes = DefaultClasses
+		}
+		for _, class := range e.Classes {
+			if _, ok := Classes[class]; !ok {
+				return fmt.Errorf("unknown character class '%s', expected lower, upper, digit, or symbol", class)
+			}
+		}
+		if e.Length < len(e.Classes) {
+			return fmt.Errorf("a length of %d can't have a character from each of %d classes", e.Length, len(e.Classes))
+		}
+	case e.Type == UUID:
+	case strings.HasPrefix(e.Type, RSA+":"):
+		if _, err := e.rsaBits(); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown type '%s', expected %s, %s, or %s:<bits>", e.Type, Password, UUID, RSA)
+	}
+	return nil
+}
+
+func (e *Entry) rsaBits() (int, error) {
+	bits, err := strconv.Atoi(strings.TrimPrefix(e.Type, RSA+":"))
+	if err != nil || bits < 2048 || bits > 16384 {
+		return 0, fmt.Errorf("invalid RSA key size in '%s', expected 2048 to 16384 bits", e.Type)
+	}
+	return bits, nil
+}
+
+// Generate returns a new random value for the entry, RSA keys are PEM encoded (PKCS #1)
+func (e *Entry) Generate() (string, error) {
+	if err := e.check(); err != nil {
+		return "", err
+	}
+	switch e.Type {
+	case Password:
+		return password(e.Length, e.Classes)
+	case UUID:
+		return uuid()
+	}
+	bits, _ := e.rsaBits()
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})), nil
+}
+
+// password returns a random password with at least one character from each class
+func password(length int, classes []string) (string, error) {
+	var all string
+	chars := make([]byte, 0, length)
+	for _, class := range classes {
+		c, err := pick(Classes[class])
+		if err != nil {
+			return "", err
+		}
+		chars = append(chars, c)
+		all += Classes[class]
+	}
+	for len(chars) < length {
+		c, err := pick(all)
+		if err != nil {
+			return "", err
+		}
+		chars = append(chars, c)
+	}
+	// shuffle so the guaranteed characters aren't always first
+	for i := len(chars) - 1; i > 0; i-- {
+		j, err := randInt(i + 1)
+		if err != nil {
+			return "", err
+		}
+		chars[i], chars[j] = chars[j], chars[i]
+	}
+	return string(chars), nil
+}
+
+func pick(alphabet string) (byte, error) {
+	i, err := randInt(len(alphabet))
+	if err != nil {
+		return 0, err
+	}
+	return alphabet[i], nil
+}
+
+// randInt returns a uniform random number in [0, n)
+func randInt(n int) (int, error) {
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, err
+	}
+	return int(i.Int64()), nil
+}
+
+// uuid returns a random (version 4) UUID
+func uuid() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
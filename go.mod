@@ -5,14 +5,16 @@ go 1.13
 require (
 	github.com/andreyvit/diff v0.0.0-20170406064948-c7f18ee00883
 	github.com/esilva-everbridge/yaml v0.0.0-20191018193138-a39befb24400
+	github.com/fsnotify/fsnotify v1.4.7
 	github.com/keybase/go-crypto v0.0.0-20190828182435-a05457805304
 	github.com/mitchellh/go-homedir v1.1.0
 	github.com/sergi/go-diff v1.0.0 // indirect
 	github.com/sirupsen/logrus v1.4.2
 	github.com/spf13/cobra v0.0.5
+	github.com/spf13/pflag v1.0.3
 	github.com/spf13/viper v1.4.0
 	github.com/y0ssar1an/q v1.0.7
-	golang.org/x/sys v0.0.0-20210305034016-7844c3c200c3 // indirect
+	golang.org/x/sys v0.0.0-20210305034016-7844c3c200c3
 	gopkg.in/mattes/go-expand-tilde.v1 v1.0.0-20150330173918-cb884138e64c
 	gopkg.in/yaml.v3 v3.0.0-20191010095647-fc94e3f71652
 )
@@ -0,0 +1,171 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+//go:build integration
+// +build integration
+
+package main
+
+import (
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/Everbridge/generate-secure-pillar/pki"
+	"github.com/Everbridge/generate-secure-pillar/sls"
+	"github.com/Everbridge/generate-secure-pillar/testenv"
+	"github.com/Everbridge/generate-secure-pillar/utils"
+)
+
+// the integration tests round trip values between the built in pgp backend and the
+// system gpg binary, and decrypt them the way Salt's gpg renderer does:
+//
+//	go test -tags integration -run Integration .
+
+// integrationValues are encrypted and decrypted by each test
+var integrationValues = []string{
+	"hunter2",
+	"multi\nline\nvalue\n",
+	"ünïcödé ✓",
+	strings.Repeat("long value ", 1000),
+}
+
+// saltRenderer finds the PGP messages in its input and decrypts them like Salt's gpg
+// renderer, with python-gnupg if it is installed (older Salt) or the gpg binary
+const saltRenderer = `
+import re, subprocess, sys
+home, gpg = sys.argv[1], sys.argv[2]
+data = sys.stdin.read()
+cipher = re.search(r'-----BEGIN PGP MESSAGE-----.*?-----END PGP MESSAGE-----', data, re.DOTALL).group(0)
+try:
+    import gnupg
+    result = gnupg.GPG(gnupghome=home, gpgbinary=gpg).decrypt(cipher)
+    if not result.ok:
+        sys.exit(result.status)
+    sys.stdout.write(str(result))
+except ImportError:
+    proc = subprocess.Popen([gpg, '--homedir', home, '--status-fd', '2', '--no-tty', '-d'],
+                            stdin=subprocess.PIPE, stdout=subprocess.PIPE, stderr=subprocess.PIPE)
+    out, err = proc.communicate(input=cipher.encode())
+    if proc.returncode:
+        sys.exit(err.decode())
+    sys.stdout.write(out.decode())
+`
+
+// newIntegrationEnv creates a test environment, the tests are skipped without gpg
+func newIntegrationEnv(t *testing.T) (*testenv.Env, pki.Pki, pki.Pki) {
+	env, err := testenv.New("")
+	Ok(t, err)
+	if !env.GPGImported {
+		_ = env.Remove()
+		t.Skip("no gpg binary to import the test key into")
+	}
+	pgp := pki.New(env.KeyName, env.PubRing, env.SecRing)
+	gpg := pki.NewGPG(env.KeyName, env.GnupgHome)
+	return env, pgp, gpg
+}
+
+// gpgBinary returns the gpg binary the gpg backend uses
+func gpgBinary(t *testing.T, env *testenv.Env) string {
+	g, err := pki.NewGPGBackend(env.KeyName, env.GnupgHome)
+	Ok(t, err)
+	return g.Binary
+}
+
+func TestIntegrationBackends(t *testing.T) {
+	env, pgp, gpg := newIntegrationEnv(t)
+	defer env.Remove()
+
+	for _, value := range integrationValues {
+		encrypted, err := pgp.EncryptSecret(value)
+		Ok(t, err)
+		decrypted, err := gpg.DecryptSecret(encrypted)
+		Ok(t, err)
+		Equals(t, value, decrypted)
+
+		encrypted, err = gpg.EncryptSecret(value)
+		Ok(t, err)
+		decrypted, err = pgp.DecryptSecret(encrypted)
+		Ok(t, err)
+		Equals(t, value, decrypted)
+	}
+}
+
+func TestIntegrationFiles(t *testing.T) {
+	env, pgp, gpg := newIntegrationEnv(t)
+	defer env.Remove()
+	files, err := utils.FindFilesByExtLimited(env.PillarDir, ".sls", utils.WalkLimits{})
+	Ok(t, err)
+	Assert(t, len(files) > 0, "no sample pillar files", len(files))
+
+	for _, file := range files {
+		plain := sls.New(file, pki.Pki{}, "")
+		Ok(t, plain.Error)
+
+		for _, backends := range [][]pki.Pki{{pgp, gpg}, {gpg, pgp}} {
+			s := sls.New(file, backends[0], "")
+			Ok(t, s.Error)
+			buffer, err := s.PerformAction(sls.Encrypt)
+			Ok(t, err)
+			encrypted := filepath.Join(env.Dir, "encrypted.sls")
+			Ok(t, ioutil.WriteFile(encrypted, buffer.Bytes(), 0600))
+
+			s = sls.New(encrypted, backends[1], "")
+			Ok(t, s.Error)
+			_, err = s.PerformAction(sls.Decrypt)
+			Ok(t, err)
+			Equals(t, plain.Yaml.Values, s.Yaml.Values)
+		}
+	}
+}
+
+func TestIntegrationSaltRenderer(t *testing.T) {
+	python, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("no python3 to run the renderer")
+	}
+	env, pgp, gpg := newIntegrationEnv(t)
+	defer env.Remove()
+	binary := gpgBinary(t, env)
+
+	for _, p := range []pki.Pki{pgp, gpg} {
+		for _, value := range integrationValues {
+			encrypted, err := p.EncryptSecret(value)
+			Ok(t, err)
+
+			// the value as Salt reads it from a rendered file
+			s := sls.New("", pki.Pki{}, "")
+			Ok(t, s.SetValueFromPath("secret", encrypted))
+			buffer, err := s.FormatBuffer("")
+			Ok(t, err)
+			Ok(t, s.ReadBytes(buffer.Bytes()))
+
+			cmd := exec.Command(python, "-c", saltRenderer, env.GnupgHome, binary)
+			cmd.Stdin = strings.NewReader(s.GetValueFromPath("secret").(string))
+			out, err := cmd.Output()
+			if err != nil {
+				t.Fatalf("renderer: %s", err)
+			}
+			Equals(t, value, string(out))
+		}
+	}
+}
@@ -0,0 +1,202 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// counters, the names are used as is for StatsD and with a gsp_ prefix
+// and _total suffix for OpenMetrics
+const (
+	// FilesProcessed counts files that an action was applied to
+	FilesProcessed = "files_processed"
+	// FilesFailed counts files that an action failed for
+	FilesFailed = "files_failed"
+	// FilesWritten counts sls files written
+	FilesWritten = "files_written"
+	// ValuesEncrypted counts values (or chunks of values) encrypted
+	ValuesEncrypted = "values_encrypted"
+	// ValuesDecrypted counts values decrypted
+	ValuesDecrypted = "values_decrypted"
+	// Failures counts values that failed to encrypt or decrypt
+	Failures = "failures"
+	// PassphraseFailures counts values that failed to decrypt because the private key passphrase
+	// was wrong or missing, they aren't counted as Failures
+	PassphraseFailures = "passphrase_failures"
+	// ValuesOutsideElement counts plain text values left outside the element when encrypting
+	ValuesOutsideElement = "values_outside_element"
+)
+
+var counterNames = []string{FilesProcessed, FilesFailed, FilesWritten, ValuesEncrypted, ValuesDecrypted, Failures, PassphraseFailures, ValuesOutsideElement}
+
+var counters = map[string]*int64{}
+var start = time.Now()
+var command string
+var metricsFile string
+var statsdAddr string
+var emitOnce sync.Once
+
+func init() {
+	for _, name := range counterNames {
+		counters[name] = new(int64)
+	}
+}
+
+// Configure sets where metrics are emitted, nothing is emitted if both are empty
+func Configure(file string, statsd string) {
+	metricsFile = file
+	statsdAddr = statsd
+}
+
+// SetCommand sets the command the metrics are labelled with
+func SetCommand(name string) {
+	command = name
+}
+
+// Inc adds one to a counter
+func Inc(name string) {
+	Add(name, 1)
+}
+
+// Add adds n to a counter, it is safe to call from concurrent workers
+func Add(name string, n int64) {
+	if c, ok := counters[name]; ok {
+		atomic.AddInt64(c, n)
+	}
+}
+
+// Value returns the current value of a counter
+func Value(name string) int64 {
+	if c, ok := counters[name]; ok {
+		return atomic.LoadInt64(c)
+	}
+	return 0
+}
+
+// Emit writes the metrics file and sends the StatsD metrics, if configured,
+// only the first call has any effect so it can be called on every exit path
+func Emit(exitCode int) error {
+	var err error
+	emitOnce.Do(func() {
+		duration := time.Since(start)
+		if metricsFile != "" {
+			err = writeFile(metricsFile, OpenMetrics(exitCode, duration))
+		}
+		if statsdAddr != "" {
+			if serr := sendStatsd(statsdAddr, StatsdLines(exitCode, duration)); serr != nil && err == nil {
+				err = serr
+			}
+		}
+	})
+	return err
+}
+
+// Exit emits the metrics and exits with the given code
+func Exit(code int) {
+	if err := Emit(code); err != nil {
+		fmt.Fprintf(os.Stderr, "metrics: %s\n", err)
+	}
+	os.Exit(code)
+}
+
+// OpenMetrics formats the metrics in the OpenMetrics text format
+func OpenMetrics(exitCode int, duration time.Duration) []byte {
+	var buf bytes.Buffer
+	labels := fmt.Sprintf("{command=%q}", command)
+
+	names := append([]string{}, counterNames...)
+	sort.Strings(names)
+	for _, name := range names {
+		buf.WriteString(fmt.Sprintf("# TYPE gsp_%s counter\n", name))
+		buf.WriteString(fmt.Sprintf("gsp_%s_total%s %d\n", name, labels, Value(name)))
+	}
+	buf.WriteString("# TYPE gsp_duration_seconds gauge\n")
+	buf.WriteString(fmt.Sprintf("gsp_duration_seconds%s %.3f\n", labels, duration.Seconds()))
+	buf.WriteString("# TYPE gsp_exit_code gauge\n")
+	buf.WriteString(fmt.Sprintf("gsp_exit_code%s %d\n", labels, exitCode))
+	buf.WriteString("# TYPE gsp_last_run_timestamp_seconds gauge\n")
+	buf.WriteString(fmt.Sprintf("gsp_last_run_timestamp_seconds%s %d\n", labels, start.Unix()))
+	buf.WriteString("# EOF\n")
+
+	return buf.Bytes()
+}
+
+// StatsdLines formats the metrics as StatsD counters and timers
+func StatsdLines(exitCode int, duration time.Duration) []string {
+	var lines []string
+	for _, name := range counterNames {
+		lines = append(lines, fmt.Sprintf("gsp.%s:%d|c", name, Value(name)))
+	}
+	lines = append(lines, fmt.Sprintf("gsp.duration:%d|ms", duration.Nanoseconds()/int64(time.Millisecond)))
+	lines = append(lines, fmt.Sprintf("gsp.exit_code:%d|g", exitCode))
+	return lines
+}
+
+// writeFile replaces the metrics file in one step, so collectors never read a partial file
+func writeFile(file string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(file), ".gsp-metrics-")
+	if err != nil {
+		return fmt.Errorf("unable to write %s: %s", file, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("unable to write %s: %s", file, err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("unable to write %s: %s", file, err)
+	}
+	if err = os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("unable to write %s: %s", file, err)
+	}
+	if err = os.Rename(tmp.Name(), file); err != nil {
+		return fmt.Errorf("unable to write %s: %s", file, err)
+	}
+	return nil
+}
+
+// sendStatsd sends the lines in one UDP packet
+func sendStatsd(addr string, lines []string) error {
+	conn, err := net.DialTimeout("udp", addr, 2*time.Second)
+	if err != nil {
+		return fmt.Errorf("statsd: %s", err)
+	}
+	defer conn.Close()
+
+	var buf bytes.Buffer
+	for _, line := range lines {
+		buf.WriteString(line + "\n")
+	}
+	if _, err = conn.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("statsd: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package output
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Dot is the Graphviz graph format (keys graph)
+const Dot = "dot"
+
+// Mermaid is the Mermaid graph format (keys graph)
+const Mermaid = "mermaid"
+
+// kinds of graph nodes
+const (
+	FileNode = "file"
+	PathNode = "path"
+	KeyNode  = "key"
+)
+
+// GraphNode is a file, a path in a file, or a key (keys graph)
+type GraphNode struct {
+	ID    string `json:"id" yaml:"id"`
+	Kind  string `json:"kind" yaml:"kind"`
+	Label string `json:"label" yaml:"label"`
+}
+
+// GraphEdge links a file to a path or key, and a path to its key,
+// Count is the number of values it stands for
+type GraphEdge struct {
+	From  string `json:"from" yaml:"from"`
+	To    string `json:"to" yaml:"to"`
+	Count int    `json:"count" yaml:"count"`
+}
+
+// KeyGraph is the graph of the keys used by the files in a directory (keys graph)
+type KeyGraph struct {
+	Nodes []GraphNode `json:"nodes" yaml:"nodes"`
+	Edges []GraphEdge `json:"edges" yaml:"edges"`
+}
+
+// IsGraph returns true for the graph formats
+func IsGraph(format string) bool {
+	return format == Dot || format == Mermaid
+}
+
+// WriteGraph writes a graph in the dot or mermaid format
+func WriteGraph(w io.Writer, format string, g KeyGraph) error {
+	var b strings.Builder
+	switch format {
+	case Dot:
+		b.WriteString("digraph keys {\n  rankdir=LR;\n")
+		for _, n := range g.Nodes {
+			b.WriteString(fmt.Sprintf("  %s [label=%s, shape=%s];\n", dotQuote(n.ID), dotQuote(n.Label), dotShapes[n.Kind]))
+		}
+		for _, e := range g.Edges {
+			b.WriteString(fmt.Sprintf("  %s -> %s [label=\"%d\"];\n", dotQuote(e.From), dotQuote(e.To), e.Count))
+		}
+		b.WriteString("}\n")
+	case Mermaid:
+		// mermaid IDs can't contain most punctuation, so nodes are numbered
+		ids := map[string]string{}
+		b.WriteString("graph LR\n")
+		for i, n := range g.Nodes {
+			ids[n.ID] = fmt.Sprintf("n%d", i)
+			shape := mermaidShapes[n.Kind]
+			b.WriteString(fmt.Sprintf("  %s%s\"%s\"%s\n", ids[n.ID], shape[0], mermaidEscape(n.Label), shape[1]))
+		}
+		for _, e := range g.Edges {
+			b.WriteString(fmt.Sprintf("  %s -->|%d| %s\n", ids[e.From], e.Count, ids[e.To]))
+		}
+	default:
+		return fmt.Errorf("'%s' is not a graph format", format)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+var dotShapes = map[string]string{FileNode: "box", PathNode: "note", KeyNode: "ellipse"}
+
+var mermaidShapes = map[string][2]string{FileNode: {"[", "]"}, PathNode: {">", "]"}, KeyNode: {"((", "))"}}
+
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
+
+func mermaidEscape(s string) string {
+	return strings.NewReplacer(`"`, "#quot;", "\n", "<br/>", "<", "#lt;", ">", "#gt;").Replace(s)
+}
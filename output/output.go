@@ -0,0 +1,259 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// Text is the human readable output format (the default)
+const Text = "text"
+
+// JSON output format
+const JSON = "json"
+
+// YAML output format
+const YAML = "yaml"
+
+// The structs below are the schemas for --format json and --format yaml,
+// fields are only ever added to them so that wrapper tooling keeps working
+
+// Key is a PGP key, identified by key ID and user ID
+type Key struct {
+	KeyID    string `json:"key_id" yaml:"key_id"`
+	Identity string `json:"identity" yaml:"identity"`
+}
+
+// KeyUse is a value in a file and the key it was encrypted with
+type KeyUse struct {
+	Path string `json:"path" yaml:"path"`
+	Key  `yaml:",inline"`
+	// Provenance is who encrypted the value and when, from the file's signed provenance sidecar
+	Provenance *Provenance `json:"provenance,omitempty" yaml:"provenance,omitempty"`
+	// Recipients are all of the keys the value is encrypted to
+	Recipients []Recipient `json:"recipients,omitempty" yaml:"recipients,omitempty"`
+}
+
+// Recipient is a key a value is encrypted to, an anonymous one (key ID 0) can be any key
+type Recipient struct {
+	KeyID     string `json:"key_id" yaml:"key_id"`
+	Identity  string `json:"identity,omitempty" yaml:"identity,omitempty"`
+	Anonymous bool   `json:"anonymous,omitempty" yaml:"anonymous,omitempty"`
+}
+
+// Provenance is who encrypted a value and when, and the key that signed the record of it
+type Provenance struct {
+	EncryptedBy string `json:"encrypted_by" yaml:"encrypted_by"`
+	EncryptedAt string `json:"encrypted_at" yaml:"encrypted_at"`
+	SignedBy    string `json:"signed_by" yaml:"signed_by"`
+}
+
+// KeyReport lists the keys used in a file (keys all, keys recurse, keys count)
+type KeyReport struct {
+	File  string   `json:"file" yaml:"file"`
+	Count int      `json:"count" yaml:"count"`
+	Keys  []Key    `json:"keys" yaml:"keys"`
+	Uses  []KeyUse `json:"uses" yaml:"uses"`
+	// Files are the files a document is assembled from (keys recurse --assemble)
+	Files []string `json:"files,omitempty" yaml:"files,omitempty"`
+}
+
+// PathValue is the value at a YAML path (encrypt path, decrypt path, keys path)
+type PathValue struct {
+	File  string      `json:"file" yaml:"file"`
+	Path  string      `json:"path" yaml:"path"`
+	Value interface{} `json:"value" yaml:"value"`
+}
+
+// ExpiringSecret is a secret that is due for rotation (expiring)
+type ExpiringSecret struct {
+	File    string                 `json:"file" yaml:"file"`
+	Path    string                 `json:"path" yaml:"path"`
+	Expires string                 `json:"expires" yaml:"expires"`
+	Expired bool                   `json:"expired" yaml:"expired"`
+	Fields  map[string]interface{} `json:"fields,omitempty" yaml:"fields,omitempty"`
+}
+
+// HistoryItem is a previous value of a secret (history)
+type HistoryItem struct {
+	Index    int    `json:"index" yaml:"index"`
+	Replaced string `json:"replaced" yaml:"replaced"`
+	Key      string `json:"key" yaml:"key"`
+}
+
+// ProfileSummary is a config file profile (config list)
+type ProfileSummary struct {
+	Name    string `json:"name" yaml:"name"`
+	Default bool   `json:"default" yaml:"default"`
+	Backend string `json:"backend,omitempty" yaml:"backend,omitempty"`
+}
+
+// Problem is something wrong with a file or a value in it (verify, manifest verify)
+type Problem struct {
+	File    string `json:"file" yaml:"file"`
+	Path    string `json:"path,omitempty" yaml:"path,omitempty"`
+	Message string `json:"message" yaml:"message"`
+	// Line is where Path is in File, 0 when it isn't known
+	Line int `json:"line,omitempty" yaml:"line,omitempty"`
+	// Rule is the kind of problem, for SARIF output
+	Rule string `json:"rule,omitempty" yaml:"rule,omitempty"`
+}
+
+// WeakSecret is an encrypted value that doesn't meet the strength policy (audit),
+// the value itself is never reported
+type WeakSecret struct {
+	File     string   `json:"file" yaml:"file"`
+	Path     string   `json:"path" yaml:"path"`
+	Length   int      `json:"length" yaml:"length"`
+	Classes  int      `json:"classes" yaml:"classes"`
+	Entropy  float64  `json:"entropy" yaml:"entropy"`
+	Problems []string `json:"problems" yaml:"problems"`
+}
+
+// SyncResult is a file compared between two trees, and whether it was written (sync)
+type SyncResult struct {
+	Path   string `json:"path" yaml:"path"`
+	Status string `json:"status" yaml:"status"`
+}
+
+// SecretChange is an encrypted value that was added, removed, or changed between two git refs,
+// and the IDs of the keys it was and is encrypted to (changelog)
+type SecretChange struct {
+	File     string   `json:"file" yaml:"file"`
+	Path     string   `json:"path" yaml:"path"`
+	Status   string   `json:"status" yaml:"status"`
+	FromKeys []string `json:"from_keys,omitempty" yaml:"from_keys,omitempty"`
+	ToKeys   []string `json:"to_keys,omitempty" yaml:"to_keys,omitempty"`
+}
+
+// ReachableSecret is an encrypted value a minion receives, the sls name and top file target
+// that give it, and the file it is in (targets)
+type ReachableSecret struct {
+	File   string `json:"file" yaml:"file"`
+	Path   string `json:"path" yaml:"path"`
+	Sls    string `json:"sls" yaml:"sls"`
+	Env    string `json:"env" yaml:"env"`
+	Target string `json:"target" yaml:"target"`
+}
+
+// RunReport is what a command run over a directory did, written and signed with --report
+type RunReport struct {
+	Action    string       `json:"action" yaml:"action"`
+	Directory string       `json:"directory" yaml:"directory"`
+	User      string       `json:"user" yaml:"user"`
+	Version   string       `json:"version" yaml:"version"`
+	Started   time.Time    `json:"started" yaml:"started"`
+	Finished  time.Time    `json:"finished" yaml:"finished"`
+	Values    int          `json:"values" yaml:"values"`
+	Keys      []string     `json:"keys" yaml:"keys"`
+	Failures  int          `json:"failures" yaml:"failures"`
+	Error     string       `json:"error,omitempty" yaml:"error,omitempty"`
+	Files     []FileResult `json:"files" yaml:"files"`
+}
+
+// FileResult is a file in a RunReport, the number of encrypted values in it and the fingerprints
+// of the keys they are encrypted to, after encrypting or rotating and before decrypting
+type FileResult struct {
+	File    string   `json:"file" yaml:"file"`
+	Values  int      `json:"values" yaml:"values"`
+	Keys    []string `json:"keys,omitempty" yaml:"keys,omitempty"`
+	Skipped bool     `json:"skipped,omitempty" yaml:"skipped,omitempty"`
+	Error   string   `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// Spelling is one way a key is written and the files that use it (normalize-paths)
+type Spelling struct {
+	Path  string   `json:"path" yaml:"path"`
+	Files []string `json:"files" yaml:"files"`
+}
+
+// CaseConflict is a path that is written with different cases across files (normalize-paths)
+type CaseConflict struct {
+	Path      string     `json:"path" yaml:"path"`
+	Spellings []Spelling `json:"spellings" yaml:"spellings"`
+}
+
+// Version is the version of the tool, how it was built, and what it supports (--version)
+type Version struct {
+	Version       string   `json:"version" yaml:"version"`
+	Commit        string   `json:"commit" yaml:"commit"`
+	BuildDate     string   `json:"build_date" yaml:"build_date"`
+	GoVersion     string   `json:"go_version" yaml:"go_version"`
+	EncryptOnly   bool     `json:"encrypt_only" yaml:"encrypt_only"`
+	Backends      []string `json:"backends" yaml:"backends"`
+	FormatVersion int      `json:"format_version" yaml:"format_version"`
+	Features      []string `json:"features" yaml:"features"`
+	OutputFormats []string `json:"output_formats" yaml:"output_formats"`
+}
+
+// Formats lists the output formats
+var Formats = []string{Text, JSON, YAML, Dot, Mermaid, SARIF}
+
+// Check returns an error if the format is not one of text, json, or yaml
+func Check(format string) error {
+	switch format {
+	case Text, JSON, YAML, Dot, Mermaid, SARIF:
+		return nil
+	}
+	return fmt.Errorf("unknown output format '%s', expected %s, %s, %s, %s, %s, or %s", format, Text, JSON, YAML, Dot, Mermaid, SARIF)
+}
+
+// IsStructured returns true for the machine readable formats
+func IsStructured(format string) bool {
+	return format == JSON || format == YAML
+}
+
+// ParseKey splits the "<key ID>: <user ID>" description of a key
+func ParseKey(desc string) Key {
+	parts := strings.SplitN(strings.TrimSpace(desc), ": ", 2)
+	if len(parts) < 2 {
+		return Key{KeyID: parts[0]}
+	}
+	return Key{KeyID: parts[0], Identity: parts[1]}
+}
+
+// Write encodes v to w in the given structured format
+func Write(w io.Writer, format string, v interface{}) error {
+	switch format {
+	case JSON:
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(v); err != nil {
+			return fmt.Errorf("json output error: %s", err)
+		}
+		return nil
+	case YAML:
+		out, err := yaml.Marshal(v)
+		if err != nil {
+			return fmt.Errorf("yaml output error: %s", err)
+		}
+		_, err = w.Write(out)
+		return err
+	}
+	return fmt.Errorf("'%s' is not a structured output format", format)
+}
@@ -0,0 +1,244 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package pki
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// AgentSocketEnv overrides where the unlock agent listens
+const AgentSocketEnv = "GSP_AGENT_SOCK"
+
+// agent requests, one per connection
+const (
+	agentGet    = "get"
+	agentStatus = "status"
+	agentLock   = "lock"
+)
+
+// AgentSecret is the key material the unlock agent keeps in memory until it expires
+type AgentSecret struct {
+	Passphrase []byte    `json:"passphrase,omitempty"`
+	SecretKey  []byte    `json:"secret_key,omitempty"`
+	Expires    time.Time `json:"expires"`
+}
+
+// wipe overwrites the key material
+func (a *AgentSecret) wipe() {
+	for i := range a.Passphrase {
+		a.Passphrase[i] = 0
+	}
+	for i := range a.SecretKey {
+		a.SecretKey[i] = 0
+	}
+	a.Passphrase, a.SecretKey = nil, nil
+}
+
+// AgentSocket returns the unlock agent socket path: $GSP_AGENT_SOCK, or gsp-agent.sock
+// in $XDG_RUNTIME_DIR (or a directory of the user's own in the temp dir)
+func AgentSocket() string {
+	if sock := os.Getenv(AgentSocketEnv); sock != "" {
+		return sock
+	}
+	dir := os.Getenv("XDG_RUNTIME_DIR")
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), fmt.Sprintf("gsp-%d", os.Getuid()))
+	}
+	return filepath.Join(dir, "gsp-agent.sock")
+}
+
+// errNoPeerCredentials is returned by peerUID where the OS can't tell who is on the other end
+var errNoPeerCredentials = fmt.Errorf("peer credentials are not available on this system")
+
+// fileOwner returns the uid that owns a file
+func fileOwner(fi os.FileInfo) (int, bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return -1, false
+	}
+	return int(st.Uid), true
+}
+
+// checkAgentDir refuses a socket directory that another user could have made first or can
+// write to, e.g. gsp-<uid> in a shared temp dir: it must be a directory, not a link, owned
+// by the user with mode 0700
+func checkAgentDir(dir string) error {
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("unlock agent: %s is not a directory", dir)
+	}
+	if uid, ok := fileOwner(fi); !ok || uid != os.Getuid() {
+		return fmt.Errorf("unlock agent: %s is not owned by uid %d", dir, os.Getuid())
+	}
+	if fi.Mode().Perm() != 0700 {
+		return fmt.Errorf("unlock agent: %s has mode %04o, expected 0700", dir, fi.Mode().Perm())
+	}
+	return nil
+}
+
+// checkAgentPeer refuses a connection from or to a process of another user
+func checkAgentPeer(conn net.Conn) error {
+	uid, err := peerUID(conn)
+	if err == errNoPeerCredentials {
+		// the socket's directory and mode keep other users out
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unlock agent: %s", err)
+	}
+	if uid != os.Getuid() {
+		return fmt.Errorf("unlock agent: the other end of the socket is uid %d, not %d", uid, os.Getuid())
+	}
+	return nil
+}
+
+// ServeAgent answers requests for the secret on a unix socket that only the user can use,
+// until the secret expires or the agent is locked, the secret is wiped before it returns
+func ServeAgent(socket string, secret AgentSecret) error {
+	defer secret.wipe()
+	if err := os.MkdirAll(filepath.Dir(socket), 0700); err != nil {
+		return err
+	}
+	if err := checkAgentDir(filepath.Dir(socket)); err != nil {
+		return err
+	}
+	if _, err := os.Stat(socket); err == nil {
+		if _, statusErr := AgentStatus(socket); statusErr == nil {
+			return fmt.Errorf("an agent is already running on %s", socket)
+		}
+		// a socket left behind by an agent that was killed
+		_ = os.Remove(socket)
+	}
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return err
+	}
+	if err = os.Chmod(socket, 0600); err != nil {
+		listener.Close()
+		return err
+	}
+
+	var once sync.Once
+	stop := func() { once.Do(func() { listener.Close() }) }
+	timer := time.AfterFunc(time.Until(secret.Expires), stop)
+	defer timer.Stop()
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			// closed when the secret expired or the agent was locked
+			_ = os.Remove(socket)
+			return nil
+		}
+		if answerAgent(conn, &secret) {
+			stop()
+		}
+	}
+}
+
+// answerAgent answers one request and returns true when the agent should stop
+func answerAgent(conn net.Conn, secret *AgentSecret) bool {
+	defer conn.Close()
+	if checkAgentPeer(conn) != nil {
+		return false
+	}
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	request, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return false
+	}
+	reply := AgentSecret{Expires: secret.Expires}
+	switch strings.TrimSpace(request) {
+	case agentGet:
+		if time.Now().After(secret.Expires) {
+			return true
+		}
+		reply.Passphrase, reply.SecretKey = secret.Passphrase, secret.SecretKey
+	case agentLock:
+		_ = json.NewEncoder(conn).Encode(reply)
+		return true
+	case agentStatus:
+	default:
+		return false
+	}
+	_ = json.NewEncoder(conn).Encode(reply)
+	return false
+}
+
+// askAgent sends a request to the unlock agent, only an agent of the user's own, on a socket
+// in a directory only they can use, is asked
+func askAgent(socket string, request string) (AgentSecret, error) {
+	var secret AgentSecret
+	fi, err := os.Lstat(socket)
+	if err != nil {
+		return secret, fmt.Errorf("no unlock agent running: %s", err)
+	}
+	if uid, ok := fileOwner(fi); !ok || uid != os.Getuid() {
+		return secret, fmt.Errorf("unlock agent: %s is not owned by uid %d", socket, os.Getuid())
+	}
+	if err = checkAgentDir(filepath.Dir(socket)); err != nil {
+		return secret, err
+	}
+	conn, err := net.DialTimeout("unix", socket, time.Second)
+	if err != nil {
+		return secret, fmt.Errorf("no unlock agent running: %s", err)
+	}
+	defer conn.Close()
+	if err = checkAgentPeer(conn); err != nil {
+		return secret, err
+	}
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err = fmt.Fprintf(conn, "%s\n", request); err != nil {
+		return secret, err
+	}
+	if err = json.NewDecoder(conn).Decode(&secret); err != nil {
+		return secret, fmt.Errorf("unlock agent: %s", err)
+	}
+	return secret, nil
+}
+
+// AgentGet returns the key material kept by the unlock agent
+func AgentGet(socket string) (AgentSecret, error) {
+	return askAgent(socket, agentGet)
+}
+
+// AgentStatus returns when the unlock agent's key material expires, without the material
+func AgentStatus(socket string) (AgentSecret, error) {
+	return askAgent(socket, agentStatus)
+}
+
+// AgentLock makes the unlock agent drop its key material and exit
+func AgentLock(socket string) error {
+	_, err := askAgent(socket, agentLock)
+	return err
+}
@@ -0,0 +1,126 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package pki
+
+import (
+	"encoding/base64"
+	"strings"
+)
+
+const armorBegin = "-----BEGIN PGP MESSAGE-----"
+const armorEnd = "-----END PGP MESSAGE-----"
+
+// the armor checksum is a CRC-24 (RFC 4880, section 6.1)
+const crc24Init = 0xb704ce
+const crc24Poly = 0x1864cfb
+
+func crc24(data []byte) uint32 {
+	crc := uint32(crc24Init)
+	for _, b := range data {
+		crc ^= uint32(b) << 16
+		for i := 0; i < 8; i++ {
+			crc <<= 1
+			if crc&0x1000000 != 0 {
+				crc ^= crc24Poly
+			}
+		}
+	}
+	return crc & 0xffffff
+}
+
+// CheckArmor checks the armor of a PGP message line by line, so that a damaged value is
+// reported as what is wrong with it (a merge conflict marker, a missing END line, a changed
+// or lost line) rather than as a failure to decrypt, the errors are ErrCorruptArmor
+func CheckArmor(armored string) error {
+	lines := strings.Split(strings.TrimSpace(strings.Replace(armored, "\r\n", "\n", -1)), "\n")
+	for i, line := range lines {
+		for _, marker := range []string{"<<<<<<<", "|||||||", "=======", ">>>>>>>"} {
+			if strings.HasPrefix(line, marker) && (line == marker || line[len(marker)] == ' ') {
+				return Errorf(ErrCorruptArmor, "armor line %d: merge conflict marker '%s'", i+1, marker)
+			}
+		}
+	}
+	if strings.TrimSpace(lines[0]) != armorBegin {
+		return Errorf(ErrCorruptArmor, "armor line 1: expected '%s'", armorBegin)
+	}
+
+	end := -1
+	for i, line := range lines {
+		if strings.TrimSpace(line) == armorEnd {
+			end = i
+			break
+		}
+	}
+	if end < 0 {
+		return Errorf(ErrCorruptArmor, "armor is cut short, there is no '%s' line", armorEnd)
+	}
+	if end != len(lines)-1 {
+		return Errorf(ErrCorruptArmor, "armor line %d: text after '%s'", end+2, armorEnd)
+	}
+
+	// armor headers, then a blank line
+	first := 1
+	for first < end && strings.Contains(lines[first], ": ") {
+		first++
+	}
+	if first < end && strings.TrimSpace(lines[first]) == "" {
+		first++
+	}
+
+	var body strings.Builder
+	checksum := ""
+	for i := first; i < end; i++ {
+		line := strings.TrimSpace(lines[i])
+		if strings.HasPrefix(line, "=") && i == end-1 {
+			checksum = line[1:]
+			break
+		}
+		for _, c := range line {
+			if !strings.ContainsRune("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789+/=", c) {
+				return Errorf(ErrCorruptArmor, "armor line %d: '%c' is not a base64 character", i+1, c)
+			}
+		}
+		body.WriteString(line)
+	}
+	if body.Len() == 0 {
+		return Errorf(ErrCorruptArmor, "armor has no data")
+	}
+	data, err := base64.StdEncoding.DecodeString(body.String())
+	if err != nil {
+		return Errorf(ErrCorruptArmor, "armor data is not valid base64 (%s), a line was cut short or lost", err)
+	}
+
+	// the checksum is optional
+	if checksum == "" {
+		return nil
+	}
+	sum, err := base64.StdEncoding.DecodeString(checksum)
+	if err != nil || len(sum) != 3 {
+		return Errorf(ErrCorruptArmor, "armor line %d: '=%s' is not a checksum", end, checksum)
+	}
+	want := uint32(sum[0])<<16 | uint32(sum[1])<<8 | uint32(sum[2])
+	if got := crc24(data); got != want {
+		var b [3]byte
+		b[0], b[1], b[2] = byte(got>>16), byte(got>>8), byte(got)
+		return Errorf(ErrCorruptArmor, "armor checksum mismatch, the checksum line is '=%s' but the data's is '=%s', a line was changed or lost", checksum, base64.StdEncoding.EncodeToString(b[:]))
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package pki
+
+import (
+	"context"
+)
+
+// ContextBackend is a Backend whose requests can be cancelled, such as one that runs a command
+type ContextBackend interface {
+	Backend
+	SetContext(ctx context.Context)
+}
+
+// ContextDecrypter is a Decrypter whose requests can be cancelled, such as a PKCS#11 token
+type ContextDecrypter interface {
+	Decrypter
+	SetContext(ctx context.Context)
+}
+
+// SetContext makes encrypting and decrypting fail once ctx is done, and cancels the
+// requests of a backend or token that supports it (e.g. the gpg binary is killed)
+func (p *Pki) SetContext(ctx context.Context) {
+	p.Context = ctx
+	if cb, ok := p.Backend.(ContextBackend); ok {
+		cb.SetContext(ctx)
+	}
+	if cd, ok := p.Token.(ContextDecrypter); ok {
+		cd.SetContext(ctx)
+	}
+}
+
+// ctxErr returns the error of the context once it is done
+func (p *Pki) ctxErr() error {
+	if p.Context == nil {
+		return nil
+	}
+	return p.Context.Err()
+}
+
+// orBackground returns ctx, or the background context for a nil one
+func orBackground(ctx context.Context) context.Context {
+	if ctx == nil {
+		return context.Background()
+	}
+	return ctx
+}
@@ -0,0 +1,162 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+//go:build !encryptonly
+// +build !encryptonly
+
+package pki
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/keybase/go-crypto/openpgp"
+	"github.com/keybase/go-crypto/openpgp/armor"
+	"github.com/keybase/go-crypto/openpgp/errors"
+)
+
+// decryption is left out of encrypt only builds (-tags encryptonly), see decrypt_encryptonly.go
+
+// EncryptOnly is true in builds without decryption
+const EncryptOnly = false
+
+// DecryptSecret returns decrypted cipherText, asking for the passphrase again
+// when it is wrong if there is a Reprompt
+func (p *Pki) DecryptSecret(cipherText string) (string, error) {
+	if err := p.ctxErr(); err != nil {
+		return cipherText, err
+	}
+	plainText, err := p.decryptSecret(cipherText)
+	if IsPassphraseError(err) && p.Reprompt != nil {
+		return p.Reprompt.retry(p, cipherText, err)
+	}
+	return plainText, err
+}
+
+// retry asks for the passphrase again until the value decrypts, a passphrase
+// given for another value in the meantime is tried first
+func (r *Reprompt) retry(p *Pki, cipherText string, err error) (string, error) {
+	r.mu.Lock()
+	plainText, err := p.decryptSecret(cipherText)
+	r.mu.Unlock()
+	if !IsPassphraseError(err) {
+		return plainText, err
+	}
+	err = r.Again(err, func(passphrase []byte) error {
+		if uerr := p.Unlock(passphrase); uerr != nil {
+			return uerr
+		}
+		plainText, err = p.decryptSecret(cipherText)
+		return err
+	})
+	if err != nil {
+		return cipherText, err
+	}
+	return plainText, nil
+}
+
+func (p *Pki) decryptSecret(cipherText string) (plainText string, err error) {
+	if p.Backend != nil {
+		return p.Backend.DecryptSecret(cipherText)
+	}
+	if p.Token != nil {
+		return p.Token.DecryptSecret(cipherText)
+	}
+	if p.SecRing == nil {
+		return cipherText, fmt.Errorf("no secring set")
+	}
+	if p.SecretKey == nil {
+		return cipherText, Errorf(ErrKeyNotFound, "unable to load PGP secret key for '%s'", p.PgpKeyName)
+	}
+
+	decbuf := bytes.NewBuffer([]byte(cipherText))
+	block, err := armor.Decode(decbuf)
+	if err != nil {
+		return cipherText, fmt.Errorf("Decode error: %s", err)
+	}
+	if block.Type != "PGP MESSAGE" {
+		return cipherText, fmt.Errorf("block type is not PGP MESSAGE: %s", err)
+	}
+
+	md, err := openpgp.ReadMessage(block.Body, p.SecRing, nil, nil)
+	if err == errors.ErrKeyIncorrect && p.locked() {
+		return cipherText, &PassphraseError{fmt.Errorf("the private key for '%s' is protected by a passphrase", p.PgpKeyName)}
+	}
+	if err != nil {
+		return cipherText, readMessageError(err)
+	}
+
+	body, err := ioutil.ReadAll(md.UnverifiedBody)
+	if err != nil {
+		return cipherText, fmt.Errorf("unable to read message body: %s", err)
+	}
+
+	return string(body), err
+}
+
+// keyUsed gets the key used to encrypt a message by reading it with the secring
+func (p *Pki) keyUsed(cipherText string) (string, error) {
+	block, err := armor.Decode(strings.NewReader(cipherText))
+	if err != nil {
+		return "", err
+	}
+
+	if block.Type != "PGP MESSAGE" {
+		return "", fmt.Errorf("error decoding private key")
+	}
+	md, err := openpgp.ReadMessage(block.Body, p.SecRing, nil, nil)
+	if err != nil {
+		return "", readMessageError(err)
+	}
+
+	for index := 0; index < len(md.EncryptedToKeyIds); index++ {
+		id := md.EncryptedToKeyIds[index]
+		keyStr := keyStringForID(p.SecRing, id)
+		if keyStr != "" {
+			return keyStr, nil
+		}
+	}
+
+	return "", Errorf(ErrKeyNotFound, "unable to find key for ids used")
+}
+
+// DecryptSecret returns decrypted cipherText, gpg-agent handles any passphrase or PIN entry
+// unless a passphrase was given
+func (g *GPGBackend) DecryptSecret(cipherText string) (string, error) {
+	var out string
+	var err error
+	if g.Passphrase != nil {
+		out, err = g.runWithPassphrase(cipherText, "--batch", "--pinentry-mode", "loopback", "--passphrase-fd", "3", "--decrypt")
+	} else {
+		out, err = g.run(cipherText, "--decrypt")
+	}
+	if err != nil && isGPGPassphraseError(err.Error()) {
+		return cipherText, &PassphraseError{fmt.Errorf("unable to read PGP message: %s", err)}
+	}
+	if err != nil && isGPGWrongKeyError(err.Error()) {
+		return cipherText, Errorf(ErrWrongKey, "unable to read PGP message: %s", err)
+	}
+	if err != nil {
+		return cipherText, fmt.Errorf("unable to read PGP message: %s", err)
+	}
+	return out, nil
+}
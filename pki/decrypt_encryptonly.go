@@ -0,0 +1,83 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+//go:build encryptonly
+// +build encryptonly
+
+package pki
+
+import (
+	"context"
+	"fmt"
+)
+
+// EncryptOnly is true in builds without decryption
+const EncryptOnly = true
+
+var errEncryptOnly = fmt.Errorf("decryption is not available in this encrypt only build")
+
+// DecryptSecret always fails, this build has no decryption
+func (p *Pki) DecryptSecret(cipherText string) (string, error) {
+	return cipherText, errEncryptOnly
+}
+
+// DecryptSecret always fails, this build has no decryption
+func (g *GPGBackend) DecryptSecret(cipherText string) (string, error) {
+	return cipherText, errEncryptOnly
+}
+
+// DecryptSecret always fails, this build has no decryption
+func (n *NaclBackend) DecryptSecret(cipherText string) (string, error) {
+	return cipherText, errEncryptOnly
+}
+
+// TokenPIN always fails, this build has no PKCS#11 decryption
+func TokenPIN(source string, slot string) ([]byte, error) {
+	return nil, errEncryptOnly
+}
+
+// keyUsed names the key a message is encrypted to from its packet headers, this build
+// can't read the message
+func (p *Pki) keyUsed(cipherText string) (string, error) {
+	return p.keyUsedFromHeaders(cipherText)
+}
+
+// FetchSecret always fails, this build reads no private keys or passphrases
+func FetchSecret(uri string) (string, error) {
+	return "", errEncryptOnly
+}
+
+// FetchSecretContext always fails, this build reads no private keys or passphrases
+func FetchSecretContext(ctx context.Context, uri string) (string, error) {
+	return "", errEncryptOnly
+}
+
+// UseSecretKey always fails, this build has no decryption
+func (p *Pki) UseSecretKey(armored string, passphrase []byte) error {
+	return errEncryptOnly
+}
+
+// Unlock fails for any passphrase, this build has no decryption
+func (p *Pki) Unlock(passphrase []byte) error {
+	if len(passphrase) == 0 {
+		return nil
+	}
+	return errEncryptOnly
+}
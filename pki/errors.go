@@ -0,0 +1,97 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package pki
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	pgperrors "github.com/keybase/go-crypto/openpgp/errors"
+)
+
+// failure classes of the errors returned by this package (sls has more), an error of a
+// class matches it with errors.Is, so callers don't have to match the error message
+var (
+	// ErrKeyNotFound is returned when no key in a key ring matches a key name, email, or ID
+	ErrKeyNotFound = errors.New("key not found")
+	// ErrWrongKey is returned when a value is not encrypted to a key that can decrypt it
+	ErrWrongKey = errors.New("not encrypted to an available key")
+	// ErrCorruptArmor is returned for an armored value that was damaged, e.g. by a merge conflict
+	ErrCorruptArmor = errors.New("corrupt armor")
+)
+
+// Error is an error of a failure class, such as ErrKeyNotFound, its message has the details
+type Error struct {
+	Class error
+	Msg   string
+}
+
+func (e *Error) Error() string {
+	return e.Msg
+}
+
+// Is returns true for the failure class of the error, for errors.Is
+func (e *Error) Is(target error) bool {
+	return target == e.Class
+}
+
+// Errorf returns an error of the given failure class
+func Errorf(class error, format string, args ...interface{}) error {
+	return &Error{Class: class, Msg: fmt.Sprintf(format, args...)}
+}
+
+// Wrapf returns an error with the formatted message that keeps the failure class of err, if it has one
+func Wrapf(err error, format string, args ...interface{}) error {
+	if class := ErrorClass(err); class != nil {
+		return Errorf(class, format, args...)
+	}
+	return fmt.Errorf(format, args...)
+}
+
+// readMessageError is the error for a message openpgp.ReadMessage can't read, ErrWrongKey
+// when none of the keys in the key ring can decrypt it
+func readMessageError(err error) error {
+	if err == pgperrors.ErrKeyIncorrect {
+		return Errorf(ErrWrongKey, "unable to read PGP message: %s", err)
+	}
+	return fmt.Errorf("unable to read PGP message: %s", err)
+}
+
+// gpgWrongKeyErrors are the messages gpg gives when it has none of the keys a value is encrypted to
+var gpgWrongKeyErrors = []string{"No secret key"}
+
+func isGPGWrongKeyError(msg string) bool {
+	for _, m := range gpgWrongKeyErrors {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrorClass returns the failure class of err, or nil if it doesn't have one
+func ErrorClass(err error) error {
+	if e, ok := err.(*Error); ok {
+		return e.Class
+	}
+	return nil
+}
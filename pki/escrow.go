@@ -0,0 +1,216 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package pki
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/keybase/go-crypto/openpgp"
+	"github.com/keybase/go-crypto/openpgp/armor"
+	"github.com/keybase/go-crypto/openpgp/packet"
+)
+
+// SetEscrow adds an escrow recipient (key name, email, ID, or fingerprint) that every value
+// is also encrypted to, so that secrets can be recovered if the main key is lost
+func (p *Pki) SetEscrow(name string) error {
+	if name == "" {
+		return nil
+	}
+
+	if g, ok := p.Backend.(*GPGBackend); ok {
+		ids, err := g.KeyIDs(name)
+		if err != nil {
+			return Errorf(ErrKeyNotFound, "unable to find escrow key '%s': %s", name, err)
+		}
+		g.Escrow = name
+		p.EscrowKeyIDs = ids
+		return nil
+	} else if p.Backend != nil {
+		return fmt.Errorf("the %s backend does not support an escrow key", p.Backend.Name())
+	}
+
+	if p.PubRing == nil {
+		return fmt.Errorf("no public key ring loaded to find escrow key '%s' in", name)
+	}
+	key := p.GetKeyByID(p.PubRing, name)
+	if key == nil {
+		key = keyByFingerprint(p.PubRing, name)
+	}
+	if key == nil {
+		return Errorf(ErrKeyNotFound, "unable to find escrow key '%s' in %s", name, p.PublicKeyRing)
+	}
+	p.EscrowKey = key
+	p.EscrowKeyIDs = entityKeyIDs(key)
+	return nil
+}
+
+// HasEscrow returns true if cipherText is encrypted to the escrow key
+func (p *Pki) HasEscrow(cipherText string) (bool, error) {
+	return encryptedToAny(cipherText, p.EscrowKeyIDs)
+}
+
+// RecipientKey is a public key that values must be encrypted to, e.g. a Salt master's key
+// checked by verify --against-pubkey without its private key
+type RecipientKey struct {
+	Fingerprint string
+	Identity    string
+	KeyIDs      []uint64
+}
+
+// ReadRecipientKeys reads the public keys in an armored or binary key file
+func ReadRecipientKeys(file string) ([]RecipientKey, error) {
+	data, err := ioutil.ReadFile(filepath.Clean(file))
+	if err != nil {
+		return nil, err
+	}
+	var ring openpgp.EntityList
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("-----BEGIN")) {
+		ring, err = openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+	} else {
+		ring, err = openpgp.ReadKeyRing(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: cannot read public keys: %s", file, err)
+	}
+	if len(ring) == 0 {
+		return nil, fmt.Errorf("%s: no public keys found", file)
+	}
+
+	keys := make([]RecipientKey, 0, len(ring))
+	for _, entity := range ring {
+		key := RecipientKey{Fingerprint: fmt.Sprintf("%X", entity.PrimaryKey.Fingerprint), KeyIDs: entityKeyIDs(entity)}
+		names := make([]string, 0, len(entity.Identities))
+		for name := range entity.Identities {
+			names = append(names, name)
+		}
+		if len(names) > 0 {
+			sort.Strings(names)
+			key.Identity = names[0]
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// EncryptedTo returns true if cipherText is encrypted to the key or one of its subkeys
+func (r RecipientKey) EncryptedTo(cipherText string) (bool, error) {
+	return encryptedToAny(cipherText, r.KeyIDs)
+}
+
+func encryptedToAny(cipherText string, keyIDs []uint64) (bool, error) {
+	ids, err := EncryptedToKeyIDs(cipherText)
+	if err != nil {
+		return false, err
+	}
+	for _, id := range ids {
+		for _, keyID := range keyIDs {
+			if id == keyID {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// EncryptedToKeyIDs returns the IDs of the keys an armored message is encrypted to,
+// no private key is needed to read them
+func EncryptedToKeyIDs(cipherText string) ([]uint64, error) {
+	var ids []uint64
+
+	block, err := armor.Decode(strings.NewReader(strings.TrimSpace(cipherText)))
+	if err != nil {
+		return ids, fmt.Errorf("unable to read PGP message: %s", err)
+	}
+	if block.Type != "PGP MESSAGE" {
+		return ids, fmt.Errorf("not a PGP message: '%s'", block.Type)
+	}
+
+	packets := packet.NewReader(block.Body)
+	for {
+		pkt, err := packets.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return ids, fmt.Errorf("unable to read PGP message: %s", err)
+		}
+		key, ok := pkt.(*packet.EncryptedKey)
+		if !ok {
+			// the encrypted data follows the keys
+			break
+		}
+		ids = append(ids, key.KeyId)
+	}
+	if len(ids) == 0 {
+		return ids, fmt.Errorf("PGP message is not encrypted to a public key")
+	}
+
+	return ids, nil
+}
+
+// KeyIDs returns the IDs of a key and its subkeys
+func (g *GPGBackend) KeyIDs(name string) ([]uint64, error) {
+	var ids []uint64
+
+	out, err := g.run("", "--batch", "--with-colons", "--list-keys", name)
+	if err != nil {
+		return ids, err
+	}
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if (fields[0] == "pub" || fields[0] == "sub") && len(fields) > 4 {
+			id, err := strconv.ParseUint(fields[4], 16, 64)
+			if err == nil {
+				ids = append(ids, id)
+			}
+		}
+	}
+	if len(ids) == 0 {
+		return ids, fmt.Errorf("no keys found")
+	}
+	return ids, scanner.Err()
+}
+
+func keyByFingerprint(keyring *openpgp.EntityList, fingerprint string) *openpgp.Entity {
+	fingerprint = strings.ToUpper(strings.Replace(strings.TrimPrefix(fingerprint, "0x"), " ", "", -1))
+	for _, entity := range *keyring {
+		if entity.PrimaryKey != nil && fmt.Sprintf("%X", entity.PrimaryKey.Fingerprint) == fingerprint {
+			return entity
+		}
+	}
+	return nil
+}
+
+func entityKeyIDs(entity *openpgp.Entity) []uint64 {
+	ids := []uint64{entity.PrimaryKey.KeyId}
+	for _, subkey := range entity.Subkeys {
+		ids = append(ids, subkey.PublicKey.KeyId)
+	}
+	return ids
+}
@@ -0,0 +1,280 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package pki
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/Everbridge/generate-secure-pillar/metrics"
+)
+
+// GPGBackendName selects the system gpg binary backend
+const GPGBackendName = "gpg"
+
+// PGPBackendName selects the built in (pure Go) OpenPGP backend
+const PGPBackendName = "pgp"
+
+// PKCS11BackendName selects the built in OpenPGP backend decrypting with a key on a PKCS#11 token
+const PKCS11BackendName = "pkcs11"
+
+// gpgCipherNames maps profile cipher names to gpg --cipher-algo names
+var gpgCipherNames = map[string]string{
+	"aes128": "AES",
+	"aes192": "AES192",
+	"aes256": "AES256",
+	"cast5":  "CAST5",
+}
+
+// Backend performs value encryption and decryption in place of the built in OpenPGP code
+type Backend interface {
+	Name() string
+	EncryptSecret(plainText string) (string, error)
+	DecryptSecret(cipherText string) (string, error)
+	KeyInfo(cipherText string) (string, error)
+}
+
+// GPGBackend shells out to the system gpg binary so that gpg.conf,
+// gpg-agent, smartcards, and keyboxd are all respected
+type GPGBackend struct {
+	Binary     string
+	HomeDir    string
+	Recipient  string
+	Escrow     string
+	Cipher     string
+	Passphrase []byte
+	ctx        context.Context
+}
+
+// NewGPG returns a pki object that uses the system gpg binary
+func NewGPG(pgpKeyName string, gpgHome string) Pki {
+	logger.Out = logOutput
+	logger.ExitFunc = metrics.Exit
+
+	g, err := NewGPGBackend(pgpKeyName, gpgHome)
+	if err != nil {
+		logger.Fatalf("Pki: %s", err)
+	}
+
+	p := Pki{PgpKeyName: pgpKeyName, Backend: g}
+	dumper(p)
+
+	return p
+}
+
+// NewGPGBackend finds the gpg binary to use, GSP_GPG_BINARY overrides the search
+func NewGPGBackend(recipient string, gpgHome string) (*GPGBackend, error) {
+	binary := os.Getenv("GSP_GPG_BINARY")
+	if binary == "" {
+		for _, name := range []string{"gpg2", "gpg"} {
+			if path, err := exec.LookPath(name); err == nil {
+				binary = path
+				break
+			}
+		}
+	}
+	if binary == "" {
+		return nil, fmt.Errorf("cannot find a gpg binary in PATH")
+	}
+
+	return &GPGBackend{Binary: binary, HomeDir: gpgHome, Recipient: recipient}, nil
+}
+
+// Name returns the backend name
+func (g *GPGBackend) Name() string {
+	return GPGBackendName
+}
+
+// EncryptSecret returns encrypted plainText
+func (g *GPGBackend) EncryptSecret(plainText string) (string, error) {
+	if g.Recipient == "" {
+		return plainText, fmt.Errorf("no PGP key given to encrypt with")
+	}
+	args := []string{"--batch", "--armor", "--encrypt", "--recipient", g.Recipient}
+	if g.Escrow != "" {
+		args = append(args, "--recipient", g.Escrow)
+	}
+	if g.Cipher != "" {
+		args = append(args, "--cipher-algo", g.Cipher)
+	}
+	out, err := g.run(plainText, args...)
+	if err != nil {
+		return plainText, fmt.Errorf("encryption error: %s", err)
+	}
+	return out, nil
+}
+
+// KeyInfo returns the key ID and identity used to encrypt cipherText
+func (g *GPGBackend) KeyInfo(cipherText string) (string, error) {
+	packets, err := g.run(cipherText, "--batch", "--list-only", "--list-packets")
+	if err != nil {
+		return "", fmt.Errorf("unable to read PGP message: %s", err)
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(packets))
+	for scanner.Scan() {
+		txt := scanner.Text()
+		if !strings.HasPrefix(txt, ":pubkey enc packet:") {
+			continue
+		}
+		idx := strings.Index(txt, "keyid ")
+		if idx < 0 {
+			continue
+		}
+		keyID := strings.ToUpper(strings.Fields(txt[idx+len("keyid "):])[0])
+		if uid := g.uidForKey(keyID); uid != "" {
+			return fmt.Sprintf("%s: %s\n", keyID, uid), nil
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return "", err
+	}
+
+	return "", Errorf(ErrKeyNotFound, "unable to find key for ids used")
+}
+
+// Fingerprint returns the fingerprint of the recipient key
+func (g *GPGBackend) Fingerprint() (string, error) {
+	out, err := g.run("", "--batch", "--with-colons", "--fingerprint", "--list-keys", g.Recipient)
+	if err != nil {
+		return "", err
+	}
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if fields[0] == "fpr" && len(fields) > 9 {
+			return fields[9], nil
+		}
+	}
+	return "", Errorf(ErrKeyNotFound, "unable to find a fingerprint for '%s'", g.Recipient)
+}
+
+// KeyListing is a key known to gpg
+type KeyListing struct {
+	Fingerprint string
+	UID         string
+}
+
+// SecretKeys returns the secret keys gpg has access to
+func (g *GPGBackend) SecretKeys() ([]KeyListing, error) {
+	var keys []KeyListing
+
+	out, err := g.run("", "--batch", "--with-colons", "--fingerprint", "--list-secret-keys")
+	if err != nil {
+		return keys, err
+	}
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) < 10 {
+			continue
+		}
+		switch fields[0] {
+		case "sec":
+			keys = append(keys, KeyListing{})
+		case "fpr":
+			if len(keys) > 0 && keys[len(keys)-1].Fingerprint == "" {
+				keys[len(keys)-1].Fingerprint = fields[9]
+			}
+		case "uid":
+			if len(keys) > 0 && keys[len(keys)-1].UID == "" {
+				keys[len(keys)-1].UID = fields[9]
+			}
+		}
+	}
+
+	return keys, scanner.Err()
+}
+
+func (g *GPGBackend) uidForKey(keyID string) string {
+	out, err := g.run("", "--batch", "--with-colons", "--list-keys", keyID)
+	if err != nil {
+		return ""
+	}
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if fields[0] == "uid" && len(fields) > 9 {
+			return fields[9]
+		}
+	}
+	return ""
+}
+
+// runWithPassphrase passes the passphrase on a pipe (fd 3) so it isn't visible in the process list
+func (g *GPGBackend) runWithPassphrase(stdin string, args ...string) (string, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	go func() {
+		_, _ = w.Write(append(g.Passphrase, '\n'))
+		w.Close()
+	}()
+	return g.runFiles(stdin, []*os.File{r}, args...)
+}
+
+// SetContext kills the gpg binary when ctx is done
+func (g *GPGBackend) SetContext(ctx context.Context) {
+	g.ctx = ctx
+}
+
+func (g *GPGBackend) run(stdin string, args ...string) (string, error) {
+	return g.runFiles(stdin, nil, args...)
+}
+
+func (g *GPGBackend) runFiles(stdin string, files []*os.File, args ...string) (string, error) {
+	base := []string{"--quiet", "--yes"}
+	if g.HomeDir != "" {
+		home, err := expandTilde(g.HomeDir)
+		if err != nil {
+			return "", err
+		}
+		base = append(base, "--homedir", home)
+	}
+
+	var stdout, stderr bytes.Buffer
+	ctx := orBackground(g.ctx)
+	cmd := exec.CommandContext(ctx, g.Binary, append(base, args...)...)
+	cmd.Stdin = strings.NewReader(stdin)
+	cmd.ExtraFiles = files
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return "", fmt.Errorf("%s", msg)
+	}
+
+	return stdout.String(), nil
+}
@@ -0,0 +1,92 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package pki
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// SecurityCLI (macOS Keychain) and SecretToolCLI (libsecret, the freedesktop Secret Service
+// used by GNOME Keyring and KWallet) read and store keychain:// secrets
+var SecurityCLI = "security"
+var SecretToolCLI = "secret-tool"
+
+// KeychainService is the service name passphrases are stored under by 'config store-passphrase'
+const KeychainService = "generate-secure-pillar"
+
+const keychainSource = "keychain://"
+
+// KeychainURI returns the keychain:// secret source for a service and account
+func KeychainURI(service string, account string) string {
+	return keychainSource + service + "/" + account
+}
+
+// splitKeychainURI splits keychain://<service>/<account>
+func splitKeychainURI(uri string) (string, string, error) {
+	parts := strings.SplitN(strings.TrimPrefix(uri, keychainSource), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("%s: expected %s<service>/<account>", uri, keychainSource)
+	}
+	return parts[0], parts[1], nil
+}
+
+// StoreKeychain stores a secret in the macOS Keychain or the Secret Service, replacing any
+// secret already stored for the service and account, the secret is passed on stdin and
+// never as an argument, where other users could see it
+func StoreKeychain(uri string, secret []byte) error {
+	service, account, err := splitKeychainURI(uri)
+	if err != nil {
+		return err
+	}
+	var stdin bytes.Buffer
+	var cmd *exec.Cmd
+	if runtime.GOOS == "darwin" {
+		// security reads commands from stdin with -i
+		quote := func(s string) string {
+			return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+		}
+		fmt.Fprintf(&stdin, "add-generic-password -U -s %s -a %s -w %s\n", quote(service), quote(account), quote(string(secret)))
+		cmd = exec.Command(SecurityCLI, "-i")
+	} else {
+		stdin.Write(secret)
+		cmd = exec.Command(SecretToolCLI, "store", "--label", fmt.Sprintf("%s (%s)", service, account), "service", service, "account", account)
+	}
+	var stderr bytes.Buffer
+	input := stdin.Bytes()
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	for i := range input {
+		input[i] = 0
+	}
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return fmt.Errorf("%s: %s", uri, msg)
+	}
+	return nil
+}
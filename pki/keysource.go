@@ -0,0 +1,83 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package pki
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// AWSCLI and GCloudCLI are the commands used to read from the cloud secret stores,
+// they use the usual credential chains (instance roles, workload identity, env vars)
+var AWSCLI = "aws"
+var GCloudCLI = "gcloud"
+
+// secret source URI schemes
+const (
+	awsSecretsManager = "aws-sm://"
+	awsSSM            = "aws-ssm://"
+	gcpSecretManager  = "gcp-sm://"
+	envSource         = "env://"
+	fileSource        = "file://"
+)
+
+// SecretSources lists the supported secret source URI forms
+var SecretSources = []string{
+	awsSecretsManager + "<secret id or ARN>",
+	awsSSM + "<parameter name>",
+	gcpSecretManager + "projects/<project>/secrets/<secret>[/versions/<version>]",
+	envSource + "<variable>",
+	fileSource + "<path>",
+	keychainSource + "<service>/<account>",
+}
+
+// PromptSecret reads a passphrase or PIN from the terminal without echoing it
+func PromptSecret(label string) ([]byte, error) {
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		return nil, fmt.Errorf("no terminal to read the %s from: %s", label, err)
+	}
+	defer tty.Close()
+
+	stty := func(arg string) error {
+		cmd := exec.Command("stty", arg)
+		cmd.Stdin = tty
+		return cmd.Run()
+	}
+	if err = stty("-echo"); err != nil {
+		return nil, fmt.Errorf("unable to turn off echo to read the %s: %s", label, err)
+	}
+	defer func() {
+		_ = stty("echo")
+		fmt.Fprintln(os.Stderr)
+	}()
+
+	fmt.Fprintf(os.Stderr, "%s: ", label)
+	line, err := bufio.NewReader(tty).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	return []byte(strings.TrimRight(line, "\r\n")), nil
+}
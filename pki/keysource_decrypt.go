@@ -0,0 +1,201 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+//go:build !encryptonly
+// +build !encryptonly
+
+package pki
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/keybase/go-crypto/openpgp"
+)
+
+// reading private keys and passphrases is left out of encrypt only builds (-tags encryptonly),
+// see decrypt_encryptonly.go
+
+// FetchSecret reads a secret (an armored private key or a passphrase) from a secret store,
+// so that it never has to be written to disk
+func FetchSecret(uri string) (string, error) {
+	return FetchSecretContext(context.Background(), uri)
+}
+
+// FetchSecretContext is FetchSecret, killing the secret store's CLI when ctx is done
+func FetchSecretContext(ctx context.Context, uri string) (string, error) {
+	var out string
+	var err error
+
+	switch {
+	case strings.HasPrefix(uri, awsSecretsManager):
+		out, err = runSecretCLI(ctx, AWSCLI, "secretsmanager", "get-secret-value",
+			"--secret-id", strings.TrimPrefix(uri, awsSecretsManager), "--query", "SecretString", "--output", "text")
+	case strings.HasPrefix(uri, awsSSM):
+		out, err = runSecretCLI(ctx, AWSCLI, "ssm", "get-parameter", "--with-decryption",
+			"--name", strings.TrimPrefix(uri, awsSSM), "--query", "Parameter.Value", "--output", "text")
+	case strings.HasPrefix(uri, gcpSecretManager):
+		var args []string
+		args, err = gcpSecretArgs(strings.TrimPrefix(uri, gcpSecretManager))
+		if err == nil {
+			out, err = runSecretCLI(ctx, GCloudCLI, args...)
+		}
+	case strings.HasPrefix(uri, keychainSource):
+		out, err = fetchKeychain(ctx, uri)
+	case strings.HasPrefix(uri, envSource):
+		name := strings.TrimPrefix(uri, envSource)
+		var ok bool
+		if out, ok = os.LookupEnv(name); !ok {
+			return "", fmt.Errorf("%s: %s is not set", uri, name)
+		}
+	case strings.HasPrefix(uri, fileSource):
+		var data []byte
+		data, err = ioutil.ReadFile(filepath.Clean(strings.TrimPrefix(uri, fileSource)))
+		out = string(data)
+	default:
+		return "", fmt.Errorf("unknown secret source '%s', expected one of: %s", uri, strings.Join(SecretSources, ", "))
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s: %s", uri, err)
+	}
+
+	// the CLIs add a trailing newline (as may env vars read from files), armored keys don't need it and passphrases must not have it
+	return strings.TrimRight(out, "\r\n"), nil
+}
+
+// gcpSecretArgs turns projects/<project>/secrets/<secret>[/versions/<version>] into gcloud arguments
+func gcpSecretArgs(name string) ([]string, error) {
+	parts := strings.Split(name, "/")
+	if (len(parts) != 4 && len(parts) != 6) || parts[0] != "projects" || parts[2] != "secrets" || (len(parts) == 6 && parts[4] != "versions") {
+		return nil, fmt.Errorf("expected projects/<project>/secrets/<secret>[/versions/<version>]")
+	}
+	version := "latest"
+	if len(parts) == 6 {
+		version = parts[5]
+	}
+	return []string{"secrets", "versions", "access", version, "--secret", parts[3], "--project", parts[1]}, nil
+}
+
+func runSecretCLI(ctx context.Context, binary string, args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, binary, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return "", fmt.Errorf("%s", msg)
+	}
+
+	return stdout.String(), nil
+}
+
+// fetchKeychain reads a secret from the macOS Keychain or the Secret Service
+func fetchKeychain(ctx context.Context, uri string) (string, error) {
+	service, account, err := splitKeychainURI(uri)
+	if err != nil {
+		return "", err
+	}
+	if runtime.GOOS == "darwin" {
+		return runSecretCLI(ctx, SecurityCLI, "find-generic-password", "-s", service, "-a", account, "-w")
+	}
+	out, err := runSecretCLI(ctx, SecretToolCLI, "lookup", "service", service, "account", account)
+	if err == nil && out == "" {
+		// secret-tool exits 0 without output when there is no such secret
+		err = fmt.Errorf("no secret for service '%s' and account '%s'", service, account)
+	}
+	return out, err
+}
+
+// UseSecretKey decrypts with the given armored private key in place of the secring,
+// the key is only kept in memory
+func (p *Pki) UseSecretKey(armored string, passphrase []byte) error {
+	if p.Backend != nil {
+		return fmt.Errorf("a secret key can't be given to the %s backend, import it into its keyring instead", p.Backend.Name())
+	}
+	ring, err := openpgp.ReadArmoredKeyRing(strings.NewReader(armored))
+	if err != nil {
+		return fmt.Errorf("cannot read private key: %s", err)
+	}
+	p.SecRing = &ring
+	if err = p.Unlock(passphrase); err != nil {
+		return err
+	}
+	p.SecretKey = p.GetKeyByID(p.SecRing, p.PgpKeyName)
+	if p.SecretKey == nil && len(ring) == 1 {
+		p.SecretKey = ring[0]
+	}
+	if p.SecretKey == nil || p.SecretKey.PrivateKey == nil {
+		return fmt.Errorf("no private key for '%s' in the given key", p.PgpKeyName)
+	}
+	if p.PubRing == nil {
+		// without a pubring (see New) values are encrypted to the given key
+		p.PubRing = &ring
+		p.PublicKey = p.SecretKey
+	}
+	if p.SecretKey.PrivateKey.Encrypted {
+		return &PassphraseError{fmt.Errorf("the private key for '%s' is protected by a passphrase", p.PgpKeyName)}
+	}
+
+	return nil
+}
+
+// Unlock decrypts passphrase protected private keys, it does nothing for an empty passphrase
+func (p *Pki) Unlock(passphrase []byte) error {
+	if len(passphrase) == 0 {
+		return nil
+	}
+	if g, ok := p.Backend.(*GPGBackend); ok {
+		g.Passphrase = passphrase
+		return nil
+	}
+	if p.SecRing == nil {
+		return fmt.Errorf("no secring set")
+	}
+	for _, entity := range *p.SecRing {
+		if entity.PrivateKey != nil && entity.PrivateKey.Encrypted {
+			if err := entity.PrivateKey.Decrypt(passphrase); err != nil {
+				return &PassphraseError{fmt.Errorf("unable to unlock private key: %s", err)}
+			}
+		}
+		for _, subkey := range entity.Subkeys {
+			if subkey.PrivateKey != nil && subkey.PrivateKey.Encrypted {
+				if err := subkey.PrivateKey.Decrypt(passphrase); err != nil {
+					return &PassphraseError{fmt.Errorf("unable to unlock private key: %s", err)}
+				}
+			}
+		}
+	}
+
+	return nil
+}
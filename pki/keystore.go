@@ -0,0 +1,120 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package pki
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/keybase/go-crypto/openpgp"
+)
+
+// Keystore keeps the keyrings that have been read, so that a process that needs the keys
+// more than once (the shell, rotating between backends, provenance) reads each keyring
+// once, a keyring is read again when its file changes (e.g. a key is imported while the
+// shell is open)
+type Keystore struct {
+	mu    sync.Mutex
+	rings map[string]cachedKeyRing
+}
+
+type cachedKeyRing struct {
+	modTime time.Time
+	size    int64
+	ring    *openpgp.EntityList
+}
+
+// Keys is the keystore New reads keyrings from
+var Keys = NewKeystore()
+
+// NewKeystore returns an empty keystore
+func NewKeystore() *Keystore {
+	return &Keystore{rings: map[string]cachedKeyRing{}}
+}
+
+// KeyRing returns the keyring in a file, it is read when it isn't cached or has changed since
+func (k *Keystore) KeyRing(file string) (*openpgp.EntityList, error) {
+	file = filepath.Clean(file)
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open key ring: %s", err)
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if cached, ok := k.rings[file]; ok && !cached.changed(info) {
+		return cached.ring, nil
+	}
+	ring, err := readKeyRing(file)
+	if err != nil {
+		return nil, err
+	}
+	k.rings[file] = cachedKeyRing{modTime: info.ModTime(), size: info.Size(), ring: ring}
+	return ring, nil
+}
+
+// Changed returns true when the file of a cached keyring has changed (or is gone) since it was read
+func (k *Keystore) Changed() bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for file, cached := range k.rings {
+		info, err := os.Stat(file)
+		if err != nil || cached.changed(info) {
+			return true
+		}
+	}
+	return false
+}
+
+// Forget drops the cached keyrings, they are read again when next used
+func (k *Keystore) Forget() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.rings = map[string]cachedKeyRing{}
+}
+
+func (c cachedKeyRing) changed(info os.FileInfo) bool {
+	return !info.ModTime().Equal(c.modTime) || info.Size() != c.size
+}
+
+// readKeyRing reads a binary keyring file
+func readKeyRing(file string) (*openpgp.EntityList, error) {
+	keyRingFile, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open key ring: %s", err)
+	}
+	ring, err := openpgp.ReadKeyRing(keyRingFile)
+	if err != nil {
+		_ = keyRingFile.Close()
+		return nil, fmt.Errorf("cannot read private keys: %s", err)
+	} else if ring == nil {
+		_ = keyRingFile.Close()
+		return nil, fmt.Errorf("%s is empty", file)
+	}
+	if err = keyRingFile.Close(); err != nil {
+		return &ring, fmt.Errorf("error closing secring: %s", err)
+	}
+
+	return &ring, nil
+}
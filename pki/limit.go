@@ -0,0 +1,239 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package pki
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// Limits control how often a remote backend is called
+type Limits struct {
+	// Concurrency is the most requests in flight at once (0 for no limit)
+	Concurrency int
+	// Retries is how many times a failed request is retried
+	Retries int
+	// Backoff is the delay before the first retry, it doubles for each retry after that
+	Backoff time.Duration
+	// MaxBackoff caps the delay between retries
+	MaxBackoff time.Duration
+	// BatchSize is the most values sent in one request to a backend that supports batches
+	BatchSize int
+}
+
+// DefaultLimits are used for backends that call a remote service
+var DefaultLimits = Limits{Retries: 3, Backoff: 500 * time.Millisecond, MaxBackoff: 30 * time.Second, BatchSize: 25}
+
+// BatchBackend is a Backend that can encrypt or decrypt several values in one request
+type BatchBackend interface {
+	Backend
+	EncryptBatch(plainTexts []string) ([]string, error)
+	DecryptBatch(cipherTexts []string) ([]string, error)
+}
+
+// RemoteBackend is a Backend that calls a remote service (e.g. KMS or Vault),
+// only remote backends are rate limited
+type RemoteBackend interface {
+	Backend
+	Remote() bool
+}
+
+// RetryableError marks an error (e.g. throttling or a timeout) as worth retrying
+type RetryableError struct {
+	Err error
+}
+
+func (e *RetryableError) Error() string {
+	return e.Err.Error()
+}
+
+// isRetryable returns true for a *RetryableError, or an error with a Temporary() method that returns true
+func isRetryable(err error) bool {
+	switch e := err.(type) {
+	case *RetryableError:
+		return true
+	case interface{ Temporary() bool }:
+		return e.Temporary()
+	}
+	return false
+}
+
+// LimitedBackend wraps a Backend with a concurrency limit, retries with
+// exponential backoff, and batching
+type LimitedBackend struct {
+	Backend Backend
+	Limits  Limits
+	slots   chan struct{}
+	sleep   func(time.Duration)
+	ctx     context.Context
+}
+
+// NewLimitedBackend returns a Backend that applies the given limits to b
+func NewLimitedBackend(b Backend, l Limits) *LimitedBackend {
+	lb := &LimitedBackend{Backend: b, Limits: l, sleep: time.Sleep}
+	if l.Concurrency > 0 {
+		lb.slots = make(chan struct{}, l.Concurrency)
+	}
+	return lb
+}
+
+// SetLimits applies limits to a remote backend, the built in and gpg backends run locally and are not limited
+func (p *Pki) SetLimits(l Limits) {
+	if rb, ok := p.Backend.(RemoteBackend); ok && rb.Remote() {
+		p.Backend = NewLimitedBackend(rb, l)
+	}
+}
+
+// SetContext stops waiting for a slot or to retry when ctx is done, and passes it on to the wrapped backend
+func (lb *LimitedBackend) SetContext(ctx context.Context) {
+	lb.ctx = ctx
+	if cb, ok := lb.Backend.(ContextBackend); ok {
+		cb.SetContext(ctx)
+	}
+}
+
+// Name returns the name of the wrapped backend
+func (lb *LimitedBackend) Name() string {
+	return lb.Backend.Name()
+}
+
+// EncryptSecret encrypts a value, within the limits
+func (lb *LimitedBackend) EncryptSecret(plainText string) (string, error) {
+	var out string
+	err := lb.call(func() (err error) {
+		out, err = lb.Backend.EncryptSecret(plainText)
+		return err
+	})
+	return out, err
+}
+
+// DecryptSecret decrypts a value, within the limits
+func (lb *LimitedBackend) DecryptSecret(cipherText string) (string, error) {
+	var out string
+	err := lb.call(func() (err error) {
+		out, err = lb.Backend.DecryptSecret(cipherText)
+		return err
+	})
+	return out, err
+}
+
+// KeyInfo returns the key used for a value, within the limits
+func (lb *LimitedBackend) KeyInfo(cipherText string) (string, error) {
+	var out string
+	err := lb.call(func() (err error) {
+		out, err = lb.Backend.KeyInfo(cipherText)
+		return err
+	})
+	return out, err
+}
+
+// EncryptBatch encrypts values in batches of BatchSize if the backend supports batches,
+// otherwise one at a time
+func (lb *LimitedBackend) EncryptBatch(plainTexts []string) ([]string, error) {
+	return lb.batch(plainTexts, lb.EncryptSecret, func(bb BatchBackend, vals []string) ([]string, error) {
+		return bb.EncryptBatch(vals)
+	})
+}
+
+// DecryptBatch decrypts values in batches of BatchSize if the backend supports batches,
+// otherwise one at a time
+func (lb *LimitedBackend) DecryptBatch(cipherTexts []string) ([]string, error) {
+	return lb.batch(cipherTexts, lb.DecryptSecret, func(bb BatchBackend, vals []string) ([]string, error) {
+		return bb.DecryptBatch(vals)
+	})
+}
+
+func (lb *LimitedBackend) batch(vals []string, single func(string) (string, error), multi func(BatchBackend, []string) ([]string, error)) ([]string, error) {
+	var results []string
+
+	bb, ok := lb.Backend.(BatchBackend)
+	if !ok {
+		for _, val := range vals {
+			res, err := single(val)
+			if err != nil {
+				return results, err
+			}
+			results = append(results, res)
+		}
+		return results, nil
+	}
+
+	size := lb.Limits.BatchSize
+	if size <= 0 {
+		size = len(vals)
+	}
+	for start := 0; start < len(vals); start += size {
+		end := start + size
+		if end > len(vals) {
+			end = len(vals)
+		}
+		var res []string
+		err := lb.call(func() (err error) {
+			res, err = multi(bb, vals[start:end])
+			return err
+		})
+		if err != nil {
+			return results, err
+		}
+		if len(res) != end-start {
+			return results, fmt.Errorf("%s: batch of %d values returned %d results", lb.Name(), end-start, len(res))
+		}
+		results = append(results, res...)
+	}
+	return results, nil
+}
+
+// call runs a request when a slot is free, retrying retryable errors with exponential backoff
+func (lb *LimitedBackend) call(request func() error) error {
+	ctx := orBackground(lb.ctx)
+	if lb.slots != nil {
+		select {
+		case lb.slots <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		defer func() { <-lb.slots }()
+	}
+
+	delay := lb.Limits.Backoff
+	for attempt := 0; ; attempt++ {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		err := request()
+		if err == nil || !isRetryable(err) || attempt >= lb.Limits.Retries {
+			return err
+		}
+		logger.Debugf("%s: retrying in %s: %s", lb.Name(), delay, err)
+		// up to 20% jitter so that concurrent workers don't retry in step
+		jitter := time.Duration(0)
+		if delay > 0 {
+			jitter = time.Duration(rand.Int63n(int64(delay)/5 + 1))
+		}
+		lb.sleep(delay + jitter)
+		delay *= 2
+		if lb.Limits.MaxBackoff > 0 && delay > lb.Limits.MaxBackoff {
+			delay = lb.Limits.MaxBackoff
+		}
+	}
+}
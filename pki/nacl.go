@@ -0,0 +1,283 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package pki
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Everbridge/generate-secure-pillar/metrics"
+	"github.com/keybase/go-crypto/curve25519"
+	"github.com/keybase/go-crypto/nacl/box"
+	"github.com/keybase/go-crypto/nacl/secretbox"
+)
+
+// NaclBackendName is the backend for values read by Salt's nacl renderer
+const NaclBackendName = "nacl"
+
+// NaclSealedBox encrypts to the public key, only the secret key can decrypt (Salt's default)
+const NaclSealedBox = "sealedbox"
+
+// NaclSecretBox encrypts and decrypts with the secret key
+const NaclSecretBox = "secretbox"
+
+// NaclPrefix starts a value for Salt's nacl renderer, NACL[<base64>]
+const NaclPrefix = "NACL["
+
+// DefaultNaclSecretKeyFile is where salt-run nacl.keygen writes the master's secret key
+const DefaultNaclSecretKeyFile = "/etc/salt/pki/master/nacl"
+
+// DefaultNaclPublicKeyFile is where salt-run nacl.keygen writes the master's public key
+const DefaultNaclPublicKeyFile = "/etc/salt/pki/master/nacl.pub"
+
+// NaclBackend encrypts values the way salt.modules.nacl does, with base64 encoded
+// keys in the files written by 'salt-run nacl.keygen'
+type NaclBackend struct {
+	PublicKey *[32]byte
+	SecretKey *[32]byte
+	BoxType   string
+}
+
+// IsNaclValue reports whether a value was encrypted for Salt's nacl renderer
+func IsNaclValue(str string) bool {
+	str = strings.TrimSpace(str)
+	return strings.HasPrefix(str, NaclPrefix) && strings.HasSuffix(str, "]")
+}
+
+// NewNacl returns a pki object that encrypts values for Salt's nacl renderer
+func NewNacl(publicKeyFile string, secretKeyFile string, boxType string) Pki {
+	logger.Out = logOutput
+	logger.ExitFunc = metrics.Exit
+
+	n, err := NewNaclBackend(publicKeyFile, secretKeyFile, boxType)
+	if err != nil {
+		logger.Fatalf("Pki: %s", err)
+	}
+
+	p := Pki{Backend: n}
+	dumper(p)
+
+	return p
+}
+
+// NewNaclBackend reads the key files, the public key is derived from the secret key when
+// there is no public key file, and a missing secret key file only prevents decryption
+func NewNaclBackend(publicKeyFile string, secretKeyFile string, boxType string) (*NaclBackend, error) {
+	n := &NaclBackend{BoxType: boxType}
+	if n.BoxType == "" {
+		n.BoxType = NaclSealedBox
+	}
+	if n.BoxType != NaclSealedBox && n.BoxType != NaclSecretBox {
+		return nil, fmt.Errorf("unknown nacl box type '%s', expected %s or %s", boxType, NaclSealedBox, NaclSecretBox)
+	}
+
+	var err error
+	if secretKeyFile != "" {
+		if n.SecretKey, err = readNaclKey(secretKeyFile); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	if publicKeyFile != "" {
+		if n.PublicKey, err = readNaclKey(publicKeyFile); err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	if n.PublicKey == nil && n.SecretKey != nil {
+		n.PublicKey = new([32]byte)
+		curve25519.ScalarBaseMult(n.PublicKey, n.SecretKey)
+	}
+
+	switch {
+	case n.BoxType == NaclSecretBox && n.SecretKey == nil:
+		return nil, fmt.Errorf("the nacl secret key (%s) is needed for %s values", secretKeyFile, NaclSecretBox)
+	case n.PublicKey == nil:
+		return nil, fmt.Errorf("unable to read a nacl public key (%s) or secret key (%s)", publicKeyFile, secretKeyFile)
+	}
+
+	return n, nil
+}
+
+func readNaclKey(keyFile string) (*[32]byte, error) {
+	path, err := expandTilde(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(filepath.Clean(path))
+	if err != nil {
+		return nil, err
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a base64 encoded nacl key: %s", keyFile, err)
+	}
+	if len(raw) != 32 {
+		return nil, fmt.Errorf("%s is not a nacl key: %d bytes instead of 32", keyFile, len(raw))
+	}
+	key := new([32]byte)
+	copy(key[:], raw)
+	return key, nil
+}
+
+// Name returns the backend name
+func (n *NaclBackend) Name() string {
+	return NaclBackendName
+}
+
+// EncryptSecret returns plainText encrypted as a NACL[...] value
+func (n *NaclBackend) EncryptSecret(plainText string) (string, error) {
+	var sealed []byte
+	switch n.BoxType {
+	case NaclSecretBox:
+		var nonce [24]byte
+		if _, err := rand.Read(nonce[:]); err != nil {
+			return plainText, fmt.Errorf("encryption error: %s", err)
+		}
+		sealed = secretbox.Seal(nonce[:], []byte(plainText), &nonce, n.SecretKey)
+	default:
+		// a sealed box is an ephemeral public key followed by a box from that key,
+		// the nonce is the BLAKE2b hash of both public keys (crypto_box_seal)
+		ephemeralPublic, ephemeralSecret, err := box.GenerateKey(rand.Reader)
+		if err != nil {
+			return plainText, fmt.Errorf("encryption error: %s", err)
+		}
+		nonce := sealNonce(ephemeralPublic, n.PublicKey)
+		sealed = box.Seal(ephemeralPublic[:], []byte(plainText), &nonce, n.PublicKey, ephemeralSecret)
+	}
+
+	return NaclPrefix + base64.StdEncoding.EncodeToString(sealed) + "]", nil
+}
+
+// KeyInfo returns an ID for the configured key, nacl values don't say which key they are for
+func (n *NaclBackend) KeyInfo(cipherText string) (string, error) {
+	if !IsNaclValue(cipherText) {
+		return "", fmt.Errorf("not a nacl value")
+	}
+	key := n.PublicKey
+	if n.BoxType == NaclSecretBox {
+		key = n.SecretKey
+	}
+	sum := sha256.Sum256(key[:])
+	return fmt.Sprintf("%X: nacl %s key", sum[:8], n.BoxType), nil
+}
+
+// sealNonce returns the nonce crypto_box_seal uses, BLAKE2b-192 of both public keys
+func sealNonce(ephemeralPublic *[32]byte, publicKey *[32]byte) [24]byte {
+	var nonce [24]byte
+	sum := blake2b(append(ephemeralPublic[:], publicKey[:]...), len(nonce))
+	copy(nonce[:], sum)
+	return nonce
+}
+
+var blake2bIV = [8]uint64{
+	0x6a09e667f3bcc908, 0xbb67ae8584caa73b, 0x3c6ef372fe94f82b, 0xa54ff53a5f1d36f1,
+	0x510e527fade682d1, 0x9b05688c2b3e6c1f, 0x1f83d9abfb41bd6b, 0x5be0cd19137e2179,
+}
+
+var blake2bSigma = [12][16]byte{
+	{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+	{14, 10, 4, 8, 9, 15, 13, 6, 1, 12, 0, 2, 11, 7, 5, 3},
+	{11, 8, 12, 0, 5, 2, 15, 13, 10, 14, 3, 6, 7, 1, 9, 4},
+	{7, 9, 3, 1, 13, 12, 11, 14, 2, 6, 5, 10, 4, 0, 15, 8},
+	{9, 0, 5, 7, 2, 4, 10, 15, 14, 1, 11, 12, 6, 8, 3, 13},
+	{2, 12, 6, 10, 0, 11, 8, 3, 4, 13, 7, 5, 15, 14, 1, 9},
+	{12, 5, 1, 15, 14, 13, 4, 10, 0, 7, 6, 3, 9, 2, 8, 11},
+	{13, 11, 7, 14, 12, 1, 3, 9, 5, 0, 15, 4, 8, 6, 2, 10},
+	{6, 15, 14, 9, 11, 3, 0, 8, 12, 2, 13, 7, 1, 4, 10, 5},
+	{10, 2, 8, 4, 7, 6, 1, 5, 15, 11, 9, 14, 3, 12, 13, 0},
+	{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15},
+	{14, 10, 4, 8, 9, 15, 13, 6, 1, 12, 0, 2, 11, 7, 5, 3},
+}
+
+// blake2b returns the unkeyed BLAKE2b hash (RFC 7693) of data, size bytes long,
+// the vendored crypto packages don't include it
+func blake2b(data []byte, size int) []byte {
+	h := blake2bIV
+	h[0] ^= 0x01010000 ^ uint64(size)
+
+	var block [128]byte
+	var counter uint64
+	for {
+		n := copy(block[:], data)
+		data = data[n:]
+		counter += uint64(n)
+		last := len(data) == 0
+		for i := n; i < len(block); i++ {
+			block[i] = 0
+		}
+		blake2bCompress(&h, &block, counter, last)
+		if last {
+			break
+		}
+	}
+
+	out := make([]byte, 64)
+	for i, v := range h {
+		binary.LittleEndian.PutUint64(out[i*8:], v)
+	}
+	return out[:size]
+}
+
+func blake2bCompress(h *[8]uint64, block *[128]byte, counter uint64, last bool) {
+	var m [16]uint64
+	for i := range m {
+		m[i] = binary.LittleEndian.Uint64(block[i*8:])
+	}
+	var v [16]uint64
+	copy(v[:8], h[:])
+	copy(v[8:], blake2bIV[:])
+	v[12] ^= counter
+	if last {
+		v[14] = ^v[14]
+	}
+
+	g := func(a, b, c, d int, x, y uint64) {
+		v[a] += v[b] + x
+		v[d] = bits.RotateLeft64(v[d]^v[a], -32)
+		v[c] += v[d]
+		v[b] = bits.RotateLeft64(v[b]^v[c], -24)
+		v[a] += v[b] + y
+		v[d] = bits.RotateLeft64(v[d]^v[a], -16)
+		v[c] += v[d]
+		v[b] = bits.RotateLeft64(v[b]^v[c], -63)
+	}
+	for _, s := range blake2bSigma {
+		g(0, 4, 8, 12, m[s[0]], m[s[1]])
+		g(1, 5, 9, 13, m[s[2]], m[s[3]])
+		g(2, 6, 10, 14, m[s[4]], m[s[5]])
+		g(3, 7, 11, 15, m[s[6]], m[s[7]])
+		g(0, 5, 10, 15, m[s[8]], m[s[9]])
+		g(1, 6, 11, 12, m[s[10]], m[s[11]])
+		g(2, 7, 8, 13, m[s[12]], m[s[13]])
+		g(3, 4, 9, 14, m[s[14]], m[s[15]])
+	}
+
+	for i := range h {
+		h[i] ^= v[i] ^ v[i+8]
+	}
+}
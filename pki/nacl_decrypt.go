@@ -0,0 +1,75 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+//go:build !encryptonly
+// +build !encryptonly
+
+package pki
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/keybase/go-crypto/nacl/box"
+	"github.com/keybase/go-crypto/nacl/secretbox"
+)
+
+// nacl decryption is left out of encrypt only builds (-tags encryptonly), see decrypt_encryptonly.go
+
+// DecryptSecret returns the plain text of a NACL[...] value
+func (n *NaclBackend) DecryptSecret(cipherText string) (string, error) {
+	if !IsNaclValue(cipherText) {
+		return cipherText, fmt.Errorf("not a nacl value")
+	}
+	if n.SecretKey == nil {
+		return cipherText, fmt.Errorf("no nacl secret key to decrypt with")
+	}
+	encoded := strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(cipherText), NaclPrefix), "]")
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return cipherText, fmt.Errorf("unable to read nacl value: %s", err)
+	}
+
+	var plain []byte
+	var ok bool
+	switch n.BoxType {
+	case NaclSecretBox:
+		if len(sealed) < 24+secretbox.Overhead {
+			return cipherText, fmt.Errorf("unable to read nacl value: too short")
+		}
+		var nonce [24]byte
+		copy(nonce[:], sealed[:24])
+		plain, ok = secretbox.Open(nil, sealed[24:], &nonce, n.SecretKey)
+	default:
+		if len(sealed) < 32+box.Overhead {
+			return cipherText, fmt.Errorf("unable to read nacl value: too short")
+		}
+		var ephemeralPublic [32]byte
+		copy(ephemeralPublic[:], sealed[:32])
+		nonce := sealNonce(&ephemeralPublic, n.PublicKey)
+		plain, ok = box.Open(nil, sealed[32:], &nonce, &ephemeralPublic, n.SecretKey)
+	}
+	if !ok {
+		return cipherText, fmt.Errorf("unable to decrypt nacl value, it was encrypted with another key or box type")
+	}
+
+	return string(plain), nil
+}
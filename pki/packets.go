@@ -0,0 +1,283 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package pki
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/keybase/go-crypto/openpgp/armor"
+)
+
+// Packet is an OpenPGP packet, its body is all that can be read of it without a key
+type Packet struct {
+	Offset    int
+	CTB       byte
+	Tag       int
+	HeaderLen int
+	NewFormat bool
+	// Partial bodies are sent in chunks, Body has them joined together
+	Partial bool
+	Body    []byte
+}
+
+// packetNames are the names gpg --list-packets uses
+var packetNames = map[int]string{
+	1:  "pubkey enc packet",
+	2:  "signature packet",
+	3:  "symkey enc packet",
+	4:  "onepass_sig packet",
+	5:  "secret key packet",
+	6:  "public key packet",
+	7:  "secret sub key packet",
+	8:  "compressed packet",
+	9:  "encrypted data packet",
+	10: "marker packet",
+	11: "literal data packet",
+	12: "trust packet",
+	13: "user ID packet",
+	14: "public sub key packet",
+	17: "attribute packet",
+	18: "encrypted data packet",
+	19: "mdc packet",
+	20: "aead encrypted packet",
+}
+
+// DumpPackets de-armors a PGP message and writes a dump of its packets like gpg --list-packets,
+// only the packets outside of the encryption are shown
+func DumpPackets(w io.Writer, armored string) error {
+	block, err := armor.Decode(strings.NewReader(strings.TrimSpace(armored)))
+	if err != nil {
+		return fmt.Errorf("unable to read PGP armor: %s", err)
+	}
+	fmt.Fprintf(w, "# armor: %s\n", block.Type)
+	names := make([]string, 0, len(block.Header))
+	for name := range block.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "# armor header: %s: %s\n", name, block.Header[name])
+	}
+	data, err := ioutil.ReadAll(block.Body)
+	if err != nil {
+		return fmt.Errorf("unable to read PGP armor: %s", err)
+	}
+
+	packets, err := ParsePackets(data)
+	for _, p := range packets {
+		p.dump(w)
+	}
+	return err
+}
+
+// CheckMessage checks that an armored PGP message is whole without decrypting it: the armor
+// and its checksum, packets that aren't cut short, at least one session key encrypted to a
+// public key, and the encrypted data after them
+func CheckMessage(armored string) error {
+	if err := CheckArmor(armored); err != nil {
+		return err
+	}
+	block, err := armor.Decode(strings.NewReader(strings.TrimSpace(armored)))
+	if err != nil {
+		return fmt.Errorf("unable to read PGP armor: %s", err)
+	}
+	if block.Type != "PGP MESSAGE" {
+		return fmt.Errorf("not a PGP message: '%s'", block.Type)
+	}
+	data, err := ioutil.ReadAll(block.Body)
+	if err != nil {
+		return fmt.Errorf("unable to read PGP armor: %s", err)
+	}
+	packets, err := ParsePackets(data)
+	if err != nil {
+		return fmt.Errorf("unable to read PGP message: %s", err)
+	}
+
+	keys, encrypted := 0, false
+	for _, p := range packets {
+		switch p.Tag {
+		case 1:
+			keys++
+		case 9, 18, 20:
+			encrypted = true
+		}
+	}
+	if keys == 0 {
+		return fmt.Errorf("PGP message is not encrypted to a public key")
+	}
+	if !encrypted {
+		return fmt.Errorf("PGP message has no encrypted data")
+	}
+	return nil
+}
+
+// ParsePackets splits binary OpenPGP data into packets, those read before an error are returned with it
+func ParsePackets(data []byte) ([]Packet, error) {
+	var packets []Packet
+	for offset := 0; offset < len(data); {
+		p, next, err := parsePacket(data, offset)
+		if err != nil {
+			return packets, fmt.Errorf("offset %d: %s", offset, err)
+		}
+		packets = append(packets, p)
+		offset = next
+	}
+	return packets, nil
+}
+
+// parsePacket reads the packet at offset, returning the offset of the packet after it
+func parsePacket(data []byte, offset int) (Packet, int, error) {
+	p := Packet{Offset: offset, CTB: data[offset]}
+	if p.CTB&0x80 == 0 {
+		return p, 0, fmt.Errorf("not a packet header: 0x%02x", p.CTB)
+	}
+	pos := offset + 1
+
+	if p.CTB&0x40 == 0 {
+		// old format, the length type is in the low bits
+		p.Tag = int(p.CTB>>2) & 0x0f
+		size := []int{1, 2, 4, 0}[p.CTB&0x03]
+		if pos+size > len(data) {
+			return p, 0, fmt.Errorf("%s header is cut short", packetName(p.Tag))
+		}
+		n := len(data) - pos - size
+		switch size {
+		case 1:
+			n = int(data[pos])
+		case 2:
+			n = int(binary.BigEndian.Uint16(data[pos:]))
+		case 4:
+			n = int(binary.BigEndian.Uint32(data[pos:]))
+		}
+		p.HeaderLen = 1 + size
+		pos += size
+		if pos+n > len(data) {
+			return p, 0, fmt.Errorf("%s is cut short", packetName(p.Tag))
+		}
+		p.Body = data[pos : pos+n]
+		return p, pos + n, nil
+	}
+
+	p.NewFormat = true
+	p.Tag = int(p.CTB & 0x3f)
+	var body bytes.Buffer
+	for {
+		n, size, partial, err := newFormatLength(data[pos:])
+		if err != nil {
+			return p, 0, err
+		}
+		if p.HeaderLen == 0 {
+			p.HeaderLen = 1 + size
+		}
+		pos += size
+		if pos+n > len(data) {
+			return p, 0, fmt.Errorf("%s is cut short", packetName(p.Tag))
+		}
+		body.Write(data[pos : pos+n])
+		pos += n
+		if !partial {
+			break
+		}
+		p.Partial = true
+	}
+	p.Body = body.Bytes()
+	return p, pos, nil
+}
+
+// newFormatLength reads a new format body length, it returns the length, how many
+// bytes it took, and whether it is the length of a chunk of a partial body
+func newFormatLength(data []byte) (int, int, bool, error) {
+	if len(data) == 0 {
+		return 0, 0, false, fmt.Errorf("missing packet length")
+	}
+	switch first := int(data[0]); {
+	case first < 192:
+		return first, 1, false, nil
+	case first < 224:
+		if len(data) < 2 {
+			return 0, 0, false, fmt.Errorf("missing packet length")
+		}
+		return (first-192)<<8 + int(data[1]) + 192, 2, false, nil
+	case first < 255:
+		return 1 << uint(first&0x1f), 1, true, nil
+	default:
+		if len(data) < 5 {
+			return 0, 0, false, fmt.Errorf("missing packet length")
+		}
+		return int(binary.BigEndian.Uint32(data[1:])), 5, false, nil
+	}
+}
+
+// dump writes the packet as gpg --list-packets does
+func (p Packet) dump(w io.Writer) {
+	plen := len(p.Body)
+	extra := ""
+	if p.Partial {
+		plen, extra = 0, " partial"
+	}
+	if p.NewFormat {
+		extra += " new-ctb"
+	}
+	fmt.Fprintf(w, "# off=%d ctb=%02x tag=%d hlen=%d plen=%d%s\n", p.Offset, p.CTB, p.Tag, p.HeaderLen, plen, extra)
+
+	b := p.Body
+	switch {
+	case p.Tag == 1 && len(b) >= 10:
+		fmt.Fprintf(w, ":%s: version %d, algo %d, keyid %016X\n", packetName(p.Tag), b[0], b[9], binary.BigEndian.Uint64(b[1:9]))
+		if binary.BigEndian.Uint64(b[1:9]) == 0 {
+			fmt.Fprintf(w, "\t(anonymous recipient, can be any key)\n")
+		}
+	case p.Tag == 3 && len(b) >= 3:
+		fmt.Fprintf(w, ":%s: version %d, cipher %d, s2k %d\n", packetName(p.Tag), b[0], b[1], b[2])
+	case p.Tag == 9:
+		fmt.Fprintf(w, ":%s:\n\tlength: %s\n\t(no integrity protection, newer GnuPG refuses it)\n", packetName(p.Tag), p.length())
+	case p.Tag == 18 && len(b) >= 1:
+		fmt.Fprintf(w, ":%s:\n\tlength: %s\n\tmdc_method: 2\n", packetName(p.Tag), p.length())
+		if b[0] != 1 {
+			fmt.Fprintf(w, "\t(version %d, needs GnuPG 2.3 or later)\n", b[0])
+		}
+	case p.Tag == 20 && len(b) >= 3:
+		fmt.Fprintf(w, ":%s: version %d, cipher %d, aead %d\n\t(needs GnuPG 2.3 or later)\n", packetName(p.Tag), b[0], b[1], b[2])
+	default:
+		fmt.Fprintf(w, ":%s:\n", packetName(p.Tag))
+	}
+}
+
+// length is the body length as gpg shows it, which it doesn't know for partial bodies
+func (p Packet) length() string {
+	if p.Partial {
+		return "unknown"
+	}
+	return fmt.Sprintf("%d", len(p.Body))
+}
+
+func packetName(tag int) string {
+	if name, ok := packetNames[tag]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown packet (tag %d)", tag)
+}
@@ -0,0 +1,104 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package pki
+
+import (
+	"strings"
+	"sync"
+)
+
+// PassphraseError is returned when a private key can't be used because its passphrase
+// is wrong or wasn't given, as opposed to a value encrypted for a key that isn't there
+type PassphraseError struct {
+	Err error
+}
+
+func (e *PassphraseError) Error() string {
+	return e.Err.Error()
+}
+
+// IsPassphraseError returns true for a *PassphraseError
+func IsPassphraseError(err error) bool {
+	_, ok := err.(*PassphraseError)
+	return ok
+}
+
+// gpgPassphraseErrors are the messages gpg gives when the passphrase is the problem
+var gpgPassphraseErrors = []string{"Bad passphrase", "No passphrase given"}
+
+func isGPGPassphraseError(msg string) bool {
+	for _, m := range gpgPassphraseErrors {
+		if strings.Contains(msg, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reprompt asks for the passphrase again when it is wrong, it is shared by
+// all of the files in a run so that the Retries are for the whole run
+type Reprompt struct {
+	// Retries is how many more times the passphrase is asked for
+	Retries int
+	// Prompt reads the passphrase, e.g. with PromptSecret
+	Prompt func() ([]byte, error)
+	// Warn is told about each wrong passphrase and how many tries are left
+	Warn  func(err error, left int)
+	mu    sync.Mutex
+	tries int
+}
+
+// Again calls try with a new passphrase until it doesn't fail because of the passphrase,
+// or there are no retries left, err is the passphrase error that led to it
+func (r *Reprompt) Again(err error, try func(passphrase []byte) error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for IsPassphraseError(err) && r.tries < r.Retries {
+		r.tries++
+		if r.Warn != nil {
+			r.Warn(err, r.Retries-r.tries+1)
+		}
+		passphrase, perr := r.Prompt()
+		if perr != nil {
+			return perr
+		}
+		err = try(passphrase)
+	}
+	return err
+}
+
+// locked returns true when a key in the secring still needs its passphrase
+func (p *Pki) locked() bool {
+	if p.SecRing == nil {
+		return false
+	}
+	for _, entity := range *p.SecRing {
+		if entity.PrivateKey != nil && entity.PrivateKey.Encrypted {
+			return true
+		}
+		for _, subkey := range entity.Subkeys {
+			if subkey.PrivateKey != nil && subkey.PrivateKey.Encrypted {
+				return true
+			}
+		}
+	}
+	return false
+}
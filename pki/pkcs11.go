@@ -0,0 +1,359 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+//go:build !encryptonly
+// +build !encryptonly
+
+package pki
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/keybase/go-crypto/openpgp/armor"
+	"github.com/keybase/go-crypto/openpgp/packet"
+)
+
+// PKCS11Tool is OpenSC's pkcs11-tool, used to decrypt with a key that stays on the token
+var PKCS11Tool = "pkcs11-tool"
+
+// GPGConnectAgent asks gpg-agent for the token PIN, which it caches, with PINFromAgent
+var GPGConnectAgent = "gpg-connect-agent"
+
+// PIN sources besides the secret store URIs FetchSecret reads
+const (
+	PINFromPrompt = "prompt"
+	PINFromAgent  = "agent"
+)
+
+// PKCS11Token decrypts the session key of PGP messages with an RSA key on a PKCS#11 token
+// (an HSM, SoftHSM, or smartcard), the private key never exists as a file
+type PKCS11Token struct {
+	Module string
+	Slot   string
+	KeyID  string
+	PIN    []byte
+	ctx    context.Context
+}
+
+// SetContext kills pkcs11-tool when ctx is done
+func (t *PKCS11Token) SetContext(ctx context.Context) {
+	t.ctx = ctx
+}
+
+// UseToken decrypts with a PKCS#11 token in place of the secring, the public key ring is
+// still used to encrypt
+func (p *Pki) UseToken(t *PKCS11Token) error {
+	if p.Backend != nil {
+		return fmt.Errorf("a PKCS#11 token can't be used with the %s backend", p.Backend.Name())
+	}
+	if t.Module == "" {
+		return fmt.Errorf("no PKCS#11 module given")
+	}
+	p.Token = t
+	return nil
+}
+
+// TokenPIN reads the token PIN from a secret store URI (see FetchSecret), the terminal
+// (PINFromPrompt), or gpg-agent (PINFromAgent), an empty source means the token has no PIN
+// or a PIN pad
+func TokenPIN(source string, slot string) ([]byte, error) {
+	switch source {
+	case "":
+		return nil, nil
+	case PINFromPrompt:
+		return promptPIN()
+	case PINFromAgent:
+		return agentPIN(slot)
+	}
+	pin, err := FetchSecret(source)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(pin), nil
+}
+
+// promptPIN reads the PIN from the terminal without echoing it
+func promptPIN() ([]byte, error) {
+	return PromptSecret("PKCS#11 token PIN")
+}
+
+// agentPIN asks gpg-agent for the PIN, pinentry prompts for it once and gpg-agent caches it
+func agentPIN(slot string) ([]byte, error) {
+	cacheID := "gsp-pkcs11-slot-" + slot
+	request := fmt.Sprintf("GET_PASSPHRASE %s X PIN Enter+the+PIN+for+the+PKCS%%2311+token+in+slot+%s", cacheID, slot)
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(GPGConnectAgent, request, "/bye")
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("gpg-agent: %s", strings.TrimSpace(stderr.String()+" "+err.Error()))
+	}
+
+	scanner := bufio.NewScanner(&stdout)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "OK ") {
+			return hex.DecodeString(strings.TrimPrefix(line, "OK "))
+		}
+		if strings.HasPrefix(line, "ERR ") {
+			return nil, fmt.Errorf("gpg-agent: %s", strings.TrimPrefix(line, "ERR "))
+		}
+	}
+	return nil, fmt.Errorf("gpg-agent did not return a PIN")
+}
+
+// DecryptSecret returns decrypted cipherText, the token decrypts the session key and the
+// message itself is decrypted here
+func (t *PKCS11Token) DecryptSecret(cipherText string) (string, error) {
+	block, err := armor.Decode(strings.NewReader(strings.TrimSpace(cipherText)))
+	if err != nil {
+		return cipherText, fmt.Errorf("Decode error: %s", err)
+	}
+	if block.Type != "PGP MESSAGE" {
+		return cipherText, fmt.Errorf("block type is not PGP MESSAGE: %s", block.Type)
+	}
+	data, err := ioutil.ReadAll(block.Body)
+	if err != nil {
+		return cipherText, fmt.Errorf("unable to read PGP message: %s", err)
+	}
+
+	// the encrypted session keys come first, one per recipient
+	var sessionKeys [][]byte
+	for {
+		tag, body, rest, err := readPacket(data)
+		if err != nil {
+			return cipherText, fmt.Errorf("unable to read PGP message: %s", err)
+		}
+		if tag != 1 {
+			break
+		}
+		if mpi, ok := rsaSessionKey(body); ok {
+			sessionKeys = append(sessionKeys, mpi)
+		}
+		data = rest
+	}
+	if len(sessionKeys) == 0 {
+		return cipherText, fmt.Errorf("PGP message is not encrypted to an RSA key")
+	}
+
+	var lastErr error
+	for _, encrypted := range sessionKeys {
+		decrypted, err := t.decrypt(encrypted)
+		if err != nil && orBackground(t.ctx).Err() != nil {
+			// timed out, the other keys would be too
+			return cipherText, err
+		}
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		cipher, key, err := parseSessionKey(decrypted)
+		if err != nil {
+			// encrypted to another key
+			lastErr = err
+			continue
+		}
+		plainText, err := decryptData(data, cipher, key)
+		if err != nil {
+			return cipherText, fmt.Errorf("unable to read PGP message: %s", err)
+		}
+		return plainText, nil
+	}
+
+	return cipherText, fmt.Errorf("unable to decrypt with the PKCS#11 key: %s", lastErr)
+}
+
+// decrypt runs an RSA PKCS#1 v1.5 decryption on the token, the session key is read
+// from stdout so that it is never written to a file
+func (t *PKCS11Token) decrypt(encrypted []byte) ([]byte, error) {
+	args := []string{"--module", t.Module, "--decrypt", "--mechanism", "RSA-PKCS"}
+	if t.Slot != "" {
+		args = append(args, "--slot", t.Slot)
+	}
+	if t.KeyID != "" {
+		args = append(args, "--id", t.KeyID)
+	}
+	ctx := orBackground(t.ctx)
+	cmd := exec.CommandContext(ctx, PKCS11Tool, args...)
+	if t.PIN != nil {
+		// the PIN is passed in the environment so it isn't visible in the process list
+		cmd.Args = append(cmd.Args, "--login", "--pin", "env:GSP_PKCS11_PIN")
+		cmd.Env = append(os.Environ(), "GSP_PKCS11_PIN="+string(t.PIN))
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = bytes.NewReader(encrypted)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return nil, fmt.Errorf("%s", msg)
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// readPacket splits the first OpenPGP packet off data, the session key packets read
+// here never use partial lengths
+func readPacket(data []byte) (tag int, body []byte, rest []byte, err error) {
+	if len(data) < 2 || data[0]&0x80 == 0 {
+		return 0, nil, nil, fmt.Errorf("bad packet header")
+	}
+	var length, offset int
+	if data[0]&0x40 == 0 {
+		// old format, the length type is in the low two bits
+		tag = int(data[0]&0x3f) >> 2
+		switch data[0] & 3 {
+		case 0:
+			length, offset = int(data[1]), 2
+		case 1:
+			if len(data) < 3 {
+				return 0, nil, nil, fmt.Errorf("short packet header")
+			}
+			length, offset = int(binary.BigEndian.Uint16(data[1:3])), 3
+		case 2:
+			if len(data) < 5 {
+				return 0, nil, nil, fmt.Errorf("short packet header")
+			}
+			length, offset = int(binary.BigEndian.Uint32(data[1:5])), 5
+		default:
+			return tag, nil, nil, nil
+		}
+	} else {
+		tag = int(data[0] & 0x3f)
+		switch {
+		case data[1] < 192:
+			length, offset = int(data[1]), 2
+		case data[1] < 224:
+			if len(data) < 3 {
+				return 0, nil, nil, fmt.Errorf("short packet header")
+			}
+			length, offset = (int(data[1])-192)<<8+int(data[2])+192, 3
+		case data[1] == 255:
+			if len(data) < 6 {
+				return 0, nil, nil, fmt.Errorf("short packet header")
+			}
+			length, offset = int(binary.BigEndian.Uint32(data[2:6])), 6
+		default:
+			// a partial length, only the encrypted data uses one
+			return tag, nil, nil, nil
+		}
+	}
+	if offset+length > len(data) {
+		return 0, nil, nil, fmt.Errorf("short packet")
+	}
+
+	return tag, data[offset : offset+length], data[offset+length:], nil
+}
+
+// rsaSessionKey returns the encrypted session key of a version 3 public key encrypted
+// session key packet for an RSA key, padded to the key size the token expects
+func rsaSessionKey(body []byte) ([]byte, bool) {
+	// version, key ID, algorithm, then the MPI
+	if len(body) < 12 || body[0] != 3 || (body[9] != byte(packet.PubKeyAlgoRSA) && body[9] != byte(packet.PubKeyAlgoRSAEncryptOnly)) {
+		return nil, false
+	}
+	bits := int(binary.BigEndian.Uint16(body[10:12]))
+	mpi := body[12:]
+	if len(mpi) != (bits+7)/8 {
+		return nil, false
+	}
+
+	// the MPI drops leading zeros, RSA key sizes are a multiple of 1024 bits
+	size := (len(mpi) + 127) / 128 * 128
+	return append(make([]byte, size-len(mpi)), mpi...), true
+}
+
+// parseSessionKey checks the decrypted session key: the cipher, the key, and a checksum
+func parseSessionKey(b []byte) (packet.CipherFunction, []byte, error) {
+	if len(b) < 4 {
+		return 0, nil, fmt.Errorf("session key is too short")
+	}
+	key := b[1 : len(b)-2]
+	var sum uint16
+	for _, v := range key {
+		sum += uint16(v)
+	}
+	if sum != binary.BigEndian.Uint16(b[len(b)-2:]) {
+		return 0, nil, fmt.Errorf("session key checksum incorrect")
+	}
+	return packet.CipherFunction(b[0]), key, nil
+}
+
+// decryptData decrypts the encrypted data packet with the session key and returns the
+// literal data, the integrity check is verified after it is read
+func decryptData(data []byte, cipher packet.CipherFunction, key []byte) (string, error) {
+	packets := packet.NewReader(bytes.NewReader(data))
+	p, err := packets.Next()
+	if err != nil {
+		return "", err
+	}
+	se, ok := p.(*packet.SymmetricallyEncrypted)
+	if !ok {
+		return "", fmt.Errorf("no encrypted data after the session keys")
+	}
+	decrypted, err := se.Decrypt(cipher, key)
+	if err != nil {
+		return "", err
+	}
+
+	var plainText []byte
+	contents := packet.NewReader(decrypted)
+	for plainText == nil {
+		p, err := contents.Next()
+		if err != nil {
+			return "", err
+		}
+		switch content := p.(type) {
+		case *packet.Compressed:
+			if err = contents.Push(content.Body); err != nil {
+				return "", err
+			}
+		case *packet.LiteralData:
+			if plainText, err = ioutil.ReadAll(content.Body); err != nil {
+				return "", err
+			}
+		}
+	}
+
+	// the modification detection code is checked once everything has been read
+	if _, err = io.Copy(ioutil.Discard, decrypted); err != nil {
+		return "", err
+	}
+	if err = decrypted.Close(); err != nil {
+		return "", err
+	}
+	return string(plainText), nil
+}
@@ -0,0 +1,73 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+//go:build !encryptonly
+// +build !encryptonly
+
+package pki_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Everbridge/generate-secure-pillar/pki"
+	"github.com/Everbridge/generate-secure-pillar/testenv"
+)
+
+func TestPKCS11Timeout(t *testing.T) {
+	env, err := testenv.New("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(env.Dir)
+	p := pki.New(env.KeyName, env.PubRing, "")
+	cipherText, err := p.EncryptSecret("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a token that never answers
+	tool := filepath.Join(env.Dir, "pkcs11-tool")
+	if err = ioutil.WriteFile(tool, []byte("#!/bin/sh\nexec sleep 10\n"), 0700); err != nil { // #nosec G306
+		t.Fatal(err)
+	}
+	defer func(old string) { pki.PKCS11Tool = old }(pki.PKCS11Tool)
+	pki.PKCS11Tool = tool
+
+	token := &pki.PKCS11Token{Module: "softhsm2.so"}
+	if err = p.UseToken(token); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	p.SetContext(ctx)
+	start := time.Now()
+	_, err = token.DecryptSecret(cipherText)
+	if err != context.DeadlineExceeded {
+		t.Fatalf("expected %s, got %v", context.DeadlineExceeded, err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatalf("expected pkcs11-tool to be killed, it took %s", time.Since(start))
+	}
+}
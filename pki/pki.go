@@ -23,20 +23,34 @@ package pki
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/user"
 	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/Everbridge/generate-secure-pillar/metrics"
 	"github.com/keybase/go-crypto/openpgp"
 	"github.com/keybase/go-crypto/openpgp/armor"
+	"github.com/keybase/go-crypto/openpgp/packet"
 	"github.com/sirupsen/logrus"
 	"github.com/y0ssar1an/q"
 )
 
 var logger = logrus.New()
+var logOutput io.Writer = os.Stdout
+
+// SetLogOutput sets where log messages are written (stdout by default)
+func SetLogOutput(w io.Writer) {
+	logOutput = w
+	logger.Out = w
+}
+
 var debug = false
 
 // PGPHeader header const
@@ -51,6 +65,28 @@ type Pki struct {
 	SecretKey     *openpgp.Entity
 	PubRing       *openpgp.EntityList
 	SecRing       *openpgp.EntityList
+	Backend       Backend
+	Config        *packet.Config
+	EscrowKey     *openpgp.Entity
+	EscrowKeyIDs  []uint64
+	Token         Decrypter
+	Reprompt      *Reprompt
+	// Context stops encrypting and decrypting once it is done (see SetContext)
+	Context context.Context
+}
+
+// Decrypter decrypts values with a private key kept somewhere other than a key ring,
+// such as a PKCS#11 token
+type Decrypter interface {
+	DecryptSecret(cipherText string) (string, error)
+}
+
+// ciphers maps the cipher names accepted in profiles to OpenPGP cipher functions
+var ciphers = map[string]packet.CipherFunction{
+	"aes128": packet.CipherAES128,
+	"aes192": packet.CipherAES192,
+	"aes256": packet.CipherAES256,
+	"cast5":  packet.CipherCAST5,
 }
 
 // if debug==true this can be used to dump values from the var(s) passed in
@@ -69,10 +105,15 @@ func New(pgpKeyName string, publicKeyRing string, secretKeyRing string) Pki {
 	if os.Getenv("GSPPKI_DEBUG") != "" {
 		debug = true
 	}
-	logger.Out = os.Stdout
+	logger.Out = logOutput
+	logger.ExitFunc = metrics.Exit
 	var err error
 
-	p := Pki{publicKeyRing, secretKeyRing, pgpKeyName, nil, nil, nil, nil}
+	p := Pki{PublicKeyRing: publicKeyRing, SecretKeyRing: secretKeyRing, PgpKeyName: pgpKeyName}
+	// without keyrings the keys are taken from the secret key given to UseSecretKey
+	if p.PublicKeyRing == "" && p.SecretKeyRing == "" {
+		return p
+	}
 	publicKeyRing, err = p.ExpandTilde(p.PublicKeyRing)
 	if err != nil {
 		logger.Fatal("cannot expand public key ring path: ", err)
@@ -83,14 +124,17 @@ func New(pgpKeyName string, publicKeyRing string, secretKeyRing string) Pki {
 		logger.Fatalf("Pki: %s", err)
 	}
 
-	secKeyRing, err := p.ExpandTilde(p.SecretKeyRing)
-	if err != nil {
-		logger.Fatal("cannot expand secret key ring path: ", err)
-	}
-	p.SecretKeyRing = secKeyRing
-	p.SecRing, err = p.setKeyRing(p.SecretKeyRing)
-	if err != nil {
-		logger.Warnf("Pki: %s", err)
+	// no secring is read when the secret key comes from elsewhere (see UseSecretKey)
+	if p.SecretKeyRing != "" {
+		secKeyRing, err := p.ExpandTilde(p.SecretKeyRing)
+		if err != nil {
+			logger.Fatal("cannot expand secret key ring path: ", err)
+		}
+		p.SecretKeyRing = secKeyRing
+		p.SecRing, err = p.setKeyRing(p.SecretKeyRing)
+		if err != nil {
+			logger.Warnf("Pki: %s", err)
+		}
 	}
 
 	// TODO: Something is goofy here sometimes when getting a key to decrypt
@@ -112,27 +156,20 @@ func (p *Pki) setKeyRing(keyRingPath string) (*openpgp.EntityList, error) {
 	if err != nil {
 		return nil, fmt.Errorf("error reading secring: %s", err)
 	}
-	keyRingFile, err := os.Open(filepath.Clean(keyRing))
-	if err != nil {
-		return nil, fmt.Errorf("unable to open key ring: %s", err)
-	}
-	ring, err := openpgp.ReadKeyRing(keyRingFile)
-	if err != nil {
-		return nil, fmt.Errorf("cannot read private keys: %s", err)
-	} else if ring == nil {
-		return nil, fmt.Errorf("%s is empty", p.SecretKeyRing)
-	}
-	if err = keyRingFile.Close(); err != nil {
-		return &ring, fmt.Errorf("error closing secring: %s", err)
-	}
-
-	return &ring, nil
+	return Keys.KeyRing(keyRing)
 }
 
 // EncryptSecret returns encrypted plainText
 func (p *Pki) EncryptSecret(plainText string) (string, error) {
 	var memBuffer bytes.Buffer
 
+	if err := p.ctxErr(); err != nil {
+		return plainText, err
+	}
+	if p.Backend != nil {
+		return p.Backend.EncryptSecret(plainText)
+	}
+
 	hints := openpgp.FileHints{IsBinary: false, ModTime: time.Time{}}
 	writer := bufio.NewWriter(&memBuffer)
 	w, err := armor.Encode(writer, "PGP MESSAGE", nil)
@@ -140,7 +177,11 @@ func (p *Pki) EncryptSecret(plainText string) (string, error) {
 		return plainText, fmt.Errorf("encode error: %s", err)
 	}
 
-	plainFile, err := openpgp.Encrypt(w, []*openpgp.Entity{p.PublicKey}, nil, &hints, nil)
+	recipients := []*openpgp.Entity{p.PublicKey}
+	if p.EscrowKey != nil {
+		recipients = append(recipients, p.EscrowKey)
+	}
+	plainFile, err := openpgp.Encrypt(w, recipients, nil, &hints, p.Config)
 	if err != nil {
 		return plainText, fmt.Errorf("encryption error: %s", err)
 	}
@@ -162,35 +203,64 @@ func (p *Pki) EncryptSecret(plainText string) (string, error) {
 	return memBuffer.String(), nil
 }
 
-// DecryptSecret returns decrypted cipherText
-func (p *Pki) DecryptSecret(cipherText string) (plainText string, err error) {
-	if p.SecRing == nil {
-		return cipherText, fmt.Errorf("no secring set")
+// EncryptBatch returns the encrypted plainTexts, in batches if the backend supports it
+func (p *Pki) EncryptBatch(plainTexts []string) ([]string, error) {
+	if bb, ok := p.Backend.(interface {
+		EncryptBatch([]string) ([]string, error)
+	}); ok {
+		return bb.EncryptBatch(plainTexts)
 	}
-	if p.SecretKey == nil {
-		return cipherText, fmt.Errorf("unable to load PGP secret key for '%s'", p.PgpKeyName)
+
+	var cipherTexts []string
+	for _, plainText := range plainTexts {
+		cipherText, err := p.EncryptSecret(plainText)
+		if err != nil {
+			return cipherTexts, err
+		}
+		cipherTexts = append(cipherTexts, cipherText)
 	}
+	return cipherTexts, nil
+}
 
-	decbuf := bytes.NewBuffer([]byte(cipherText))
-	block, err := armor.Decode(decbuf)
-	if err != nil {
-		return cipherText, fmt.Errorf("Decode error: %s", err)
+// SetCipher sets the symmetric cipher used when encrypting values
+func (p *Pki) SetCipher(name string) error {
+	if name == "" {
+		return nil
 	}
-	if block.Type != "PGP MESSAGE" {
-		return cipherText, fmt.Errorf("block type is not PGP MESSAGE: %s", err)
+	cipher, ok := ciphers[strings.ToLower(name)]
+	if !ok {
+		return fmt.Errorf("unknown cipher '%s', expected one of: %s", name, strings.Join(CipherNames(), ", "))
 	}
-
-	md, err := openpgp.ReadMessage(block.Body, p.SecRing, nil, nil)
-	if err != nil {
-		return cipherText, fmt.Errorf("unable to read PGP message: %s", err)
+	if g, ok := p.Backend.(*GPGBackend); ok {
+		g.Cipher = gpgCipherNames[strings.ToLower(name)]
+		return nil
+	}
+	if p.Config == nil {
+		p.Config = &packet.Config{}
 	}
+	p.Config.DefaultCipher = cipher
+	return nil
+}
 
-	body, err := ioutil.ReadAll(md.UnverifiedBody)
-	if err != nil {
-		return cipherText, fmt.Errorf("unable to read message body: %s", err)
+// CipherNames returns the names of the supported ciphers
+func CipherNames() []string {
+	names := make([]string, 0, len(ciphers))
+	for name := range ciphers {
+		names = append(names, name)
 	}
+	sort.Strings(names)
+	return names
+}
 
-	return string(body), err
+// Fingerprint returns the hex fingerprint of the key used for encryption
+func (p *Pki) Fingerprint() (string, error) {
+	if g, ok := p.Backend.(*GPGBackend); ok {
+		return g.Fingerprint()
+	}
+	if p.PublicKey == nil || p.PublicKey.PrimaryKey == nil {
+		return "", fmt.Errorf("no public key loaded for '%s'", p.PgpKeyName)
+	}
+	return fmt.Sprintf("%X", p.PublicKey.PrimaryKey.Fingerprint), nil
 }
 
 // GetKeyByID returns a keyring by the given ID
@@ -232,6 +302,10 @@ func checkIdentities(id string, entity *openpgp.Entity) bool {
 
 // ExpandTilde does exactly what it says on the tin
 func (p *Pki) ExpandTilde(path string) (string, error) {
+	return expandTilde(path)
+}
+
+func expandTilde(path string) (string, error) {
 	if len(path) == 0 || path[0] != '~' {
 		return path, nil
 	}
@@ -250,37 +324,57 @@ func (p *Pki) KeyUsedForEncryptedFile(file string) (string, error) {
 		return "", err
 	}
 
-	in, err := os.Open(filepath.Clean(filePath))
+	cipherText, err := ioutil.ReadFile(filepath.Clean(filePath))
 	if err != nil {
 		return "", err
 	}
 
-	block, err := armor.Decode(in)
-	if err != nil {
-		return "", err
+	return p.KeyUsedForEncryptedText(string(cipherText))
+}
+
+// Renderer returns the Salt renderer that reads the values, used in the #!yaml|<renderer> line
+func (p *Pki) Renderer() string {
+	if p.Backend != nil && p.Backend.Name() == NaclBackendName {
+		return NaclBackendName
 	}
+	return "gpg"
+}
 
-	if block.Type != "PGP MESSAGE" {
-		return "", fmt.Errorf("error decoding private key")
+// KeyUsedForEncryptedText gets the key used to encrypt an armored message
+func (p *Pki) KeyUsedForEncryptedText(cipherText string) (string, error) {
+	if p.Backend != nil {
+		return p.Backend.KeyInfo(cipherText)
 	}
-	md, err := openpgp.ReadMessage(block.Body, p.SecRing, nil, nil)
-	if err != nil {
-		return "", fmt.Errorf("unable to read PGP message: %s", err)
+	if p.SecRing == nil {
+		// no secring (a PKCS#11 token or a secret key from elsewhere), the public key ring names the key
+		return p.keyUsedFromHeaders(cipherText)
 	}
+	return p.keyUsed(cipherText)
+}
 
-	for index := 0; index < len(md.EncryptedToKeyIds); index++ {
-		id := md.EncryptedToKeyIds[index]
-		keyStr := p.keyStringForID(id)
-		if keyStr != "" {
+// keyUsedFromHeaders names the key a message is encrypted to from the key IDs in its packet
+// headers, without decrypting it
+func (p *Pki) keyUsedFromHeaders(cipherText string) (string, error) {
+	ids, err := EncryptedToKeyIDs(cipherText)
+	if err != nil {
+		return "", err
+	}
+	for _, id := range ids {
+		if keyStr := keyStringForID(p.SecRing, id); keyStr != "" {
+			return keyStr, nil
+		}
+		if keyStr := keyStringForID(p.PubRing, id); keyStr != "" {
 			return keyStr, nil
 		}
 	}
-
-	return "", fmt.Errorf("unable to find key for ids used")
+	return "", Errorf(ErrKeyNotFound, "unable to find key for ids used")
 }
 
-func (p *Pki) keyStringForID(id uint64) string {
-	keys := p.SecRing.KeysById(id, nil)
+func keyStringForID(keyRing *openpgp.EntityList, id uint64) string {
+	if keyRing == nil {
+		return ""
+	}
+	keys := keyRing.KeysById(id, nil)
 	if len(keys) > 0 {
 		for n := 0; n < len(keys); n++ {
 			key := keys[n]
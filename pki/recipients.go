@@ -0,0 +1,80 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package pki
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/keybase/go-crypto/openpgp"
+)
+
+// Recipient is a key a PGP message is encrypted to, as named by one of its public key
+// encrypted session key packets
+type Recipient struct {
+	KeyID    string
+	Identity string
+	// Fingerprint is the fingerprint of the primary key, if the key is in the keyrings
+	Fingerprint string
+	// Anonymous is a recipient with a key ID of 0 (gpg --throw-keyids), it can be any key
+	Anonymous bool
+}
+
+// Recipients lists every key cipherText is encrypted to, not just the one the local keys
+// can decrypt it with, the identities of the keys in the keyrings are filled in
+func (p *Pki) Recipients(cipherText string) ([]Recipient, error) {
+	ids, err := EncryptedToKeyIDs(cipherText)
+	if err != nil {
+		return nil, err
+	}
+	recipients := make([]Recipient, len(ids))
+	for i, id := range ids {
+		recipients[i] = Recipient{KeyID: fmt.Sprintf("%016X", id), Anonymous: id == 0}
+		if id == 0 {
+			continue
+		}
+		desc := keyStringForID(p.PubRing, id)
+		if desc == "" {
+			desc = keyStringForID(p.SecRing, id)
+		}
+		recipients[i].Fingerprint = fingerprintForID(p.PubRing, id)
+		if recipients[i].Fingerprint == "" {
+			recipients[i].Fingerprint = fingerprintForID(p.SecRing, id)
+		}
+		if parts := strings.SplitN(strings.TrimSpace(desc), ": ", 2); len(parts) == 2 {
+			recipients[i].Identity = parts[1]
+		}
+	}
+	return recipients, nil
+}
+
+// fingerprintForID returns the fingerprint of the primary key of the key (or subkey) with the ID
+func fingerprintForID(keyRing *openpgp.EntityList, id uint64) string {
+	if keyRing == nil {
+		return ""
+	}
+	for _, key := range keyRing.KeysById(id, nil) {
+		if key.Entity != nil {
+			return fmt.Sprintf("%X", key.Entity.PrimaryKey.Fingerprint)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,185 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package pki
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/keybase/go-crypto/openpgp"
+	"github.com/keybase/go-crypto/openpgp/clearsign"
+)
+
+// ClearSign returns text signed with the private key, the text stays readable
+func (p *Pki) ClearSign(text string) (string, error) {
+	if g, ok := p.Backend.(*GPGBackend); ok {
+		return g.ClearSign(text)
+	}
+	if p.Backend != nil {
+		return "", fmt.Errorf("the %s backend cannot sign", p.Backend.Name())
+	}
+	if p.SecretKey == nil || p.SecretKey.PrivateKey == nil {
+		return "", fmt.Errorf("unable to load PGP secret key for '%s'", p.PgpKeyName)
+	}
+	if p.SecretKey.PrivateKey.Encrypted {
+		return "", fmt.Errorf("the private key for '%s' is protected by a passphrase", p.PgpKeyName)
+	}
+
+	var buffer bytes.Buffer
+	w, err := clearsign.Encode(&buffer, p.SecretKey.PrivateKey, p.Config)
+	if err != nil {
+		return "", fmt.Errorf("unable to sign: %s", err)
+	}
+	if _, err = w.Write([]byte(text)); err != nil {
+		return "", fmt.Errorf("unable to sign: %s", err)
+	}
+	if err = w.Close(); err != nil {
+		return "", fmt.Errorf("unable to sign: %s", err)
+	}
+
+	return buffer.String(), nil
+}
+
+// VerifySigned checks that clear signed text was signed by the public key
+// and returns the text that was signed
+func (p *Pki) VerifySigned(signed string) (string, error) {
+	block, _ := clearsign.Decode([]byte(signed))
+	if block == nil {
+		return "", fmt.Errorf("not a PGP signed message")
+	}
+
+	if g, ok := p.Backend.(*GPGBackend); ok {
+		if err := g.VerifySigned(signed); err != nil {
+			return "", err
+		}
+		return string(block.Plaintext), nil
+	}
+	if p.Backend != nil {
+		return "", fmt.Errorf("the %s backend cannot verify signatures", p.Backend.Name())
+	}
+
+	signer, err := openpgp.CheckDetachedSignature(p.PubRing, bytes.NewReader(block.Bytes), block.ArmoredSignature.Body)
+	if err != nil {
+		return "", fmt.Errorf("bad signature: %s", err)
+	}
+	if signer.PrimaryKey.KeyId != p.PublicKey.PrimaryKey.KeyId {
+		return "", fmt.Errorf("signed by %X, not '%s'", signer.PrimaryKey.KeyId, p.PgpKeyName)
+	}
+
+	return string(block.Plaintext), nil
+}
+
+// ClearSign returns text signed by the recipient key, gpg-agent handles any
+// passphrase or PIN entry unless a passphrase was given
+func (g *GPGBackend) ClearSign(text string) (string, error) {
+	if g.Recipient == "" {
+		return "", fmt.Errorf("no PGP key given to sign with")
+	}
+	var out string
+	var err error
+	if g.Passphrase != nil {
+		out, err = g.runWithPassphrase(text, "--batch", "--pinentry-mode", "loopback", "--passphrase-fd", "3", "--local-user", g.Recipient, "--clearsign")
+	} else {
+		out, err = g.run(text, "--local-user", g.Recipient, "--clearsign")
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to sign: %s", err)
+	}
+	return out, nil
+}
+
+// VerifySigned checks that clear signed text has a good signature from the recipient key
+func (g *GPGBackend) VerifySigned(signed string) error {
+	fingerprint, err := g.Fingerprint()
+	if err != nil {
+		return err
+	}
+	out, err := g.run(signed, "--batch", "--status-fd", "1", "--verify")
+	if err != nil {
+		return fmt.Errorf("bad signature: %s", err)
+	}
+
+	// VALIDSIG <fingerprint> <date> ... <primary key fingerprint>
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 2 && fields[1] == "VALIDSIG" {
+			if fields[len(fields)-1] == fingerprint {
+				return nil
+			}
+			return fmt.Errorf("signed by %s, not '%s'", fields[len(fields)-1], g.Recipient)
+		}
+	}
+	return fmt.Errorf("bad signature: no valid signature found")
+}
+
+// SignedBy checks that clear signed text has a good signature from any key in the
+// key ring and returns the text that was signed and who signed it
+func (p *Pki) SignedBy(signed string) (string, string, error) {
+	block, _ := clearsign.Decode([]byte(signed))
+	if block == nil {
+		return "", "", fmt.Errorf("not a PGP signed message")
+	}
+
+	if g, ok := p.Backend.(*GPGBackend); ok {
+		signer, err := g.SignedBy(signed)
+		if err != nil {
+			return "", "", err
+		}
+		return string(block.Plaintext), signer, nil
+	}
+	if p.Backend != nil {
+		return "", "", fmt.Errorf("the %s backend cannot verify signatures", p.Backend.Name())
+	}
+
+	signer, err := openpgp.CheckDetachedSignature(p.PubRing, bytes.NewReader(block.Bytes), block.ArmoredSignature.Body)
+	if err != nil {
+		return "", "", fmt.Errorf("bad signature: %s", err)
+	}
+	name := fmt.Sprintf("%X", signer.PrimaryKey.KeyId)
+	for ident := range signer.Identities {
+		name = fmt.Sprintf("%s: %s", name, ident)
+		break
+	}
+
+	return string(block.Plaintext), name, nil
+}
+
+// SignedBy checks that clear signed text has a good signature from any key in
+// the keyring and returns the key ID and user ID of the key that signed it
+func (g *GPGBackend) SignedBy(signed string) (string, error) {
+	out, err := g.run(signed, "--batch", "--status-fd", "1", "--verify")
+	if err != nil {
+		return "", fmt.Errorf("bad signature: %s", err)
+	}
+
+	// GOODSIG <long key ID> <user ID>
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), " ", 4)
+		if len(fields) == 4 && fields[1] == "GOODSIG" {
+			return fmt.Sprintf("%s: %s", fields[2], fields[3]), nil
+		}
+	}
+	return "", fmt.Errorf("bad signature: no valid signature found")
+}
@@ -0,0 +1,496 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package schema validates pillar documents against a JSON Schema, the commonly used keywords
+// are supported: type, enum, const, properties, required, additionalProperties, patternProperties,
+// items, minItems, maxItems, minLength, maxLength, pattern, minimum, maximum,
+// allOf, anyOf, oneOf, not, and $ref to #/definitions or #/$defs, a schema with any other
+// keyword fails to parse, so no constraint is left unchecked
+package schema
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// Schema is a parsed JSON Schema
+type Schema struct {
+	File string
+	root interface{}
+}
+
+// Violation is a value that does not match the schema
+type Violation struct {
+	Path []string
+	Msg  string
+}
+
+// Options change how values are checked
+type Options struct {
+	// Opaque values (encrypted values) only have to be where the schema allows a value,
+	// their type and contents are not checked
+	Opaque func(val interface{}) bool
+	// Join joins the keys of a path for messages
+	Join func(path []string) string
+}
+
+// Load reads a schema from a JSON or YAML file
+func Load(file string) (*Schema, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	sc, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	sc.File = file
+	return sc, nil
+}
+
+// Parse parses a schema from JSON or YAML
+func Parse(data []byte) (*Schema, error) {
+	var root interface{}
+	if err := yamlv3.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	switch root.(type) {
+	case map[string]interface{}, bool:
+	default:
+		return nil, fmt.Errorf("a schema must be an object or a boolean")
+	}
+	if err := checkKeywords(root, "#"); err != nil {
+		return nil, err
+	}
+	return &Schema{root: root}, nil
+}
+
+// keywords are the keywords that are checked
+var keywords = map[string]bool{
+	"type": true, "enum": true, "const": true, "required": true, "minItems": true, "maxItems": true,
+	"minLength": true, "maxLength": true, "pattern": true, "minimum": true, "maximum": true, "$ref": true,
+}
+
+// annotations are the keywords that don't constrain a value
+var annotations = map[string]bool{
+	"$schema": true, "$id": true, "id": true, "$comment": true, "title": true, "description": true,
+	"default": true, "examples": true, "readOnly": true, "writeOnly": true, "deprecated": true,
+}
+
+// checkKeywords returns an error for the first keyword in a schema that isn't
+// supported, at is the JSON pointer of the schema for the message
+func checkKeywords(s interface{}, at string) error {
+	sch, ok := s.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	names := make([]string, 0, len(sch))
+	for name := range sch {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		var subs map[string]interface{}
+		switch val := sch[name].(type) {
+		case map[string]interface{}:
+			subs = val
+		case []interface{}:
+			subs = map[string]interface{}{}
+			for i, item := range val {
+				subs[strconv.Itoa(i)] = item
+			}
+		}
+		switch name {
+		case "properties", "patternProperties", "definitions", "$defs", "allOf", "anyOf", "oneOf":
+			keys := make([]string, 0, len(subs))
+			for key := range subs {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				if err := checkKeywords(subs[key], at+"/"+name+"/"+key); err != nil {
+					return err
+				}
+			}
+		case "items", "additionalProperties", "not":
+			if _, list := sch[name].([]interface{}); list {
+				return fmt.Errorf("%s: '%s' as a list is not supported", at, name)
+			}
+			if err := checkKeywords(sch[name], at+"/"+name); err != nil {
+				return err
+			}
+		default:
+			if !keywords[name] && !annotations[name] {
+				return fmt.Errorf("%s: keyword '%s' is not supported", at, name)
+			}
+		}
+	}
+	return nil
+}
+
+// Validate returns the places where doc does not match the schema, sorted by path
+func (sc *Schema) Validate(doc interface{}, opts Options) []Violation {
+	v := validator{root: sc.root, opts: opts}
+	v.check(sc.root, doc, nil)
+	sort.SliceStable(v.violations, func(i, j int) bool {
+		return strings.Join(v.violations[i].Path, "\x00") < strings.Join(v.violations[j].Path, "\x00")
+	})
+	return v.violations
+}
+
+// String formats a violation with the path joined by join
+func (v Violation) String(join func(path []string) string) string {
+	if len(v.Path) == 0 {
+		return v.Msg
+	}
+	return fmt.Sprintf("'%s' %s", join(v.Path), v.Msg)
+}
+
+type validator struct {
+	root       interface{}
+	opts       Options
+	violations []Violation
+}
+
+func (v *validator) fail(path []string, format string, args ...interface{}) {
+	v.violations = append(v.violations, Violation{Path: append([]string{}, path...), Msg: fmt.Sprintf(format, args...)})
+}
+
+// valid checks val against s without recording violations
+func (v *validator) valid(s interface{}, val interface{}, path []string) bool {
+	sub := validator{root: v.root, opts: v.opts}
+	sub.check(s, val, path)
+	return len(sub.violations) == 0
+}
+
+func (v *validator) check(s interface{}, val interface{}, path []string) {
+	switch sch := s.(type) {
+	case bool:
+		if !sch {
+			v.fail(path, "is not allowed")
+		}
+		return
+	case map[string]interface{}:
+		if ref, ok := sch["$ref"].(string); ok {
+			target, err := v.resolve(ref)
+			if err != nil {
+				v.fail(path, "%s", err)
+				return
+			}
+			v.check(target, val, path)
+		}
+		v.checkCombinators(sch, val, path)
+		if v.opts.Opaque != nil && v.opts.Opaque(val) {
+			return
+		}
+		if !v.checkType(sch, val, path) {
+			return
+		}
+		v.checkValue(sch, val, path)
+		switch typed := val.(type) {
+		case map[string]interface{}:
+			v.checkObject(sch, typed, path)
+		case []interface{}:
+			v.checkArray(sch, typed, path)
+		case string:
+			v.checkString(sch, typed, path)
+		}
+		if n, ok := number(val); ok {
+			v.checkNumber(sch, n, path)
+		}
+	}
+}
+
+func (v *validator) resolve(ref string) (interface{}, error) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, fmt.Errorf("$ref '%s' is not supported, only references within the schema are", ref)
+	}
+	node := v.root
+	for _, part := range strings.Split(strings.TrimPrefix(strings.TrimPrefix(ref, "#"), "/"), "/") {
+		if part == "" {
+			continue
+		}
+		part = strings.Replace(strings.Replace(part, "~1", "/", -1), "~0", "~", -1)
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("$ref '%s' not found", ref)
+		}
+		if node, ok = m[part]; !ok {
+			return nil, fmt.Errorf("$ref '%s' not found", ref)
+		}
+	}
+	return node, nil
+}
+
+func (v *validator) checkCombinators(sch map[string]interface{}, val interface{}, path []string) {
+	if all, ok := sch["allOf"].([]interface{}); ok {
+		for _, s := range all {
+			v.check(s, val, path)
+		}
+	}
+	if anyOf, ok := sch["anyOf"].([]interface{}); ok {
+		matched := false
+		for _, s := range anyOf {
+			if v.valid(s, val, path) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			v.fail(path, "does not match any of the schemas in anyOf")
+		}
+	}
+	if oneOf, ok := sch["oneOf"].([]interface{}); ok {
+		count := 0
+		for _, s := range oneOf {
+			if v.valid(s, val, path) {
+				count++
+			}
+		}
+		if count != 1 {
+			v.fail(path, "matches %d of the schemas in oneOf, not exactly one", count)
+		}
+	}
+	if not, ok := sch["not"]; ok && v.valid(not, val, path) {
+		v.fail(path, "matches the schema in not")
+	}
+}
+
+// checkType returns false when the type is wrong, so that no more checks are made
+func (v *validator) checkType(sch map[string]interface{}, val interface{}, path []string) bool {
+	var types []string
+	switch t := sch["type"].(type) {
+	case string:
+		types = []string{t}
+	case []interface{}:
+		for _, item := range t {
+			if s, ok := item.(string); ok {
+				types = append(types, s)
+			}
+		}
+	default:
+		return true
+	}
+	for _, t := range types {
+		if hasType(val, t) {
+			return true
+		}
+	}
+	v.fail(path, "should be %s, not %s", strings.Join(types, " or "), typeName(val))
+	return false
+}
+
+func (v *validator) checkValue(sch map[string]interface{}, val interface{}, path []string) {
+	if c, ok := sch["const"]; ok && !equal(c, val) {
+		v.fail(path, "should be %v", c)
+	}
+	if enum, ok := sch["enum"].([]interface{}); ok {
+		for _, e := range enum {
+			if equal(e, val) {
+				return
+			}
+		}
+		var values []string
+		for _, e := range enum {
+			values = append(values, fmt.Sprintf("%v", e))
+		}
+		v.fail(path, "should be one of %s", strings.Join(values, ", "))
+	}
+}
+
+func (v *validator) checkObject(sch map[string]interface{}, obj map[string]interface{}, path []string) {
+	if required, ok := sch["required"].([]interface{}); ok {
+		for _, r := range required {
+			if key, ok := r.(string); ok {
+				if _, found := obj[key]; !found {
+					v.fail(append(path, key), "is required")
+				}
+			}
+		}
+	}
+	props, _ := sch["properties"].(map[string]interface{})
+	patterns, _ := sch["patternProperties"].(map[string]interface{})
+	additional, hasAdditional := sch["additionalProperties"]
+
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		child := append(append([]string{}, path...), key)
+		matched := false
+		if s, ok := props[key]; ok {
+			matched = true
+			v.check(s, obj[key], child)
+		}
+		for pattern, s := range patterns {
+			re, err := regexp.Compile(pattern)
+			if err != nil {
+				v.fail(path, "bad pattern '%s': %s", pattern, err)
+				continue
+			}
+			if re.MatchString(key) {
+				matched = true
+				v.check(s, obj[key], child)
+			}
+		}
+		if !matched && hasAdditional {
+			if allowed, ok := additional.(bool); ok && !allowed {
+				v.fail(child, "is not an allowed key")
+				continue
+			}
+			v.check(additional, obj[key], child)
+		}
+	}
+}
+
+func (v *validator) checkArray(sch map[string]interface{}, arr []interface{}, path []string) {
+	if min, ok := number(sch["minItems"]); ok && float64(len(arr)) < min {
+		v.fail(path, "should have at least %v items, has %d", min, len(arr))
+	}
+	if max, ok := number(sch["maxItems"]); ok && float64(len(arr)) > max {
+		v.fail(path, "should have at most %v items, has %d", max, len(arr))
+	}
+	if items, ok := sch["items"]; ok {
+		for i, item := range arr {
+			v.check(items, item, append(append([]string{}, path...), strconv.Itoa(i)))
+		}
+	}
+}
+
+func (v *validator) checkString(sch map[string]interface{}, str string, path []string) {
+	length := float64(len([]rune(str)))
+	if min, ok := number(sch["minLength"]); ok && length < min {
+		v.fail(path, "should be at least %v characters long", min)
+	}
+	if max, ok := number(sch["maxLength"]); ok && length > max {
+		v.fail(path, "should be at most %v characters long", max)
+	}
+	if pattern, ok := sch["pattern"].(string); ok {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			v.fail(path, "bad pattern '%s': %s", pattern, err)
+		} else if !re.MatchString(str) {
+			v.fail(path, "should match '%s'", pattern)
+		}
+	}
+}
+
+func (v *validator) checkNumber(sch map[string]interface{}, n float64, path []string) {
+	if min, ok := number(sch["minimum"]); ok && n < min {
+		v.fail(path, "should be at least %v", min)
+	}
+	if max, ok := number(sch["maximum"]); ok && n > max {
+		v.fail(path, "should be at most %v", max)
+	}
+}
+
+// hasType checks a value against a JSON Schema type, values are strings once they
+// have been decrypted, so a string that reads as a number or boolean is one
+func hasType(val interface{}, t string) bool {
+	switch t {
+	case "object":
+		switch val.(type) {
+		case map[string]interface{}, map[interface{}]interface{}:
+			return true
+		}
+	case "array":
+		_, ok := val.([]interface{})
+		return ok
+	case "string":
+		_, ok := val.(string)
+		return ok
+	case "null":
+		return val == nil
+	case "boolean":
+		switch b := val.(type) {
+		case bool:
+			return true
+		case string:
+			return b == "true" || b == "false"
+		}
+	case "number":
+		_, ok := number(val)
+		return ok
+	case "integer":
+		n, ok := number(val)
+		return ok && n == math.Trunc(n)
+	}
+	return false
+}
+
+func typeName(val interface{}) string {
+	switch val.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}, map[interface{}]interface{}:
+		return "object"
+	}
+	if n, ok := number(val); ok {
+		if n == math.Trunc(n) {
+			return "integer"
+		}
+		return "number"
+	}
+	return fmt.Sprintf("%T", val)
+}
+
+// number returns a numeric value (or a string that reads as one) as a float64
+func number(val interface{}) (float64, bool) {
+	switch n := val.(type) {
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float64:
+		return n, true
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func equal(a interface{}, b interface{}) bool {
+	if x, ok := number(a); ok {
+		if _, str := a.(string); !str {
+			y, ok := number(b)
+			return ok && x == y
+		}
+	}
+	return reflect.DeepEqual(a, b) || fmt.Sprintf("%v", a) == fmt.Sprintf("%v", b)
+}
@@ -0,0 +1,184 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package shamir splits a secret into shares using Shamir's secret sharing over GF(2^8),
+// any threshold of the shares recovers the secret and fewer reveal nothing about it
+package shamir
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SharePrefix starts each encoded share
+const SharePrefix = "gsp-share"
+
+// checksumSize is the number of bytes of the SHA-256 sum kept with the secret,
+// so that combining the wrong shares is an error rather than garbage
+const checksumSize = 8
+
+var expTable [510]byte
+var logTable [256]byte
+
+func init() {
+	// 3 generates the multiplicative group of GF(2^8) with the AES polynomial
+	x := byte(1)
+	for i := 0; i < 255; i++ {
+		expTable[i] = x
+		expTable[i+255] = x
+		logTable[x] = byte(i)
+		x ^= xtime(x)
+	}
+}
+
+// xtime multiplies by 2 in GF(2^8)
+func xtime(b byte) byte {
+	if b&0x80 != 0 {
+		return b<<1 ^ 0x1b
+	}
+	return b << 1
+}
+
+func mul(a byte, b byte) byte {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	return expTable[int(logTable[a])+int(logTable[b])]
+}
+
+func div(a byte, b byte) byte {
+	if a == 0 {
+		return 0
+	}
+	return expTable[int(logTable[a])+255-int(logTable[b])]
+}
+
+// Split returns parts shares of secret, threshold of which are needed to recover it,
+// the last byte of each share is its x coordinate
+func Split(secret []byte, parts int, threshold int) ([][]byte, error) {
+	switch {
+	case len(secret) == 0:
+		return nil, fmt.Errorf("cannot split an empty secret")
+	case threshold < 2:
+		return nil, fmt.Errorf("threshold must be at least 2")
+	case parts < threshold:
+		return nil, fmt.Errorf("shares (%d) must be at least the threshold (%d)", parts, threshold)
+	case parts > 255:
+		return nil, fmt.Errorf("shares must be at most 255")
+	}
+
+	sum := sha256.Sum256(secret)
+	secret = append(append([]byte{}, secret...), sum[:checksumSize]...)
+
+	shares := make([][]byte, parts)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret)+1)
+		shares[i][len(secret)] = byte(i + 1)
+	}
+
+	coefficients := make([]byte, threshold)
+	for n, b := range secret {
+		coefficients[0] = b
+		if _, err := rand.Read(coefficients[1:]); err != nil {
+			return nil, fmt.Errorf("unable to read random data: %s", err)
+		}
+		for i := range shares {
+			// Horner's method
+			x := byte(i + 1)
+			y := byte(0)
+			for c := threshold - 1; c >= 0; c-- {
+				y = mul(y, x) ^ coefficients[c]
+			}
+			shares[i][n] = y
+		}
+	}
+
+	return shares, nil
+}
+
+// Combine recovers the secret from at least the threshold number of shares
+func Combine(shares [][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, fmt.Errorf("at least 2 shares are needed")
+	}
+	size := len(shares[0])
+	xs := make([]byte, len(shares))
+	seen := make(map[byte]bool)
+	for i, share := range shares {
+		if len(share) != size || size <= checksumSize+1 {
+			return nil, fmt.Errorf("share %d is not the same length as the others", i+1)
+		}
+		xs[i] = share[size-1]
+		if xs[i] == 0 || seen[xs[i]] {
+			return nil, fmt.Errorf("share %d is a duplicate or invalid", i+1)
+		}
+		seen[xs[i]] = true
+	}
+
+	secret := make([]byte, size-1)
+	for n := range secret {
+		// Lagrange interpolation at x = 0
+		var y byte
+		for i, share := range shares {
+			basis := byte(1)
+			for j := range shares {
+				if i != j {
+					basis = mul(basis, div(xs[j], xs[j]^xs[i]))
+				}
+			}
+			y ^= mul(share[n], basis)
+		}
+		secret[n] = y
+	}
+
+	secret, checksum := secret[:len(secret)-checksumSize], secret[len(secret)-checksumSize:]
+	sum := sha256.Sum256(secret)
+	if !bytes.Equal(sum[:checksumSize], checksum) {
+		return nil, fmt.Errorf("the shares do not combine to a valid secret (too few shares, or shares of different secrets)")
+	}
+	return secret, nil
+}
+
+// Encode formats a share as text, recording the threshold needed to combine it
+func Encode(share []byte, threshold int) string {
+	return fmt.Sprintf("%s:%d:%s", SharePrefix, threshold, base64.StdEncoding.EncodeToString(share))
+}
+
+// Decode parses an encoded share, returning it and its threshold
+func Decode(text string) ([]byte, int, error) {
+	parts := strings.Split(strings.TrimSpace(text), ":")
+	if len(parts) != 3 || parts[0] != SharePrefix {
+		return nil, 0, fmt.Errorf("not a share, expected %s:<threshold>:<data>", SharePrefix)
+	}
+	threshold, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid share threshold '%s'", parts[1])
+	}
+	share, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid share data: %s", err)
+	}
+	return share, threshold, nil
+}
@@ -0,0 +1,81 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package sls
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// ACLKey is the sibling key naming the teams that may read the values in a map: a list
+// of teams applies to every value in the map (and the maps under it), a map of names to
+// lists applies to the value (or the map) with that name, the nearest one applies
+const ACLKey = "__acl"
+
+// ACLs returns the teams that may read each encrypted value that has an __acl, by path
+func (s *Sls) ACLs() map[string][]string {
+	acls := map[string][]string{}
+	collectACLs("", s.Yaml.Values, nil, acls)
+	return acls
+}
+
+func collectACLs(path string, val interface{}, teams []string, acls map[string][]string) {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		named := map[string][]string{}
+		switch acl := v[ACLKey].(type) {
+		case []interface{}:
+			teams = aclTeams(acl)
+		case map[string]interface{}:
+			for name, list := range acl {
+				if l, ok := list.([]interface{}); ok {
+					named[name] = aclTeams(l)
+				}
+			}
+		}
+		for key, item := range v {
+			if IsMetaKey(key) {
+				continue
+			}
+			itemTeams := teams
+			if t, ok := named[key]; ok {
+				itemTeams = t
+			}
+			collectACLs(JoinPath(path, key), item, itemTeams, acls)
+		}
+	case []interface{}:
+		for i, item := range v {
+			collectACLs(JoinPath(path, strconv.Itoa(i)), item, teams, acls)
+		}
+	case string:
+		if teams != nil && isEncrypted(v) {
+			acls[path] = teams
+		}
+	}
+}
+
+func aclTeams(list []interface{}) []string {
+	teams := make([]string, 0, len(list))
+	for _, team := range list {
+		teams = append(teams, fmt.Sprintf("%v", team))
+	}
+	return teams
+}
@@ -0,0 +1,135 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package sls
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Everbridge/generate-secure-pillar/metrics"
+)
+
+// ChunksKey is the sibling key listing values that were split across list items
+// when encrypted, each item is encrypted separately and decrypt joins them again
+const ChunksKey = "__chunks"
+
+func (s *Sls) shouldChunk(val interface{}) bool {
+	str, ok := val.(string)
+	return ok && s.Options.ChunkSize > 0 && len(str) > s.Options.ChunkSize && !isEncrypted(str)
+}
+
+// checkSize warns about values that some renderers may truncate once armored
+func (s *Sls) checkSize(val string) {
+	if s.Options.MaxValueSize > 0 && len(val) > s.Options.MaxValueSize {
+		logger.Warnf("%s: a %d byte value is larger than the %d byte limit, consider using --chunk-size",
+			shortFileName(s.FilePath), len(val), s.Options.MaxValueSize)
+	}
+}
+
+// encryptChunks splits a value into ChunkSize pieces, encrypts each of them,
+// and records the number of pieces in the __chunks map of ret
+func (s *Sls) encryptChunks(key string, val string, ret map[string]interface{}) ([]interface{}, error) {
+	var chunks []interface{}
+
+	for start := 0; start < len(val); start += s.Options.ChunkSize {
+		end := start + s.Options.ChunkSize
+		if end > len(val) {
+			end = len(val)
+		}
+		cipherText, err := s.Pki.EncryptSecret(EncodeValue(val[start:end]))
+		if err != nil {
+			metrics.Inc(metrics.Failures)
+			return chunks, err
+		}
+		metrics.Inc(metrics.ValuesEncrypted)
+		s.emit(Event{Kind: ValueEncrypted})
+		chunks = append(chunks, cipherText)
+	}
+
+	meta, ok := ret[ChunksKey].(map[string]interface{})
+	if !ok {
+		meta = map[string]interface{}{}
+		ret[ChunksKey] = meta
+	}
+	meta[key] = len(chunks)
+
+	return chunks, nil
+}
+
+// readChunks decrypts and joins the chunked values of a map when decrypting, setting them
+// in ret, the keys handled (including the __chunks map itself, which is dropped) are returned,
+// for other actions a copy of the __chunks map is put in ret so that chunks can be added to it
+func (s *Sls) readChunks(vals map[string]interface{}, action string, ret map[string]interface{}) (map[string]bool, error) {
+	handled := map[string]bool{}
+
+	meta, ok := vals[ChunksKey].(map[string]interface{})
+	if !ok {
+		return handled, nil
+	}
+	if action != Decrypt {
+		if action != Validate {
+			copied := make(map[string]interface{}, len(meta))
+			for k, v := range meta {
+				copied[k] = v
+			}
+			ret[ChunksKey] = copied
+		}
+		return handled, nil
+	}
+
+	for key := range meta {
+		items, ok := vals[key].([]interface{})
+		if !ok {
+			return handled, fmt.Errorf("%s is listed in %s but is not a list", key, ChunksKey)
+		}
+		var joined strings.Builder
+		for _, item := range items {
+			plainText, err := s.decryptVal(fmt.Sprintf("%v", item))
+			if err != nil {
+				return handled, err
+			}
+			joined.WriteString(plainText)
+		}
+		ret[key] = joined.String()
+		handled[key] = true
+	}
+	handled[ChunksKey] = true
+
+	return handled, nil
+}
+
+// setChunks encrypts a value in chunks and sets it at the given path
+func (s *Sls) setChunks(path string, val string) error {
+	parts := s.splitPath(path)
+	name := parts[len(parts)-1]
+
+	meta := map[string]interface{}{}
+	chunks, err := s.encryptChunks(name, val, meta)
+	if err != nil {
+		return err
+	}
+
+	if err = s.setParts(parts, chunks); err != nil {
+		return err
+	}
+	metaParts := append(parts[:len(parts)-1:len(parts)-1], ChunksKey, name)
+	return s.setParts(metaParts, len(chunks))
+}
@@ -0,0 +1,77 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package sls
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+
+	"github.com/Everbridge/generate-secure-pillar/pki"
+)
+
+// conflictMarkers are the lines git writes around the two sides of a merge conflict
+var conflictMarkers = []string{"<<<<<<<", "|||||||", "=======", ">>>>>>>"}
+
+// checkConflicts returns an ErrMergeConflict for the first merge conflict marker in a file, a
+// marker is at the start of a line, or indented (e.g. by an editor) and followed by a branch name
+func (s *Sls) checkConflicts(buf []byte) error {
+	scanner := bufio.NewScanner(bytes.NewReader(buf))
+	scanner.Buffer(make([]byte, 64*1024), s.maxLineSize())
+	inArmor := false
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case strings.Contains(trimmed, pki.PGPHeader):
+			inArmor = true
+		case strings.Contains(trimmed, "-----END PGP MESSAGE-----"):
+			inArmor = false
+		}
+		marker := conflictMarker(line, trimmed)
+		if marker == "" {
+			continue
+		}
+		where := ""
+		if inArmor {
+			where = " inside an encrypted value"
+		}
+		return pki.Errorf(ErrMergeConflict, "%s: line %d: merge conflict marker '%s'%s", shortFileName(s.FilePath), n, marker, where)
+	}
+	return nil
+}
+
+func conflictMarker(line string, trimmed string) string {
+	for _, marker := range conflictMarkers {
+		if !strings.HasPrefix(trimmed, marker) {
+			continue
+		}
+		rest := trimmed[len(marker):]
+		if strings.HasPrefix(line, marker) && (rest == "" || rest[0] == ' ') {
+			return marker
+		}
+		// indented, only the markers that name a side are told apart from text
+		if marker != "=======" && strings.HasPrefix(rest, " ") && strings.TrimSpace(rest) != "" {
+			return marker
+		}
+	}
+	return ""
+}
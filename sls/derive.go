@@ -0,0 +1,147 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package sls
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/Everbridge/generate-secure-pillar/metrics"
+)
+
+// DeriveKey is the sibling key under which the templates of derived values in a map are kept,
+// e.g. "dsn: postgres://app:{{db:password}}@{{db:host}}/app" under db:__derive sets db:dsn
+const DeriveKey = "__derive"
+
+// templateRef is a {{path}} reference to another value in a template
+var templateRef = regexp.MustCompile(`\{\{\s*(.*?)\s*\}\}`)
+
+// Derived returns the template of each derived value in the document, by path
+func (s *Sls) Derived() map[string]string {
+	templates := make(map[string]string)
+	collectDerived("", s.Yaml.Values, templates)
+	return templates
+}
+
+func collectDerived(prefix string, vals map[string]interface{}, templates map[string]string) {
+	for key, val := range vals {
+		m, ok := val.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if key != DeriveKey {
+			collectDerived(JoinPath(prefix, key), m, templates)
+			continue
+		}
+		for name, tmpl := range m {
+			if t, ok := tmpl.(string); ok {
+				templates[JoinPath(prefix, name)] = t
+			}
+		}
+	}
+}
+
+// TemplateRefs returns the paths a template refers to
+func TemplateRefs(tmpl string) []string {
+	var refs []string
+	for _, m := range templateRef.FindAllStringSubmatch(tmpl, -1) {
+		refs = append(refs, m[1])
+	}
+	return refs
+}
+
+// Derive assembles each derived value from its template and encrypts it, the values the
+// template refers to are decrypted first. With changed only the values whose templates
+// refer to one of those paths (or a value under one) are derived, all of them otherwise.
+func (s *Sls) Derive(changed []string) ([]string, error) {
+	templates := s.Derived()
+	paths := make([]string, 0, len(templates))
+	for p := range templates {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	var derived []string
+	for _, p := range paths {
+		if changed != nil && !refersTo(templates[p], changed) {
+			continue
+		}
+		plainText, err := s.expandTemplate(p, templates)
+		if err != nil {
+			return derived, err
+		}
+		cipherText, err := s.Pki.EncryptSecret(EncodeValue(plainText))
+		if err != nil {
+			metrics.Inc(metrics.Failures)
+			return derived, fmt.Errorf("%s: %s", p, err)
+		}
+		metrics.Inc(metrics.ValuesEncrypted)
+		s.emit(Event{Kind: ValueEncrypted, Path: p})
+		if err = s.setParts(SplitPath(p), cipherText); err != nil {
+			return derived, fmt.Errorf("%s: %s", p, err)
+		}
+		derived = append(derived, p)
+	}
+	return derived, nil
+}
+
+// expandTemplate replaces the references in the template of the value at path with the values they refer to
+func (s *Sls) expandTemplate(path string, templates map[string]string) (string, error) {
+	var err error
+	out := templateRef.ReplaceAllStringFunc(templates[path], func(ref string) string {
+		if err != nil {
+			return ""
+		}
+		name := templateRef.FindStringSubmatch(ref)[1]
+		if _, ok := templates[name]; ok {
+			err = fmt.Errorf("%s: {{%s}} is a derived value, templates can only refer to values that are set", path, name)
+			return ""
+		}
+		val, ok := s.lookup(SplitPath(name))
+		if !ok {
+			err = fmt.Errorf("%s: {{%s}}: %s", path, name, s.PathError(name))
+			return ""
+		}
+		if val == nil || reflect.TypeOf(val).Kind() == reflect.Map || reflect.TypeOf(val).Kind() == reflect.Slice {
+			err = fmt.Errorf("%s: {{%s}} is not a single value", path, name)
+			return ""
+		}
+		var plainText string
+		plainText, err = s.decryptVal(fmt.Sprintf("%v", val))
+		return plainText
+	})
+	return out, err
+}
+
+// refersTo returns true when a template refers to one of the paths, or a value under one
+func refersTo(tmpl string, paths []string) bool {
+	for _, ref := range TemplateRefs(tmpl) {
+		for _, p := range paths {
+			if ref == p || strings.HasPrefix(ref, p+PathDelimiter) {
+				return true
+			}
+		}
+	}
+	return false
+}
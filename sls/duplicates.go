@@ -0,0 +1,87 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package sls
+
+import (
+	"strconv"
+
+	"github.com/Everbridge/generate-secure-pillar/pki"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// duplicateKey is a key given twice in the same map, the one on line is kept and the one on dropped isn't
+type duplicateKey struct {
+	path    string
+	line    int
+	dropped int
+}
+
+// dropDuplicateKeys removes all but the last of each key given more than once in
+// a map, as most YAML parsers do, and returns the keys that were removed
+func dropDuplicateKeys(path string, n *yamlv3.Node) []duplicateKey {
+	var found []duplicateKey
+	switch n.Kind {
+	case yamlv3.DocumentNode:
+		for _, c := range n.Content {
+			found = append(found, dropDuplicateKeys(path, c)...)
+		}
+	case yamlv3.MappingNode:
+		last := map[string]int{}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if !isMergeKey(n.Content[i]) {
+				last[n.Content[i].Value] = i
+			}
+		}
+		var content []*yamlv3.Node
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, val := n.Content[i], n.Content[i+1]
+			if j, ok := last[key.Value]; ok && j != i {
+				found = append(found, duplicateKey{JoinPath(path, key.Value), n.Content[j].Line, key.Line})
+				continue
+			}
+			content = append(content, key, val)
+			found = append(found, dropDuplicateKeys(JoinPath(path, key.Value), val)...)
+		}
+		n.Content = content
+	case yamlv3.SequenceNode:
+		for i, c := range n.Content {
+			found = append(found, dropDuplicateKeys(JoinPath(path, strconv.Itoa(i)), c)...)
+		}
+	}
+	return found
+}
+
+// checkDuplicateKeys warns about each key given more than once in a map, only the last
+// one is kept, or fails with an ErrDuplicateKey with the FailOnDuplicateKeys option
+func (s *Sls) checkDuplicateKeys(doc *yamlv3.Node) error {
+	for _, d := range dropDuplicateKeys("", doc) {
+		if s.Options.FailOnDuplicateKeys {
+			return pki.Errorf(ErrDuplicateKey, "%s: line %d: duplicate key %s, it is given again on line %d",
+				shortFileName(s.FilePath), d.dropped, d.path, d.line)
+		}
+		err := s.classWarnf(ErrDuplicateKey, "%s: line %d: duplicate key %s, the value on line %d is used and this one is dropped when the file is written",
+			shortFileName(s.FilePath), d.dropped, d.path, d.line)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package sls
+
+import (
+	"errors"
+)
+
+// failure classes of the errors returned by this package, along with pki.ErrKeyNotFound and
+// pki.ErrWrongKey, an error of a class matches it with errors.Is (see pki.Error)
+var (
+	// ErrNotEncrypted is returned when a value that should be encrypted is plain text
+	ErrNotEncrypted = errors.New("value is not encrypted")
+	// ErrIncludeSkipped is returned in strict mode for a file with include directives,
+	// which is otherwise skipped (see Sls.IsInclude)
+	ErrIncludeSkipped = errors.New("file with include directives skipped")
+	// ErrMergeConflict is returned for a file with git merge conflict markers in it
+	ErrMergeConflict = errors.New("merge conflict")
+	// ErrDuplicateKey is returned for a key given more than once in the same map
+	// with the FailOnDuplicateKeys option, or in strict mode
+	ErrDuplicateKey = errors.New("duplicate key")
+)
@@ -0,0 +1,69 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package sls
+
+// the kinds of events sent to an EventSink
+const (
+	// FileStarted is sent before an action is applied to a file
+	FileStarted = "file_started"
+	// FileFinished is sent after an action was applied to a file, with the error if it failed
+	FileFinished = "file_finished"
+	// ValueEncrypted is sent for each value (or chunk of a value) encrypted
+	ValueEncrypted = "value_encrypted"
+	// ValueDecrypted is sent for each value decrypted
+	ValueDecrypted = "value_decrypted"
+	// EventError is sent when a file can't be read or an action fails for it
+	EventError = "error"
+)
+
+// Event is something that happened while a file was processed, Action is set for
+// the file events and Path for the value events when the path of the value is known
+type Event struct {
+	Kind   string
+	File   string
+	Action string
+	Path   string
+	Err    error
+}
+
+// EventSink receives the events of the files processed with the Events option, e.g. for a
+// progress bar or an audit trail, events for several files are sent at once when a directory
+// is processed, so a sink must be safe for concurrent use
+type EventSink interface {
+	Event(e Event)
+}
+
+// EventFunc is an EventSink that calls a function for each event
+type EventFunc func(e Event)
+
+// Event calls f with the event
+func (f EventFunc) Event(e Event) {
+	f(e)
+}
+
+// emit sends an event for the file to the Events option, if there is one
+func (s *Sls) emit(e Event) {
+	if s.Options.Events == nil {
+		return
+	}
+	e.File = s.FilePath
+	s.Options.Events.Event(e)
+}
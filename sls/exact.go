@@ -0,0 +1,390 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package sls
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Everbridge/generate-secure-pillar/pki"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// exactEdit replaces the bytes from start to end of the file as it was read
+type exactEdit struct {
+	start int
+	end   int
+	text  string
+}
+
+// spliceValues returns the file as it was read with the text of each value that changed replaced, and
+// the renderer line added or replaced when Salt wouldn't decrypt the values with it, the result is
+// read back and compared with the values so nothing else can have changed
+func (s *Sls) spliceValues() ([]byte, error) {
+	if s.raw == nil {
+		return nil, fmt.Errorf("the file wasn't read")
+	}
+	var doc yamlv3.Node
+	if err := yamlv3.Unmarshal(s.raw, &doc); err != nil {
+		return nil, err
+	}
+	var edits []exactEdit
+	lines := newLineIndex(s.raw)
+	if err := s.exactEdits(lines, "", -1, false, &doc, &edits); err != nil {
+		return nil, err
+	}
+	if s.RendererProblem() != "" {
+		// last, so it goes before a comment inserted at the start of the file
+		renderer := fmt.Sprintf("#!yaml|%s", s.Pki.Renderer())
+		if s.renderer != "" {
+			edits = append(edits, exactEdit{0, lines.lineEnd(0), renderer})
+		} else {
+			edits = append(edits, exactEdit{0, 0, renderer + s.newline() + s.newline()})
+		}
+	}
+
+	sort.SliceStable(edits, func(i, j int) bool { return edits[i].start > edits[j].start })
+	out := append([]byte{}, s.raw...)
+	for _, e := range edits {
+		out = append(out[:e.start], append([]byte(e.text), out[e.end:]...)...)
+	}
+
+	var check yamlv3.Node
+	if err := yamlv3.Unmarshal(out, &check); err != nil {
+		return nil, fmt.Errorf("the replaced values don't parse: %s", err)
+	}
+	var values map[string]interface{}
+	if check.Kind != 0 {
+		resolved, err := resolveNode(&check)
+		if err != nil {
+			return nil, err
+		}
+		if err = resolved.Decode(&values); err != nil {
+			return nil, err
+		}
+	}
+	if !reflect.DeepEqual(values, s.Yaml.Values) {
+		return nil, fmt.Errorf("the changes aren't only to existing values, e.g. keys were added or removed")
+	}
+	return out, nil
+}
+
+// exactEdits collects an edit for each scalar whose value changed, indent is the column that the
+// lines of a block scalar at this level must be right of and flow is true inside brackets or braces
+func (s *Sls) exactEdits(lines lineIndex, path string, indent int, flow bool, n *yamlv3.Node, edits *[]exactEdit) error {
+	flow = flow || n.Style&yamlv3.FlowStyle != 0
+	switch n.Kind {
+	case yamlv3.DocumentNode:
+		for _, c := range n.Content {
+			if err := s.exactEdits(lines, path, indent, flow, c, edits); err != nil {
+				return err
+			}
+		}
+	case yamlv3.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key := n.Content[i]
+			if key.Value == "<<" {
+				// merged values are checked when the result is read back
+				continue
+			}
+			if err := s.exactEdits(lines, JoinPath(path, key.Value), key.Column-1, flow, n.Content[i+1], edits); err != nil {
+				return err
+			}
+		}
+	case yamlv3.SequenceNode:
+		for i, c := range n.Content {
+			if err := s.exactEdits(lines, JoinPath(path, strconv.Itoa(i)), n.Column-1, flow, c, edits); err != nil {
+				return err
+			}
+		}
+	case yamlv3.ScalarNode:
+		if path == "" {
+			return nil
+		}
+		val, ok := s.lookup(SplitPath(path))
+		if !ok {
+			return fmt.Errorf("%s was removed", path)
+		}
+		var old interface{}
+		if err := n.Decode(&old); err == nil && reflect.DeepEqual(old, val) {
+			return nil
+		}
+		return s.exactEdit(lines, path, indent, flow, n, val, edits)
+	}
+	return nil
+}
+
+// exactEdit adds the edit replacing a scalar with its new value
+func (s *Sls) exactEdit(lines lineIndex, path string, indent int, flow bool, n *yamlv3.Node, val interface{}, edits *[]exactEdit) error {
+	switch {
+	case n.Anchor != "":
+		return fmt.Errorf("%s on line %d has an anchor", path, n.Line)
+	case flow:
+		return fmt.Errorf("%s on line %d is in a flow collection", path, n.Line)
+	}
+	start := lines.offset(n.Line, n.Column)
+	first, end, contentIndent, err := lines.scalarEnd(start, indent, n)
+	if err != nil {
+		return fmt.Errorf("%s on line %d: %s", path, n.Line, err)
+	}
+	if contentIndent < 0 {
+		step := s.Options.Indent
+		if step == 0 {
+			step = DefaultIndent
+		}
+		contentIndent = indent + step
+	}
+
+	text, err := s.exactScalar(path, val, contentIndent)
+	if err != nil {
+		return err
+	}
+	*edits = append(*edits, s.hashCommentEdits(lines, n, val)...)
+	if strings.TrimSpace(string(lines.rest(first))) == "" {
+		*edits = append(*edits, exactEdit{start, end, text})
+		return nil
+	}
+
+	// a comment after the value, or after the header of a block scalar, stays where it
+	// is, the lines of a block scalar go after it and the rest of the old value is removed
+	header := strings.SplitN(text, "\n", 2)
+	header[0] = strings.TrimSuffix(header[0], "\r")
+	rest := ""
+	if len(header) == 2 {
+		if !strings.HasPrefix(header[0], "|") && !strings.HasPrefix(header[0], ">") {
+			return fmt.Errorf("%s on line %d is followed by a comment", path, n.Line)
+		}
+		rest = text[len(header[0]):]
+	}
+	lineEnd := lines.lineEnd(first)
+	if end < lineEnd {
+		end = lineEnd
+	}
+	*edits = append(*edits, exactEdit{start, first, header[0]}, exactEdit{lineEnd, end, rest})
+	return nil
+}
+
+// exactScalar returns the text a value is written as when its lines start at indent
+func (s *Sls) exactScalar(path string, val interface{}, indent int) (string, error) {
+	switch val.(type) {
+	case map[string]interface{}, []interface{}:
+		return "", fmt.Errorf("%s is no longer a single value", path)
+	}
+	n, err := marshaler{opts: s.Options, styles: s.styleHints()}.toNode(marshalPath(path), val)
+	if err != nil {
+		return "", err
+	}
+	n.HeadComment = ""
+
+	var buffer bytes.Buffer
+	enc := yamlv3.NewEncoder(&buffer)
+	enc.SetIndent(2)
+	if err = enc.Encode(n); err != nil {
+		return "", err
+	}
+	if err = enc.Close(); err != nil {
+		return "", err
+	}
+
+	out := strings.Split(strings.TrimSuffix(buffer.String(), "\n"), "\n")
+	for i := 1; i < len(out); i++ {
+		if out[i] != "" {
+			out[i] = strings.Repeat(" ", indent) + strings.TrimPrefix(out[i], "  ")
+		}
+	}
+	return strings.Join(out, s.newline()), nil
+}
+
+// hashCommentEdits add the hash comment of a new encrypted value with the HashComments option on the
+// line before it, where one from an earlier run is replaced, or removed when there is no new one
+func (s *Sls) hashCommentEdits(lines lineIndex, n *yamlv3.Node, val interface{}) []exactEdit {
+	lineStart := lines.offset(n.Line, 1)
+	comment := ""
+	if str, ok := val.(string); ok && s.Options.HashComments && isEncrypted(str) {
+		if s.Options.ArmorWidth > 0 && strings.Contains(str, pki.PGPHeader) {
+			str = wrapArmor(str, s.Options.ArmorWidth)
+		}
+		line := lines.rest(lineStart)
+		comment = strings.Repeat(" ", len(line)-len(bytes.TrimLeft(line, " "))) + "# " + HashComment(str)
+	}
+	if n.Line > 1 {
+		prev := lines.offset(n.Line-1, 1)
+		if bytes.HasPrefix(bytes.TrimSpace(lines.rest(prev)), []byte("# "+HashCommentPrefix)) {
+			if comment == "" {
+				return []exactEdit{{prev, lineStart, ""}}
+			}
+			return []exactEdit{{prev, lines.lineEnd(prev), comment}}
+		}
+	}
+	if comment == "" {
+		return nil
+	}
+	return []exactEdit{{lineStart, lineStart, comment + s.newline()}}
+}
+
+// newline returns the line ending of the file as it was read
+func (s *Sls) newline() string {
+	if s.crlf {
+		return "\r\n"
+	}
+	return "\n"
+}
+
+// lineIndex finds the lines of a file by their starting offsets
+type lineIndex struct {
+	buf    []byte
+	starts []int
+}
+
+func newLineIndex(buf []byte) lineIndex {
+	starts := []int{0}
+	for i, b := range buf {
+		if b == '\n' {
+			starts = append(starts, i+1)
+		}
+	}
+	return lineIndex{buf, starts}
+}
+
+// offset returns the byte offset of a line and column counted from 1, columns are characters
+func (l lineIndex) offset(line int, column int) int {
+	if line < 1 || line > len(l.starts) {
+		return len(l.buf)
+	}
+	i := l.starts[line-1]
+	for c := 1; c < column && i < len(l.buf) && l.buf[i] != '\n'; c++ {
+		_, size := utf8.DecodeRune(l.buf[i:])
+		i += size
+	}
+	return i
+}
+
+// lineEnd returns the offset of the end of the line at offset, before any carriage return
+func (l lineIndex) lineEnd(offset int) int {
+	end := offset
+	for end < len(l.buf) && l.buf[end] != '\n' {
+		end++
+	}
+	if end > offset && l.buf[end-1] == '\r' {
+		end--
+	}
+	return end
+}
+
+// nextLine returns the offset of the start of the line after the one at offset
+func (l lineIndex) nextLine(offset int) int {
+	i := bytes.IndexByte(l.buf[offset:], '\n')
+	if i < 0 {
+		return len(l.buf)
+	}
+	return offset + i + 1
+}
+
+// rest returns what follows offset on its line
+func (l lineIndex) rest(offset int) []byte {
+	return l.buf[offset:l.lineEnd(offset)]
+}
+
+// scalarEnd returns the offsets of the end of the first line of the scalar starting at start (the header
+// of a block scalar) and of its end, and the indentation of the lines of a block scalar (-1 for the other styles)
+func (l lineIndex) scalarEnd(start int, indent int, n *yamlv3.Node) (int, int, int, error) {
+	i := start
+	if i < len(l.buf) && l.buf[i] == '!' {
+		// the tag is replaced along with the value
+		for i < len(l.buf) && l.buf[i] != ' ' && l.buf[i] != '\t' && l.buf[i] != '\n' {
+			i++
+		}
+		for i < len(l.buf) && (l.buf[i] == ' ' || l.buf[i] == '\t') {
+			i++
+		}
+	}
+
+	switch n.Style &^ yamlv3.TaggedStyle {
+	case yamlv3.LiteralStyle, yamlv3.FoldedStyle:
+		header := i
+		for header < len(l.buf) && bytes.IndexByte([]byte("|>+-0123456789"), l.buf[header]) >= 0 {
+			header++
+		}
+		end, contentIndent := l.blockEnd(start, indent)
+		return header, end, contentIndent, nil
+	case yamlv3.DoubleQuotedStyle:
+		for j := i + 1; j < len(l.buf); j++ {
+			switch l.buf[j] {
+			case '\\':
+				j++
+			case '"':
+				return j + 1, j + 1, -1, nil
+			}
+		}
+	case yamlv3.SingleQuotedStyle:
+		for j := i + 1; j < len(l.buf); j++ {
+			if l.buf[j] != '\'' {
+				continue
+			}
+			if j+1 < len(l.buf) && l.buf[j+1] == '\'' {
+				j++
+				continue
+			}
+			return j + 1, j + 1, -1, nil
+		}
+	default:
+		text := l.rest(i)
+		for j := 1; j < len(text); j++ {
+			if text[j] == '#' && (text[j-1] == ' ' || text[j-1] == '\t') {
+				text = text[:j]
+				break
+			}
+		}
+		text = bytes.TrimRight(text, " \t")
+		if string(text) != n.Value {
+			return 0, 0, 0, fmt.Errorf("the value continues on the next line")
+		}
+		return i + len(text), i + len(text), -1, nil
+	}
+	return 0, 0, 0, fmt.Errorf("the closing quote is missing")
+}
+
+// blockEnd returns the end of the last line of the block scalar whose header starts at
+// start, and the indentation of its lines, trailing blank lines aren't part of it
+func (l lineIndex) blockEnd(start int, indent int) (int, int) {
+	end := l.lineEnd(start)
+	contentIndent := -1
+	for next := l.nextLine(start); next < len(l.buf); next = l.nextLine(next) {
+		line := l.buf[next:l.lineEnd(next)]
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		spaces := len(line) - len(bytes.TrimLeft(line, " "))
+		if spaces <= indent {
+			break
+		}
+		if contentIndent < 0 {
+			contentIndent = spaces
+		}
+		end = l.lineEnd(next)
+	}
+	return end, contentIndent
+}
@@ -0,0 +1,97 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package sls
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// FileTag reads a value from a plain text file, e.g. "password: !file secrets/db_password.txt",
+// so that the plain text never has to be in the document
+const FileTag = "!file"
+
+// resolveFiles replaces the values tagged with FileTag by the contents of their files,
+// relative to the document's directory, when the ResolveFiles option is set
+func (s *Sls) resolveFiles(path string, n *yamlv3.Node) error {
+	switch n.Kind {
+	case yamlv3.DocumentNode:
+		for _, c := range n.Content {
+			if err := s.resolveFiles(path, c); err != nil {
+				return err
+			}
+		}
+	case yamlv3.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			if err := s.resolveFiles(JoinPath(path, n.Content[i].Value), n.Content[i+1]); err != nil {
+				return err
+			}
+		}
+	case yamlv3.SequenceNode:
+		for i, c := range n.Content {
+			if err := s.resolveFiles(JoinPath(path, strconv.Itoa(i)), c); err != nil {
+				return err
+			}
+		}
+	case yamlv3.ScalarNode:
+		if n.Tag != FileTag {
+			return nil
+		}
+		if !s.Options.ResolveFiles {
+			return s.Warnf("%s: line %d: %s is only read when encrypting, '%s' is left as it is", shortFileName(s.FilePath), n.Line, FileTag, n.Value)
+		}
+		file := n.Value
+		if !filepath.IsAbs(file) && s.FilePath != "" && s.FilePath != os.Stdin.Name() && !IsRemote(s.FilePath) {
+			file = filepath.Join(filepath.Dir(s.FilePath), file)
+		}
+		buf, err := ioutil.ReadFile(filepath.Clean(file))
+		if err != nil {
+			return fmt.Errorf("line %d: %s %s: %s", n.Line, FileTag, n.Value, err)
+		}
+		// files usually end with a newline that isn't part of the secret
+		n.Value = strings.TrimSuffix(strings.TrimSuffix(string(buf), "\n"), "\r")
+		n.Tag = "!!str"
+		s.fileRefs[path] = n.Value
+	}
+	return nil
+}
+
+// checkFileRefs fails when a value read from a file is about to be written in plain text
+func (s *Sls) checkFileRefs() error {
+	var plain []string
+	for path := range s.fileRefs {
+		if val, ok := s.getParts(SplitPath(path)).(string); ok && !isEncrypted(val) {
+			plain = append(plain, path)
+		}
+	}
+	if len(plain) > 0 {
+		sort.Strings(plain)
+		return fmt.Errorf("%s: %s, read with %s, would be written in plain text", shortFileName(s.FilePath), strings.Join(plain, ", "), FileTag)
+	}
+	return nil
+}
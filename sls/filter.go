@@ -0,0 +1,130 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package sls
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+)
+
+// CheckPathPatterns returns an error for a pattern that path.Match can't use,
+// each part of a pattern is matched against the same part of a path
+func CheckPathPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		for _, part := range SplitPath(pattern) {
+			if _, err := path.Match(part, ""); err != nil {
+				return fmt.Errorf("bad path pattern '%s': %s", pattern, err)
+			}
+		}
+	}
+	return nil
+}
+
+// filtered returns true when the IncludePaths or ExcludePaths options limit an action to part of the document
+func (s *Sls) filtered() bool {
+	return len(s.Options.IncludePaths) > 0 || len(s.Options.ExcludePaths) > 0
+}
+
+// FilteredPaths returns the paths of the subtrees an action is applied to when it is
+// limited by the IncludePaths and ExcludePaths options, under the element if there is one
+func (s *Sls) FilteredPaths() []string {
+	var paths []string
+	if s.EncryptionPath != "" {
+		if val, ok := s.Yaml.Values[s.EncryptionPath]; ok {
+			s.filterPaths(EscapeKey(s.EncryptionPath), val, &paths)
+		}
+		return paths
+	}
+	for key, val := range s.Yaml.Values {
+		if !IsMetaKey(key) {
+			s.filterPaths(EscapeKey(key), val, &paths)
+		}
+	}
+	return paths
+}
+
+// filterPaths adds p when it is included and nothing under it is excluded,
+// otherwise it looks at each of its items
+func (s *Sls) filterPaths(p string, val interface{}, paths *[]string) {
+	parts := SplitPath(p)
+	if matchesAny(s.Options.ExcludePaths, parts) {
+		return
+	}
+	included := len(s.Options.IncludePaths) == 0 || matchesAny(s.Options.IncludePaths, parts)
+	if included && !excludedBelow(s.Options.ExcludePaths, p, val) {
+		*paths = append(*paths, p)
+		return
+	}
+	switch v := val.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			if !IsMetaKey(k) {
+				s.filterPaths(JoinPath(p, k), item, paths)
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			s.filterPaths(JoinPath(p, strconv.Itoa(i)), item, paths)
+		}
+	}
+}
+
+// processFiltered applies an action to the subtrees selected by FilteredPaths
+func (s *Sls) processFiltered(action string) error {
+	_, err := s.ProcessPaths(s.FilteredPaths(), action)
+	return err
+}
+
+// matchesAny returns true when one of the patterns matches parts or the start of them
+func matchesAny(patterns []string, parts []string) bool {
+	for _, pattern := range patterns {
+		pp := SplitPath(pattern)
+		if len(pp) <= len(parts) && matchParts(pp, parts[:len(pp)]) {
+			return true
+		}
+	}
+	return false
+}
+
+// excludedBelow returns true when one of the patterns matches a path under p
+func excludedBelow(patterns []string, p string, val interface{}) bool {
+	if len(patterns) == 0 {
+		return false
+	}
+	var below []string
+	collectPaths(p, val, &below)
+	for _, b := range below {
+		if matchesAny(patterns, SplitPath(b)) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchParts(patterns []string, parts []string) bool {
+	for i := range patterns {
+		if ok, _ := path.Match(patterns[i], parts[i]); !ok {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,105 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package sls
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ForbiddenMatch is a key or plain text value that matches a forbidden pattern
+type ForbiddenMatch struct {
+	Path    string
+	Pattern string
+}
+
+// CompilePatterns compiles the forbidden patterns
+func CompilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	var res []*regexp.Regexp
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern '%s': %s", p, err)
+		}
+		res = append(res, re)
+	}
+	return res, nil
+}
+
+// ForbiddenPlainText returns the keys and plain text values anywhere in the document
+// (metadata included) that match one of the Forbidden patterns, encrypted values are skipped
+func (s *Sls) ForbiddenPlainText() []ForbiddenMatch {
+	var matches []ForbiddenMatch
+	if len(s.Options.Forbidden) == 0 {
+		return matches
+	}
+	s.findForbidden("", s.Yaml.Values, &matches)
+	sort.Slice(matches, func(i, j int) bool { return matches[i].Path < matches[j].Path })
+	return matches
+}
+
+func (s *Sls) findForbidden(path string, val interface{}, matches *[]ForbiddenMatch) {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			p := JoinPath(path, k)
+			s.matchForbidden(p, k, matches)
+			s.findForbidden(p, item, matches)
+		}
+	case []interface{}:
+		for i, item := range v {
+			s.findForbidden(JoinPath(path, strconv.Itoa(i)), item, matches)
+		}
+	case string:
+		if !isEncrypted(v) {
+			s.matchForbidden(path, v, matches)
+		}
+	case nil:
+	default:
+		s.matchForbidden(path, fmt.Sprintf("%v", v), matches)
+	}
+}
+
+func (s *Sls) matchForbidden(path string, text string, matches *[]ForbiddenMatch) {
+	for _, re := range s.Options.Forbidden {
+		if re.MatchString(text) {
+			*matches = append(*matches, ForbiddenMatch{Path: path, Pattern: re.String()})
+			return
+		}
+	}
+}
+
+// checkForbidden fails if plain text that matches a forbidden pattern is left in the
+// document, the text itself is not repeated in the error
+func (s *Sls) checkForbidden() error {
+	matches := s.ForbiddenPlainText()
+	if len(matches) == 0 {
+		return nil
+	}
+	var found []string
+	for _, m := range matches {
+		found = append(found, fmt.Sprintf("%s (%s)", m.Path, m.Pattern))
+	}
+	return fmt.Errorf("%s: plain text matching a forbidden pattern: %s", shortFileName(s.FilePath), strings.Join(found, ", "))
+}
@@ -0,0 +1,122 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package sls
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// HistoryKey is the sibling key under which previous values are kept
+const HistoryKey = "__history"
+
+// HistoryEntry is a previous (still encrypted) value
+type HistoryEntry struct {
+	Value    string
+	Replaced string
+	Key      string
+}
+
+// PushHistory adds the current value at the given path to its history,
+// it does nothing if there is no current value
+func (s *Sls) PushHistory(path string) error {
+	val, ok := s.GetValueFromPath(path).(string)
+	if !ok || val == "" {
+		return nil
+	}
+
+	key := ""
+	if isEncrypted(val) {
+		if info, err := s.keyInfo(val); err == nil {
+			key = strings.TrimSpace(info)
+		}
+	}
+	entry := map[string]interface{}{
+		"value":    val,
+		"replaced": time.Now().UTC().Format(time.RFC3339),
+		"key":      key,
+	}
+
+	entries := s.historyList(path)
+	return s.setHistoryList(path, append(entries, entry))
+}
+
+// History returns the previous values for the given path, oldest first
+func (s *Sls) History(path string) []HistoryEntry {
+	var history []HistoryEntry
+	for _, item := range s.historyList(path) {
+		m, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		history = append(history, HistoryEntry{
+			Value:    fmt.Sprintf("%v", m["value"]),
+			Replaced: fmt.Sprintf("%v", m["replaced"]),
+			Key:      fmt.Sprintf("%v", m["key"]),
+		})
+	}
+	return history
+}
+
+// Rollback restores a previous value from the history of the given path,
+// the current value is added to the history so the rollback can be undone
+func (s *Sls) Rollback(path string, index int) error {
+	entries := s.historyList(path)
+	if len(entries) == 0 {
+		return fmt.Errorf("no history for %s", path)
+	}
+	if index < 0 || index >= len(entries) {
+		return fmt.Errorf("history index %d out of range for %s (0-%d)", index, path, len(entries)-1)
+	}
+	m, ok := entries[index].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("invalid history entry %d for %s", index, path)
+	}
+	restored := fmt.Sprintf("%v", m["value"])
+
+	remaining := append(append([]interface{}{}, entries[:index]...), entries[index+1:]...)
+	if err := s.setHistoryList(path, remaining); err != nil {
+		return err
+	}
+	if err := s.PushHistory(path); err != nil {
+		return err
+	}
+
+	return s.SetValueFromPath(path, restored)
+}
+
+func (s *Sls) historyPath(path string) []string {
+	parts := s.splitPath(path)
+	return append(parts[:len(parts)-1:len(parts)-1], HistoryKey, parts[len(parts)-1])
+}
+
+func (s *Sls) historyList(path string) []interface{} {
+	list, _ := s.getParts(s.historyPath(path)).([]interface{})
+	return list
+}
+
+func (s *Sls) setHistoryList(path string, list []interface{}) error {
+	if err := s.setParts(s.historyPath(path), list); err != nil {
+		return fmt.Errorf("unable to set history for %s: %s", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package sls
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Everbridge/generate-secure-pillar/pki"
+)
+
+// IncludeKey is the top level key listing the files a pillar file includes
+const IncludeKey = "include"
+
+// Include is an entry of a pillar file's include list, Key nests the
+// included values under a key (with a PathDelimiter between parts)
+type Include struct {
+	Name string
+	Key  string
+}
+
+// Includes returns the include list of the file
+func (s *Sls) Includes() []Include {
+	list, ok := s.Yaml.Values[IncludeKey].([]interface{})
+	if !ok {
+		return nil
+	}
+
+	var includes []Include
+	for _, item := range list {
+		switch val := item.(type) {
+		case string:
+			includes = append(includes, Include{Name: val})
+		case map[string]interface{}:
+			// - name: {key: some:path}
+			for name, opts := range val {
+				inc := Include{Name: name}
+				if o, ok := opts.(map[string]interface{}); ok {
+					if key, ok := o["key"].(string); ok {
+						inc.Key = key
+					}
+				}
+				includes = append(includes, inc)
+			}
+		}
+	}
+	return includes
+}
+
+// IncludeFile returns the file an include name refers to, names are dotted paths from the
+// pillar root, or from the including file's directory when they start with a dot (one
+// more dot for each parent directory), and are either <name>.sls or <name>/init.sls
+func IncludeFile(root string, from string, name string) (string, error) {
+	base := root
+	if strings.HasPrefix(name, ".") {
+		base = filepath.Dir(from)
+		trimmed := strings.TrimLeft(name, ".")
+		for i := 1; i < len(name)-len(trimmed); i++ {
+			base = filepath.Dir(base)
+		}
+		name = trimmed
+	}
+	rel := filepath.Join(strings.Split(name, ".")...)
+
+	for _, file := range []string{filepath.Join(base, rel+".sls"), filepath.Join(base, rel, "init.sls")} {
+		if fi, err := os.Stat(file); err == nil && fi.Mode().IsRegular() {
+			return file, nil
+		}
+	}
+	return "", fmt.Errorf("%s: include '%s' not found under %s", shortFileName(from), name, shortFileName(base))
+}
+
+// Assemble reads a file and the files it includes (and so on) as one document, the way
+// Salt assembles pillar data: each file is included once, and the values of included files
+// are merged over the values of the including file in the order they are listed,
+// it returns the document and the files that make it up
+func Assemble(root string, entry string, p pki.Pki, encPath string) (Sls, []string, error) {
+	doc := New("", p, encPath)
+	doc.FilePath = entry
+	doc.IsInclude = true
+
+	seen := map[string]bool{}
+	values, err := doc.assemble(root, entry, seen)
+	if err != nil {
+		return doc, nil, err
+	}
+	doc.Yaml.Values = values
+
+	files := make([]string, 0, len(seen))
+	for file := range seen {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	return doc, files, nil
+}
+
+func (s *Sls) assemble(root string, file string, seen map[string]bool) (map[string]interface{}, error) {
+	file, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+	seen[file] = true
+
+	part := NewWithOptions("", *s.Pki, s.EncryptionPath, s.Options)
+	part.FilePath = file
+	buf, err := ioutil.ReadFile(filepath.Clean(file))
+	if err != nil {
+		return nil, err
+	}
+	if err = part.decode(buf); err != nil {
+		return nil, fmt.Errorf("%s: %s", shortFileName(file), err)
+	}
+
+	values := part.Yaml.Values
+	if values == nil {
+		values = map[string]interface{}{}
+	}
+	includes := part.Includes()
+	delete(values, IncludeKey)
+
+	for _, inc := range includes {
+		incFile, err := IncludeFile(root, file, inc.Name)
+		if err != nil {
+			if err = s.Warnf("%s", err); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if abs, _ := filepath.Abs(incFile); seen[abs] {
+			continue
+		}
+		included, err := s.assemble(root, incFile, seen)
+		if err != nil {
+			return nil, err
+		}
+		var merged interface{} = included
+		if inc.Key != "" {
+			parts := SplitPath(inc.Key)
+			for i := len(parts) - 1; i >= 0; i-- {
+				merged = map[string]interface{}{parts[i]: merged}
+			}
+		}
+		values = mergeValues(values, merged.(map[string]interface{}))
+	}
+	return values, nil
+}
+
+// mergeValues merges src over dst, maps are merged recursively and anything else is replaced
+func mergeValues(dst map[string]interface{}, src map[string]interface{}) map[string]interface{} {
+	for key, val := range src {
+		srcMap, srcOk := val.(map[string]interface{})
+		dstMap, dstOk := dst[key].(map[string]interface{})
+		if srcOk && dstOk {
+			dst[key] = mergeValues(dstMap, srcMap)
+			continue
+		}
+		dst[key] = val
+	}
+	return dst
+}
@@ -0,0 +1,145 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package sls
+
+import (
+	"fmt"
+
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// MinAliasNodes and AliasExpansion limit the nodes a document can have once its aliases are
+// replaced by copies of their anchors: the larger of MinAliasNodes and AliasExpansion times the
+// nodes in the document as it was written, so a document of nested aliases (a billion laughs)
+// fails to read rather than using up all of the memory
+const (
+	MinAliasNodes  = 100000
+	AliasExpansion = 10
+)
+
+// resolveNode returns a copy of the node with aliases replaced by copies of
+// their anchors and merge keys (<<) applied, so the values read are exactly
+// what Salt renders: keys in a mapping win over merged keys, and earlier
+// merge sources win over later ones
+func resolveNode(n *yamlv3.Node) (*yamlv3.Node, error) {
+	limit := AliasExpansion * countNodes(n)
+	if limit < MinAliasNodes {
+		limit = MinAliasNodes
+	}
+	r := resolver{active: map[*yamlv3.Node]bool{}, limit: limit}
+	return r.resolve(n)
+}
+
+// countNodes returns the number of nodes in a document without following aliases
+func countNodes(n *yamlv3.Node) int {
+	count := 1
+	for _, c := range n.Content {
+		count += countNodes(c)
+	}
+	return count
+}
+
+// resolver copies nodes, active holds the anchors being copied
+// and nodes counts the nodes copied so far
+type resolver struct {
+	active map[*yamlv3.Node]bool
+	nodes  int
+	limit  int
+}
+
+func (r *resolver) resolve(n *yamlv3.Node) (*yamlv3.Node, error) {
+	if r.nodes++; r.nodes > r.limit {
+		return nil, fmt.Errorf("line %d: aliases expand the document to more than %d values", n.Line, r.limit)
+	}
+	if n.Kind == yamlv3.AliasNode {
+		if n.Alias == nil {
+			return nil, fmt.Errorf("line %d: unknown anchor '%s'", n.Line, n.Value)
+		}
+		if r.active[n.Alias] {
+			return nil, fmt.Errorf("line %d: anchor '%s' refers to itself", n.Line, n.Value)
+		}
+		r.active[n.Alias] = true
+		res, err := r.resolve(n.Alias)
+		delete(r.active, n.Alias)
+		return res, err
+	}
+
+	res := *n
+	res.Anchor = ""
+	res.Content = nil
+	for _, child := range n.Content {
+		c, err := r.resolve(child)
+		if err != nil {
+			return nil, err
+		}
+		res.Content = append(res.Content, c)
+	}
+
+	if res.Kind == yamlv3.MappingNode {
+		return mergeMapping(&res)
+	}
+	return &res, nil
+}
+
+// mergeMapping applies the merge keys of an already resolved mapping node
+func mergeMapping(n *yamlv3.Node) (*yamlv3.Node, error) {
+	var sources []*yamlv3.Node
+	var content []*yamlv3.Node
+	seen := map[string]bool{}
+
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, val := n.Content[i], n.Content[i+1]
+		if !isMergeKey(key) {
+			content = append(content, key, val)
+			seen[key.Value] = true
+			continue
+		}
+		switch val.Kind {
+		case yamlv3.MappingNode:
+			sources = append(sources, val)
+		case yamlv3.SequenceNode:
+			for _, item := range val.Content {
+				if item.Kind != yamlv3.MappingNode {
+					return nil, fmt.Errorf("line %d: merge key values must be maps", item.Line)
+				}
+				sources = append(sources, item)
+			}
+		default:
+			return nil, fmt.Errorf("line %d: merge key values must be maps", val.Line)
+		}
+	}
+
+	for _, src := range sources {
+		for i := 0; i+1 < len(src.Content); i += 2 {
+			if key := src.Content[i]; !seen[key.Value] {
+				content = append(content, key, src.Content[i+1])
+				seen[key.Value] = true
+			}
+		}
+	}
+	n.Content = content
+
+	return n, nil
+}
+
+func isMergeKey(n *yamlv3.Node) bool {
+	return n.Kind == yamlv3.ScalarNode && n.Value == "<<" && n.ShortTag() == "!!merge"
+}
@@ -0,0 +1,142 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package sls
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MetaKey is the sibling key under which metadata for the values in a map is kept
+const MetaKey = "__meta"
+
+// ExpiresField is the metadata field holding a value's expiry date
+const ExpiresField = "expires"
+
+// DateFormat is the format of dates in metadata
+const DateFormat = "2006-01-02"
+
+// reservedKeys are the keys holding metadata rather than secrets, any other
+// key is a value, even one starting with __
+var reservedKeys = map[string]bool{
+	MetaKey:    true,
+	HistoryKey: true,
+	ChunksKey:  true,
+	ACLKey:     true,
+	DeriveKey:  true,
+}
+
+// IsMetaKey returns true for keys holding metadata rather than secrets,
+// these are never encrypted or decrypted
+func IsMetaKey(key string) bool {
+	return reservedKeys[key]
+}
+
+// SetMeta adds metadata fields for the value at the given path,
+// it is kept in plain text in a __meta map next to the value
+func (s *Sls) SetMeta(path string, meta map[string]string) error {
+	parts := s.splitPath(path)
+	name := parts[len(parts)-1]
+	parent := parts[:len(parts)-1]
+
+	for field, val := range meta {
+		metaParts := append(parent[:len(parent):len(parent)], MetaKey, name, field)
+		if err := s.setParts(metaParts, val); err != nil {
+			return fmt.Errorf("unable to set metadata for %s: %s", path, err)
+		}
+	}
+
+	return nil
+}
+
+// GetMeta returns the metadata for the value at the given path
+func (s *Sls) GetMeta(path string) map[string]interface{} {
+	parts := s.splitPath(path)
+	name := parts[len(parts)-1]
+	metaParts := append(parts[:len(parts)-1:len(parts)-1], MetaKey, name)
+
+	if meta, ok := s.getParts(metaParts).(map[string]interface{}); ok {
+		return meta
+	}
+	return nil
+}
+
+// ParseMeta turns a list of "field=value" strings into a map, expiry dates are checked
+func ParseMeta(fields []string) (map[string]string, error) {
+	meta := make(map[string]string)
+	for _, f := range fields {
+		parts := strings.SplitN(f, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return meta, fmt.Errorf("invalid metadata '%s', expected field=value", f)
+		}
+		if parts[0] == ExpiresField {
+			if _, err := time.Parse(DateFormat, parts[1]); err != nil {
+				return meta, fmt.Errorf("invalid expiry date '%s', expected YYYY-MM-DD", parts[1])
+			}
+		}
+		meta[parts[0]] = parts[1]
+	}
+	return meta, nil
+}
+
+// MetaEntry is the metadata for a single value
+type MetaEntry struct {
+	Path    string
+	Expires time.Time
+	Fields  map[string]interface{}
+}
+
+// MetaEntries returns the metadata for all values in the file, sorted by path
+func (s *Sls) MetaEntries() []MetaEntry {
+	var entries []MetaEntry
+	collectMeta("", s.Yaml.Values, &entries)
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	return entries
+}
+
+func collectMeta(prefix string, vals map[string]interface{}, entries *[]MetaEntry) {
+	for key, val := range vals {
+		m, ok := val.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if key != MetaKey {
+			collectMeta(JoinPath(prefix, key), m, entries)
+			continue
+		}
+		for name, fields := range m {
+			f, ok := fields.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			entry := MetaEntry{Path: JoinPath(prefix, name), Fields: f}
+			switch exp := f[ExpiresField].(type) {
+			case time.Time:
+				entry.Expires = exp
+			case string:
+				entry.Expires, _ = time.Parse(DateFormat, exp)
+			}
+			*entries = append(*entries, entry)
+		}
+	}
+}
@@ -0,0 +1,418 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package sls
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Everbridge/generate-secure-pillar/pki"
+)
+
+// PathDelimiter separates the keys in a path, a key containing it is
+// written with a backslash before it, e.g. "urls:https\://example.com"
+var PathDelimiter = ":"
+
+// SplitPath splits a path into its keys, unescaping delimiters and backslashes in them,
+// list items are addressed by index either as a key or in brackets ("users:0:name" or "users[0]:name")
+func SplitPath(path string) []string {
+	var parts []string
+	var key strings.Builder
+	for i := 0; i < len(path); {
+		switch {
+		case strings.HasPrefix(path[i:], `\`+PathDelimiter):
+			key.WriteString(PathDelimiter)
+			i += 1 + len(PathDelimiter)
+		case strings.HasPrefix(path[i:], `\\`):
+			key.WriteByte('\\')
+			i += 2
+		case strings.HasPrefix(path[i:], PathDelimiter):
+			parts = append(parts, splitIndexes(key.String())...)
+			key.Reset()
+			i += len(PathDelimiter)
+		default:
+			key.WriteByte(path[i])
+			i++
+		}
+	}
+	return append(parts, splitIndexes(key.String())...)
+}
+
+// splitIndexes splits "users[0][1]" into "users", "0", "1"
+func splitIndexes(key string) []string {
+	var indexes []string
+	for strings.HasSuffix(key, "]") {
+		open := strings.LastIndex(key, "[")
+		if open < 0 {
+			break
+		}
+		if _, err := strconv.Atoi(key[open+1 : len(key)-1]); err != nil {
+			break
+		}
+		indexes = append([]string{key[open+1 : len(key)-1]}, indexes...)
+		key = key[:open]
+	}
+	if key == "" && len(indexes) > 0 {
+		return indexes
+	}
+	return append([]string{key}, indexes...)
+}
+
+// EscapeKey escapes the delimiters and backslashes in a key so it can be used in a path
+func EscapeKey(key string) string {
+	key = strings.Replace(key, `\`, `\\`, -1)
+	return strings.Replace(key, PathDelimiter, `\`+PathDelimiter, -1)
+}
+
+// JoinPath adds a key to a path
+func JoinPath(prefix string, key string) string {
+	if prefix == "" {
+		return EscapeKey(key)
+	}
+	return prefix + PathDelimiter + EscapeKey(key)
+}
+
+// splitPath splits a path into its keys, with IgnoreCase the keys are
+// changed to the case used in the document when one key matches
+func (s *Sls) splitPath(path string) []string {
+	parts := SplitPath(path)
+	if s.Options.IgnoreCase {
+		return s.matchCase(parts)
+	}
+	return parts
+}
+
+func (s *Sls) matchCase(parts []string) []string {
+	var cur interface{} = s.Yaml.Values
+	for i, part := range parts {
+		switch v := cur.(type) {
+		case map[string]interface{}:
+			if next, ok := v[part]; ok {
+				cur = next
+				continue
+			}
+			match := ""
+			for k := range v {
+				if strings.EqualFold(k, part) {
+					if match != "" {
+						// ambiguous, leave it as given
+						return parts
+					}
+					match = k
+				}
+			}
+			if match == "" {
+				return parts
+			}
+			parts[i] = match
+			cur = v[match]
+		case []interface{}:
+			n, err := strconv.Atoi(part)
+			if err != nil || n < 0 || n >= len(v) {
+				return parts
+			}
+			cur = v[n]
+		default:
+			return parts
+		}
+	}
+	return parts
+}
+
+// RenameKey changes the last key of path to name, moving any metadata kept for it
+func (s *Sls) RenameKey(path string, name string) error {
+	parts := s.splitPath(path)
+	old := parts[len(parts)-1]
+	m, ok := s.getParts(parts[:len(parts)-1]).(map[string]interface{})
+	if !ok {
+		return s.PathError(path)
+	}
+	if _, ok = m[old]; !ok {
+		return s.PathError(path)
+	}
+	if _, ok = m[name]; ok {
+		return fmt.Errorf("cannot rename %s to %s, it already exists", path, name)
+	}
+
+	m[name] = m[old]
+	delete(m, old)
+	for k, v := range m {
+		if meta, ok := v.(map[string]interface{}); ok && IsMetaKey(k) {
+			if item, ok := meta[old]; ok {
+				meta[name] = item
+				delete(meta, old)
+			}
+		}
+	}
+	return nil
+}
+
+// PathExists returns true if there is a value, even an empty one, at path
+func (s *Sls) PathExists(path string) bool {
+	_, ok := s.lookup(s.splitPath(path))
+	return ok
+}
+
+// ItemPaths returns the paths of the items of the list at path, path[0], path[1], and so on (and of the
+// items of lists in them), or just path when there isn't a list with items at it
+func (s *Sls) ItemPaths(path string) []string {
+	items, ok := s.GetValueFromPath(path).([]interface{})
+	if !ok || len(items) == 0 {
+		return []string{path}
+	}
+	var paths []string
+	for i := range items {
+		paths = append(paths, s.ItemPaths(fmt.Sprintf("%s[%d]", path, i))...)
+	}
+	return paths
+}
+
+// lookup returns the value at the given keys, list items are addressed by their index
+func (s *Sls) lookup(parts []string) (interface{}, bool) {
+	var cur interface{} = s.Yaml.Values
+	for _, part := range parts {
+		switch v := cur.(type) {
+		case map[string]interface{}:
+			next, ok := v[part]
+			if !ok {
+				return nil, false
+			}
+			cur = next
+		case []interface{}:
+			i, err := strconv.Atoi(part)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			cur = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+// ProcessPaths applies an action to the values at each of the paths and returns the results,
+// the encrypted or decrypted values replace the values in the document so that all of the
+// paths are written with one write, a path that is given twice or is inside another one
+// is only processed once
+func (s *Sls) ProcessPaths(paths []string, action string) ([]interface{}, error) {
+	results := make([]interface{}, len(paths))
+	split := make([][]string, len(paths))
+	for i, path := range paths {
+		split[i] = s.splitPath(path)
+		if _, ok := s.lookup(split[i]); !ok {
+			return results, s.PathError(path)
+		}
+	}
+	for i := range paths {
+		if action != Validate && coveredPath(split, i) {
+			continue
+		}
+		vals, err := s.ProcessValues(s.getParts(split[i]), action)
+		if err != nil {
+			return results, pki.Wrapf(err, "%s: %s", paths[i], err)
+		}
+		if action == Validate {
+			// keys leaves the document as it is
+			results[i] = vals
+			continue
+		}
+		if err = s.setParts(split[i], vals); err != nil {
+			return results, err
+		}
+	}
+	if action != Validate {
+		for i := range paths {
+			results[i] = s.getParts(split[i])
+		}
+	}
+	return results, nil
+}
+
+// coveredPath returns true when paths[i] is inside another of the paths, or the same as an earlier one
+func coveredPath(paths [][]string, i int) bool {
+	for j, other := range paths {
+		if j == i || len(other) > len(paths[i]) || (len(other) == len(paths[i]) && j > i) {
+			continue
+		}
+		inside := true
+		for k := range other {
+			if other[k] != paths[i][k] {
+				inside = false
+				break
+			}
+		}
+		if inside {
+			return true
+		}
+	}
+	return false
+}
+
+// getParts returns the value at the given keys, or nil if there is none
+func (s *Sls) getParts(parts []string) interface{} {
+	val, _ := s.lookup(parts)
+	return val
+}
+
+// setParts sets the value at the given keys, maps are created for missing keys
+// and list items must already exist
+func (s *Sls) setParts(parts []string, value interface{}) error {
+	if s.Yaml.Values == nil {
+		s.Yaml.Values = map[string]interface{}{}
+	}
+	var cur interface{} = s.Yaml.Values
+	for i, part := range parts {
+		last := i == len(parts)-1
+		switch v := cur.(type) {
+		case map[string]interface{}:
+			if last {
+				v[part] = value
+				return nil
+			}
+			if !isContainer(v[part]) {
+				v[part] = map[string]interface{}{}
+			}
+			cur = v[part]
+		case []interface{}:
+			n, err := strconv.Atoi(part)
+			if err != nil || n < 0 || n >= len(v) {
+				return fmt.Errorf("no list item '%s' in %s (%d items)", part, strings.Join(parts[:i], PathDelimiter), len(v))
+			}
+			if last {
+				v[n] = value
+				return nil
+			}
+			if !isContainer(v[n]) {
+				v[n] = map[string]interface{}{}
+			}
+			cur = v[n]
+		default:
+			return fmt.Errorf("cannot set a value under %s", strings.Join(parts[:i], PathDelimiter))
+		}
+	}
+	return nil
+}
+
+func isContainer(val interface{}) bool {
+	switch val.(type) {
+	case map[string]interface{}, []interface{}:
+		return true
+	}
+	return false
+}
+
+// Paths returns the paths of all of the values in the document, including maps and lists,
+// metadata is left out
+func (s *Sls) Paths() []string {
+	var paths []string
+	collectPaths("", s.Yaml.Values, &paths)
+	sort.Strings(paths)
+	return paths
+}
+
+func collectPaths(prefix string, val interface{}, paths *[]string) {
+	join := func(k string) string {
+		return JoinPath(prefix, k)
+	}
+	switch v := val.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			if IsMetaKey(k) {
+				continue
+			}
+			*paths = append(*paths, join(k))
+			collectPaths(join(k), item, paths)
+		}
+	case []interface{}:
+		for i, item := range v {
+			*paths = append(*paths, join(strconv.Itoa(i)))
+			collectPaths(join(strconv.Itoa(i)), item, paths)
+		}
+	}
+}
+
+// PathError returns a "path not found" error for path, listing any similar paths
+// in the document to help spot a typo
+func (s *Sls) PathError(path string) error {
+	similar := s.SimilarPaths(path, 3)
+	if len(similar) == 0 {
+		return fmt.Errorf("path not found: '%s'", path)
+	}
+	return fmt.Errorf("path not found: '%s', similar paths: %s", path, strings.Join(similar, ", "))
+}
+
+// SimilarPaths returns up to max paths in the document that are close to path, closest first
+func (s *Sls) SimilarPaths(path string, max int) []string {
+	type match struct {
+		path     string
+		distance int
+	}
+	var matches []match
+
+	limit := len(path) / 4
+	if limit < 2 {
+		limit = 2
+	}
+	for _, p := range s.Paths() {
+		if d := EditDistance(strings.ToLower(path), strings.ToLower(p)); d <= limit {
+			matches = append(matches, match{p, d})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool { return matches[i].distance < matches[j].distance })
+
+	var similar []string
+	for i := 0; i < len(matches) && i < max; i++ {
+		similar = append(similar, matches[i].path)
+	}
+	return similar
+}
+
+// EditDistance is the Levenshtein distance between two strings, used to suggest
+// the path or name that was probably meant
+func EditDistance(a string, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, minInt(cur[j-1]+1, prev[j-1]+cost))
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
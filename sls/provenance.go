@@ -0,0 +1,144 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package sls
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ProvenanceSuffix is added to the name of a file for its signed provenance sidecar
+const ProvenanceSuffix = ".provenance.asc"
+
+// provenanceHeader is the first line of a provenance record
+const provenanceHeader = "# generate-secure-pillar provenance v1"
+
+// HashCommentPrefix starts the comment written next to an encrypted value with the HashComments
+// option, followed by the first HashCommentLength hex digits of the value's digest
+const HashCommentPrefix = "sha256:"
+
+// HashCommentLength is the number of hex digits of the digest in a hash comment
+const HashCommentLength = 12
+
+// Provenance records who encrypted a value and when, the digest ties it to the
+// encrypted value so that a record is only used for the value it was made for
+type Provenance struct {
+	Path   string
+	Digest string
+	User   string
+	Time   time.Time
+}
+
+// ProvenancePath returns the sidecar file the provenance of a file's values is kept in
+func ProvenancePath(file string) string {
+	return file + ProvenanceSuffix
+}
+
+// ValueDigest returns the SHA-256 digest of an encrypted value
+func ValueDigest(cipherText string) string {
+	sum := sha256.Sum256([]byte(cipherText))
+	return hex.EncodeToString(sum[:])
+}
+
+// HashComment returns the comment written next to an encrypted value with the HashComments
+// option, the start of its digest, which changes whenever the ciphertext does
+func HashComment(cipherText string) string {
+	return HashCommentPrefix + ValueDigest(cipherText)[:HashCommentLength]
+}
+
+// UpdateProvenance returns the provenance of each encrypted value in the document, the
+// records in old are kept for values that haven't changed and the others are new,
+// made by user at now
+func (s *Sls) UpdateProvenance(old map[string]Provenance, user string, now time.Time) map[string]Provenance {
+	records := make(map[string]Provenance)
+	for p, val := range s.EncryptedValues() {
+		digest := ValueDigest(val)
+		if rec, ok := old[p]; ok && rec.Digest == digest {
+			records[p] = rec
+			continue
+		}
+		records[p] = Provenance{Path: p, Digest: digest, User: user, Time: now.UTC().Truncate(time.Second)}
+	}
+	return records
+}
+
+// CurrentProvenance returns the records that match the encrypted values in the document,
+// a record for a value that has been changed (or removed) since is left out
+func (s *Sls) CurrentProvenance(records map[string]Provenance) map[string]Provenance {
+	current := make(map[string]Provenance)
+	for p, val := range s.EncryptedValues() {
+		if rec, ok := records[p]; ok && rec.Digest == ValueDigest(val) {
+			current[p] = rec
+		}
+	}
+	return current
+}
+
+// FormatProvenance returns the records one per line, sorted by path, ready to be signed
+func FormatProvenance(records map[string]Provenance) string {
+	paths := make([]string, 0, len(records))
+	for p := range records {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	var b strings.Builder
+	b.WriteString(provenanceHeader + "\n")
+	for _, p := range paths {
+		rec := records[p]
+		fmt.Fprintf(&b, "%s\t%s\t%s\t%s\n", rec.Digest, rec.Time.Format(time.RFC3339), rec.User, rec.Path)
+	}
+	return b.String()
+}
+
+// ParseProvenance reads records written by FormatProvenance
+func ParseProvenance(text string) (map[string]Provenance, error) {
+	records := make(map[string]Provenance)
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	line := 0
+	for scanner.Scan() {
+		line++
+		if line == 1 {
+			if strings.TrimSpace(scanner.Text()) != provenanceHeader {
+				return records, fmt.Errorf("not a provenance record")
+			}
+			continue
+		}
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		fields := strings.SplitN(strings.TrimRight(scanner.Text(), "\r"), "\t", 4)
+		if len(fields) != 4 {
+			return records, fmt.Errorf("line %d: expected digest, time, user, and path", line)
+		}
+		t, err := time.Parse(time.RFC3339, fields[1])
+		if err != nil {
+			return records, fmt.Errorf("line %d: %s", line, err)
+		}
+		records[fields[3]] = Provenance{Path: fields[3], Digest: fields[0], User: fields[2], Time: t}
+	}
+	return records, scanner.Err()
+}
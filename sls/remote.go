@@ -0,0 +1,155 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package sls
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Everbridge/generate-secure-pillar/pki"
+)
+
+// remote file URL schemes, s3 and gs objects are read and written with the aws
+// and gcloud CLIs so that their usual credential chains are used
+const (
+	httpScheme  = "http://"
+	httpsScheme = "https://"
+	s3Scheme    = "s3://"
+	gcsScheme   = "gs://"
+)
+
+// RemoteTimeout limits how long reading a file from a URL can take
+var RemoteTimeout = 60 * time.Second
+
+// IsRemote returns true if the file path is an http(s), s3, or gs URL
+func IsRemote(filePath string) bool {
+	for _, scheme := range []string{httpScheme, httpsScheme, s3Scheme, gcsScheme} {
+		if strings.HasPrefix(filePath, scheme) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadRemote reads a file from an http(s), s3, or gs URL
+func ReadRemote(uri string) ([]byte, error) {
+	return ReadRemoteContext(context.Background(), uri)
+}
+
+// ReadRemoteContext is ReadRemote, giving up on the request when ctx is done
+func ReadRemoteContext(ctx context.Context, uri string) ([]byte, error) {
+	switch {
+	case strings.HasPrefix(uri, s3Scheme):
+		return runStorageCLI(ctx, pki.AWSCLI, "s3", "cp", "--only-show-errors", uri, "-")
+	case strings.HasPrefix(uri, gcsScheme):
+		return runStorageCLI(ctx, pki.GCloudCLI, "storage", "cat", uri)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	client := http.Client{Timeout: RemoteTimeout}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("%s: %s", uri, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// WriteRemote writes a file to an s3 or gs URL in a single put, which replaces the object at once,
+// the MD5 sum is checked by the storage service and the SHA-256 sum is kept in the object metadata
+func WriteRemote(uri string, data []byte) error {
+	return WriteRemoteContext(context.Background(), uri, data)
+}
+
+// WriteRemoteContext is WriteRemote, killing the storage CLI when ctx is done
+func WriteRemoteContext(ctx context.Context, uri string, data []byte) error {
+	if !strings.HasPrefix(uri, s3Scheme) && !strings.HasPrefix(uri, gcsScheme) {
+		return fmt.Errorf("cannot write to %s, only s3:// and gs:// URLs can be written to", uri)
+	}
+
+	// the storage CLIs upload from a file, it's kept in a directory only the user can read
+	dir, err := ioutil.TempDir(TempDir, ".gsp-upload")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	body := filepath.Join(dir, "body")
+	if err = ioutil.WriteFile(body, data, 0600); err != nil {
+		return err
+	}
+
+	md5Sum := md5.Sum(data)
+	contentMD5 := base64.StdEncoding.EncodeToString(md5Sum[:])
+	sha256Sum := fmt.Sprintf("sha256=%x", sha256.Sum256(data))
+
+	if strings.HasPrefix(uri, gcsScheme) {
+		_, err = runStorageCLI(ctx, pki.GCloudCLI, "storage", "cp", "--content-md5="+contentMD5, "--custom-metadata="+sha256Sum, body, uri)
+		return err
+	}
+	bucket, key := splitBucket(strings.TrimPrefix(uri, s3Scheme))
+	if bucket == "" || key == "" {
+		return fmt.Errorf("%s: expected s3://<bucket>/<key>", uri)
+	}
+	_, err = runStorageCLI(ctx, pki.AWSCLI, "s3api", "put-object", "--bucket", bucket, "--key", key,
+		"--body", body, "--content-md5", contentMD5, "--metadata", sha256Sum)
+	return err
+}
+
+// splitBucket splits <bucket>/<key>
+func splitBucket(path string) (string, string) {
+	i := strings.Index(path, "/")
+	if i < 0 {
+		return path, ""
+	}
+	return path[:i], path[i+1:]
+}
+
+// runStorageCLI runs an object storage CLI and returns what it wrote to stdout
+func runStorageCLI(ctx context.Context, binary string, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, binary, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%s %s: %s: %s", binary, strings.Join(args[:2], " "), err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
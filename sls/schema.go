@@ -0,0 +1,145 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package sls
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Everbridge/generate-secure-pillar/schema"
+)
+
+// checkSchema validates the document against the Schema option, when is "before" or "after" processing
+func (s *Sls) checkSchema(when string) error {
+	violations := s.Options.Schema.Validate(schemaView(s.Yaml.Values), schema.Options{
+		Opaque: func(val interface{}) bool {
+			str, ok := val.(string)
+			return ok && isEncrypted(str)
+		},
+	})
+	if len(violations) == 0 {
+		return nil
+	}
+	msgs := make([]string, 0, len(violations))
+	for _, v := range violations {
+		msgs = append(msgs, v.String(joinPath))
+	}
+	return fmt.Errorf("%s: does not match the schema %s %s processing: %s",
+		shortFileName(s.FilePath), shortFileName(s.Options.Schema.File), when, strings.Join(msgs, ", "))
+}
+
+// checkStructure returns an error when processing changed the structure of the document
+func (s *Sls) checkStructure(before map[string]string) error {
+	after := structure(s.Yaml.Values)
+	var changes []string
+	for path, kind := range before {
+		if now, ok := after[path]; !ok {
+			changes = append(changes, fmt.Sprintf("'%s' was removed", path))
+		} else if now != kind {
+			changes = append(changes, fmt.Sprintf("'%s' was a %s and is a %s", path, kind, now))
+		}
+	}
+	for path := range after {
+		if _, ok := before[path]; !ok {
+			changes = append(changes, fmt.Sprintf("'%s' was added", path))
+		}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+	sort.Strings(changes)
+	return fmt.Errorf("%s: processing changed the structure of the document: %s", shortFileName(s.FilePath), strings.Join(changes, ", "))
+}
+
+// schemaView returns a copy of the values without metadata, with chunked values joined again
+func schemaView(val interface{}) interface{} {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		chunks, _ := v[ChunksKey].(map[string]interface{})
+		view := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			if IsMetaKey(key) {
+				continue
+			}
+			if _, chunked := chunks[key]; chunked {
+				view[key] = joinChunks(item)
+				continue
+			}
+			view[key] = schemaView(item)
+		}
+		return view
+	case []interface{}:
+		view := make([]interface{}, len(v))
+		for i, item := range v {
+			view[i] = schemaView(item)
+		}
+		return view
+	}
+	return val
+}
+
+func joinChunks(val interface{}) interface{} {
+	items, ok := val.([]interface{})
+	if !ok {
+		return val
+	}
+	var b strings.Builder
+	for _, item := range items {
+		b.WriteString(fmt.Sprintf("%v", item))
+	}
+	return b.String()
+}
+
+// structure maps the path of each value in the document to its kind (map, list, or value)
+func structure(values map[string]interface{}) map[string]string {
+	kinds := map[string]string{}
+	addStructure("", schemaView(values), kinds)
+	return kinds
+}
+
+func addStructure(path string, val interface{}, kinds map[string]string) {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		if path != "" {
+			kinds[path] = "map"
+		}
+		for key, item := range v {
+			addStructure(JoinPath(path, key), item, kinds)
+		}
+	case []interface{}:
+		kinds[path] = "list"
+		for i, item := range v {
+			addStructure(JoinPath(path, strconv.Itoa(i)), item, kinds)
+		}
+	default:
+		kinds[path] = "value"
+	}
+}
+
+func joinPath(path []string) string {
+	joined := ""
+	for _, key := range path {
+		joined = JoinPath(joined, key)
+	}
+	return joined
+}
@@ -0,0 +1,119 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package sls
+
+import (
+	"sort"
+	"strconv"
+
+	"github.com/Everbridge/generate-secure-pillar/metrics"
+)
+
+// OutsideElement returns the paths of the plain text values that are outside
+// the encryption element, and so are left as they are when encrypting
+func (s *Sls) OutsideElement() []string {
+	var paths []string
+	if s.EncryptionPath == "" {
+		return paths
+	}
+	for key, val := range s.Yaml.Values {
+		if key == s.EncryptionPath || IsMetaKey(key) {
+			continue
+		}
+		collectPlainValues(key, val, &paths)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// PlainValues returns the paths of the plain text values that encrypting would encrypt,
+// those under the encryption element, or in the whole document without one
+func (s *Sls) PlainValues() []string {
+	var paths []string
+	for key, val := range s.Yaml.Values {
+		if IsMetaKey(key) || (s.EncryptionPath != "" && key != s.EncryptionPath) {
+			continue
+		}
+		collectPlainValues(key, val, &paths)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// ElementPaths returns the paths of names under the encryption element, so that
+// create and update don't add values at the top of the document,
+// names that already start with the element are kept as they are
+func (s *Sls) ElementPaths(names []string) []string {
+	if s.EncryptionPath == "" {
+		return names
+	}
+	paths := make([]string, len(names))
+	for i, name := range names {
+		if parts := SplitPath(name); len(parts) > 1 && parts[0] == s.EncryptionPath {
+			paths[i] = name
+			continue
+		}
+		paths[i] = EscapeKey(s.EncryptionPath) + PathDelimiter + name
+	}
+	return paths
+}
+
+func collectPlainValues(path string, val interface{}, paths *[]string) {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			if !IsMetaKey(k) {
+				collectPlainValues(JoinPath(path, k), item, paths)
+			}
+		}
+	case []interface{}:
+		for i, item := range v {
+			collectPlainValues(JoinPath(path, strconv.Itoa(i)), item, paths)
+		}
+	case string:
+		if !isEncrypted(v) {
+			*paths = append(*paths, path)
+		}
+	case nil:
+	default:
+		*paths = append(*paths, path)
+	}
+}
+
+// reportOutsideElement logs how many plain text values encrypting leaves outside the element,
+// with the WarnOutsideElement option each of them is a warning (an error in strict mode)
+func (s *Sls) reportOutsideElement() error {
+	paths := s.OutsideElement()
+	if len(paths) == 0 {
+		return nil
+	}
+	metrics.Add(metrics.ValuesOutsideElement, int64(len(paths)))
+	if !s.Options.WarnOutsideElement {
+		logger.Infof("%s: %d plain text values outside element '%s' left as they are", shortFileName(s.FilePath), len(paths), s.EncryptionPath)
+		return nil
+	}
+	for _, path := range paths {
+		if err := s.Warnf("%s: '%s' is outside element '%s' and not encrypted", shortFileName(s.FilePath), path, s.EncryptionPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
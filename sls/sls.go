@@ -23,6 +23,7 @@ package sls
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -30,9 +31,13 @@ import (
 	"path"
 	"path/filepath"
 	"reflect"
+	"regexp"
+	"strconv"
 	"strings"
 
+	"github.com/Everbridge/generate-secure-pillar/metrics"
 	"github.com/Everbridge/generate-secure-pillar/pki"
+	"github.com/Everbridge/generate-secure-pillar/schema"
 	yaml "github.com/esilva-everbridge/yaml"
 	"github.com/sirupsen/logrus"
 	yamlv3 "gopkg.in/yaml.v3"
@@ -51,6 +56,13 @@ const Validate = "validate"
 const Rotate = "rotate"
 
 var logger = logrus.New()
+var logOutput io.Writer = os.Stdout
+
+// SetLogOutput sets where log messages are written (stdout by default)
+func SetLogOutput(w io.Writer) {
+	logOutput = w
+	logger.Out = w
+}
 
 // Sls sls data
 type Sls struct {
@@ -63,17 +75,108 @@ type Sls struct {
 	KeyMeta        string
 	KeyCount       int
 	Error          error
+	Options        Options
+	keyOrder       map[string][]string
+	fileRefs       map[string]string
+	crlf           bool
+	lines          map[string]int
+	renderer       string
+	styles         map[string]string
+	raw            []byte
+}
+
+// Options control how values are processed
+type Options struct {
+	// MaxValueSize logs a warning when a larger value is encrypted without chunking (0 for no limit)
+	MaxValueSize int
+	// ResolveFiles replaces values tagged with FileTag by the contents of their files when
+	// reading, which is only done when encrypting, they are never written in plain text
+	ResolveFiles bool
+	// Whitespace is what is done about CRLF line endings and whitespace in encrypted values,
+	// WhitespaceWarn ("" too), WhitespaceError, or WhitespaceFix
+	Whitespace string
+	// MaxLineSize is the longest line that is read, e.g. an armored value or minified
+	// JSON on one line (0 for DefaultMaxLineSize)
+	MaxLineSize int
+	// ChunkSize splits values larger than this across list items when encrypting (0 to never split)
+	ChunkSize int
+	// Strict returns warnings (include files, a missing element, values of unsupported types) as errors
+	Strict bool
+	// IgnoreCase matches the keys in a path case insensitively when there is no exact match
+	IgnoreCase bool
+	// KeyOrder is KeyOrderSorted (the default) or KeyOrderOriginal to write map keys in the order they were read
+	KeyOrder string
+	// Indent is the number of spaces per nesting level when writing (0 for DefaultIndent)
+	Indent int
+	// ArmorWidth rewraps the lines of armored values to this width when writing (0 to leave them as they are)
+	ArmorWidth int
+	// WarnOutsideElement warns about each plain text value outside the element when encrypting
+	WarnOutsideElement bool
+	// Schema is checked before and after a document is encrypted, decrypted, or rotated,
+	// which fails if the document doesn't match it or processing changed its structure
+	Schema *schema.Schema
+	// Forbidden fails encrypting when a key or plain text value left in the document
+	// matches one of these, e.g. an unencrypted private key or AWS access key ID
+	Forbidden []*regexp.Regexp
+	// RotateFrom decrypts values when rotating, so a file can be moved to another
+	// backend: values are re-encrypted with the Sls Pki (nil to decrypt with it too)
+	RotateFrom *pki.Pki
+	// IncludePaths limits encrypting, decrypting, and rotating to the subtrees matching one of
+	// these path patterns, e.g. "db:*", and ExcludePaths leaves those matching one of them as they are
+	IncludePaths []string
+	ExcludePaths []string
+	// StyleHints records the scalar style of plain text values as metadata when encrypting,
+	// so they are written in the same style when they are decrypted
+	StyleHints bool
+	// KeepQuotes writes plain text values quoted or unquoted as they were read, e.g. on stays
+	// a boolean for Salt, values that were encrypted are still quoted when YAML 1.1 would change their type
+	KeepQuotes bool
+	// HashComments writes a comment with the start of each encrypted value's SHA-256 digest next to it,
+	// so a changed ciphertext can be seen without decrypting it
+	HashComments bool
+	// PreserveExact writes a file by replacing the text of the values that changed in the file as it
+	// was read, so no other byte changes, which fails when a change can't be made that way
+	PreserveExact bool
+	// FailOnDuplicateKeys fails reading a file with a key given more than once in the same map,
+	// which is otherwise a warning and only the last one is kept
+	FailOnDuplicateKeys bool
+	// Events receives an event for each file processed and value encrypted or decrypted (nil for none)
+	Events EventSink
+	// Context stops reading remote files and processing values once it is done, e.g. on a timeout (nil for none)
+	Context context.Context
 }
 
+// FormatVersion is the version of the files this package reads and writes, it changes
+// when a file could be written in a way that an older version can't read back
+const FormatVersion = 1
+
+// FormatFeatures are the special keys and tags this version understands in a file
+var FormatFeatures = []string{ChunksKey, DeriveKey, FileTag, ProvenanceSuffix}
+
+// DefaultMaxLineSize is the longest line read when MaxLineSize isn't set
+const DefaultMaxLineSize = 16 * 1024 * 1024
+
+// DefaultOptions are used by New
+var DefaultOptions = Options{MaxValueSize: 64 * 1024, MaxLineSize: DefaultMaxLineSize}
+
 // New returns a Sls object
 func New(filePath string, p pki.Pki, encPath string) Sls {
-	logger.Out = os.Stdout
-	s := Sls{filePath, yaml.New(), &p, false, encPath, map[string]interface{}{}, "", 0, nil}
+	return NewWithOptions(filePath, p, encPath, DefaultOptions)
+}
+
+// NewWithOptions returns a Sls object using the given options
+func NewWithOptions(filePath string, p pki.Pki, encPath string, opts Options) Sls {
+	logger.Out = logOutput
+	s := Sls{filePath, yaml.New(), &p, false, encPath, map[string]interface{}{}, "", 0, nil, opts, map[string][]string{}, map[string]string{}, false, map[string]int{}, "", map[string]string{}, nil}
+	if opts.Context != nil {
+		s.Pki.SetContext(opts.Context)
+	}
 	if len(filePath) > 0 {
 		err := s.ReadSlsFile()
 		if err != nil {
 			logger.Errorf("init error for %s: %s", s.FilePath, err)
 			s.Error = err
+			s.emit(Event{Kind: EventError, Err: err})
 		}
 	}
 
@@ -85,29 +188,87 @@ func (s *Sls) ReadBytes(buf []byte) error {
 	reader := strings.NewReader(string(buf))
 
 	err := s.ScanForIncludes(reader)
+	if err == bufio.ErrTooLong {
+		return fmt.Errorf("%s has a line longer than %d bytes", shortFileName(s.FilePath), s.maxLineSize())
+	}
 	if err != nil {
 		s.IsInclude = true
-		logger.Warnf("%s", err)
+		if err = s.classWarnf(ErrIncludeSkipped, "%s", err); err != nil {
+			return err
+		}
 	}
 
-	return yamlv3.Unmarshal(buf, &s.Yaml.Values)
+	if err = s.decode(buf); err != nil {
+		return err
+	}
+	s.crlf = bytes.Contains(buf, []byte("\r\n"))
+	s.raw = buf
+	if bytes.HasPrefix(buf, []byte("#!")) {
+		s.renderer = strings.TrimSpace(strings.SplitN(string(buf), "\n", 2)[0])
+	}
+	return s.checkWhitespace()
+}
+
+// decode loads YAML from a []byte without checking for include directives
+func (s *Sls) decode(buf []byte) error {
+	if err := s.checkConflicts(buf); err != nil {
+		return err
+	}
+	// aliases and merge keys are resolved here, so they are written out as
+	// plain values that render the same as the original document
+	var doc yamlv3.Node
+	if err := yamlv3.Unmarshal(buf, &doc); err != nil || doc.Kind == 0 {
+		return err
+	}
+	if err := s.checkDuplicateKeys(&doc); err != nil {
+		return err
+	}
+	resolved, err := resolveNode(&doc)
+	if err != nil {
+		return err
+	}
+	s.fileRefs = map[string]string{}
+	if err = s.resolveFiles("", resolved); err != nil {
+		return err
+	}
+	s.keyOrder = map[string][]string{}
+	recordKeyOrder("", resolved, s.keyOrder)
+	s.lines = map[string]int{}
+	recordLines("", resolved, s.lines)
+	s.styles = map[string]string{}
+	recordStyles("", resolved, s.styles)
+
+	return resolved.Decode(&s.Yaml.Values)
 }
 
+// includeDirective matches a top level include key, the only place Salt reads one,
+// so "include:" in a comment, a nested key, or a value doesn't count
+var includeDirective = regexp.MustCompile(`^(include|"include"|'include')[ \t]*:([ \t]|$)`)
+
 // ScanForIncludes looks for include statements in the given io.Reader
 func (s *Sls) ScanForIncludes(reader io.Reader) error {
 	// Splits on newlines by default.
 	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), s.maxLineSize())
 
 	// https://golang.org/pkg/bufio/#Scanner.Scan
 	for scanner.Scan() {
-		txt := scanner.Text()
-		if strings.Contains(txt, "include:") {
+		txt := strings.TrimRight(scanner.Text(), "\r")
+		if includeDirective.MatchString(txt) {
 			return fmt.Errorf("%s contains include directives", shortFileName(s.FilePath))
 		}
 	}
 	return scanner.Err()
 }
 
+// maxLineSize returns the longest line ScanForIncludes reads
+func (s *Sls) maxLineSize() int {
+	if s.Options.MaxLineSize > 0 {
+		return s.Options.MaxLineSize
+	}
+	return DefaultMaxLineSize
+}
+
 // ReadSlsFile open and read a yaml file, if the file has include statements
 // we throw an error as the YAML parser will try to act on the include directives
 func (s *Sls) ReadSlsFile() error {
@@ -119,17 +280,17 @@ func (s *Sls) ReadSlsFile() error {
 		return nil
 	}
 
-	// this could be called when creating a new file, so check the path
-	if _, statErr := os.Stat(s.FilePath); os.IsNotExist(statErr) {
-		dir := filepath.Dir(s.FilePath)
-		err := os.MkdirAll(dir, 0700)
-		if err != nil {
-			return err
-		}
-		_, err = os.OpenFile(s.FilePath, os.O_RDONLY|os.O_CREATE, 0600)
+	if IsRemote(s.FilePath) {
+		buf, err := ReadRemoteContext(s.context(), s.FilePath)
 		if err != nil {
 			return err
 		}
+		return s.ReadBytes(buf)
+	}
+
+	// reading never creates files, new files are only written by WriteSlsFile
+	if _, statErr := os.Stat(s.FilePath); os.IsNotExist(statErr) {
+		return fmt.Errorf("%s does not exist", s.FilePath)
 	}
 
 	fullPath, err := filepath.Abs(s.FilePath)
@@ -149,6 +310,20 @@ func (s *Sls) ReadSlsFile() error {
 // WriteSlsFile writes a buffer to the specified file
 // If the outFilePath is not stdout an INFO string will be printed to stdout
 func WriteSlsFile(buffer bytes.Buffer, outFilePath string) (int, error) {
+	return WriteSlsFileContext(context.Background(), buffer, outFilePath)
+}
+
+// WriteSlsFileContext is WriteSlsFile, giving up on writing a remote file when ctx is done
+func WriteSlsFileContext(ctx context.Context, buffer bytes.Buffer, outFilePath string) (int, error) {
+	if IsRemote(outFilePath) {
+		if err := WriteRemoteContext(ctx, outFilePath, buffer.Bytes()); err != nil {
+			return 0, err
+		}
+		metrics.Inc(metrics.FilesWritten)
+		logger.Infof("wrote out to '%s'", outFilePath)
+		return buffer.Len(), nil
+	}
+
 	fullPath, err := filepath.Abs(outFilePath)
 	if err != nil {
 		fullPath = outFilePath
@@ -176,6 +351,7 @@ func WriteSlsFile(buffer bytes.Buffer, outFilePath string) (int, error) {
 	}
 
 	if !stdOut && err == nil {
+		metrics.Inc(metrics.FilesWritten)
 		shortFile := shortFileName(outFilePath)
 		logger.Infof("wrote out to file: '%s'", shortFile)
 	}
@@ -183,9 +359,20 @@ func WriteSlsFile(buffer bytes.Buffer, outFilePath string) (int, error) {
 	return byteCount, err
 }
 
+// TempDir is where temporary files are written, when empty they are
+// written next to the output file so nothing is written outside of its tree
+var TempDir string
+
+// rename moves the temporary file over the output file, a variable so that the tests
+// can see where the temporary file is and make it fail as it does across devices
+var rename = os.Rename
+
 func atomicWrite(fullPath string, buffer bytes.Buffer) (int, error) {
-	_, name := path.Split(fullPath)
-	f, err := ioutil.TempFile("", fmt.Sprintf("gsp-%s", name))
+	dir, name := path.Split(fullPath)
+	if TempDir != "" {
+		dir = TempDir
+	}
+	f, err := ioutil.TempFile(dir, fmt.Sprintf(".gsp-%s", name))
 	if err != nil {
 		return 0, err
 	}
@@ -196,24 +383,33 @@ func atomicWrite(fullPath string, buffer bytes.Buffer) (int, error) {
 	if closeErr := f.Close(); err == nil {
 		err = closeErr
 	}
-	if permErr := os.Chmod(f.Name(), 0600); err == nil {
+
+	// keep the mode of an existing file
+	mode := os.FileMode(0600)
+	if fi, statErr := os.Stat(fullPath); statErr == nil {
+		mode = fi.Mode().Perm()
+	}
+	if permErr := os.Chmod(f.Name(), mode); err == nil {
 		err = permErr
 	}
 	if err == nil {
-		err = copyFile(f.Name(), fullPath)
-	}
-	if err != nil {
-		return byteCount, err
+		// the temp dir may be on another device, so fall back to copying
+		if err = rename(f.Name(), fullPath); err != nil {
+			err = copyFile(f.Name(), fullPath, mode)
+		}
 	}
 
 	if _, statErr := os.Stat(f.Name()); !os.IsNotExist(statErr) {
-		err = os.Remove(f.Name())
+		if rmErr := os.Remove(f.Name()); err == nil {
+			err = rmErr
+		}
 	}
 
 	return byteCount, err
 }
 
-func copyFile(src string, dst string) error {
+// copyFile copies src over dst, which ends up with the given mode
+func copyFile(src string, dst string, mode os.FileMode) error {
 	srcStat, err := os.Stat(src)
 	if err != nil {
 		return err
@@ -223,25 +419,28 @@ func copyFile(src string, dst string) error {
 	if err != nil {
 		return err
 	}
+	defer fsrc.Close()
 
-	fdst, err := os.Create(dst)
+	fdst, err := os.OpenFile(filepath.Clean(dst), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
 	if err != nil {
 		return err
 	}
 
 	size, err := io.Copy(fdst, fsrc)
-	if err != nil {
-		return err
+	if err == nil && size != srcStat.Size() {
+		err = fmt.Errorf("%s: %d/%d copied", src, size, srcStat.Size())
 	}
-	if size != srcStat.Size() {
-		return fmt.Errorf("%s: %d/%d copied", src, size, srcStat.Size())
+	if err == nil {
+		err = fdst.Sync()
+	}
+	if closeErr := fdst.Close(); err == nil {
+		err = closeErr
 	}
-
-	err = fsrc.Close()
 	if err != nil {
-		return fdst.Close()
+		return err
 	}
-	return err
+	// an existing file keeps its mode when it's opened
+	return os.Chmod(dst, mode)
 }
 
 // FormatBuffer returns a formatted .sls buffer with the gpg renderer line
@@ -261,13 +460,29 @@ func (s *Sls) FormatBuffer(action string) (bytes.Buffer, error) {
 		return buffer, fmt.Errorf("%s has no values to format", s.FilePath)
 	}
 
-	out, err = yamlv3.Marshal(data)
+	if action != Validate && s.Options.PreserveExact {
+		out, err = s.spliceValues()
+		if err != nil {
+			return buffer, fmt.Errorf("%s can't be written with --preserve-exact: %s", s.FilePath, err)
+		}
+		if err = s.checkFileRefs(); err != nil {
+			return buffer, err
+		}
+		_, err = buffer.Write(out)
+		return buffer, err
+	}
+
+	out, err = s.marshal(data)
 	if err != nil {
 		return buffer, fmt.Errorf("%s format error: %s", s.FilePath, err)
 	}
 
+	if err = s.checkFileRefs(); err != nil {
+		return buffer, err
+	}
+
 	if action != Validate {
-		_, err = buffer.WriteString("#!yaml|gpg\n\n")
+		_, err = buffer.WriteString(fmt.Sprintf("#!yaml|%s\n\n", s.Pki.Renderer()))
 		if err != nil {
 			return buffer, fmt.Errorf("%s format error: %s", s.FilePath, err)
 		}
@@ -277,68 +492,186 @@ func (s *Sls) FormatBuffer(action string) (bytes.Buffer, error) {
 	return buffer, err
 }
 
-// ProcessYaml encrypts elements matching keys specified on the command line
+// ProcessYaml encrypts elements matching keys specified on the command line,
+// the values are encrypted in batches when the backend supports it
 func (s *Sls) ProcessYaml(secretNames []string, secretValues []string) error {
 	var err error
+	var names []string
+	var plainTexts []string
 
 	for index := 0; index < len(secretNames); index++ {
-		cipherText := ""
-		if index >= 0 && index < len(secretValues) {
-			cipherText, err = s.Pki.EncryptSecret(secretValues[index])
+		if index < len(secretValues) && s.shouldChunk(secretValues[index]) {
+			err = s.setChunks(secretNames[index], secretValues[index])
 			if err != nil {
 				return err
 			}
+			continue
 		}
-		err = s.SetValueFromPath(secretNames[index], cipherText)
+		if index >= 0 && index < len(secretValues) {
+			s.checkSize(secretValues[index])
+			names = append(names, secretNames[index])
+			plainTexts = append(plainTexts, EncodeValue(secretValues[index]))
+			continue
+		}
+		err = s.SetValueFromPath(secretNames[index], "")
+		if err != nil {
+			return err
+		}
+	}
+
+	cipherTexts, err := s.Pki.EncryptBatch(plainTexts)
+	if err != nil {
+		metrics.Inc(metrics.Failures)
+		return err
+	}
+	metrics.Add(metrics.ValuesEncrypted, int64(len(cipherTexts)))
+	for i, name := range names {
+		err = s.SetValueFromPath(name, cipherTexts[i])
 		if err != nil {
 			return err
 		}
+		s.emit(Event{Kind: ValueEncrypted, Path: name})
 	}
 
 	return err
 }
 
-// GetValueFromPath returns the value from a path string
-func (s *Sls) GetValueFromPath(path string) interface{} {
-	parts := strings.Split(path, ":")
+// Unchanged returns true if the value at path is already value encrypted, whole or in chunks,
+// plain text values are never unchanged so that they are encrypted
+func (s *Sls) Unchanged(path string, value string) (bool, error) {
+	var items []interface{}
+	switch existing := s.GetValueFromPath(path).(type) {
+	case string:
+		items = []interface{}{existing}
+	case []interface{}:
+		items = existing
+	default:
+		return false, nil
+	}
 
-	args := make([]interface{}, len(parts))
-	for i := 0; i < len(parts); i++ {
-		args[i] = parts[i]
+	var plainText strings.Builder
+	for _, item := range items {
+		str, ok := item.(string)
+		if !ok || !isEncrypted(str) {
+			return false, nil
+		}
+		decrypted, err := s.decryptVal(str)
+		if err != nil {
+			return false, pki.Wrapf(err, "%s: %s", path, err)
+		}
+		plainText.WriteString(decrypted)
 	}
-	results := s.Yaml.Get(args...)
-	return results
+	return plainText.String() == value, nil
+}
+
+// GetValueFromPath returns the value from a path string
+func (s *Sls) GetValueFromPath(path string) interface{} {
+	return s.getParts(s.splitPath(path))
 }
 
 // SetValueFromPath returns the value from a path string
 func (s *Sls) SetValueFromPath(path string, value string) error {
-	parts := strings.Split(path, ":")
-
-	// construct the args list
-	args := make([]interface{}, len(parts)+1)
-	for i := 0; i < len(parts); i++ {
-		args[i] = parts[i]
-	}
-	args[len(args)-1] = value
-	err := s.Yaml.Set(args...)
-	if err == nil {
-		return nil
-	}
-	return fmt.Errorf("%s", err)
+	return s.setParts(s.splitPath(path), value)
 }
 
 // PerformAction takes an action string (encrypt or decrypt)
 // and applies that action on all items
 func (s *Sls) PerformAction(action string) (bytes.Buffer, error) {
+	s.emit(Event{Kind: FileStarted, Action: action})
+	buf, err := s.performAction(action)
+	if err != nil {
+		metrics.Inc(metrics.FilesFailed)
+		s.emit(Event{Kind: EventError, Action: action, Err: err})
+	} else {
+		metrics.Inc(metrics.FilesProcessed)
+	}
+	s.emit(Event{Kind: FileFinished, Action: action, Err: err})
+	return buf, err
+}
+
+// PerformActionContext is PerformAction, stopping with the context's error once ctx is done
+func (s *Sls) PerformActionContext(ctx context.Context, action string) (bytes.Buffer, error) {
+	s.Options.Context = ctx
+	s.Pki.SetContext(ctx)
+	return s.PerformAction(action)
+}
+
+// context returns the context of the options, or the background context when there isn't one
+func (s *Sls) context() context.Context {
+	if s.Options.Context == nil {
+		return context.Background()
+	}
+	return s.Options.Context
+}
+
+func (s *Sls) performAction(action string) (bytes.Buffer, error) {
 	var err error
 	var buf bytes.Buffer
 
+	if err = s.context().Err(); err != nil {
+		return buf, err
+	}
 	if validAction(action) {
 		var stuff = make(map[string]interface{})
 
-		for key := range s.Yaml.Values {
-			if s.EncryptionPath != "" {
-				vals := s.GetValueFromPath(key)
+		if action == Validate || action == Decrypt || action == Rotate {
+			if err = s.checkArmor(); err != nil {
+				return buf, err
+			}
+		}
+
+		if action == Encrypt {
+			// derived values are assembled from the values they refer to first
+			if _, err = s.Derive(nil); err != nil {
+				return buf, err
+			}
+			if s.Options.StyleHints {
+				if err = s.addStyleHints(); err != nil {
+					return buf, err
+				}
+			}
+		}
+
+		var before map[string]string
+		if s.Options.Schema != nil && action != Validate {
+			if err = s.checkSchema("before"); err != nil {
+				return buf, err
+			}
+			before = structure(s.Yaml.Values)
+		}
+
+		if action != Validate && s.filtered() {
+			// only the selected subtrees are changed, in place
+			if err = s.processFiltered(action); err != nil {
+				return buf, err
+			}
+			stuff = s.Yaml.Values
+		} else if s.EncryptionPath == "" {
+			// the whole document is processed as one map so that
+			// chunked values are found next to their __chunks entry
+			stuff, err = s.doMap(s.Yaml.Values, action)
+			if err != nil {
+				return buf, err
+			}
+		} else {
+			if _, ok := s.Yaml.Values[s.EncryptionPath]; !ok {
+				if err = s.Warnf("%s: element '%s' not found", shortFileName(s.FilePath), s.EncryptionPath); err != nil {
+					return buf, err
+				}
+			}
+			if action == Encrypt {
+				if err = s.reportOutsideElement(); err != nil {
+					return buf, err
+				}
+			}
+			for key := range s.Yaml.Values {
+				if IsMetaKey(key) {
+					if action != Validate {
+						stuff[key] = s.Yaml.Values[key]
+					}
+					continue
+				}
+				vals := s.Yaml.Values[key]
 				if s.EncryptionPath == key {
 					stuff[key], err = s.ProcessValues(vals, action)
 					if err != nil {
@@ -347,17 +680,24 @@ func (s *Sls) PerformAction(action string) (bytes.Buffer, error) {
 				} else {
 					stuff[key] = vals
 				}
-			} else {
-				vals := s.GetValueFromPath(key)
-				stuff[key], err = s.ProcessValues(vals, action)
-				if err != nil {
-					return buf, err
-				}
 			}
 		}
 		if action != Validate {
 			// replace the values in the Yaml object
 			s.Yaml.Values = stuff
+			if before != nil {
+				if err = s.checkStructure(before); err != nil {
+					return buf, err
+				}
+				if err = s.checkSchema("after"); err != nil {
+					return buf, err
+				}
+			}
+			if action == Encrypt {
+				if err = s.checkForbidden(); err != nil {
+					return buf, err
+				}
+			}
 		} else {
 			s.KeyMap = stuff
 			var vals []string
@@ -383,6 +723,109 @@ func (s *Sls) PerformAction(action string) (bytes.Buffer, error) {
 	return s.FormatBuffer(action)
 }
 
+// KeyPaths returns the key found by the validate action for each encrypted value, by YAML path
+func (s *Sls) KeyPaths() map[string]string {
+	paths := make(map[string]string)
+	flattenKeys("", s.KeyMap, paths)
+	return paths
+}
+
+// EncryptedValues returns the encrypted values in the document, by YAML path
+func (s *Sls) EncryptedValues() map[string]string {
+	paths := make(map[string]string)
+	flattenKeys("", s.Yaml.Values, paths)
+	for path, val := range paths {
+		if !isEncrypted(val) {
+			delete(paths, path)
+		}
+	}
+	return paths
+}
+
+// Redacted returns a copy of the document with encrypted values replaced by
+// '<encrypted: KEYID>' and plain text values by '<plaintext>', or their first
+// show characters when show is more than zero, no private key is needed
+func (s *Sls) Redacted(show int) map[string]interface{} {
+	return redact(s.Yaml.Values, show).(map[string]interface{})
+}
+
+// Tree returns the redacted document formatted as YAML
+func (s *Sls) Tree(show int) (bytes.Buffer, error) {
+	var buffer bytes.Buffer
+
+	out, err := s.marshal(s.Redacted(show))
+	if err != nil {
+		return buffer, fmt.Errorf("%s format error: %s", s.FilePath, err)
+	}
+	_, err = buffer.Write(out)
+
+	return buffer, err
+}
+
+func redact(val interface{}, show int) interface{} {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			if IsMetaKey(k) {
+				continue
+			}
+			out[k] = redact(item, show)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, item := range v {
+			out[i] = redact(item, show)
+		}
+		return out
+	case nil:
+		return nil
+	}
+
+	str := fmt.Sprintf("%v", val)
+	if pki.IsNaclValue(str) {
+		return "<encrypted: nacl>"
+	}
+	if isEncrypted(str) {
+		ids, err := pki.EncryptedToKeyIDs(str)
+		if err != nil {
+			return "<encrypted: unreadable>"
+		}
+		keys := make([]string, len(ids))
+		for i, id := range ids {
+			keys[i] = fmt.Sprintf("%X", id)
+		}
+		return fmt.Sprintf("<encrypted: %s>", strings.Join(keys, ", "))
+	}
+	if show <= 0 {
+		return "<plaintext>"
+	}
+	if runes := []rune(str); len(runes) > show {
+		return string(runes[:show]) + "..."
+	}
+	return val
+}
+
+func flattenKeys(prefix string, val interface{}, paths map[string]string) {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		for k, item := range v {
+			if IsMetaKey(k) {
+				continue
+			}
+			flattenKeys(JoinPath(prefix, k), item, paths)
+		}
+	case []interface{}:
+		for i, item := range v {
+			flattenKeys(JoinPath(prefix, strconv.Itoa(i)), item, paths)
+		}
+	case nil:
+	default:
+		paths[prefix] = fmt.Sprintf("%v", v)
+	}
+}
+
 // ProcessValues will encrypt or decrypt given values
 func (s *Sls) ProcessValues(vals interface{}, action string) (interface{}, error) {
 	var res interface{}
@@ -408,8 +851,19 @@ func (s *Sls) doSlice(vals interface{}, action string) (interface{}, error) {
 		return things, nil
 	}
 
-	for _, item := range vals.([]interface{}) {
+	for i, item := range vals.([]interface{}) {
 		var thing interface{}
+		if item == nil {
+			things = append(things, nil)
+			continue
+		}
+		if !supportedType(item) {
+			if err := s.Warnf("%s: item %d is left as is, %s", shortFileName(s.FilePath), i, unsupportedType); err != nil {
+				return vals, err
+			}
+			things = append(things, item)
+			continue
+		}
 		vtype := reflect.TypeOf(item).Kind()
 
 		switch vtype {
@@ -442,9 +896,38 @@ func (s *Sls) doMap(vals map[string]interface{}, action string) (map[string]inte
 	var ret = make(map[string]interface{})
 	var err error
 
+	chunked, err := s.readChunks(vals, action, ret)
+	if err != nil {
+		return ret, err
+	}
+
 	for key, val := range vals {
+		if chunked[key] {
+			continue
+		}
 		if val == nil {
-			return ret, err
+			ret[key] = nil
+			continue
+		}
+		if IsMetaKey(key) {
+			if _, done := ret[key]; !done && action != Validate {
+				ret[key] = val
+			}
+			continue
+		}
+		if !supportedType(val) {
+			if err = s.Warnf("%s: '%s' is left as is, %s", shortFileName(s.FilePath), key, unsupportedType); err != nil {
+				return ret, err
+			}
+			ret[key] = val
+			continue
+		}
+		if action == Encrypt && s.shouldChunk(val) {
+			ret[key], err = s.encryptChunks(key, val.(string), ret)
+			if err != nil {
+				return ret, err
+			}
+			continue
 		}
 
 		vtype := reflect.TypeOf(val).Kind()
@@ -456,6 +939,9 @@ func (s *Sls) doMap(vals map[string]interface{}, action string) (map[string]inte
 		default:
 			ret[key], err = s.doString(val, action)
 		}
+		if err != nil {
+			return ret, err
+		}
 	}
 
 	return ret, err
@@ -468,6 +954,9 @@ func (s *Sls) doString(val interface{}, action string) (string, error) {
 	// and allow it to be used as a string output with sprintf
 	strVal := fmt.Sprintf("%v", val)
 
+	if err = s.context().Err(); err != nil {
+		return strVal, err
+	}
 	switch action {
 	case Decrypt:
 		strVal, err = s.decryptVal(strVal)
@@ -476,10 +965,14 @@ func (s *Sls) doString(val interface{}, action string) (string, error) {
 		}
 	case Encrypt:
 		if !isEncrypted(strVal) {
-			strVal, err = s.Pki.EncryptSecret(strVal)
+			s.checkSize(strVal)
+			strVal, err = s.Pki.EncryptSecret(EncodeValue(strVal))
 			if err != nil {
+				metrics.Inc(metrics.Failures)
 				return strVal, err
 			}
+			metrics.Inc(metrics.ValuesEncrypted)
+			s.emit(Event{Kind: ValueEncrypted})
 		}
 	case Validate:
 		strVal, err = s.keyInfo(strVal)
@@ -497,41 +990,48 @@ func (s *Sls) doString(val interface{}, action string) (string, error) {
 }
 
 func (s *Sls) rotateVal(strVal string) (string, error) {
-	strVal, err := s.decryptVal(strVal)
+	var err error
+	if s.Options.RotateFrom != nil && isEncrypted(strVal) {
+		strVal, err = s.Options.RotateFrom.DecryptSecret(strVal)
+		if err != nil {
+			return strVal, decryptError(err)
+		}
+		metrics.Inc(metrics.ValuesDecrypted)
+		s.emit(Event{Kind: ValueDecrypted})
+		strVal = DecodeValue(strVal)
+	} else {
+		strVal, err = s.decryptVal(strVal)
+		if err != nil {
+			return strVal, err
+		}
+	}
+	strVal, err = s.Pki.EncryptSecret(EncodeValue(strVal))
 	if err != nil {
+		metrics.Inc(metrics.Failures)
 		return strVal, err
 	}
-	return s.Pki.EncryptSecret(strVal)
+	metrics.Inc(metrics.ValuesEncrypted)
+	s.emit(Event{Kind: ValueEncrypted})
+	return strVal, nil
 }
 
 func isEncrypted(str string) bool {
-	return strings.Contains(str, pki.PGPHeader)
+	return strings.Contains(str, pki.PGPHeader) || pki.IsNaclValue(str)
 }
 
 func (s *Sls) keyInfo(val string) (string, error) {
 	if !isEncrypted(val) {
-		return val, fmt.Errorf("value is not encrypted")
+		return val, pki.Errorf(ErrNotEncrypted, "value is not encrypted")
 	}
-
-	tmpfile, err := ioutil.TempFile("", "gsp-")
-	if err != nil {
-		return val, fmt.Errorf("keyInfo: %s", err)
-	}
-
-	if _, err = tmpfile.Write([]byte(val)); err != nil {
-		return val, fmt.Errorf("keyInfo: %s", err)
+	if !pki.IsNaclValue(val) {
+		if err := pki.CheckArmor(val); err != nil {
+			return val, err
+		}
 	}
 
-	keyInfo, err := s.Pki.KeyUsedForEncryptedFile(tmpfile.Name())
+	keyInfo, err := s.Pki.KeyUsedForEncryptedText(val)
 	if err != nil {
-		return val, fmt.Errorf("keyInfo: %s", err)
-	}
-
-	if err = tmpfile.Close(); err != nil {
-		return val, fmt.Errorf("keyInfo: %s", err)
-	}
-	if err = os.Remove(tmpfile.Name()); err != nil {
-		return val, fmt.Errorf("keyInfo: %s", err)
+		return val, pki.Wrapf(err, "keyInfo: %s", err)
 	}
 
 	return keyInfo, nil
@@ -541,11 +1041,20 @@ func (s *Sls) decryptVal(strVal string) (string, error) {
 	var plainText string
 
 	if isEncrypted(strVal) {
+		if !pki.IsNaclValue(strVal) {
+			if err := pki.CheckArmor(strVal); err != nil {
+				metrics.Inc(metrics.Failures)
+				return strVal, err
+			}
+		}
 		var err error
 		plainText, err = s.Pki.DecryptSecret(strVal)
 		if err != nil {
-			return strVal, fmt.Errorf("error decrypting value: %s", err)
+			return strVal, decryptError(err)
 		}
+		metrics.Inc(metrics.ValuesDecrypted)
+		s.emit(Event{Kind: ValueDecrypted})
+		plainText = DecodeValue(plainText)
 	} else {
 		return strVal, nil
 	}
@@ -553,6 +1062,27 @@ func (s *Sls) decryptVal(strVal string) (string, error) {
 	return plainText, nil
 }
 
+// decryptError counts a failure to decrypt, separately when the passphrase was the problem
+func decryptError(err error) error {
+	if pki.IsPassphraseError(err) {
+		metrics.Inc(metrics.PassphraseFailures)
+		return fmt.Errorf("error decrypting value, wrong or missing passphrase: %s", err)
+	}
+	metrics.Inc(metrics.Failures)
+	return pki.Wrapf(err, "error decrypting value: %s", err)
+}
+
+const unsupportedType = "maps with keys that are not strings are not supported"
+
+// supportedType returns false for values that can't be processed, i.e. maps with non-string keys
+func supportedType(val interface{}) bool {
+	if reflect.TypeOf(val).Kind() != reflect.Map {
+		return true
+	}
+	_, ok := val.(map[string]interface{})
+	return ok
+}
+
 func validAction(action string) bool {
 	return action == Encrypt || action == Decrypt || action == Validate || action == Rotate
 }
@@ -0,0 +1,157 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package sls
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// Assert fails the test if the condition is false
+func Assert(tb testing.TB, condition bool, msg string, v ...interface{}) {
+	if !condition {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d: "+msg+"\033[39m\n\n", append([]interface{}{filepath.Base(file), line}, v...)...)
+		tb.FailNow()
+	}
+}
+
+// Ok fails the test if the `err` is not nil
+func Ok(tb testing.TB, err error) {
+	if err != nil {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d: Unexpected error: %s\033[39m\n\n", filepath.Base(file), line, err.Error())
+		tb.FailNow()
+	}
+}
+
+// Equals fails the test if exp is not equal to act
+func Equals(tb testing.TB, exp, act interface{}) {
+	if !reflect.DeepEqual(exp, act) {
+		_, file, line, _ := runtime.Caller(1)
+		fmt.Printf("\033[31m%s:%d:\n\n\tExpected: %#v\n\n\tGot: %#v\033[39m\n\n", filepath.Base(file), line, exp, act)
+		tb.FailNow()
+	}
+}
+
+// writeAndCheck writes content to file with atomicWrite, and checks it and its mode
+func writeAndCheck(t *testing.T, file string, content string, mode os.FileMode) {
+	n, err := atomicWrite(file, *bytes.NewBufferString(content))
+	Ok(t, err)
+	Equals(t, len(content), n)
+	data, err := ioutil.ReadFile(file)
+	Ok(t, err)
+	Equals(t, content, string(data))
+	info, err := os.Stat(file)
+	Ok(t, err)
+	Equals(t, mode, info.Mode().Perm())
+}
+
+// leftOver returns the temporary files left in dir
+func leftOver(t *testing.T, dir string) []string {
+	files, err := ioutil.ReadDir(dir)
+	Ok(t, err)
+	var names []string
+	for _, f := range files {
+		if strings.HasPrefix(f.Name(), ".gsp-") {
+			names = append(names, f.Name())
+		}
+	}
+	return names
+}
+
+func TestAtomicWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gsp-write")
+	Ok(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "pillar", "secrets.sls")
+	Ok(t, os.Mkdir(filepath.Dir(file), 0700))
+
+	var renamed []string
+	rename = func(src string, dst string) error {
+		renamed = append(renamed, src)
+		return os.Rename(src, dst)
+	}
+	defer func() { rename = os.Rename }()
+
+	// a new file is only readable by the user, the temporary file is next to it
+	writeAndCheck(t, file, "first: one\n", 0600)
+	Equals(t, 1, len(renamed))
+	Equals(t, filepath.Dir(file), filepath.Dir(renamed[0]))
+	Assert(t, strings.HasPrefix(filepath.Base(renamed[0]), ".gsp-secrets.sls"), "unexpected temporary file %s", renamed[0])
+
+	// an existing file keeps its mode and is replaced, not written over
+	Ok(t, os.Chmod(file, 0640))
+	link := filepath.Join(dir, "link.sls")
+	Ok(t, os.Link(file, link))
+	writeAndCheck(t, file, "second: two\n", 0640)
+	old, err := ioutil.ReadFile(link)
+	Ok(t, err)
+	Equals(t, "first: one\n", string(old))
+	Equals(t, []string(nil), leftOver(t, filepath.Dir(file)))
+
+	// with TempDir set the temporary file is written there
+	TempDir = filepath.Join(dir, "tmp")
+	defer func() { TempDir = "" }()
+	Ok(t, os.Mkdir(TempDir, 0700))
+	writeAndCheck(t, file, "third: three\n", 0640)
+	Equals(t, TempDir, filepath.Dir(renamed[len(renamed)-1]))
+
+	// a rename that fails, as it does across devices, falls back to copying
+	rename = func(src string, dst string) error {
+		return &os.LinkError{Op: "rename", Old: src, New: dst, Err: fmt.Errorf("invalid cross-device link")}
+	}
+	writeAndCheck(t, file, "fourth: four\n", 0640)
+	writeAndCheck(t, filepath.Join(dir, "pillar", "new.sls"), "fifth: five\n", 0600)
+	Equals(t, []string(nil), leftOver(t, TempDir))
+	Equals(t, []string(nil), leftOver(t, filepath.Dir(file)))
+
+	TempDir = filepath.Join(dir, "missing")
+	_, err = atomicWrite(file, *bytes.NewBufferString("sixth: six\n"))
+	Assert(t, err != nil && strings.Contains(err.Error(), TempDir), "expected the missing temp dir to fail the write, got %v", err)
+	data, err := ioutil.ReadFile(file)
+	Ok(t, err)
+	Equals(t, "fourth: four\n", string(data))
+}
+
+func TestEditDistance(t *testing.T) {
+	for _, tt := range []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"", "abc", 3},
+		{"backend", "backend", 0},
+		{"backnd", "backend", 1},
+		{"chiper", "cipher", 2},
+		{"kitten", "sitting", 3},
+	} {
+		Equals(t, tt.want, EditDistance(tt.a, tt.b))
+		Equals(t, tt.want, EditDistance(tt.b, tt.a))
+	}
+}
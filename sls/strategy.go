@@ -0,0 +1,143 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package sls
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MergeReplace replaces a list with the new value when values are merged
+const MergeReplace = "replace"
+
+// MergeAppend adds the new items to the end of a list
+const MergeAppend = "append"
+
+// MergeUnique adds the new items that are not already in a list, encrypted items are compared by their plain text
+const MergeUnique = "unique"
+
+// MergeStrategies lists the ways lists can be merged
+var MergeStrategies = []string{MergeReplace, MergeAppend, MergeUnique}
+
+// CheckMergeStrategy returns an error for an unknown merge strategy
+func CheckMergeStrategy(strategy string) error {
+	for _, name := range MergeStrategies {
+		if strategy == name {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown merge strategy '%s', expected one of: %s", strategy, strings.Join(MergeStrategies, ", "))
+}
+
+// MergeYaml encrypts the values like ProcessYaml and merges them into the document: maps are
+// merged key by key, anything else is replaced, and a list that a name points at is replaced
+// or has the new value added to it, as the strategy says
+func (s *Sls) MergeYaml(secretNames []string, secretValues []string, strategy string) error {
+	if err := CheckMergeStrategy(strategy); err != nil {
+		return err
+	}
+	if strategy == MergeReplace {
+		// the same as setting each value, and list items can be named with an index
+		return s.ProcessYaml(secretNames, secretValues)
+	}
+
+	if s.Yaml.Values == nil {
+		s.Yaml.Values = map[string]interface{}{}
+	}
+	// one name at a time, so that a name given more than once adds each of its values
+	for i, name := range secretNames {
+		var values []string
+		if i < len(secretValues) {
+			values = secretValues[i : i+1]
+		}
+		if strategy == MergeUnique && len(values) > 0 {
+			found, err := s.inList(name, values[0])
+			if err != nil {
+				return err
+			}
+			if found {
+				logger.Infof("%s already has the value, it was not added", name)
+				continue
+			}
+		}
+
+		part := NewWithOptions("", *s.Pki, "", s.Options)
+		if err := part.ProcessYaml([]string{name}, values); err != nil {
+			return err
+		}
+		for key, val := range part.Yaml.Values {
+			s.Yaml.Values[key] = mergeStrategy(s.Yaml.Values[key], val, strategy)
+		}
+	}
+	return nil
+}
+
+// mergeStrategy merges src over dst, lists are added to unless the strategy is replace
+func mergeStrategy(dst interface{}, src interface{}, strategy string) interface{} {
+	switch d := dst.(type) {
+	case map[string]interface{}:
+		srcMap, ok := src.(map[string]interface{})
+		if !ok {
+			return src
+		}
+		for key, val := range srcMap {
+			d[key] = mergeStrategy(d[key], val, strategy)
+		}
+		return d
+	case []interface{}:
+		if strategy == MergeReplace {
+			return src
+		}
+		if srcList, ok := src.([]interface{}); ok {
+			return append(d, srcList...)
+		}
+		if _, ok := src.(map[string]interface{}); ok {
+			return src
+		}
+		return append(d, src)
+	}
+	return src
+}
+
+// inList returns true if the value at the path, or one of its items, is the given plain text
+func (s *Sls) inList(path string, value string) (bool, error) {
+	var items []interface{}
+	switch existing := s.GetValueFromPath(path).(type) {
+	case []interface{}:
+		items = existing
+	case string:
+		items = []interface{}{existing}
+	}
+	for _, item := range items {
+		str, ok := item.(string)
+		if !ok {
+			continue
+		}
+		plainText, err := s.decryptVal(str)
+		if err != nil {
+			return false, fmt.Errorf("%s: %s (unique compares plain text, so the private key is needed)", path, err)
+		}
+		if plainText == value {
+			return true, nil
+		}
+	}
+	return false, nil
+}
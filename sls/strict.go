@@ -0,0 +1,64 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package sls
+
+import "fmt"
+
+// StrictError is returned in strict mode for a condition that is otherwise only a warning
+type StrictError struct {
+	Msg string
+	// Class is the failure class of the warning, such as ErrIncludeSkipped (nil for none)
+	Class error
+}
+
+func (e *StrictError) Error() string {
+	return e.Msg
+}
+
+// Is returns true for the failure class of the warning, for errors.Is
+func (e *StrictError) Is(target error) bool {
+	return e.Class != nil && target == e.Class
+}
+
+// IsStrictError returns true if err is a *StrictError
+func IsStrictError(err error) bool {
+	_, ok := err.(*StrictError)
+	return ok
+}
+
+// Warnf logs a warning, in strict mode the warning is returned as a *StrictError instead
+func (s *Sls) Warnf(format string, args ...interface{}) error {
+	msg := fmt.Sprintf(format, args...)
+	if s.Options.Strict {
+		return &StrictError{Msg: msg}
+	}
+	logger.Warnf("%s", msg)
+	return nil
+}
+
+// classWarnf is Warnf for a warning of a failure class, which is kept in the *StrictError
+func (s *Sls) classWarnf(class error, format string, args ...interface{}) error {
+	err := s.Warnf(format, args...)
+	if strictErr, ok := err.(*StrictError); ok {
+		strictErr.Class = class
+	}
+	return err
+}
@@ -0,0 +1,94 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package sls
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/Everbridge/generate-secure-pillar/pki"
+)
+
+// RendererLine returns the "#!" renderer line at the top of the file as it was read, "" if it has none
+func (s *Sls) RendererLine() string {
+	return s.renderer
+}
+
+// RendererProblem returns what is wrong with the renderer line for the encrypted values in
+// the file, Salt only decrypts them with the gpg (or nacl) renderer in the line, "" if nothing is
+func (s *Sls) RendererProblem() string {
+	gpg, nacl := false, false
+	for _, val := range s.EncryptedValues() {
+		if pki.IsNaclValue(val) {
+			nacl = true
+		} else {
+			gpg = true
+		}
+	}
+	renderers := map[string]bool{}
+	for _, r := range strings.Split(strings.TrimPrefix(s.renderer, "#!"), "|") {
+		renderers[strings.TrimSpace(r)] = true
+	}
+
+	for _, need := range []struct {
+		name string
+		used bool
+	}{{"gpg", gpg}, {pki.NaclBackendName, nacl}} {
+		if !need.used || renderers[need.name] {
+			continue
+		}
+		if s.renderer == "" {
+			return fmt.Sprintf("no '#!yaml|%s' renderer line, Salt won't decrypt the values", need.name)
+		}
+		return fmt.Sprintf("renderer line '%s' doesn't have %s, Salt won't decrypt the values", s.renderer, need.name)
+	}
+	return ""
+}
+
+// ArmorProblem is a PGP value with damaged armor, and what is wrong with it
+type ArmorProblem struct {
+	Path    string
+	Message string
+}
+
+// ArmorProblems checks the armor of each PGP value in the file, sorted by path
+func (s *Sls) ArmorProblems() []ArmorProblem {
+	var problems []ArmorProblem
+	for path, val := range s.EncryptedValues() {
+		if pki.IsNaclValue(val) {
+			continue
+		}
+		if err := pki.CheckArmor(val); err != nil {
+			problems = append(problems, ArmorProblem{Path: path, Message: err.Error()})
+		}
+	}
+	sort.Slice(problems, func(i, j int) bool { return problems[i].Path < problems[j].Path })
+	return problems
+}
+
+// checkArmor returns an error for the first value with damaged armor, naming the file and path
+func (s *Sls) checkArmor() error {
+	if problems := s.ArmorProblems(); len(problems) > 0 {
+		return pki.Errorf(pki.ErrCorruptArmor, "%s: %s: %s", shortFileName(s.FilePath), problems[0].Path, problems[0].Message)
+	}
+	return nil
+}
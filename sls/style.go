@@ -0,0 +1,135 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package sls
+
+import (
+	"strconv"
+
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// StyleField is the metadata field holding the scalar style a value is written in
+// when it is decrypted, so a block scalar stays a block scalar after a round trip
+const StyleField = "style"
+
+// styles are the scalar styles kept as hints, folded values are rewrapped
+// by the YAML library at its line width
+var styles = map[string]yamlv3.Style{
+	"literal": yamlv3.LiteralStyle,
+	"folded":  yamlv3.FoldedStyle,
+	"single":  yamlv3.SingleQuotedStyle,
+	"double":  yamlv3.DoubleQuotedStyle,
+}
+
+// plainStyle is recorded for a string read without quotes that would be quoted when it is written,
+// it is only used with the KeepQuotes option and never as a hint, so decrypted values are quoted
+const plainStyle = "plain"
+
+// recordStyles keeps the style of each string written in a style other
+// than the one it would be written in anyway
+func recordStyles(path string, n *yamlv3.Node, found map[string]string) {
+	switch n.Kind {
+	case yamlv3.DocumentNode:
+		for _, c := range n.Content {
+			recordStyles(path, c, found)
+		}
+	case yamlv3.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			recordStyles(JoinPath(path, n.Content[i].Value), n.Content[i+1], found)
+		}
+	case yamlv3.SequenceNode:
+		for i, c := range n.Content {
+			recordStyles(JoinPath(path, strconv.Itoa(i)), c, found)
+		}
+	case yamlv3.ScalarNode:
+		style := n.Style &^ yamlv3.TaggedStyle
+		if n.Tag != "!!str" || style == stringNode(n.Value, false).Style {
+			return
+		}
+		if style == 0 {
+			// e.g. on, which is only quoted when it is written
+			found[path] = plainStyle
+			return
+		}
+		for name, s := range styles {
+			if s == style {
+				found[path] = name
+			}
+		}
+	}
+}
+
+// addStyleHints records the style of the plain text values as metadata before
+// they are encrypted, values in lists have no metadata so their style isn't kept
+func (s *Sls) addStyleHints() error {
+	for _, path := range s.PlainValues() {
+		style, ok := s.styles[path]
+		if !ok || style == plainStyle {
+			continue
+		}
+		parts := SplitPath(path)
+		if _, ok := s.getParts(parts[:len(parts)-1]).(map[string]interface{}); !ok && len(parts) > 1 {
+			continue
+		}
+		if err := s.SetMeta(path, map[string]string{StyleField: style}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// styleHints returns the styles from the metadata by the paths the marshaler uses
+func (s *Sls) styleHints() map[string]yamlv3.Style {
+	hints := map[string]yamlv3.Style{}
+	for _, entry := range s.MetaEntries() {
+		name, ok := entry.Fields[StyleField].(string)
+		if !ok {
+			continue
+		}
+		if style, ok := styles[name]; ok {
+			hints[marshalPath(entry.Path)] = style
+		}
+	}
+	if s.Options.KeepQuotes {
+		// quoted or not as the values were read, where there is no hint
+		for path, name := range s.styles {
+			if _, ok := hints[marshalPath(path)]; ok {
+				continue
+			}
+			switch name {
+			case plainStyle:
+				hints[marshalPath(path)] = 0
+			case "single", "double":
+				hints[marshalPath(path)] = styles[name]
+			}
+		}
+	}
+	return hints
+}
+
+// marshalPath turns a path into the path the marshaler uses
+func marshalPath(path string) string {
+	joined := ""
+	for _, part := range SplitPath(path) {
+		joined = orderPath(joined, part)
+	}
+	return joined
+}
@@ -0,0 +1,281 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package sls
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// TopTarget is a target in a pillar top file, and the sls names it gives the minions it matches
+type TopTarget struct {
+	Env    string
+	Target string
+	Match  string
+	Names  []string
+}
+
+// Minion is the minion ID and grains a target is matched against, a grain can have more than one value
+type Minion struct {
+	ID     string
+	Grains map[string][]string
+}
+
+// ReadTop reads the targets of a pillar top file, sorted by environment and in the order of the file,
+// the top file is read as plain YAML, so one that needs a renderer (e.g. jinja) can't be read
+func ReadTop(file string) ([]TopTarget, error) {
+	buf, err := ioutil.ReadFile(filepath.Clean(file))
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.Node
+	if err = yaml.Unmarshal(buf, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %s", shortFileName(file), err)
+	}
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s: not a top file", shortFileName(file))
+	}
+
+	var targets []TopTarget
+	envs := doc.Content[0].Content
+	for i := 0; i+1 < len(envs); i += 2 {
+		if envs[i+1].Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("%s: environment '%s' is not a map of targets", shortFileName(file), envs[i].Value)
+		}
+		entries := envs[i+1].Content
+		for j := 0; j+1 < len(entries); j += 2 {
+			t := TopTarget{Env: envs[i].Value, Target: entries[j].Value, Match: "glob"}
+			var items []interface{}
+			if err = entries[j+1].Decode(&items); err != nil {
+				return nil, fmt.Errorf("%s: target '%s': %s", shortFileName(file), t.Target, err)
+			}
+			for _, item := range items {
+				switch v := item.(type) {
+				case string:
+					t.Names = append(t.Names, v)
+				case map[string]interface{}:
+					if match, ok := v["match"].(string); ok {
+						t.Match = match
+					}
+				}
+			}
+			targets = append(targets, t)
+		}
+	}
+	sort.SliceStable(targets, func(i, j int) bool { return targets[i].Env < targets[j].Env })
+	return targets, nil
+}
+
+// ResolveDir returns the file to use for a directory given as a file: its init.sls, the way Salt
+// finds the sls named by a directory, or else the only sls its top.sls names, if it names one
+func ResolveDir(dir string) (string, error) {
+	initFile := filepath.Join(dir, "init.sls")
+	if fi, err := os.Stat(initFile); err == nil && fi.Mode().IsRegular() {
+		return initFile, nil
+	}
+	topFile := filepath.Join(dir, "top.sls")
+	if _, err := os.Stat(topFile); err != nil {
+		return "", fmt.Errorf("%s is a directory without an init.sls or top.sls", dir)
+	}
+	targets, err := ReadTop(topFile)
+	if err != nil {
+		return "", err
+	}
+
+	var files []string
+	seen := map[string]bool{}
+	for _, t := range targets {
+		for _, name := range t.Names {
+			file, err := IncludeFile(dir, topFile, name)
+			if err == nil && !seen[file] {
+				seen[file] = true
+				files = append(files, file)
+			}
+		}
+	}
+	switch len(files) {
+	case 0:
+		return "", fmt.Errorf("%s has no init.sls and its top.sls names no sls files in it", dir)
+	case 1:
+		return files[0], nil
+	}
+	sort.Strings(files)
+	for i, file := range files {
+		if rel, err := filepath.Rel(dir, file); err == nil {
+			files[i] = rel
+		}
+	}
+	return "", fmt.Errorf("%s has no init.sls and its top.sls names %d sls files, give one of them: %s", dir, len(files), strings.Join(files, ", "))
+}
+
+// Matches returns true if the target matches the minion, an error for a match type that
+// needs data only the master has (pillar, ipcidr, nodegroup)
+func (t TopTarget) Matches(m Minion) (bool, error) {
+	switch t.Match {
+	case "glob":
+		return globMatch(t.Target, m.ID), nil
+	case "pcre":
+		return regexMatch(t.Target, m.ID)
+	case "list":
+		return listMatch(t.Target, m.ID), nil
+	case "grain":
+		return m.grainMatch(t.Target, false)
+	case "grain_pcre":
+		return m.grainMatch(t.Target, true)
+	case "compound":
+		c := compound{words: strings.Fields(t.Target), minion: m}
+		ok, err := c.or()
+		if err == nil && c.pos < len(c.words) {
+			err = fmt.Errorf("unexpected '%s'", c.words[c.pos])
+		}
+		if err != nil {
+			return false, fmt.Errorf("compound target '%s': %s", t.Target, err)
+		}
+		return ok, nil
+	}
+	return false, fmt.Errorf("target '%s': match type '%s' can't be simulated", t.Target, t.Match)
+}
+
+func globMatch(pattern string, s string) bool {
+	ok, err := path.Match(pattern, s)
+	return err == nil && ok
+}
+
+// regexMatch matches from the start of s, like Salt's re.match
+func regexMatch(pattern string, s string) (bool, error) {
+	re, err := regexp.Compile("^(?:" + pattern + ")")
+	if err != nil {
+		return false, err
+	}
+	return re.MatchString(s), nil
+}
+
+func listMatch(list string, id string) bool {
+	for _, item := range strings.Split(list, ",") {
+		if strings.TrimSpace(item) == id {
+			return true
+		}
+	}
+	return false
+}
+
+// grainMatch matches "<grain>:<value>", nested grains are written with colons ("a:b:value")
+func (m Minion) grainMatch(target string, pcre bool) (bool, error) {
+	i := strings.LastIndex(target, ":")
+	if i < 0 {
+		return false, fmt.Errorf("grain target '%s' has no value", target)
+	}
+	for _, val := range m.Grains[target[:i]] {
+		if !pcre && globMatch(target[i+1:], val) {
+			return true, nil
+		}
+		if pcre {
+			if ok, err := regexMatch(target[i+1:], val); err != nil || ok {
+				return ok, err
+			}
+		}
+	}
+	return false, nil
+}
+
+// compound evaluates a compound target: words joined with and, or, and not, and grouped with
+// parentheses (separated by spaces, as Salt requires)
+type compound struct {
+	words  []string
+	pos    int
+	minion Minion
+}
+
+func (c *compound) next() string {
+	if c.pos < len(c.words) {
+		return c.words[c.pos]
+	}
+	return ""
+}
+
+func (c *compound) or() (bool, error) {
+	ok, err := c.and()
+	for err == nil && c.next() == "or" {
+		c.pos++
+		var right bool
+		right, err = c.and()
+		ok = ok || right
+	}
+	return ok, err
+}
+
+func (c *compound) and() (bool, error) {
+	ok, err := c.not()
+	for err == nil && c.next() == "and" {
+		c.pos++
+		var right bool
+		right, err = c.not()
+		ok = ok && right
+	}
+	return ok, err
+}
+
+func (c *compound) not() (bool, error) {
+	word := c.next()
+	c.pos++
+	switch word {
+	case "":
+		return false, fmt.Errorf("unexpected end")
+	case "not":
+		ok, err := c.not()
+		return !ok, err
+	case "(":
+		ok, err := c.or()
+		if err == nil && c.next() != ")" {
+			err = fmt.Errorf("missing ')'")
+		}
+		c.pos++
+		return ok, err
+	case "and", "or", ")":
+		return false, fmt.Errorf("unexpected '%s'", word)
+	}
+	return c.word(word)
+}
+
+func (c *compound) word(word string) (bool, error) {
+	if len(word) < 2 || word[1] != '@' {
+		return globMatch(word, c.minion.ID), nil
+	}
+	switch word[0] {
+	case 'G':
+		return c.minion.grainMatch(word[2:], false)
+	case 'P':
+		return c.minion.grainMatch(word[2:], true)
+	case 'E':
+		return regexMatch(word[2:], c.minion.ID)
+	case 'L':
+		return listMatch(word[2:], c.minion.ID), nil
+	}
+	return false, fmt.Errorf("'%s' can't be simulated", word)
+}
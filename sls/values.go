@@ -0,0 +1,357 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package sls
+
+import (
+	"bytes"
+	"encoding/base64"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/Everbridge/generate-secure-pillar/pki"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// Base64Marker prefixes plain text that was base64 encoded before encryption
+// because it is not valid UTF-8 and would not survive a YAML round trip
+const Base64Marker = "gsp:base64:"
+
+// yaml11Words are plain scalars that YAML 1.1 parsers (such as the one Salt uses)
+// read as booleans or nulls, but YAML 1.2 writers leave unquoted
+var yaml11Words = map[string]bool{
+	"y": true, "yes": true, "n": true, "no": true,
+	"on": true, "off": true, "true": true, "false": true,
+	"null": true, "~": true,
+	// the value and merge keys, which Salt's YAML loader can't read as a value
+	"=": true, "<<": true,
+}
+
+// yaml11Sexagesimal matches base 60 numbers, e.g. 1:20 or 190:20:30.15, which YAML 1.1
+// parsers read as integers or floats, but YAML 1.2 writers leave unquoted
+var yaml11Sexagesimal = regexp.MustCompile(`^[-+]?[0-9][0-9_]*(:[0-5]?[0-9])+(\.[0-9_]*)?$`)
+
+// yaml11Reinterpreted returns true if a YAML 1.1 parser would read the plain scalar
+// as something other than a string, numbers the YAML library quotes itself
+func yaml11Reinterpreted(val string) bool {
+	return yaml11Words[strings.ToLower(val)] || yaml11Sexagesimal.MatchString(val)
+}
+
+// EncodeValue prepares plain text for encryption, values that are
+// not valid UTF-8 are base64 encoded with a marker
+func EncodeValue(plainText string) string {
+	if utf8.ValidString(plainText) {
+		return plainText
+	}
+	return Base64Marker + base64.StdEncoding.EncodeToString([]byte(plainText))
+}
+
+// DecodeValue reverses EncodeValue on decrypted plain text
+func DecodeValue(plainText string) string {
+	if !strings.HasPrefix(plainText, Base64Marker) {
+		return plainText
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(plainText, Base64Marker))
+	if err != nil {
+		return plainText
+	}
+	return string(decoded)
+}
+
+// KeyOrderSorted writes map keys sorted, as the YAML library does
+const KeyOrderSorted = "sorted"
+
+// KeyOrderOriginal writes map keys in the order they were read, new keys follow sorted
+const KeyOrderOriginal = "original"
+
+// DefaultIndent is the number of spaces per nesting level
+const DefaultIndent = 4
+
+// marshalSafe marshals the data as YAML making sure string values read back
+// the same in YAML 1.1 parsers: multi-line values use literal block scalars
+// where possible and words like 'yes' or 'off' are quoted
+func marshalSafe(data interface{}) ([]byte, error) {
+	return marshaler{}.marshal(data)
+}
+
+// marshal marshals the data like marshalSafe, with the key order, indent,
+// and armor width options
+func (s *Sls) marshal(data interface{}) ([]byte, error) {
+	return marshaler{opts: s.Options, order: s.keyOrder, styles: s.styleHints()}.marshal(data)
+}
+
+// marshaler applies the output options, order holds the original key
+// order of each map by path and styles the style hints of values by path
+type marshaler struct {
+	opts   Options
+	order  map[string][]string
+	styles map[string]yamlv3.Style
+}
+
+func (m marshaler) marshal(data interface{}) ([]byte, error) {
+	n, err := m.toNode("", data)
+	if err != nil {
+		return nil, err
+	}
+
+	indent := m.opts.Indent
+	if indent == 0 {
+		indent = DefaultIndent
+	}
+	var buffer bytes.Buffer
+	enc := yamlv3.NewEncoder(&buffer)
+	enc.SetIndent(indent)
+	if err = enc.Encode(n); err != nil {
+		return nil, err
+	}
+	if err = enc.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func (m marshaler) toNode(path string, v interface{}) (*yamlv3.Node, error) {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		n := &yamlv3.Node{Kind: yamlv3.MappingNode, Tag: "!!map"}
+		keys, err := m.keys(path, val)
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range keys {
+			item, err := m.toNode(orderPath(path, k), val[k])
+			if err != nil {
+				return nil, err
+			}
+			// a comment goes above the key, after a block scalar it would be part of the value
+			key := stringNode(k, true)
+			key.HeadComment, item.HeadComment = item.HeadComment, ""
+			n.Content = append(n.Content, key, item)
+		}
+		return n, nil
+	case []interface{}:
+		n := &yamlv3.Node{Kind: yamlv3.SequenceNode, Tag: "!!seq"}
+		for i, v := range val {
+			item, err := m.toNode(orderPath(path, strconv.Itoa(i)), v)
+			if err != nil {
+				return nil, err
+			}
+			n.Content = append(n.Content, item)
+		}
+		return n, nil
+	case string:
+		if m.opts.ArmorWidth > 0 && strings.Contains(val, pki.PGPHeader) {
+			val = wrapArmor(val, m.opts.ArmorWidth)
+		}
+		n := stringNode(val, false)
+		if style, ok := m.styles[path]; ok && n.Tag == "!!str" && !isEncrypted(val) {
+			n.Style = style
+		}
+		if m.opts.HashComments && isEncrypted(val) {
+			n.HeadComment = "# " + HashComment(val)
+		}
+		return n, nil
+	}
+
+	// numbers, booleans, dates, and nulls are left to the YAML library
+	out, err := yamlv3.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var doc yamlv3.Node
+	if err = yamlv3.Unmarshal(out, &doc); err != nil {
+		return nil, err
+	}
+	return doc.Content[0], nil
+}
+
+// keys returns the keys of a map in the order they are written
+func (m marshaler) keys(path string, val map[string]interface{}) ([]string, error) {
+	sorted, err := keyOrder(val)
+	if err != nil || m.opts.KeyOrder != KeyOrderOriginal {
+		return sorted, err
+	}
+
+	keys := make([]string, 0, len(sorted))
+	seen := make(map[string]bool, len(sorted))
+	for _, k := range m.order[path] {
+		if _, ok := val[k]; ok && !seen[k] {
+			keys = append(keys, k)
+			seen[k] = true
+		}
+	}
+	for _, k := range sorted {
+		if !seen[k] {
+			keys = append(keys, k)
+		}
+	}
+	return keys, nil
+}
+
+// orderPath joins keys with a separator that can't be in a key, unlike PathDelimiter
+func orderPath(path string, key string) string {
+	return path + "\x00" + key
+}
+
+// recordKeyOrder keeps the order of the keys of each map in a document
+func recordKeyOrder(path string, n *yamlv3.Node, order map[string][]string) {
+	switch n.Kind {
+	case yamlv3.DocumentNode:
+		for _, c := range n.Content {
+			recordKeyOrder(path, c, order)
+		}
+	case yamlv3.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key := n.Content[i].Value
+			order[path] = append(order[path], key)
+			recordKeyOrder(orderPath(path, key), n.Content[i+1], order)
+		}
+	case yamlv3.SequenceNode:
+		for i, c := range n.Content {
+			recordKeyOrder(orderPath(path, strconv.Itoa(i)), c, order)
+		}
+	}
+}
+
+// recordLines keeps the line each path starts on, aliased values
+// keep the line of the value they refer to
+func recordLines(path string, n *yamlv3.Node, lines map[string]int) {
+	switch n.Kind {
+	case yamlv3.DocumentNode:
+		for _, c := range n.Content {
+			recordLines(path, c, lines)
+		}
+	case yamlv3.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			p := JoinPath(path, n.Content[i].Value)
+			lines[p] = n.Content[i].Line
+			recordLines(p, n.Content[i+1], lines)
+		}
+	case yamlv3.SequenceNode:
+		for i, c := range n.Content {
+			p := JoinPath(path, strconv.Itoa(i))
+			lines[p] = c.Line
+			recordLines(p, c, lines)
+		}
+	}
+}
+
+// Line returns the line a path starts on in the file as it was read, 0 when it isn't known
+func (s *Sls) Line(path string) int {
+	return s.lines[path]
+}
+
+// wrapArmor rewraps the base64 lines of an armored PGP message at width characters
+func wrapArmor(armored string, width int) string {
+	lines := strings.Split(armored, "\n")
+	var out []string
+	var body strings.Builder
+	inBody := false
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "-----BEGIN"):
+			out = append(out, line)
+		case !inBody && strings.TrimSpace(line) == "" && i > 0 && strings.HasPrefix(lines[0], "-----BEGIN"):
+			// the blank line after the armor headers
+			out = append(out, line)
+			inBody = true
+		case inBody && (strings.HasPrefix(line, "=") || strings.HasPrefix(line, "-----END")):
+			encoded := body.String()
+			for len(encoded) > width {
+				out = append(out, encoded[:width])
+				encoded = encoded[width:]
+			}
+			if encoded != "" {
+				out = append(out, encoded)
+			}
+			body.Reset()
+			inBody = false
+			out = append(out, line)
+		case inBody:
+			body.WriteString(strings.TrimSpace(line))
+		default:
+			out = append(out, line)
+		}
+	}
+	return strings.Join(out, "\n")
+}
+
+// keyOrder returns the keys of a map in the order the YAML library writes them
+func keyOrder(m map[string]interface{}) ([]string, error) {
+	keysOnly := make(map[string]interface{}, len(m))
+	for k := range m {
+		keysOnly[k] = nil
+	}
+	out, err := yamlv3.Marshal(keysOnly)
+	if err != nil {
+		return nil, err
+	}
+	var doc yamlv3.Node
+	if err = yamlv3.Unmarshal(out, &doc); err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	if len(doc.Content) > 0 {
+		for i := 0; i < len(doc.Content[0].Content); i += 2 {
+			keys = append(keys, doc.Content[0].Content[i].Value)
+		}
+	}
+	return keys, nil
+}
+
+func stringNode(val string, isKey bool) *yamlv3.Node {
+	n := &yamlv3.Node{Kind: yamlv3.ScalarNode, Tag: "!!str", Value: val}
+	switch {
+	case !utf8.ValidString(val):
+		n.Tag = "!!binary"
+		n.Value = base64.StdEncoding.EncodeToString([]byte(val))
+	case strings.Contains(val, "\n"):
+		n.Style = yamlv3.DoubleQuotedStyle
+		if !isKey && literalSafe(val) {
+			n.Style = yamlv3.LiteralStyle
+		}
+	case yaml11Reinterpreted(val):
+		n.Style = yamlv3.SingleQuotedStyle
+	}
+	return n
+}
+
+// literalSafe returns true if a multi-line value can be written as a literal block scalar,
+// carriage returns and other control characters need the escapes of a double quoted string,
+// and values with leading or trailing whitespace on a line are left to the emitter
+func literalSafe(val string) bool {
+	if strings.HasPrefix(val, " ") || strings.HasPrefix(val, "\t") {
+		return false
+	}
+	for _, r := range val {
+		if r != '\n' && r != '\t' && (r < ' ' || r == 0x7f) {
+			return false
+		}
+	}
+	for _, line := range strings.Split(val, "\n") {
+		if strings.HasSuffix(line, " ") || strings.HasSuffix(line, "\t") {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,140 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package sls
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// what is done about CRLF line endings and whitespace in encrypted values that
+// some renderers choke on when reading a file, see the Whitespace option
+const (
+	// WhitespaceWarn logs a warning for each problem (the default)
+	WhitespaceWarn = "warn"
+	// WhitespaceError fails reading the file
+	WhitespaceError = "error"
+	// WhitespaceFix removes the whitespace from the encrypted values, and the
+	// file is written with LF line endings when it is written
+	WhitespaceFix = "fix"
+)
+
+// WhitespaceModes lists the values of the Whitespace option
+var WhitespaceModes = []string{WhitespaceWarn, WhitespaceError, WhitespaceFix}
+
+// CheckWhitespaceMode returns an error for an unknown Whitespace option
+func CheckWhitespaceMode(mode string) error {
+	for _, m := range WhitespaceModes {
+		if mode == m {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown whitespace mode '%s', expected one of: %s", mode, strings.Join(WhitespaceModes, ", "))
+}
+
+// WhitespaceProblem is a file with CRLF line endings (Path is empty) or an encrypted
+// value with whitespace that doesn't belong in an armored block
+type WhitespaceProblem struct {
+	Path    string
+	Message string
+}
+
+// WhitespaceProblems returns the whitespace problems found when the file was read, sorted by path
+func (s *Sls) WhitespaceProblems() []WhitespaceProblem {
+	var problems []WhitespaceProblem
+	if s.crlf {
+		problems = append(problems, WhitespaceProblem{Message: "CRLF line endings"})
+	}
+	for path, val := range s.EncryptedValues() {
+		if msg := armorWhitespace(val); msg != "" {
+			problems = append(problems, WhitespaceProblem{Path: path, Message: msg})
+		}
+	}
+	sort.Slice(problems, func(i, j int) bool { return problems[i].Path < problems[j].Path })
+	return problems
+}
+
+// armorWhitespace describes the first suspicious whitespace in an encrypted value
+func armorWhitespace(val string) string {
+	for i, line := range strings.Split(val, "\n") {
+		switch {
+		case strings.Contains(line, "\r"):
+			return fmt.Sprintf("carriage return on line %d of the encrypted value", i+1)
+		case strings.Contains(line, "\u00a0"):
+			return fmt.Sprintf("non-breaking space on line %d of the encrypted value", i+1)
+		case strings.TrimRight(line, " \t") != line:
+			return fmt.Sprintf("trailing whitespace on line %d of the encrypted value", i+1)
+		case strings.Contains(line, "\t"):
+			return fmt.Sprintf("tab on line %d of the encrypted value", i+1)
+		}
+	}
+	return ""
+}
+
+// cleanArmor removes carriage returns, non-breaking spaces, and tabs from an
+// encrypted value and whitespace from the end of its lines
+func cleanArmor(val string) string {
+	lines := strings.Split(val, "\n")
+	for i, line := range lines {
+		line = strings.NewReplacer("\r", "", "\u00a0", "", "\t", "").Replace(line)
+		lines[i] = strings.TrimRight(line, " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// checkWhitespace handles the whitespace problems in a file that was just read
+func (s *Sls) checkWhitespace() error {
+	problems := s.WhitespaceProblems()
+	if len(problems) == 0 {
+		return nil
+	}
+	name := shortFileName(s.FilePath)
+	switch s.Options.Whitespace {
+	case WhitespaceFix:
+		for _, p := range problems {
+			if p.Path == "" {
+				continue
+			}
+			if err := s.setParts(SplitPath(p.Path), cleanArmor(s.getParts(SplitPath(p.Path)).(string))); err != nil {
+				return err
+			}
+		}
+		logger.Infof("%s: fixed %d whitespace problems, they are gone once the file is written", name, len(problems))
+	case WhitespaceError:
+		p := problems[0]
+		if p.Path == "" {
+			return fmt.Errorf("%s: %s", name, p.Message)
+		}
+		return fmt.Errorf("%s: %s: %s", name, p.Path, p.Message)
+	default:
+		for _, p := range problems {
+			msg := fmt.Sprintf("%s: %s", name, p.Message)
+			if p.Path != "" {
+				msg = fmt.Sprintf("%s: %s: %s", name, p.Path, p.Message)
+			}
+			if err := s.Warnf("%s", msg); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
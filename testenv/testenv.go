@@ -0,0 +1,174 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package testenv creates disposable GnuPG homes with a test key pair and sample
+// pillar files, so encrypt and decrypt can be tried without touching a real keyring
+package testenv
+
+import (
+	"bytes"
+	"crypto"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/Everbridge/generate-secure-pillar/pki"
+	"github.com/keybase/go-crypto/openpgp"
+	"github.com/keybase/go-crypto/openpgp/packet"
+)
+
+// KeyName is the name of the generated test key
+const KeyName = "Test Salt Master"
+
+// KeyComment is the comment of the generated test key
+const KeyComment = "test key"
+
+// samples are the pillar files written to the pillar directory
+var samples = map[string]string{
+	"secrets.sls": "secret_stuff:\n    db_password: hunter2\n    api_key: abc123\n",
+	"app/db.sls":  "db:\n    user: app\n    password: s3cret\n    hosts:\n        - db1.example.com\n        - db2.example.com\n",
+}
+
+// Env is a test environment, its GnuPG home holds pubring.gpg and secring.gpg
+// for the pgp backend and is imported into gpg when a gpg binary is found
+type Env struct {
+	Dir         string `json:"dir"`
+	GnupgHome   string `json:"gnupg_home"`
+	PubRing     string `json:"pubring"`
+	SecRing     string `json:"secring"`
+	PillarDir   string `json:"pillar_dir"`
+	KeyName     string `json:"key_name"`
+	Fingerprint string `json:"fingerprint"`
+	GPGImported bool   `json:"gpg_imported"`
+}
+
+// New creates a test environment in dir, which must not exist or be empty,
+// a temporary directory is created when dir is empty
+func New(dir string) (*Env, error) {
+	var err error
+	if dir == "" {
+		dir, err = ioutil.TempDir("", "gsp-test-env")
+		if err != nil {
+			return nil, err
+		}
+	} else if files, err := ioutil.ReadDir(dir); err == nil && len(files) > 0 {
+		return nil, fmt.Errorf("%s is not empty", dir)
+	}
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	env := Env{
+		Dir:       dir,
+		GnupgHome: filepath.Join(dir, "gnupg"),
+		PillarDir: filepath.Join(dir, "pillar"),
+		KeyName:   KeyName,
+	}
+	env.PubRing = filepath.Join(env.GnupgHome, "pubring.gpg")
+	env.SecRing = filepath.Join(env.GnupgHome, "secring.gpg")
+	if err = os.MkdirAll(env.GnupgHome, 0700); err != nil {
+		return nil, err
+	}
+	// gpg warns about a home that others can read
+	if err = os.Chmod(env.GnupgHome, 0700); err != nil {
+		return nil, err
+	}
+
+	if env.Fingerprint, err = WriteKeyRings(env.GnupgHome); err != nil {
+		return nil, err
+	}
+	if err = env.writeSamples(); err != nil {
+		return nil, err
+	}
+	env.GPGImported = env.importGPG() == nil
+
+	return &env, nil
+}
+
+// WriteKeyRings generates a test key pair and writes pubring.gpg and secring.gpg
+// to gnupgHome, it returns the fingerprint of the key
+func WriteKeyRings(gnupgHome string) (string, error) {
+	config := &packet.Config{RSABits: 2048, DefaultHash: crypto.SHA256, DefaultCipher: packet.CipherAES256}
+	entity, err := openpgp.NewEntity(KeyName, KeyComment, "", config)
+	if err != nil {
+		return "", fmt.Errorf("cannot generate the test key: %s", err)
+	}
+
+	// the self signatures are made when the private key is serialized
+	var sec bytes.Buffer
+	if err = entity.SerializePrivate(&sec, config); err != nil {
+		return "", err
+	}
+	var pub bytes.Buffer
+	if err = entity.Serialize(&pub); err != nil {
+		return "", err
+	}
+	if err = ioutil.WriteFile(filepath.Join(gnupgHome, "pubring.gpg"), pub.Bytes(), 0600); err != nil {
+		return "", err
+	}
+	if err = ioutil.WriteFile(filepath.Join(gnupgHome, "secring.gpg"), sec.Bytes(), 0600); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%X", entity.PrimaryKey.Fingerprint), nil
+}
+
+// writeSamples writes plain text pillar files to encrypt
+func (env *Env) writeSamples() error {
+	for name, content := range samples {
+		file := filepath.Join(env.PillarDir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importGPG imports the key pair into the GnuPG home with ultimate trust,
+// so that the gpg backend can use it too
+func (env *Env) importGPG() error {
+	g, err := pki.NewGPGBackend(KeyName, env.GnupgHome)
+	if err != nil {
+		return err
+	}
+	steps := [][]string{
+		{"--import", env.SecRing},
+		{"--import-ownertrust"},
+	}
+	for _, args := range steps {
+		cmd := exec.Command(g.Binary, append([]string{"--homedir", env.GnupgHome, "--batch", "--yes"}, args...)...)
+		cmd.Stdin = strings.NewReader(env.Fingerprint + ":6:\n")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("gpg %s: %s: %s", args[0], err, strings.TrimSpace(string(out)))
+		}
+	}
+	return nil
+}
+
+// Remove deletes the test environment
+func (env *Env) Remove() error {
+	return os.RemoveAll(env.Dir)
+}
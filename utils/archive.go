@@ -0,0 +1,306 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package utils
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Everbridge/generate-secure-pillar/pki"
+	"github.com/Everbridge/generate-secure-pillar/sls"
+)
+
+// archive formats, chosen by the extension of the archive file name
+const (
+	ArchiveTar   = "tar"
+	ArchiveTarGz = "tar.gz"
+	ArchiveZip   = "zip"
+)
+
+// ArchiveFormat returns the format of an archive from its file name (.tar, .tar.gz or .tgz, or .zip)
+func ArchiveFormat(file string) (string, error) {
+	lower := strings.ToLower(file)
+	switch {
+	case strings.HasSuffix(lower, ".tar.gz"), strings.HasSuffix(lower, ".tgz"):
+		return ArchiveTarGz, nil
+	case strings.HasSuffix(lower, ".tar"):
+		return ArchiveTar, nil
+	case strings.HasSuffix(lower, ".zip"):
+		return ArchiveZip, nil
+	}
+	return "", fmt.Errorf("%s: unknown archive format, expected .tar, .tar.gz, .tgz, or .zip", file)
+}
+
+// ArchiveEntry is a file, directory, or link in an archive, the header it was read with is
+// kept so that it is written back the same when the archive is written in the same format
+type ArchiveEntry struct {
+	Name    string
+	Mode    os.FileMode
+	ModTime time.Time
+	Data    []byte
+	tarHdr  *tar.Header
+	zipHdr  *zip.FileHeader
+}
+
+// ReadArchive reads the entries of a tar, gzipped tar, or zip archive from a file or URL
+func ReadArchive(ctx context.Context, file string) ([]ArchiveEntry, error) {
+	format, err := ArchiveFormat(file)
+	if err != nil {
+		return nil, err
+	}
+	var buf []byte
+	if sls.IsRemote(file) {
+		buf, err = sls.ReadRemoteContext(ctx, file)
+	} else {
+		buf, err = ioutil.ReadFile(filepath.Clean(file))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if format == ArchiveZip {
+		return readZip(buf)
+	}
+	var r io.Reader = bytes.NewReader(buf)
+	if format == ArchiveTarGz {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", file, err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+	entries, err := readTar(r)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", file, err)
+	}
+	return entries, nil
+}
+
+func readTar(r io.Reader) ([]ArchiveEntry, error) {
+	var entries []ArchiveEntry
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, ArchiveEntry{hdr.Name, hdr.FileInfo().Mode(), hdr.ModTime, data, hdr, nil})
+	}
+}
+
+func readZip(buf []byte) ([]ArchiveEntry, error) {
+	zr, err := zip.NewReader(bytes.NewReader(buf), int64(len(buf)))
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]ArchiveEntry, 0, len(zr.File))
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", f.Name, err)
+		}
+		data, err := ioutil.ReadAll(rc)
+		if closeErr := rc.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", f.Name, err)
+		}
+		hdr := f.FileHeader
+		entries = append(entries, ArchiveEntry{f.Name, f.Mode(), f.Modified, data, nil, &hdr})
+	}
+	return entries, nil
+}
+
+// ArchiveFiles returns the regular files with the extension in an archive
+func ArchiveFiles(entries []ArchiveEntry, fileExt string) []*ArchiveEntry {
+	var files []*ArchiveEntry
+	for i := range entries {
+		if entries[i].Mode.IsRegular() && strings.HasSuffix(entries[i].Name, fileExt) {
+			files = append(files, &entries[i])
+		}
+	}
+	return files
+}
+
+// Sls returns a Sls for a file in an archive, its file path is the path
+// of the file in the archive under the archive's, e.g. pillar.tar.gz/app/db.sls
+func (e *ArchiveEntry) Sls(ctx context.Context, archive string, topLevelElement string, pk pki.Pki) sls.Sls {
+	opts := sls.DefaultOptions
+	opts.Context = ctx
+	s := sls.NewWithOptions("", pk, topLevelElement, opts)
+	s.FilePath = path.Join(archive, e.Name)
+	s.Error = s.ReadBytes(e.Data)
+	return s
+}
+
+// ProcessArchive applies an action to the files with the extension in the entries of an archive,
+// the changed files replace their entries (see WriteArchive) and the others are left as they are,
+// the keys of each file are printed for sls.Validate, it returns the number of files processed
+func ProcessArchive(ctx context.Context, entries []ArchiveEntry, archive string, fileExt string, action string, topLevelElement string, pk pki.Pki) (int, error) {
+	count := 0
+	for _, e := range ArchiveFiles(entries, fileExt) {
+		if ctx.Err() != nil {
+			return count, ctx.Err()
+		}
+		s := e.Sls(ctx, archive, topLevelElement, pk)
+		if s.Error != nil || s.IsInclude {
+			if s.Error != nil {
+				if err := archiveWarning(&s, s.Error); err != nil {
+					return count, err
+				}
+			}
+			continue
+		}
+
+		buf, err := s.PerformAction(action)
+		if ctx.Err() != nil {
+			return count, ctx.Err()
+		}
+		if err != nil {
+			// a file that failed is left as it was
+			if err = archiveWarning(&s, err); err != nil {
+				return count, err
+			}
+			continue
+		}
+		count++
+		if action == sls.Validate {
+			fmt.Printf("%s:\nkey count: %d\n%s\n", s.FilePath, s.KeyCount, buf.String())
+			continue
+		}
+		e.Data = buf.Bytes()
+	}
+	return count, nil
+}
+
+// archiveWarning logs an error with a file in an archive as a warning, in strict mode it is returned
+func archiveWarning(s *sls.Sls, err error) error {
+	if s.Options.Strict {
+		if !sls.IsStrictError(err) {
+			err = &sls.StrictError{Msg: fmt.Sprintf("%s: %s", s.FilePath, err), Class: pki.ErrorClass(err)}
+		}
+		return err
+	}
+	logger.Warnf("%s: %s", s.FilePath, err)
+	return nil
+}
+
+// WriteArchive writes the entries to an archive file, or an s3 or gs URL, in the format of its name,
+// the file is replaced at once so an archive can be written over itself
+func WriteArchive(ctx context.Context, entries []ArchiveEntry, file string) error {
+	format, err := ArchiveFormat(file)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	switch format {
+	case ArchiveZip:
+		err = writeZip(&buf, entries)
+	case ArchiveTarGz:
+		gz := gzip.NewWriter(&buf)
+		err = writeTar(gz, entries)
+		if closeErr := gz.Close(); err == nil {
+			err = closeErr
+		}
+	default:
+		err = writeTar(&buf, entries)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %s", file, err)
+	}
+	_, err = sls.WriteSlsFileContext(ctx, buf, file)
+	return err
+}
+
+func writeTar(w io.Writer, entries []ArchiveEntry) error {
+	tw := tar.NewWriter(w)
+	for _, e := range entries {
+		var hdr tar.Header
+		if e.tarHdr != nil {
+			hdr = *e.tarHdr
+		} else if e.Mode.IsDir() || e.Mode.IsRegular() {
+			hdr = tar.Header{Name: e.Name, Mode: int64(e.Mode.Perm()), ModTime: e.ModTime, Typeflag: tar.TypeReg}
+			if e.Mode.IsDir() {
+				hdr.Typeflag = tar.TypeDir
+			}
+		} else {
+			return fmt.Errorf("%s: only files and directories can be written to another archive format", e.Name)
+		}
+		hdr.Size = int64(len(e.Data))
+		if err := tw.WriteHeader(&hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(e.Data); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+func writeZip(w io.Writer, entries []ArchiveEntry) error {
+	zw := zip.NewWriter(w)
+	for _, e := range entries {
+		var hdr zip.FileHeader
+		if e.zipHdr != nil {
+			hdr = *e.zipHdr
+			// the sizes and checksum are those of the new data
+			hdr.CRC32, hdr.CompressedSize64, hdr.UncompressedSize64 = 0, 0, 0
+			hdr.CompressedSize, hdr.UncompressedSize = 0, 0
+		} else if e.Mode.IsDir() || e.Mode.IsRegular() {
+			hdr = zip.FileHeader{Name: e.Name, Method: zip.Deflate, Modified: e.ModTime}
+			if e.Mode.IsDir() && !strings.HasSuffix(hdr.Name, "/") {
+				hdr.Name += "/"
+			}
+			hdr.SetMode(e.Mode)
+		} else {
+			return fmt.Errorf("%s: only files and directories can be written to another archive format", e.Name)
+		}
+		fw, err := zw.CreateHeader(&hdr)
+		if err != nil {
+			return err
+		}
+		if _, err = fw.Write(e.Data); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
@@ -0,0 +1,89 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package utils
+
+import (
+	"path/filepath"
+	"sort"
+
+	"github.com/Everbridge/generate-secure-pillar/pki"
+	"github.com/Everbridge/generate-secure-pillar/sls"
+)
+
+// LogicalDocument is a file assembled with the files it includes
+type LogicalDocument struct {
+	Sls   sls.Sls
+	Files []string
+}
+
+// LogicalDocuments treats searchDir as a pillar root and returns its files as Salt assembles them:
+// a document for each file that no other file includes, made up of it and the files it includes,
+// files that are only included in a cycle start a document of their own
+func LogicalDocuments(searchDir string, fileExt string, limits WalkLimits, topLevelElement string, pk pki.Pki) ([]LogicalDocument, error) {
+	root, err := filepath.Abs(searchDir)
+	if err != nil {
+		return nil, err
+	}
+	if err = checkForDir(root); err != nil {
+		return nil, err
+	}
+
+	files, err := FindFilesByExtLimited(root, fileExt, limits)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	assembled := map[string]LogicalDocument{}
+	included := map[string]bool{}
+	for _, file := range files {
+		s, parts, err := sls.Assemble(root, file, pk, topLevelElement)
+		if err != nil {
+			return nil, err
+		}
+		assembled[file] = LogicalDocument{Sls: s, Files: parts}
+		for _, part := range parts {
+			if part != file {
+				included[part] = true
+			}
+		}
+	}
+
+	var docs []LogicalDocument
+	covered := map[string]bool{}
+	add := func(file string) {
+		doc := assembled[file]
+		docs = append(docs, doc)
+		for _, part := range doc.Files {
+			covered[part] = true
+		}
+	}
+	for _, file := range files {
+		if !included[file] {
+			add(file)
+		}
+	}
+	for _, file := range files {
+		if !covered[file] {
+			add(file)
+		}
+	}
+	return docs, nil
+}
@@ -0,0 +1,114 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package utils
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/Everbridge/generate-secure-pillar/output"
+	"github.com/Everbridge/generate-secure-pillar/pki"
+	"github.com/Everbridge/generate-secure-pillar/sls"
+)
+
+// changelog statuses, a value encrypted again to the same keys is "rewritten"
+// unless the values are decrypted to tell whether it changed
+const (
+	ChangeAdded       = "added"
+	ChangeRemoved     = "removed"
+	ChangeChanged     = "changed"
+	ChangeReEncrypted = "re-encrypted"
+	ChangeRewritten   = "rewritten"
+)
+
+// CompareSecrets lists the encrypted values that were added, removed, or changed between two
+// versions of a file, from or to is nil for a file that was added or removed. Values are
+// compared by ciphertext and the keys they are encrypted to, with decrypt the changed
+// values are decrypted to tell a new value from the same value re-encrypted
+func CompareSecrets(file string, from *sls.Sls, to *sls.Sls, decrypt bool) ([]output.SecretChange, error) {
+	fromValues := map[string]string{}
+	if from != nil {
+		fromValues = from.EncryptedValues()
+	}
+	toValues := map[string]string{}
+	if to != nil {
+		toValues = to.EncryptedValues()
+	}
+
+	paths := make([]string, 0, len(fromValues)+len(toValues))
+	for path := range fromValues {
+		paths = append(paths, path)
+	}
+	for path := range toValues {
+		if _, ok := fromValues[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	changes := []output.SecretChange{}
+	for _, path := range paths {
+		oldValue, inFrom := fromValues[path]
+		newValue, inTo := toValues[path]
+		change := output.SecretChange{File: file, Path: path, FromKeys: valueKeys(oldValue), ToKeys: valueKeys(newValue)}
+		switch {
+		case !inFrom:
+			change.Status = ChangeAdded
+		case !inTo:
+			change.Status = ChangeRemoved
+		case oldValue == newValue:
+			continue
+		case decrypt:
+			same, err := samePlainText(from, oldValue, to, newValue)
+			if err != nil {
+				return changes, fmt.Errorf("%s: %s: %s", file, path, err)
+			}
+			change.Status = ChangeChanged
+			if same {
+				change.Status = ChangeReEncrypted
+			}
+		case !reflect.DeepEqual(change.FromKeys, change.ToKeys):
+			change.Status = ChangeReEncrypted
+		default:
+			change.Status = ChangeRewritten
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+// valueKeys returns the sorted IDs of the keys a value is encrypted to, none for nacl values
+func valueKeys(value string) []string {
+	if value == "" || pki.IsNaclValue(value) {
+		return nil
+	}
+	ids, err := pki.EncryptedToKeyIDs(value)
+	if err != nil {
+		return nil
+	}
+	keys := make([]string, 0, len(ids))
+	for _, id := range ids {
+		keys = append(keys, fmt.Sprintf("%016X", id))
+	}
+	sort.Strings(keys)
+	return keys
+}
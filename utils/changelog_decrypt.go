@@ -0,0 +1,43 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+//go:build !encryptonly
+// +build !encryptonly
+
+package utils
+
+import (
+	"reflect"
+
+	"github.com/Everbridge/generate-secure-pillar/sls"
+)
+
+// samePlainText decrypts two values and compares them
+func samePlainText(from *sls.Sls, oldValue string, to *sls.Sls, newValue string) (bool, error) {
+	oldPlain, err := from.ProcessValues(oldValue, sls.Decrypt)
+	if err != nil {
+		return false, err
+	}
+	newPlain, err := to.ProcessValues(newValue, sls.Decrypt)
+	if err != nil {
+		return false, err
+	}
+	return reflect.DeepEqual(oldPlain, newPlain), nil
+}
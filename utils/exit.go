@@ -0,0 +1,57 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package utils
+
+import (
+	"errors"
+
+	"github.com/Everbridge/generate-secure-pillar/metrics"
+	"github.com/Everbridge/generate-secure-pillar/sls"
+)
+
+// exit codes, see EXIT CODES in the README
+const (
+	// ExitError is used when a command fails
+	ExitError = 1
+	// ExitUsage is used for an unknown command, argument, or flag, or a missing required flag
+	ExitUsage = 2
+	// ExitConfig is used when the config file can't be read, is invalid, or the profile can't be used
+	ExitConfig = 3
+	// ExitStrict is used when --strict turns a warning into an error
+	ExitStrict = 4
+)
+
+// ExitCode returns the exit code for an error
+func ExitCode(err error) int {
+	if sls.IsStrictError(err) {
+		return ExitStrict
+	}
+	if errors.Is(err, ErrWalkLimit) {
+		return ExitUsage
+	}
+	return ExitError
+}
+
+// Exit logs the error and exits with the exit code for it
+func Exit(err error) {
+	logger.Errorf("%s", err)
+	metrics.Exit(ExitCode(err))
+}
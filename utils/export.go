@@ -0,0 +1,368 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/Everbridge/generate-secure-pillar/sls"
+)
+
+// TfVars export target (terraform.tfvars.json)
+const TfVars = "tfvars"
+
+// EnvFile export target (KEY='value' lines)
+const EnvFile = "env"
+
+// Shell, DotEnv, and Systemd are the formats of the env command: export lines to source
+// into a shell, a .env file, and a systemd EnvironmentFile
+const (
+	Shell   = "shell"
+	DotEnv  = "dotenv"
+	Systemd = "systemd"
+)
+
+// Creds and SystemdCreds are export targets that write a file per value to a directory,
+// in plain text or encrypted with systemd-creds
+const (
+	Creds        = "creds"
+	SystemdCreds = "systemd-creds"
+)
+
+// SystemdCredsCLI encrypts credentials for the systemd-creds target
+var SystemdCredsCLI = "systemd-creds"
+
+// maskedValue replaces each value with --mask
+const maskedValue = "********"
+
+var invalidVarChars = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// ExportValues decrypts the values found at the given paths and formats them for the given target,
+// names maps a YAML path to the variable name it should be exported as
+func ExportValues(s *sls.Sls, paths []string, names map[string]string, target string) (bytes.Buffer, error) {
+	var buffer bytes.Buffer
+
+	vars, err := exportVars(s, paths, names, "", target == EnvFile)
+	if err != nil {
+		return buffer, err
+	}
+	if target == EnvFile {
+		vars = upperKeys(vars)
+	}
+
+	switch target {
+	case TfVars, "json":
+		out, err := json.MarshalIndent(vars, "", "  ")
+		if err != nil {
+			return buffer, fmt.Errorf("export format error: %s", err)
+		}
+		buffer.Write(out)
+		buffer.WriteString("\n")
+	case EnvFile:
+		keys := make([]string, 0, len(vars))
+		for k := range vars {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			buffer.WriteString(fmt.Sprintf("%s=%s\n", k, shellQuote(fmt.Sprintf("%v", vars[k]))))
+		}
+	default:
+		return buffer, fmt.Errorf("unknown export target: '%s'", target)
+	}
+
+	return buffer, nil
+}
+
+// EnvValues decrypts the values found at the given paths and formats them as environment
+// variables named with the prefix, mask replaces each value so the output can be shown
+func EnvValues(s *sls.Sls, paths []string, names map[string]string, prefix string, format string, mask bool) (bytes.Buffer, error) {
+	var buffer bytes.Buffer
+
+	if err := CheckEnvFormat(format); err != nil {
+		return buffer, err
+	}
+	vars, err := exportVars(s, paths, names, prefix, true)
+	if err != nil {
+		return buffer, err
+	}
+	vars = upperKeys(vars)
+
+	keys := make([]string, 0, len(vars))
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		val := fmt.Sprintf("%v", vars[k])
+		if mask {
+			val = maskedValue
+		}
+		switch format {
+		case Shell:
+			buffer.WriteString(fmt.Sprintf("export %s=%s\n", k, shellQuote(val)))
+		default:
+			buffer.WriteString(fmt.Sprintf("%s=%s\n", k, doubleQuote(val, format)))
+		}
+	}
+
+	return buffer, nil
+}
+
+// CheckEnvFormat returns an error if the format is not shell, dotenv, or systemd
+func CheckEnvFormat(format string) error {
+	if format != Shell && format != DotEnv && format != Systemd {
+		return fmt.Errorf("unknown format '%s', expected %s, %s, or %s", format, Shell, DotEnv, Systemd)
+	}
+	return nil
+}
+
+// exportVars decrypts the values at the paths (by default, all of the values under the
+// top level element), flat gives a variable for each value in maps and lists
+func exportVars(s *sls.Sls, paths []string, names map[string]string, prefix string, flat bool) (map[string]interface{}, error) {
+	if len(paths) == 0 {
+		paths = defaultExportPaths(s)
+	}
+
+	vars := make(map[string]interface{})
+	for _, path := range paths {
+		if !s.PathExists(path) {
+			return vars, s.PathError(path)
+		}
+		plainText, err := s.ProcessValues(s.GetValueFromPath(path), sls.Decrypt)
+		if sls.IsStrictError(err) {
+			return vars, err
+		} else if err != nil {
+			return vars, fmt.Errorf("export of '%s' failed: %s", path, err)
+		}
+		plainText = stripMeta(plainText)
+
+		if flat {
+			values := make(map[string]interface{})
+			flattenValues(path, plainText, values)
+			for p, v := range values {
+				vars[prefix+ExportName(p, s.EncryptionPath, names)] = v
+			}
+		} else {
+			vars[prefix+ExportName(path, s.EncryptionPath, names)] = plainText
+		}
+	}
+	return vars, nil
+}
+
+// upperKeys returns the variables with their names in upper case, as environment variables are
+func upperKeys(vars map[string]interface{}) map[string]interface{} {
+	res := make(map[string]interface{}, len(vars))
+	for k, v := range vars {
+		res[strings.ToUpper(k)] = v
+	}
+	return res
+}
+
+// WriteCredentials decrypts the values found at the given paths and writes each of them to a file
+// named after it in dir, for systemd's LoadCredential= (or, encrypted with systemd-creds, for
+// LoadCredentialEncrypted=), the directory is created with mode 0700 and the files with 0600
+func WriteCredentials(s *sls.Sls, paths []string, names map[string]string, dir string, encrypt bool) ([]string, error) {
+	vars, err := exportVars(s, paths, names, "", true)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	if err = os.Chmod(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	creds := make([]string, 0, len(vars))
+	for name := range vars {
+		creds = append(creds, name)
+	}
+	sort.Strings(creds)
+	for _, name := range creds {
+		file := filepath.Join(dir, name)
+		value := []byte(fmt.Sprintf("%v", vars[name]))
+		if encrypt {
+			err = encryptCredential(name, value, file)
+		} else {
+			err = writeCredential(value, file)
+		}
+		if err != nil {
+			return creds, fmt.Errorf("credential '%s': %s", name, err)
+		}
+	}
+	return creds, nil
+}
+
+// writeCredential replaces a credential file, it is never readable by others, even briefly
+func writeCredential(value []byte, file string) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(file), ".cred-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(value); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// encryptCredential runs systemd-creds to encrypt a credential (with the host key and/or TPM2,
+// as systemd-creds chooses by default), the value is passed on stdin
+func encryptCredential(name string, value []byte, file string) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command(SystemdCredsCLI, "encrypt", "--name="+name, "-", file) // #nosec G204
+	cmd.Stdin = bytes.NewReader(value)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return fmt.Errorf("%s: %s", SystemdCredsCLI, msg)
+	}
+	return os.Chmod(file, 0600)
+}
+
+// ExportName returns the variable name used for a YAML path, names are taken
+// from the explicit mapping if present, otherwise the path (less the top level element)
+// is joined with underscores and any characters not valid in a variable name are replaced
+func ExportName(path string, element string, names map[string]string) string {
+	if name, ok := names[path]; ok {
+		return name
+	}
+	parts := sls.SplitPath(path)
+	if element != "" && len(parts) > 1 && parts[0] == element {
+		parts = parts[1:]
+	}
+	name := invalidVarChars.ReplaceAllString(strings.Join(parts, "_"), "_")
+	if len(name) > 0 && name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+// ParseNameMappings turns a list of "path=name" strings into a map
+func ParseNameMappings(mappings []string) (map[string]string, error) {
+	names := make(map[string]string)
+	for _, m := range mappings {
+		parts := strings.SplitN(m, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return names, fmt.Errorf("invalid mapping '%s', expected path=name", m)
+		}
+		names[parts[0]] = parts[1]
+	}
+	return names, nil
+}
+
+// defaultExportPaths returns the children of the top level element if set,
+// otherwise all of the top level keys in the document
+func defaultExportPaths(s *sls.Sls) []string {
+	var paths []string
+
+	if s.EncryptionPath != "" {
+		if vals, ok := s.GetValueFromPath(s.EncryptionPath).(map[string]interface{}); ok {
+			for k := range vals {
+				if !sls.IsMetaKey(k) {
+					paths = append(paths, sls.JoinPath(sls.EscapeKey(s.EncryptionPath), k))
+				}
+			}
+		}
+	} else {
+		for k := range s.Yaml.Values {
+			if !sls.IsMetaKey(k) {
+				paths = append(paths, sls.EscapeKey(k))
+			}
+		}
+	}
+	sort.Strings(paths)
+
+	return paths
+}
+
+func flattenValues(path string, val interface{}, out map[string]interface{}) {
+	if val == nil {
+		out[path] = ""
+		return
+	}
+	switch reflect.TypeOf(val).Kind() {
+	case reflect.Map:
+		for k, v := range val.(map[string]interface{}) {
+			flattenValues(sls.JoinPath(path, k), v, out)
+		}
+	case reflect.Slice:
+		for i, v := range val.([]interface{}) {
+			flattenValues(sls.JoinPath(path, strconv.Itoa(i)), v, out)
+		}
+	default:
+		out[path] = val
+	}
+}
+
+// stripMeta removes metadata keys from decrypted values
+func stripMeta(val interface{}) interface{} {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			if !sls.IsMetaKey(k) {
+				res[k] = stripMeta(item)
+			}
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, len(v))
+		for i, item := range v {
+			res[i] = stripMeta(item)
+		}
+		return res
+	}
+	return val
+}
+
+func shellQuote(val string) string {
+	return "'" + strings.Replace(val, "'", `'\''`, -1) + "'"
+}
+
+// doubleQuote quotes a value for a .env file or a systemd EnvironmentFile, which both
+// read backslash escapes in double quotes, systemd keeps new lines in quotes as they are
+func doubleQuote(val string, format string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`", "\n", `\n`)
+	if format == Systemd {
+		r = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "$", `\$`, "`", "\\`")
+	}
+	return `"` + r.Replace(val) + `"`
+}
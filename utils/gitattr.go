@@ -0,0 +1,157 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package utils
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// GitCrypt is the name GitFilter returns for files encrypted by git-crypt
+const GitCrypt = "git-crypt"
+
+// Transcrypt is the name GitFilter returns for files encrypted by transcrypt
+const Transcrypt = "transcrypt"
+
+// GitFilter returns the name of the tool (GitCrypt or Transcrypt) that encrypts a file
+// through a git filter set in .gitattributes, or "" when the file isn't in a git
+// repository or isn't filtered
+func GitFilter(file string) (string, error) {
+	full, err := filepath.Abs(file)
+	if err != nil {
+		return "", err
+	}
+	root := gitRoot(filepath.Dir(full))
+	if root == "" {
+		return "", nil
+	}
+
+	// .gitattributes files closer to the file take precedence, then .git/info/attributes
+	rel, err := filepath.Rel(root, full)
+	if err != nil {
+		return "", err
+	}
+	name := filepath.ToSlash(rel)
+
+	filter, err := readFilterAttr(filepath.Join(root, ".gitattributes"), name, "")
+	if err != nil {
+		return "", err
+	}
+	parts := strings.Split(name, "/")
+	for i := 1; i < len(parts); i++ {
+		attrFile := filepath.Join(root, filepath.FromSlash(strings.Join(parts[:i], "/")), ".gitattributes")
+		filter, err = readFilterAttr(attrFile, strings.Join(parts[i:], "/"), filter)
+		if err != nil {
+			return "", err
+		}
+	}
+	filter, err = readFilterAttr(filepath.Join(root, ".git", "info", "attributes"), name, filter)
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case strings.HasPrefix(filter, "git-crypt"):
+		return GitCrypt, nil
+	case filter == "crypt":
+		return Transcrypt, nil
+	}
+	return "", nil
+}
+
+// gitRoot returns the top directory of the git repository dir is in, or ""
+func gitRoot(dir string) string {
+	for {
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// readFilterAttr returns the filter attribute the lines of an attributes file that match
+// name set, the last match wins, filter is returned unchanged when none match
+func readFilterAttr(attrFile string, name string, filter string) (string, error) {
+	f, err := os.Open(filepath.Clean(attrFile))
+	if os.IsNotExist(err) {
+		return filter, nil
+	} else if err != nil {
+		return filter, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") || !attrPatternMatches(fields[0], name) {
+			continue
+		}
+		for _, attr := range fields[1:] {
+			switch {
+			case strings.HasPrefix(attr, "filter="):
+				filter = strings.TrimPrefix(attr, "filter=")
+			case attr == "-filter" || attr == "!filter":
+				filter = ""
+			}
+		}
+	}
+
+	return filter, scanner.Err()
+}
+
+// attrPatternMatches matches a gitattributes pattern against a slash separated path,
+// patterns without a slash match the file name at any depth
+func attrPatternMatches(pattern string, name string) bool {
+	if !strings.Contains(strings.TrimSuffix(pattern, "/"), "/") {
+		name = name[strings.LastIndex(name, "/")+1:]
+	}
+	pattern = strings.TrimPrefix(pattern, "/")
+
+	var expr strings.Builder
+	expr.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			expr.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			expr.WriteString(".*")
+			i++
+		case pattern[i] == '*':
+			expr.WriteString("[^/]*")
+		case pattern[i] == '?':
+			expr.WriteString("[^/]")
+		default:
+			expr.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	expr.WriteString("$")
+
+	matched, err := regexp.MatchString(expr.String(), name)
+	return err == nil && matched
+}
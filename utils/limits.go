@@ -0,0 +1,88 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package utils
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Everbridge/generate-secure-pillar/pki"
+)
+
+// WalkLimits are the deepest directory below the search directory that is gone into, and
+// the most files found (0 for no limit), so pointing a recursive command at / or the root
+// of a large repository fails instead of walking all of it
+type WalkLimits struct {
+	MaxDepth int
+	MaxFiles int
+}
+
+// ErrWalkLimit is returned when a directory is deeper, or has more files, than the limits allow
+var ErrWalkLimit = errors.New("directory limit exceeded")
+
+// ListFiles returns the files with the given extension under searchDir, except those left out by
+// an IgnoreFile, failing with ErrWalkLimit when a directory is deeper, or there are more files,
+// than the limits allow
+func ListFiles(searchDir string, ext string, limits WalkLimits) ([]string, error) {
+	fileList := []string{}
+	searchDir = filepath.Clean(searchDir)
+	rules := map[string][]ignoreRule{}
+	err := filepath.Walk(searchDir, func(path string, f os.FileInfo, err error) error {
+		if err != nil && f == nil {
+			return err
+		}
+		inherited := rules[filepath.Dir(path)]
+		if f.IsDir() {
+			if path != searchDir && ignored(inherited, path, true) {
+				logger.Debugf("ignoring %s (%s)", path, IgnoreFile)
+				return filepath.SkipDir
+			}
+			rel, err := filepath.Rel(searchDir, path)
+			if err != nil {
+				return err
+			}
+			if depth := strings.Count(rel, string(filepath.Separator)) + 1; rel != "." && limits.MaxDepth > 0 && depth > limits.MaxDepth {
+				return pki.Errorf(ErrWalkLimit, "%s is %d directories below %s, more than --max-depth %d", path, depth, searchDir, limits.MaxDepth)
+			}
+			own, err := readIgnoreFile(path)
+			if err != nil {
+				return err
+			}
+			rules[path] = append(inherited[:len(inherited):len(inherited)], own...)
+			return nil
+		}
+		if filepath.Ext(f.Name()) != ext {
+			return nil
+		}
+		if ignored(inherited, path, false) {
+			logger.Debugf("ignoring %s (%s)", path, IgnoreFile)
+			return nil
+		}
+		if limits.MaxFiles > 0 && len(fileList) == limits.MaxFiles {
+			return pki.Errorf(ErrWalkLimit, "%s has more than --max-files %d %s files", searchDir, limits.MaxFiles, ext)
+		}
+		fileList = append(fileList, path)
+		return nil
+	})
+	return fileList, err
+}
@@ -0,0 +1,171 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// key codes read from a terminal in raw mode
+const (
+	keyCtrlA     = 0x01
+	keyCtrlC     = 0x03
+	keyCtrlD     = 0x04
+	keyCtrlE     = 0x05
+	keyBackspace = 0x08
+	keyCtrlU     = 0x15
+	keyEscape    = 0x1b
+	keyDelete    = 0x7f
+)
+
+// ErrInterrupted is returned by ReadLine when ctrl-c is pressed
+var ErrInterrupted = fmt.Errorf("interrupted")
+
+// LineEditor reads lines from a terminal in raw mode with basic editing: the left and right
+// arrows, backspace, ctrl-a, ctrl-e, ctrl-u, and the up and down arrows for the history
+type LineEditor struct {
+	Prompt  string
+	History []string
+	in      *bufio.Reader
+	out     io.Writer
+}
+
+// NewLineEditor returns a LineEditor reading keys from in and echoing to out
+func NewLineEditor(in io.Reader, out io.Writer, prompt string) *LineEditor {
+	return &LineEditor{Prompt: prompt, in: bufio.NewReader(in), out: out}
+}
+
+// ReadLine reads a line, io.EOF is returned when ctrl-d is pressed on an empty line
+func (e *LineEditor) ReadLine() (string, error) {
+	var line []rune
+	pos := 0
+	// the index in the history being shown, len(History) is the line being typed
+	hist := len(e.History)
+	typed := ""
+
+	e.redraw(line, pos)
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return string(line), err
+		}
+
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(e.out, "\r\n")
+			return string(line), nil
+		case keyCtrlC:
+			fmt.Fprint(e.out, "^C\r\n")
+			return "", ErrInterrupted
+		case keyCtrlD:
+			if len(line) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+		case keyCtrlA:
+			pos = 0
+		case keyCtrlE:
+			pos = len(line)
+		case keyCtrlU:
+			line = line[pos:]
+			pos = 0
+		case keyBackspace, keyDelete:
+			if pos > 0 {
+				line = append(line[:pos-1], line[pos:]...)
+				pos--
+			}
+		case keyEscape:
+			switch e.escape() {
+			case 'A':
+				if hist > 0 {
+					if hist == len(e.History) {
+						typed = string(line)
+					}
+					hist--
+					line = []rune(e.History[hist])
+					pos = len(line)
+				}
+			case 'B':
+				if hist < len(e.History) {
+					hist++
+					if hist == len(e.History) {
+						line = []rune(typed)
+					} else {
+						line = []rune(e.History[hist])
+					}
+					pos = len(line)
+				}
+			case 'C':
+				if pos < len(line) {
+					pos++
+				}
+			case 'D':
+				if pos > 0 {
+					pos--
+				}
+			}
+		default:
+			if r < ' ' {
+				continue
+			}
+			line = append(line[:pos], append([]rune{r}, line[pos:]...)...)
+			pos++
+		}
+		e.redraw(line, pos)
+	}
+}
+
+// escape reads the rest of an escape sequence and returns its final character
+func (e *LineEditor) escape() rune {
+	r, _, err := e.in.ReadRune()
+	if err != nil || (r != '[' && r != 'O') {
+		return 0
+	}
+	for {
+		r, _, err = e.in.ReadRune()
+		// parameters are digits and semicolons, the final character ends the sequence
+		if err != nil || !strings.ContainsRune("0123456789;", r) {
+			return r
+		}
+	}
+}
+
+// redraw writes the prompt and the line, and puts the cursor at pos
+func (e *LineEditor) redraw(line []rune, pos int) {
+	fmt.Fprintf(e.out, "\r%s%s\x1b[K", e.Prompt, string(line))
+	if back := len(line) - pos; back > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dD", back)
+	}
+}
+
+// AddHistory adds a line to the history, blank lines and repeats of the last line are skipped
+func (e *LineEditor) AddHistory(line string) {
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+	if n := len(e.History); n > 0 && e.History[n-1] == line {
+		return
+	}
+	e.History = append(e.History, line)
+}
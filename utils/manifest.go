@@ -0,0 +1,132 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package utils
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Everbridge/generate-secure-pillar/output"
+)
+
+// ManifestName is the file a signed manifest is written to in the directory it covers
+const ManifestName = "MANIFEST.asc"
+
+// Manifest returns the SHA-256 checksum of each file with the extension under searchDir,
+// one per line in the format sha256sum uses, with paths relative to searchDir
+func Manifest(searchDir string, fileExt string, limits WalkLimits) (string, error) {
+	sums, err := checksums(searchDir, fileExt, limits)
+	if err != nil {
+		return "", err
+	}
+
+	names := make([]string, 0, len(sums))
+	for name := range sums {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var manifest strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&manifest, "%s  %s\n", sums[name], name)
+	}
+	return manifest.String(), nil
+}
+
+// CheckManifest compares a manifest with the files with the extension under searchDir,
+// a problem is returned for each file that was changed, removed, or added since
+func CheckManifest(searchDir string, fileExt string, limits WalkLimits, manifest string) ([]output.Problem, error) {
+	problems := []output.Problem{}
+
+	sums, err := checksums(searchDir, fileExt, limits)
+	if err != nil {
+		return problems, err
+	}
+
+	listed := make(map[string]bool)
+	scanner := bufio.NewScanner(strings.NewReader(manifest))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		fields := strings.SplitN(line, "  ", 2)
+		if len(fields) != 2 {
+			return problems, fmt.Errorf("malformed manifest line: '%s'", line)
+		}
+		name := fields[1]
+		listed[name] = true
+		sum, ok := sums[name]
+		switch {
+		case !ok:
+			problems = append(problems, output.Problem{File: filepath.Join(searchDir, name), Message: "missing"})
+		case sum != fields[0]:
+			problems = append(problems, output.Problem{File: filepath.Join(searchDir, name), Message: "changed"})
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return problems, err
+	}
+
+	for name := range sums {
+		if !listed[name] {
+			problems = append(problems, output.Problem{File: filepath.Join(searchDir, name), Message: "not in the manifest"})
+		}
+	}
+	sort.Slice(problems, func(i, j int) bool { return problems[i].File < problems[j].File })
+
+	return problems, nil
+}
+
+// checksums maps the path (relative to searchDir) of each file to its SHA-256 checksum
+func checksums(searchDir string, fileExt string, limits WalkLimits) (map[string]string, error) {
+	sums := make(map[string]string)
+
+	searchDir, err := filepath.Abs(searchDir)
+	if err != nil {
+		return sums, err
+	}
+	if err = checkForDir(searchDir); err != nil {
+		return sums, err
+	}
+
+	files, err := FindFilesByExtLimited(searchDir, fileExt, limits)
+	if err != nil {
+		return sums, err
+	}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(filepath.Clean(file))
+		if err != nil {
+			return sums, err
+		}
+		name, err := filepath.Rel(searchDir, file)
+		if err != nil {
+			return sums, err
+		}
+		sums[filepath.ToSlash(name)] = fmt.Sprintf("%x", sha256.Sum256(data))
+	}
+	return sums, nil
+}
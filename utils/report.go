@@ -0,0 +1,108 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package utils
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Everbridge/generate-secure-pillar/output"
+	"github.com/Everbridge/generate-secure-pillar/pki"
+	"github.com/Everbridge/generate-secure-pillar/sls"
+)
+
+// RunReport collects the files processed by ProcessDirReport, it is safe to use from the workers
+type RunReport struct {
+	mu     sync.Mutex
+	Report output.RunReport
+}
+
+// NewRunReport starts a report of an action over a directory
+func NewRunReport(action string, dir string, user string, version string) *RunReport {
+	return &RunReport{Report: output.RunReport{Action: action, Directory: dir, User: user, Version: version, Started: time.Now().UTC()}}
+}
+
+func (r *RunReport) add(res output.FileResult) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Report.Files = append(r.Report.Files, res)
+}
+
+// Finish sorts the files and adds up the values, keys, and failures, err is the error that
+// stopped the run, if any
+func (r *RunReport) Finish(err error) output.RunReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rep := r.Report
+	rep.Finished = time.Now().UTC()
+	if err != nil {
+		rep.Error = err.Error()
+	}
+	sort.Slice(rep.Files, func(i, j int) bool { return rep.Files[i].File < rep.Files[j].File })
+	keys := map[string]bool{}
+	rep.Keys = []string{}
+	for _, f := range rep.Files {
+		rep.Values += f.Values
+		if f.Error != "" {
+			rep.Failures++
+		}
+		for _, k := range f.Keys {
+			if !keys[k] {
+				keys[k] = true
+				rep.Keys = append(rep.Keys, k)
+			}
+		}
+	}
+	sort.Strings(rep.Keys)
+	return rep
+}
+
+// countValues sets the number of encrypted values in a file and the fingerprints of the keys
+// they are encrypted to, or their key IDs when the keys aren't in the keyrings
+func countValues(s *sls.Sls, res *output.FileResult) {
+	values := s.EncryptedValues()
+	res.Values = len(values)
+	seen := map[string]bool{}
+	for _, val := range values {
+		if pki.IsNaclValue(val) {
+			continue
+		}
+		recipients, err := s.Pki.Recipients(val)
+		if err != nil {
+			continue
+		}
+		for _, r := range recipients {
+			key := r.Fingerprint
+			if key == "" {
+				key = r.KeyID
+			}
+			if !r.Anonymous && !seen[key] {
+				seen[key] = true
+				res.Keys = append(res.Keys, key)
+			}
+		}
+	}
+	sort.Strings(res.Keys)
+}
@@ -0,0 +1,147 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package utils
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Everbridge/generate-secure-pillar/pki"
+	"github.com/Everbridge/generate-secure-pillar/sls"
+)
+
+// StageTar stage target, a tar stream of the decrypted files
+const StageTar = "tar"
+
+// StageTmpfs stage target, a directory of decrypted files on a RAM backed file system
+const StageTmpfs = "tmpfs"
+
+// ramDirs are the RAM backed directories tried for StageTmpfs, in order
+var ramDirs = []string{"/dev/shm", os.Getenv("XDG_RUNTIME_DIR")}
+
+// StageToTar writes decrypted copies of the files in searchDir to w as a tar stream,
+// the files in searchDir are not changed
+func StageToTar(searchDir string, fileExt string, limits WalkLimits, topLevelElement string, pk pki.Pki, w io.Writer) (int, error) {
+	tw := tar.NewWriter(w)
+	count, err := stageFiles(searchDir, fileExt, limits, topLevelElement, pk, func(name string, data []byte) error {
+		hdr := &tar.Header{
+			Name:    filepath.ToSlash(name),
+			Mode:    0600,
+			Size:    int64(len(data)),
+			ModTime: time.Now(),
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	})
+	if err != nil {
+		return count, err
+	}
+	return count, tw.Close()
+}
+
+// StageToTmpfs writes decrypted copies of the files in searchDir to a new directory
+// on a RAM backed file system and returns its path, the files in searchDir are not changed
+func StageToTmpfs(searchDir string, fileExt string, limits WalkLimits, topLevelElement string, pk pki.Pki) (string, int, error) {
+	base := ""
+	for _, dir := range ramDirs {
+		if fi, err := os.Stat(dir); dir != "" && err == nil && fi.IsDir() {
+			base = dir
+			break
+		}
+	}
+	if base == "" {
+		return "", 0, fmt.Errorf("no RAM backed directory found (tried /dev/shm and $XDG_RUNTIME_DIR)")
+	}
+
+	stageDir, err := ioutil.TempDir(base, "gsp-stage-")
+	if err != nil {
+		return "", 0, fmt.Errorf("unable to create stage directory: %s", err)
+	}
+	count, err := stageFiles(searchDir, fileExt, limits, topLevelElement, pk, func(name string, data []byte) error {
+		file := filepath.Join(stageDir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(file, data, 0600)
+	})
+	if err != nil {
+		_ = os.RemoveAll(stageDir)
+		return "", count, err
+	}
+	return stageDir, count, nil
+}
+
+// stageFiles decrypts each file in searchDir and passes it to write with its path relative to searchDir,
+// include files are passed as they are
+func stageFiles(searchDir string, fileExt string, limits WalkLimits, topLevelElement string, pk pki.Pki, write func(string, []byte) error) (int, error) {
+	if len(searchDir) == 0 {
+		return 0, fmt.Errorf("search directory not specified")
+	}
+	searchDir, err := filepath.Abs(searchDir)
+	if err != nil {
+		return 0, err
+	}
+
+	files, err := FindFilesByExtLimited(searchDir, fileExt, limits)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, file := range files {
+		name, err := filepath.Rel(searchDir, file)
+		if err != nil {
+			return count, err
+		}
+
+		s := sls.New(file, pk, topLevelElement)
+		if s.Error != nil {
+			return count, fmt.Errorf("%s: %s", file, s.Error)
+		}
+		var data []byte
+		if s.IsInclude {
+			if data, err = ioutil.ReadFile(filepath.Clean(file)); err != nil {
+				return count, err
+			}
+		} else {
+			buf, err := s.PerformAction(sls.Decrypt)
+			if err != nil && (buf.Len() == 0 || s.Options.Strict) {
+				return count, fmt.Errorf("%s: %s", file, err)
+			} else if err != nil {
+				logger.Warnf("%s: %s", file, err)
+			}
+			data = buf.Bytes()
+		}
+
+		if err = write(name, data); err != nil {
+			return count, fmt.Errorf("unable to stage %s: %s", name, err)
+		}
+		count++
+	}
+	return count, nil
+}
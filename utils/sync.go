@@ -0,0 +1,140 @@
+// Copyright © 2018 Everbridge, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package utils
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+
+	"github.com/Everbridge/generate-secure-pillar/output"
+	"github.com/Everbridge/generate-secure-pillar/pki"
+	"github.com/Everbridge/generate-secure-pillar/sls"
+)
+
+// sync statuses, files are only written when they are added or changed
+const (
+	SyncAdded     = "added"
+	SyncChanged   = "changed"
+	SyncUnchanged = "unchanged"
+	SyncDstOnly   = "only in destination"
+)
+
+// SyncDir compares the files with the extension in srcDir and dstDir by their decrypted values,
+// and writes the files that are new or changed to dstDir re-encrypted with the key of pk,
+// files that are only in dstDir are reported but left as they are, it stops once ctx is done
+func SyncDir(ctx context.Context, srcDir string, dstDir string, fileExt string, limits WalkLimits, topLevelElement string, pk pki.Pki, dryRun bool) ([]output.SyncResult, error) {
+	results := []output.SyncResult{}
+	if err := checkForDir(srcDir); err != nil {
+		return results, err
+	}
+
+	srcFiles, err := relativeFiles(srcDir, fileExt, limits)
+	if err != nil {
+		return results, err
+	}
+	dstFiles := map[string]bool{}
+	if _, statErr := os.Stat(dstDir); statErr == nil {
+		names, err := relativeFiles(dstDir, fileExt, limits)
+		if err != nil {
+			return results, err
+		}
+		for _, name := range names {
+			dstFiles[name] = true
+		}
+	}
+
+	opts := sls.DefaultOptions
+	opts.Context = ctx
+	for _, name := range srcFiles {
+		if ctx.Err() != nil {
+			return results, ctx.Err()
+		}
+		src := sls.NewWithOptions(filepath.Join(srcDir, name), pk, topLevelElement, opts)
+		if src.Error != nil {
+			return results, src.Error
+		}
+		if _, err = src.PerformAction(sls.Decrypt); err != nil {
+			return results, fmt.Errorf("%s: %s", src.FilePath, err)
+		}
+
+		status := SyncAdded
+		dstFile := filepath.Join(dstDir, name)
+		if dstFiles[name] {
+			dst := sls.NewWithOptions(dstFile, pk, topLevelElement, opts)
+			if dst.Error != nil {
+				return results, dst.Error
+			}
+			if _, err = dst.PerformAction(sls.Decrypt); err != nil {
+				return results, fmt.Errorf("%s: %s", dst.FilePath, err)
+			}
+			status = SyncChanged
+			if reflect.DeepEqual(src.Yaml.Values, dst.Yaml.Values) {
+				status = SyncUnchanged
+			}
+			delete(dstFiles, name)
+		}
+		results = append(results, output.SyncResult{Path: name, Status: status})
+		if status == SyncUnchanged || dryRun {
+			continue
+		}
+
+		// the values are decrypted, so rotating encrypts them all with the new key
+		buffer, err := src.PerformAction(sls.Rotate)
+		if err != nil {
+			return results, fmt.Errorf("%s: %s", src.FilePath, err)
+		}
+		if _, err = sls.WriteSlsFileContext(ctx, buffer, dstFile); err != nil {
+			return results, err
+		}
+	}
+
+	for name := range dstFiles {
+		results = append(results, output.SyncResult{Path: name, Status: SyncDstOnly})
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Path < results[j].Path })
+
+	return results, nil
+}
+
+// relativeFiles returns the paths, relative to dir, of the files with the extension in it
+func relativeFiles(dir string, fileExt string, limits WalkLimits) ([]string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	files, err := FindFilesByExtLimited(dir, fileExt, limits)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(files))
+	for _, file := range files {
+		name, err := filepath.Rel(dir, file)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
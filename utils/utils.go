@@ -22,55 +22,83 @@ package utils
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 
+	"github.com/Everbridge/generate-secure-pillar/metrics"
+	"github.com/Everbridge/generate-secure-pillar/output"
 	"github.com/Everbridge/generate-secure-pillar/pki"
 	"github.com/Everbridge/generate-secure-pillar/sls"
 	"github.com/sirupsen/logrus"
 )
 
 var logger = logrus.New()
+var logOutput io.Writer = os.Stdout
+
+// SetLogOutput sets where log messages are written (stdout by default)
+func SetLogOutput(w io.Writer) {
+	logOutput = w
+	logger.Out = w
+}
 
 func init() {
-	logger.Out = os.Stdout
+	logger.Out = logOutput
+	logger.ExitFunc = metrics.Exit
 }
 
-// SafeWrite checks that there is no error prior to trying to write a file
-func SafeWrite(buffer bytes.Buffer, outputFilePath string, err error) {
+// SafeWrite checks that there is no error prior to trying to write a file,
+// a remote file is not written once ctx is done
+func SafeWrite(ctx context.Context, buffer bytes.Buffer, outputFilePath string, err error) {
 	if err != nil {
-		logger.Fatalf("%s", err)
+		Exit(err)
 	} else {
-		_, err = sls.WriteSlsFile(buffer, outputFilePath)
+		_, err = sls.WriteSlsFileContext(ctx, buffer, outputFilePath)
 		if err != nil {
-			logger.Fatalf("%s", err)
+			Exit(err)
 		}
 	}
 }
 
 // PathAction applies an action to a YAML path
 func PathAction(s *sls.Sls, path string, action string) {
-	vals := s.GetValueFromPath(path)
-	if vals != nil {
-		processedVals, err := s.ProcessValues(vals, action)
-		if err != nil {
-			logger.Fatalf("path action failed: %s", err)
-		}
-		fmt.Printf("%s: %s\n", path, processedVals)
-	} else {
-		logger.Warnf("unable to find path: '%s'", path)
+	if !s.PathExists(path) {
+		Exit(s.PathError(path))
 	}
+	processedVals, err := s.ProcessValues(s.GetValueFromPath(path), action)
+	if err != nil {
+		logger.Errorf("path action failed: %s", err)
+		metrics.Exit(ExitCode(err))
+	}
+	fmt.Printf("%s: %s\n", path, processedVals)
 }
 
 // ProcessDir applies an action concurrently to a directory of files
 func ProcessDir(searchDir string, fileExt string, action string, outputFilePath string, topLevelElement string, pk pki.Pki) error {
+	return ProcessDirContext(context.Background(), searchDir, fileExt, WalkLimits{}, action, outputFilePath, topLevelElement, pk)
+}
+
+// ProcessDirContext is ProcessDir within the limits, returning the context's error once ctx is done,
+// files that were being processed then are left as they were
+func ProcessDirContext(ctx context.Context, searchDir string, fileExt string, limits WalkLimits, action string, outputFilePath string, topLevelElement string, pk pki.Pki) error {
+	return ProcessDirReport(ctx, searchDir, fileExt, limits, action, outputFilePath, topLevelElement, pk, nil)
+}
+
+// ProcessDirReport is ProcessDirContext, adding each file it processes to report (nil for no report)
+func ProcessDirReport(ctx context.Context, searchDir string, fileExt string, limits WalkLimits, action string, outputFilePath string, topLevelElement string, pk pki.Pki, report *RunReport) error {
 	if len(searchDir) == 0 {
 		return fmt.Errorf("search directory not specified")
 	}
 
 	// get a list of sls files along with the count
-	files, count := FindFilesByExt(searchDir, fileExt)
+	files, err := FindFilesByExtLimited(searchDir, fileExt, limits)
+	if err != nil {
+		return err
+	}
+	count := len(files)
 
 	// copy files to a channel then close the
 	// channel so that workers stop when done
@@ -88,7 +116,10 @@ func ProcessDir(searchDir string, fileExt string, action string, outputFilePath
 	for i := 0; i < count; i++ {
 		go func() {
 			for file := range filesChan {
-				resChan <- applyActionAndWrite(file, action, &pk, topLevelElement, errChan)
+				if ctx.Err() != nil {
+					return
+				}
+				resChan <- applyActionAndWrite(ctx, file, action, &pk, topLevelElement, errChan, report)
 			}
 		}()
 	}
@@ -104,51 +135,101 @@ func ProcessDir(searchDir string, fileExt string, action string, outputFilePath
 			remaining--
 		case err := <-errChan:
 			return err
+		case <-ctx.Done():
+			return ctx.Err()
 		}
 		if remaining == 0 {
 			break
 		}
 	}
+
+	// an error may still be waiting if all of the results were read first
+	select {
+	case err := <-errChan:
+		return err
+	default:
+	}
 	return nil
 }
 
-func applyActionAndWrite(file string, action string, pk *pki.Pki, topLevelElement string, errChan chan error) int {
+func applyActionAndWrite(ctx context.Context, file string, action string, pk *pki.Pki, topLevelElement string, errChan chan error, report *RunReport) int {
 	byteCount := 0
-	s := sls.New(file, *pk, topLevelElement)
+	res := output.FileResult{File: file}
+	defer func() { report.add(res) }()
+	opts := sls.DefaultOptions
+	opts.Context = ctx
+	s := sls.NewWithOptions(file, *pk, topLevelElement, opts)
 	if s.IsInclude || s.Error != nil {
+		res.Skipped = true
 		if s.Error != nil {
-			logger.Warnf("%s", s.Error)
+			res.Error = s.Error.Error()
+			warnOrSend(&s, s.Error, errChan)
 		}
 		return 0
 	}
 
+	if report != nil && (action == sls.Decrypt || action == sls.Validate) {
+		// the values and keys the file had before they were decrypted
+		countValues(&s, &res)
+	}
 	buf, err := s.PerformAction(action)
+	if err != nil {
+		res.Error = err.Error()
+	}
+	if ctx.Err() != nil {
+		// never write a file that was only partly processed when time ran out
+		res.Error = ctx.Err().Error()
+		handleErr(ctx.Err(), errChan)
+		return byteCount
+	}
 	if buf.Len() > 0 && err != nil && action != sls.Validate {
-		logger.Warnf("%s", err)
+		if warnOrSend(&s, err, errChan) {
+			// don't write a partly processed file
+			return byteCount
+		}
 	} else if err != nil && action == sls.Validate {
-		logger.Warnf("%s", err)
+		warnOrSend(&s, err, errChan)
 	} else if action == sls.Validate {
 		fmt.Printf("%s:\nkey count: %d\n%s\n", s.FilePath, s.KeyCount, buf.String())
 		return byteCount
 	} else if buf.Len() == 0 {
 		err = fmt.Errorf("zero length buffer produced by '%s' for file '%s'", action, file)
+		res.Error = err.Error()
 		handleErr(err, errChan)
 		return byteCount
 	}
 
+	if report != nil && (action == sls.Encrypt || action == sls.Rotate) {
+		countValues(&s, &res)
+	}
 	if action != sls.Validate {
-		byteCount, err = sls.WriteSlsFile(buf, file)
+		byteCount, err = sls.WriteSlsFileContext(ctx, buf, file)
 	} else {
 		byteCount, err = os.Stdout.Write(buf.Bytes())
 	}
 
 	if err != nil {
+		res.Error = err.Error()
 		handleErr(err, errChan)
 	}
 
 	return byteCount
 }
 
+// warnOrSend logs an error as a warning, in strict mode it is sent on errChan instead
+// and true is returned
+func warnOrSend(s *sls.Sls, err error, errChan chan error) bool {
+	if s.Options.Strict {
+		if !sls.IsStrictError(err) {
+			err = &sls.StrictError{Msg: err.Error(), Class: pki.ErrorClass(err)}
+		}
+		handleErr(err, errChan)
+		return true
+	}
+	logger.Warnf("%s", err)
+	return false
+}
+
 func handleErr(err error, errChan chan error) {
 	if err != nil {
 		select {
@@ -163,29 +244,31 @@ func handleErr(err error, errChan chan error) {
 
 // FindFilesByExt recurses through the given searchDir returning a list of files with a given extension and it's length
 func FindFilesByExt(searchDir string, ext string) ([]string, int) {
-	fileList := []string{}
-	searchDir, err := filepath.Abs(searchDir)
-	if err != nil {
-		logger.Error(err)
-		return fileList, 0
-	}
-	err = checkForDir(searchDir)
+	fileList, err := FindFilesByExtLimited(searchDir, ext, WalkLimits{})
 	if err != nil {
 		logger.Error(err)
-		return fileList, 0
+		return []string{}, 0
 	}
 
-	err = filepath.Walk(searchDir, func(path string, f os.FileInfo, err error) error {
-		if !f.IsDir() && filepath.Ext(f.Name()) == ext {
-			fileList = append(fileList, path)
-		}
-		return nil
-	})
+	return fileList, len(fileList)
+}
+
+// FindFilesByExtLimited recurses through the given searchDir returning a list of files with a given extension,
+// within the limits
+func FindFilesByExtLimited(searchDir string, ext string, limits WalkLimits) ([]string, error) {
+	searchDir, err := filepath.Abs(searchDir)
 	if err != nil {
-		logger.Fatal("error walking file path: ", err)
+		return []string{}, err
+	}
+	if err = checkForDir(searchDir); err != nil {
+		return []string{}, err
 	}
 
-	return fileList, len(fileList)
+	fileList, err := ListFiles(searchDir, ext, limits)
+	if err != nil && !errors.Is(err, ErrWalkLimit) {
+		err = fmt.Errorf("error walking file path: %s", err)
+	}
+	return fileList, err
 }
 
 //checkForDir does exactly what it says on the tin